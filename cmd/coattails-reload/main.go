@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"time"
 
-	"github.com/bluedresscapital/coattails/pkg/util"
+	"github.com/bluedresscapital/coattails/pkg/mannequin"
 
-	"github.com/bluedresscapital/coattails/pkg/routes"
+	"github.com/bluedresscapital/coattails/pkg/util"
 
 	"github.com/bluedresscapital/coattails/pkg/diapers"
 
@@ -33,73 +37,110 @@ var (
 	pgPwd              string
 	pgDb               string
 	cacheHost          string
+	sqlitePath         string
 	loadBdcKeyFromFile bool
 	bdcKeyFile         string
+	keyProviderConfig  *secrets.KeyProviderConfig
 	parallelism        int
+	tdaBaseURL         string
+	rhBaseURL          string
+	rhMinervaBaseURL   string
+	brokerTimeout      time.Duration
+	brokerRetries      int
+	brokerFixturesDir  string
+	brokerFixturesMode string
+	pgStatementTimeout time.Duration
+	reloadTimeout      time.Duration
 )
 
-func reloadPortfolios() {
-	ids, err := wardrobe.FetchAllPortfolioIds()
+// Points the broker apis at their configured hosts, optionally recording or replaying fixtures
+func configureBrokers() {
+	transport, err := mannequin.NewTransport(brokerFixturesDir, mannequin.Mode(brokerFixturesMode))
+	if err != nil {
+		log.Fatalf("error initializing broker fixtures: %v", err)
+	}
+	cfg := util.ClientConfig{Timeout: brokerTimeout, Retries: brokerRetries, Transport: transport}
+	cfg.BaseURL = tdaBaseURL
+	tda.Configure(cfg)
+	cfg.BaseURL = rhBaseURL
+	robinhood.Configure(cfg, rhMinervaBaseURL)
+}
+
+func reloadPortfolios(ctx context.Context) {
+	ids, err := wardrobe.FetchAllPortfolioIds(ctx)
 	if err != nil {
 		log.Printf("error fetching portfolio ids: %v", err)
 	}
 	for _, id := range ids {
-		log.Printf("Reloading portfolio %d", id)
-		port, err := wardrobe.FetchPortfolioById(id)
-		if err != nil {
-			log.Printf("error fetching portfolio by id: %v", err)
+		if ctx.Err() != nil {
+			log.Printf("stopping portfolio reloads: %v", ctx.Err())
+			return
 		}
-		var orderAPI orders.OrderAPI
-		var transferAPI transfers.TransferAPI
-		var needsOrderReload bool
-		var needsTransferReload bool
-		if port.Type == "tda" {
-			orderAPI = tda.API{AccountId: port.TDAccountId}
-			transferAPI = tda.API{AccountId: port.TDAccountId}
-		} else if port.Type == "rh" {
-			orderAPI = robinhood.API{AccountId: port.RHAccountId}
-			transferAPI = robinhood.API{AccountId: port.RHAccountId}
-		} else {
-			// Just check if we have uncommitted transfers or orders
-			needsOrderReload, err = wardrobe.HasUncommittedOrders(port.Id)
-			if err != nil {
-				log.Printf("error checking for uncommitted orders: %v", err)
-			}
-			needsTransferReload, err = wardrobe.HasUncommittedTransfers(port.Id)
-			if err != nil {
-				log.Printf("error checking for uncommitted transfers: %v", err)
-			}
-		}
-		if orderAPI != nil {
-			needsOrderReload, err = orders.ReloadOrders(orderAPI, stockings.FingoPack{})
-			if err != nil {
-				log.Printf("error reloading orders: %v", err)
-			}
-		}
-		if transferAPI != nil {
-			needsTransferReload, err = transfers.ReloadTransfers(transferAPI)
-			if err != nil {
-				log.Printf("error reloading transfers: %v", err)
-			}
+		portCtx, cancel := context.WithTimeout(ctx, reloadTimeout)
+		reloadPortfolio(portCtx, id)
+		cancel()
+	}
+}
+
+func reloadPortfolio(ctx context.Context, id int) {
+	log.Printf("Reloading portfolio %d", id)
+	port, err := wardrobe.FetchPortfolioById(ctx, id)
+	if err != nil {
+		log.Printf("error fetching portfolio by id: %v", err)
+		return
+	}
+	var orderAPI orders.OrderAPI
+	var transferAPI transfers.TransferAPI
+	var needsOrderReload bool
+	var needsTransferReload bool
+	if port.Type == "tda" {
+		ctx = wardrobe.WithSource(ctx, wardrobe.SourceTDA)
+		orderAPI = tda.API{AccountId: port.TDAccountId}
+		transferAPI = tda.API{AccountId: port.TDAccountId}
+	} else if port.Type == "rh" {
+		ctx = wardrobe.WithSource(ctx, wardrobe.SourceRH)
+		orderAPI = robinhood.API{AccountId: port.RHAccountId}
+		transferAPI = robinhood.API{AccountId: port.RHAccountId}
+	} else {
+		// Just check if we have uncommitted transfers or orders
+		needsOrderReload, err = wardrobe.HasUncommittedOrders(ctx, port.Id)
+		if err != nil {
+			log.Printf("error checking for uncommitted orders: %v", err)
 		}
-		depsChanged := make([]diapers.Data, 0)
-		if needsOrderReload {
-			depsChanged = append(depsChanged, diapers.Order)
+		needsTransferReload, err = wardrobe.HasUncommittedTransfers(ctx, port.Id)
+		if err != nil {
+			log.Printf("error checking for uncommitted transfers: %v", err)
 		}
-		if needsTransferReload {
-			depsChanged = append(depsChanged, diapers.Transfer)
+	}
+	if orderAPI != nil {
+		needsOrderReload, err = orders.ReloadOrders(ctx, wardrobe.Default(), orderAPI, stockings.FingoPack{})
+		if err != nil {
+			log.Printf("error reloading orders: %v", err)
 		}
-		err = diapers.BulkReloadDepsAndPublish(depsChanged, port.Id, port.UserId, routes.GetChannelFromUserId(port.UserId))
+	}
+	if transferAPI != nil {
+		needsTransferReload, err = transfers.ReloadTransfers(ctx, wardrobe.Default(), transferAPI)
 		if err != nil {
-			log.Printf("error reloading deps for %v: %v", depsChanged, err)
+			log.Printf("error reloading transfers: %v", err)
 		}
 	}
+	depsChanged := make([]diapers.Data, 0)
+	if needsOrderReload {
+		depsChanged = append(depsChanged, diapers.Order)
+	}
+	if needsTransferReload {
+		depsChanged = append(depsChanged, diapers.Transfer)
+	}
+	err = diapers.BulkReloadDepsAndPublish(ctx, wardrobe.Default(), depsChanged, port.Id)
+	if err != nil {
+		log.Printf("error reloading deps for %v: %v", depsChanged, err)
+	}
 }
 
-func reloadStockIndustryBuckets(tickers []string, doneChan chan map[string][]string) {
+func reloadStockIndustryBuckets(ctx context.Context, tickers []string, doneChan chan map[string][]string) {
 	res := make(map[string][]string)
 	for _, t := range tickers {
-		collections, err := stockings.ScrapeCollections(t)
+		collections, err := stockings.ScrapeCollections(ctx, t)
 		if err != nil {
 			log.Printf("errored scraping collections for %s: %v", t, err)
 		}
@@ -109,15 +150,15 @@ func reloadStockIndustryBuckets(tickers []string, doneChan chan map[string][]str
 	doneChan <- res
 }
 
-func reloadStockIndustries(parallelism int) {
-	tickers, err := wardrobe.FetchStaleStockCollections()
+func reloadStockIndustries(ctx context.Context, parallelism int) {
+	tickers, err := wardrobe.FetchStaleStockCollections(ctx)
 	if err != nil {
 		log.Printf("error fetching stale stock collectionMap: %v", err)
 	}
 	tickerBuckets := util.PartitionTickers(tickers, parallelism)
 	doneChan := make(chan map[string][]string)
 	for _, bucket := range tickerBuckets {
-		go reloadStockIndustryBuckets(bucket, doneChan)
+		go reloadStockIndustryBuckets(ctx, bucket, doneChan)
 	}
 	done := 0
 	// maps a ticker to a list of collection names
@@ -143,7 +184,7 @@ L:
 	for c := range collectionSet {
 		collections = append(collections, c)
 	}
-	collectionIds, err := wardrobe.UpsertCollections(collections)
+	collectionIds, err := wardrobe.UpsertCollections(ctx, collections)
 	if err != nil {
 		log.Printf("error upserting collections: %v", err)
 	}
@@ -153,7 +194,7 @@ L:
 			collectIds = append(collectIds, collectionIds[c])
 		}
 		log.Printf("Upserting stock collections for %s", t)
-		err = wardrobe.UpsertStockCollections(collectIds, t)
+		err = wardrobe.UpsertStockCollections(ctx, collectIds, t)
 		if err != nil {
 			log.Printf("error upserting stock collection edge for %s with collections %v: %v", t, collectIds, err)
 		}
@@ -172,15 +213,35 @@ func main() {
 	flag.StringVar(&pgPwd, "pg-pwd", "bdc", "postgresql password")
 	flag.StringVar(&pgDb, "pg-db", "wardrobe", "postgresql db")
 	flag.StringVar(&cacheHost, "redis-host", "localhost", "redis host")
+	flag.StringVar(&sqlitePath, "sqlite", "", "path to a sqlite db to use instead of postgresql and redis")
 	flag.BoolVar(&loadBdcKeyFromFile, "load-bdc-key-from-file", false, "flag for whether or not we should get bdc key from file")
 	flag.StringVar(&bdcKeyFile, "bdc-key-file", "", "file location of bdc-key. Required if load-bdc-key-from-file is set")
+	keyProviderConfig = secrets.RegisterKeyProviderFlags(flag.CommandLine)
 	flag.IntVar(&parallelism, "parallelism", 10, "parallelism")
+	flag.StringVar(&tdaBaseURL, "tda-base-url", tda.DefaultBaseURL, "td ameritrade api base url")
+	flag.StringVar(&rhBaseURL, "rh-base-url", robinhood.DefaultBaseURL, "robinhood api base url")
+	flag.StringVar(&rhMinervaBaseURL, "rh-minerva-base-url", robinhood.DefaultMinervaBaseURL, "robinhood banking api base url")
+	flag.DurationVar(&brokerTimeout, "broker-timeout", util.DefaultTimeout, "timeout for broker api requests")
+	flag.IntVar(&brokerRetries, "broker-retries", util.DefaultRetries, "number of times we retry failed broker api GETs")
+	flag.StringVar(&brokerFixturesDir, "broker-fixtures-dir", "fixtures", "directory broker api fixtures are recorded to and replayed from")
+	flag.StringVar(&brokerFixturesMode, "broker-fixtures-mode", "", "set to record or replay to record or replay broker api fixtures")
+	flag.DurationVar(&pgStatementTimeout, "pg-statement-timeout", 30*time.Second, "postgresql cancels any single query that runs longer than this")
+	flag.DurationVar(&reloadTimeout, "reload-timeout", 5*time.Minute, "how long we give each portfolio to reload before giving up on it")
 	flag.Parse()
+	// Stop reloading (and roll back whatever's in flight) on SIGINT
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 	// Initialize singleton instances after parsing flag
-	wardrobe.InitDB(fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		pgHost, pgPort, pgUser, pgPwd, pgDb))
-	wardrobe.InitCache(cacheHost)
-	secrets.InitSundress(loadBdcKeyFromFile, bdcKeyFile)
-	reloadPortfolios()
-	reloadStockIndustries(parallelism)
+	if sqlitePath != "" {
+		wardrobe.InitSQLite(sqlitePath)
+		wardrobe.InitLocalCache()
+	} else {
+		wardrobe.InitDB(fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable statement_timeout=%d",
+			pgHost, pgPort, pgUser, pgPwd, pgDb, pgStatementTimeout.Milliseconds()))
+		wardrobe.InitCache(cacheHost)
+	}
+	secrets.InitSundress(keyProviderConfig, loadBdcKeyFromFile, bdcKeyFile)
+	configureBrokers()
+	reloadPortfolios(ctx)
+	reloadStockIndustries(ctx, parallelism)
 }
@@ -5,15 +5,24 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"time"
 
+	"github.com/bluedresscapital/coattails/pkg/mailer"
+	"github.com/bluedresscapital/coattails/pkg/mannequin"
+	"github.com/bluedresscapital/coattails/pkg/robinhood"
 	"github.com/bluedresscapital/coattails/pkg/routes"
 	"github.com/bluedresscapital/coattails/pkg/secrets"
 	"github.com/bluedresscapital/coattails/pkg/stockings"
+	"github.com/bluedresscapital/coattails/pkg/tda"
+	"github.com/bluedresscapital/coattails/pkg/tickertape"
+	"github.com/bluedresscapital/coattails/pkg/util"
 	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/bluedresscapital/coattails/pkg/webhooks"
 	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
 	"github.com/rs/cors"
@@ -27,11 +36,107 @@ var (
 	pgPwd              string
 	pgDb               string
 	cacheHost          string
+	sqlitePath         string
 	debugNoDeps        bool
 	loadBdcKeyFromFile bool
 	bdcKeyFile         string
+	keyProviderConfig  *secrets.KeyProviderConfig
+	tdaBaseURL         string
+	rhBaseURL          string
+	rhMinervaBaseURL   string
+	brokerTimeout      time.Duration
+	brokerRetries      int
+	brokerFixturesDir  string
+	brokerFixturesMode string
+	pgStatementTimeout time.Duration
+	requestTimeout     time.Duration
+	insecureCookies    bool
+	trustProxyHeaders  bool
+	streamQuotes       bool
+	quoteInterval      time.Duration
+	webhookInterval    time.Duration
+	allowPrivateHooks  bool
+	smtpConfig         *mailer.Config
 )
 
+// Points the broker apis at their configured hosts, optionally recording or replaying fixtures
+func configureBrokers() {
+	transport, err := mannequin.NewTransport(brokerFixturesDir, mannequin.Mode(brokerFixturesMode))
+	if err != nil {
+		log.Fatalf("error initializing broker fixtures: %v", err)
+	}
+	cfg := util.ClientConfig{Timeout: brokerTimeout, Retries: brokerRetries, Transport: transport}
+	cfg.BaseURL = tdaBaseURL
+	tda.Configure(cfg)
+	cfg.BaseURL = rhBaseURL
+	robinhood.Configure(cfg, rhMinervaBaseURL)
+}
+
+// Runs `coattails migrate [up [version] | down <version> | baseline <version> | status]`. Up migrates to the latest
+// version by default.
+func migrate(args []string) {
+	ctx := context.Background()
+	if sqlitePath != "" {
+		wardrobe.ConnectSQLite(sqlitePath)
+	} else {
+		wardrobe.ConnectDB(fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
+			pgHost, pgPort, pgUser, pgPwd, pgDb))
+	}
+	defer wardrobe.CloseDB()
+	cmd := "up"
+	if len(args) > 0 {
+		cmd = args[0]
+	}
+	var target int
+	var err error
+	if len(args) > 1 {
+		target, err = strconv.Atoi(args[1])
+		if err != nil {
+			log.Fatalf("invalid migration version %s: %v", args[1], err)
+		}
+	}
+	switch cmd {
+	case "up":
+		if len(args) < 2 {
+			target, err = wardrobe.LatestSchemaVersion()
+			if err != nil {
+				log.Fatalf("error reading migrations: %v", err)
+			}
+		}
+		err = wardrobe.MigrateUp(ctx, target)
+	case "down":
+		if len(args) < 2 {
+			log.Fatal("migrate down requires a target version, i.e. `coattails migrate down 0` to revert everything")
+		}
+		err = wardrobe.MigrateDown(ctx, target)
+	case "baseline":
+		if len(args) < 2 {
+			log.Fatal("migrate baseline requires a version, i.e. `coattails migrate baseline 1` for a db that predates migrations")
+		}
+		err = wardrobe.BaselineSchema(ctx, target)
+	case "status":
+		var statuses []wardrobe.MigrationStatus
+		statuses, err = wardrobe.FetchMigrationStatuses(ctx)
+		for _, s := range statuses {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = fmt.Sprintf("applied %s", s.AppliedAt.Format(time.RFC3339))
+			}
+			fmt.Printf("%04d_%s\t%s\n", s.Version, s.Name, applied)
+		}
+	default:
+		log.Fatalf("unknown migrate command %s, expected up, down, baseline or status", cmd)
+	}
+	if err != nil {
+		log.Fatalf("error migrating: %v", err)
+	}
+	version, err := wardrobe.SchemaVersion(ctx)
+	if err != nil {
+		log.Fatalf("error fetching schema version: %v", err)
+	}
+	log.Printf("db schema is at version %d", version)
+}
+
 func initDeps() {
 	err := godotenv.Load()
 	if err != nil {
@@ -47,18 +152,52 @@ func initDeps() {
 	flag.StringVar(&pgPwd, "pg-pwd", "bdc", "postgresql password")
 	flag.StringVar(&pgDb, "pg-db", "wardrobe", "postgresql db")
 	flag.StringVar(&cacheHost, "redis-host", "localhost", "redis host")
+	flag.StringVar(&sqlitePath, "sqlite", "", "path to a sqlite db to use instead of postgresql. Also keeps sessions and pub/sub in process instead of in redis")
 	flag.BoolVar(&debugNoDeps, "run-without-deps", false, "debug setting")
 	flag.BoolVar(&loadBdcKeyFromFile, "load-bdc-key-from-file", false, "flag for whether or not we should get bdc key from file")
 	flag.StringVar(&bdcKeyFile, "bdc-key-file", "", "file location of bdc-key. Required if load-bdc-key-from-file is set")
+	keyProviderConfig = secrets.RegisterKeyProviderFlags(flag.CommandLine)
+	flag.StringVar(&tdaBaseURL, "tda-base-url", tda.DefaultBaseURL, "td ameritrade api base url")
+	flag.StringVar(&rhBaseURL, "rh-base-url", robinhood.DefaultBaseURL, "robinhood api base url")
+	flag.StringVar(&rhMinervaBaseURL, "rh-minerva-base-url", robinhood.DefaultMinervaBaseURL, "robinhood banking api base url")
+	flag.DurationVar(&brokerTimeout, "broker-timeout", util.DefaultTimeout, "timeout for broker api requests")
+	flag.IntVar(&brokerRetries, "broker-retries", util.DefaultRetries, "number of times we retry failed broker api GETs")
+	flag.StringVar(&brokerFixturesDir, "broker-fixtures-dir", "fixtures", "directory broker api fixtures are recorded to and replayed from")
+	flag.StringVar(&brokerFixturesMode, "broker-fixtures-mode", "", "set to record or replay to record or replay broker api fixtures")
+	flag.DurationVar(&pgStatementTimeout, "pg-statement-timeout", 30*time.Second, "postgresql cancels any single query that runs longer than this")
+	flag.DurationVar(&requestTimeout, "request-timeout", routes.RequestTimeout, "how long a request gets before everything it's doing is cancelled")
+	flag.BoolVar(&insecureCookies, "insecure-cookies", false, "send session cookies over plain http too. Only for serving without https")
+	flag.BoolVar(&trustProxyHeaders, "trust-proxy-headers", false, "take client ips from X-Forwarded-For. Only set behind a proxy that sets it")
+	flag.BoolVar(&streamQuotes, "stream-quotes", false, "push live prices to connected websockets during market hours. Only one process should set it")
+	flag.DurationVar(&quoteInterval, "quote-interval", 15*time.Second, "how often live prices are fetched, with -stream-quotes")
+	flag.DurationVar(&webhookInterval, "webhook-poll-interval", 5*time.Second, "how often queued webhooks are checked for and delivered. 0 disables delivering them")
+	flag.BoolVar(&allowPrivateHooks, "allow-private-webhooks", false, "let webhook urls point at private addresses, i.e. localhost. Only for development")
+	smtpConfig = mailer.RegisterFlags(flag.CommandLine)
 	flag.Parse()
+	if flag.Arg(0) == "migrate" {
+		migrate(flag.Args()[1:])
+		os.Exit(0)
+	}
 	// Initialize singleton instances after parsing flag
 	stockings.InitKeygen()
+	configureBrokers()
+	routes.RequestTimeout = requestTimeout
+	routes.SecureCookies = !insecureCookies
+	routes.TrustProxyHeaders = trustProxyHeaders
+	util.AllowPrivateAddresses = allowPrivateHooks
+	routes.Mailer, err = smtpConfig.New()
+	if err != nil {
+		log.Fatalf("error configuring smtp: %v", err)
+	}
 	if debugNoDeps {
 		log.Println("Warning: You are starting a server without a Database and Cache")
 		log.Println("Calls to functions that use a Database or Cache will segfault")
+	} else if sqlitePath != "" {
+		wardrobe.InitSQLite(sqlitePath)
+		wardrobe.InitLocalCache()
 	} else {
-		wardrobe.InitDB(fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-			pgHost, pgPort, pgUser, pgPwd, pgDb))
+		wardrobe.InitDB(fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable statement_timeout=%d",
+			pgHost, pgPort, pgUser, pgPwd, pgDb, pgStatementTimeout.Milliseconds()))
 		wardrobe.InitCache(cacheHost)
 	}
 }
@@ -78,6 +217,9 @@ func main() {
 
 	routes.RegisterAllRoutes(r)
 
+	// Every request's context descends from this one, so cancelling it stops whatever requests are still
+	// running (i.e. a long ReloadHistory) once we're done waiting on them during shutdown
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
 	srv := &http.Server{
 		Addr: "0.0.0.0:8080",
 		// Good practice to set timeouts to avoid Slowloris attacks.
@@ -85,6 +227,9 @@ func main() {
 		ReadTimeout:  time.Second * 15,
 		IdleTimeout:  time.Second * 60,
 		Handler:      handler, // Pass our instance of gorilla/mux in.
+		BaseContext: func(net.Listener) context.Context {
+			return baseCtx
+		},
 	}
 
 	// Run our server in a goroutine so that it doesn't block.
@@ -94,9 +239,17 @@ func main() {
 		}
 	}()
 
+	if streamQuotes {
+		go tickertape.New(stockings.FingoPack{}, wardrobe.Default(), quoteInterval).Run(baseCtx)
+	}
+
 	// NOTE(ma): It's important to initialize secrets AFTER all startup routines are done. In case we run into
 	// a crashloop, we don't want to make unnecessary requests to kms due to our monthly limit
-	secrets.InitSundress(loadBdcKeyFromFile, bdcKeyFile)
+	secrets.InitSundress(keyProviderConfig, loadBdcKeyFromFile, bdcKeyFile)
+	// Deliveries are signed with secrets we can only decrypt from here on
+	if webhookInterval > 0 {
+		go webhooks.NewDispatcher(wardrobe.Default()).Run(baseCtx, webhookInterval)
+	}
 	c := make(chan os.Signal, 1)
 	// We'll accept graceful shutdowns when quit via SIGINT (Ctrl+C)
 	// SIGKILL, SIGQUIT or SIGTERM (Ctrl+/) will not be caught.
@@ -111,8 +264,10 @@ func main() {
 	// Doesn't block if no connections, but will otherwise wait
 	// until the timeout deadline.
 	err := srv.Shutdown(ctx)
+	// Anything still running past the deadline gets cancelled, which rolls back its in flight transactions
+	cancelRequests()
 	if err != nil {
-		panic(err)
+		log.Printf("Error gracefully shutting down: %v", err)
 	}
 
 	err = wardrobe.CloseDB()
@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
+	"time"
 
 	"github.com/bluedresscapital/coattails/pkg/secrets"
 	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/bluedresscapital/coattails/pkg/webhooks"
 	"github.com/joho/godotenv"
 )
 
@@ -17,15 +22,22 @@ var (
 	pgPwd              string
 	pgDb               string
 	cacheHost          string
+	sqlitePath         string
 	loadBdcKeyFromFile bool
 	bdcKeyFile         string
+	keyProviderConfig  *secrets.KeyProviderConfig
+	pgStatementTimeout time.Duration
 )
 
-func cleanupPreviousDayData() {
-	err := wardrobe.DeletePrevDailyPortValues()
+func cleanupPreviousDayData(ctx context.Context) {
+	err := wardrobe.DeletePrevDailyPortValues(ctx)
 	if err != nil {
 		log.Printf("Error deleting prev daily port values %v", err)
 	}
+	err = wardrobe.DeleteWebhookDeliveriesBefore(ctx, time.Now().Add(-webhooks.LogRetention))
+	if err != nil {
+		log.Printf("Error deleting old webhook deliveries %v", err)
+	}
 }
 
 func main() {
@@ -39,13 +51,23 @@ func main() {
 	flag.StringVar(&pgPwd, "pg-pwd", "bdc", "postgresql password")
 	flag.StringVar(&pgDb, "pg-db", "wardrobe", "postgresql db")
 	flag.StringVar(&cacheHost, "redis-host", "localhost", "redis host")
+	flag.StringVar(&sqlitePath, "sqlite", "", "path to a sqlite db to use instead of postgresql and redis")
 	flag.BoolVar(&loadBdcKeyFromFile, "load-bdc-key-from-file", false, "flag for whether or not we should get bdc key from file")
 	flag.StringVar(&bdcKeyFile, "bdc-key-file", "", "file location of bdc-key. Required if load-bdc-key-from-file is set")
+	keyProviderConfig = secrets.RegisterKeyProviderFlags(flag.CommandLine)
+	flag.DurationVar(&pgStatementTimeout, "pg-statement-timeout", 30*time.Second, "postgresql cancels any single query that runs longer than this")
 	flag.Parse()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 	// Initialize singleton instances after parsing flag
-	wardrobe.InitDB(fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		pgHost, pgPort, pgUser, pgPwd, pgDb))
-	wardrobe.InitCache(cacheHost)
-	secrets.InitSundress(loadBdcKeyFromFile, bdcKeyFile)
-	cleanupPreviousDayData()
+	if sqlitePath != "" {
+		wardrobe.InitSQLite(sqlitePath)
+		wardrobe.InitLocalCache()
+	} else {
+		wardrobe.InitDB(fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable statement_timeout=%d",
+			pgHost, pgPort, pgUser, pgPwd, pgDb, pgStatementTimeout.Milliseconds()))
+		wardrobe.InitCache(cacheHost)
+	}
+	secrets.InitSundress(keyProviderConfig, loadBdcKeyFromFile, bdcKeyFile)
+	cleanupPreviousDayData(ctx)
 }
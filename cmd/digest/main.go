@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/digest"
+	"github.com/bluedresscapital/coattails/pkg/mailer"
+	"github.com/bluedresscapital/coattails/pkg/stockings"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/joho/godotenv"
+)
+
+var (
+	pgHost             string
+	pgPort             int
+	pgUser             string
+	pgPwd              string
+	pgDb               string
+	cacheHost          string
+	sqlitePath         string
+	pgStatementTimeout time.Duration
+	digestTimeout      time.Duration
+	dryRun             bool
+	smtpConfig         *mailer.Config
+)
+
+// How long we give putting back a digest's claim after failing to send it
+const releaseTimeout = 10 * time.Second
+
+// Sends every digest that's due. Meant to run hourly, since users pick the hour theirs goes out.
+func sendDueDigests(ctx context.Context, store wardrobe.Store, m *mailer.Mailer, now time.Time) {
+	settings, err := store.FetchAllDigestSettings(ctx)
+	if err != nil {
+		log.Printf("error fetching digest settings: %v", err)
+		return
+	}
+	for _, s := range settings {
+		if ctx.Err() != nil {
+			return
+		}
+		slot, due := digest.Due(s, now)
+		if !due {
+			continue
+		}
+		userCtx, cancel := context.WithTimeout(ctx, digestTimeout)
+		sendDigest(userCtx, store, m, s, slot, now)
+		cancel()
+	}
+}
+
+func sendDigest(ctx context.Context, store wardrobe.Store, m *mailer.Mailer, s wardrobe.DigestSettings, slot time.Time, now time.Time) {
+	// Settings saved before addresses had to be verified, or whose address was removed since
+	verified, err := store.IsEmailVerified(ctx, s.UserId, s.Email)
+	if err != nil {
+		log.Printf("error checking digest email for user %d: %v", s.UserId, err)
+		return
+	}
+	if !verified {
+		log.Printf("Skipping digest for user %d, their email isn't verified", s.UserId)
+		return
+	}
+	d, err := digest.Compile(ctx, store, stockings.FingoPack{}, s.UserId, s.Frequency, now)
+	if err != nil {
+		log.Printf("error compiling digest for user %d: %v", s.UserId, err)
+		return
+	}
+	if d.Empty() {
+		log.Printf("Nothing to digest for user %d", s.UserId)
+		return
+	}
+	msg, err := d.Message(s.Email)
+	if err != nil {
+		log.Printf("error rendering digest for user %d: %v", s.UserId, err)
+		return
+	}
+	if dryRun {
+		fmt.Printf("To: %s\nSubject: %s\n\n%s\n", s.Email, msg.Subject, msg.Text)
+		return
+	}
+	claimed, err := store.ClaimDigest(ctx, s.UserId, slot, now)
+	if err != nil {
+		log.Printf("error claiming digest for user %d: %v", s.UserId, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+	err = m.Send(ctx, *msg)
+	if err != nil {
+		log.Printf("error sending digest to user %d: %v", s.UserId, err)
+		// ctx may be why it failed, the release still needs to happen
+		releaseCtx, cancel := context.WithTimeout(context.Background(), releaseTimeout)
+		defer cancel()
+		err = store.ReleaseDigest(releaseCtx, s.UserId, now, s.LastSentAt)
+		if err != nil {
+			log.Printf("error releasing digest for user %d, it won't be retried until its next slot: %v", s.UserId, err)
+		}
+		return
+	}
+	log.Printf("Sent %s digest to user %d", s.Frequency, s.UserId)
+}
+
+func main() {
+	now := time.Now()
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+	flag.StringVar(&pgHost, "pg-host", "localhost", "postgresql host name")
+	flag.IntVar(&pgPort, "pg-port", 5432, "postgresql port")
+	flag.StringVar(&pgUser, "pg-user", "postgres", "postgresql user")
+	flag.StringVar(&pgPwd, "pg-pwd", "bdc", "postgresql password")
+	flag.StringVar(&pgDb, "pg-db", "wardrobe", "postgresql db")
+	flag.StringVar(&cacheHost, "redis-host", "localhost", "redis host")
+	flag.StringVar(&sqlitePath, "sqlite", "", "path to a sqlite db to use instead of postgresql and redis")
+	flag.DurationVar(&pgStatementTimeout, "pg-statement-timeout", 30*time.Second, "postgresql cancels any single query that runs longer than this")
+	flag.DurationVar(&digestTimeout, "digest-timeout", time.Minute, "how long we give each user's digest to compile and send before giving up on it")
+	flag.BoolVar(&dryRun, "dry-run", false, "print due digests instead of sending them (or marking them sent)")
+	smtpConfig = mailer.RegisterFlags(flag.CommandLine)
+	flag.Parse()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	m, err := smtpConfig.New()
+	if err != nil {
+		log.Fatalf("error configuring smtp: %v", err)
+	}
+	if m == nil && !dryRun {
+		log.Fatal("-smtp-host is required to send digests, or use -dry-run")
+	}
+	// Initialize singleton instances after parsing flag
+	if sqlitePath != "" {
+		wardrobe.InitSQLite(sqlitePath)
+		wardrobe.InitLocalCache()
+	} else {
+		wardrobe.InitDB(fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable statement_timeout=%d",
+			pgHost, pgPort, pgUser, pgPwd, pgDb, pgStatementTimeout.Milliseconds()))
+		wardrobe.InitCache(cacheHost)
+	}
+	sendDueDigests(ctx, wardrobe.Default(), m, now)
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/secrets"
+	"github.com/joho/godotenv"
+)
+
+var createLocalKeyfile bool
+
+func main() {
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+	providerConfig := secrets.RegisterKeyProviderFlags(flag.CommandLine)
+	flag.BoolVar(&createLocalKeyfile, "create-local-keyfile", false, "first create -local-keyfile, protected by $BDC_KEYFILE_PASSPHRASE")
+	flag.Parse()
+	if createLocalKeyfile {
+		err = secrets.CreateLocalKeyfile(providerConfig.LocalKeyfile, os.Getenv("BDC_KEYFILE_PASSPHRASE"))
+		if err != nil {
+			log.Fatalf("Unable to create keyfile: %v", err)
+		}
+		log.Printf("Created keyfile %s. Back it up along with its passphrase, AND NEVER LOSE THEM", providerConfig.LocalKeyfile)
+	}
+	provider, err := providerConfig.NewKeyProvider()
+	if err != nil {
+		log.Fatalf("Unable to initialize %s key provider: %v", providerConfig.Provider, err)
+	}
+	// Generate datakey
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		log.Fatal(err)
+	}
+	dataKey := hex.EncodeToString(b)
+	log.Printf("Generated the following datakey: %s. NEVER PERSISTENTLY STORE THIS", dataKey)
+	// Wrap it, and output its wrapped value
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	cipher, err := provider.WrapKey(ctx, []byte(dataKey))
+	if err != nil {
+		log.Fatalf("Unable to wrap datakey: %v", err)
+	}
+	cipherStr := hex.EncodeToString(cipher)
+	log.Printf("Here is your encrypted datakey: %s. Store it somewhere safe, AND NEVER LOSE IT", cipherStr)
+}
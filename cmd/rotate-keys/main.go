@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/secrets"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/joho/godotenv"
+)
+
+var (
+	pgHost             string
+	pgPort             int
+	pgUser             string
+	pgPwd              string
+	pgDb               string
+	sqlitePath         string
+	loadBdcKeyFromFile bool
+	bdcKeyFile         string
+	keyProviderConfig  *secrets.KeyProviderConfig
+	pgStatementTimeout time.Duration
+	dryRun             bool
+)
+
+// Re-encrypts every stored secret under the active bdc datakey. To rotate keys, add the new key (with a higher
+// version) to every coattails binary's keys, make it the active one, then run this. Once it reports nothing
+// left to rotate, older keys can be dropped.
+func main() {
+	err := godotenv.Load()
+	if err != nil {
+		log.Fatal("Error loading .env file")
+	}
+	flag.StringVar(&pgHost, "pg-host", "localhost", "postgresql host name")
+	flag.IntVar(&pgPort, "pg-port", 5432, "postgresql port")
+	flag.StringVar(&pgUser, "pg-user", "postgres", "postgresql user")
+	flag.StringVar(&pgPwd, "pg-pwd", "bdc", "postgresql password")
+	flag.StringVar(&pgDb, "pg-db", "wardrobe", "postgresql db")
+	flag.StringVar(&sqlitePath, "sqlite", "", "path to a sqlite db to use instead of postgresql")
+	flag.BoolVar(&loadBdcKeyFromFile, "load-bdc-key-from-file", false, "flag for whether or not we should get bdc key from file")
+	flag.StringVar(&bdcKeyFile, "bdc-key-file", "", "file location of bdc-key. Required if load-bdc-key-from-file is set")
+	keyProviderConfig = secrets.RegisterKeyProviderFlags(flag.CommandLine)
+	flag.DurationVar(&pgStatementTimeout, "pg-statement-timeout", 30*time.Second, "postgresql cancels any single query that runs longer than this")
+	flag.BoolVar(&dryRun, "dry-run", false, "only report what would be re-encrypted")
+	flag.Parse()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if sqlitePath != "" {
+		wardrobe.InitSQLite(sqlitePath)
+	} else {
+		wardrobe.InitDB(fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable statement_timeout=%d",
+			pgHost, pgPort, pgUser, pgPwd, pgDb, pgStatementTimeout.Milliseconds()))
+	}
+	secrets.InitSundress(keyProviderConfig, loadBdcKeyFromFile, bdcKeyFile)
+	log.Printf("Re-encrypting secrets under datakey version %d", secrets.ActiveKeyVersion())
+	rotated, err := wardrobe.RotateDataKeys(ctx, dryRun)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, c := range rotated {
+		if dryRun {
+			log.Printf("%s.%s: %d to rotate, %d already current", c.Table, c.Column, c.Rotated, c.Current)
+		} else {
+			log.Printf("%s.%s: rotated %d, %d already current, %d changed while rotating", c.Table, c.Column, c.Rotated, c.Current, c.Skipped)
+		}
+	}
+	err = wardrobe.CloseDB()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
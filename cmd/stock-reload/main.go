@@ -1,16 +1,20 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"log"
+	"os"
+	"os/signal"
 	"time"
 
+	"github.com/bluedresscapital/coattails/pkg/alerts"
+	"github.com/bluedresscapital/coattails/pkg/mailer"
 	"github.com/bluedresscapital/coattails/pkg/secrets"
 
 	"github.com/bluedresscapital/coattails/pkg/positions"
 
-	"github.com/bluedresscapital/coattails/pkg/routes"
 	"github.com/bluedresscapital/coattails/pkg/socks"
 
 	"github.com/bluedresscapital/coattails/pkg/portfolios"
@@ -20,6 +24,7 @@ import (
 	"github.com/bluedresscapital/coattails/pkg/stockings"
 
 	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/bluedresscapital/coattails/pkg/webhooks"
 	"github.com/joho/godotenv"
 )
 
@@ -30,22 +35,29 @@ var (
 	pgPwd              string
 	pgDb               string
 	cacheHost          string
+	sqlitePath         string
 	loadBdcKeyFromFile bool
 	bdcKeyFile         string
+	keyProviderConfig  *secrets.KeyProviderConfig
 	parallelism        int
+	pgStatementTimeout time.Duration
+	reloadTimeout      time.Duration
+	smtpConfig         *mailer.Config
+	allowPrivateHooks  bool
+	alertEngine        *alerts.Engine
 )
 
-func reloadCurrentDayStockPrices(i int, tickers []string, doneChan chan bool) {
+func reloadCurrentDayStockPrices(ctx context.Context, i int, tickers []string, doneChan chan bool) {
 	log.Printf("worker %d reloading stock prices for %v", i, tickers)
 	api := stockings.FingoPack{}
 	now := util.GetTimelessDate(time.Now())
 	for _, t := range tickers {
-		s, err := api.GetCurrentPrice(t)
+		s, err := api.GetCurrentPrice(ctx, t)
 		if err != nil {
 			log.Printf("errored getting stock price: %v", err)
 			continue
 		}
-		err = wardrobe.UpsertStockQuotePrice(s.Symbol, now, s.LatestPrice)
+		err = wardrobe.UpsertStockQuotePrice(ctx, s.Symbol, now, s.LatestPrice)
 		if err != nil {
 			log.Printf("errored updating stock quote price: %v", err)
 		}
@@ -53,58 +65,93 @@ func reloadCurrentDayStockPrices(i int, tickers []string, doneChan chan bool) {
 	doneChan <- true
 }
 
-func reloadCurrentDayPortfolioHandler(i int, now time.Time, ports []int, doneChan chan bool) {
+func reloadCurrentDayPortfolioHandler(ctx context.Context, i int, now time.Time, ports []int, doneChan chan bool) {
 	log.Printf("Worker %d loading ports: %v", i, ports)
 	for _, portId := range ports {
-		port, err := wardrobe.FetchPortfolioById(portId)
-		if err != nil {
-			log.Printf("error fetching portfolio %d: %v", portId, err)
-			continue
-		}
-		pv, err := portfolios.ReloadCurrentDay(*port)
-		if err != nil {
-			log.Printf("error reloading current day portfolio: %v", err)
-			continue
+		if ctx.Err() != nil {
+			break
 		}
-		res := make(map[int]portfolios.PortValueDiff)
-		res[portId] = *pv
-		// Update minutely portfolio values
-		if now.Minute()%5 == 0 {
-			log.Printf("Worker %d saving daily portfolio value snapshots for portfolio %d", i, portId)
-			err = wardrobe.InsertDailyPortValue(wardrobe.DailyPortVal{
-				PortId: portId,
-				Date:   now,
-				Value:  (*pv).CurrVal,
-			})
-			if err != nil {
-				log.Printf("error saving daily port value: %v", err)
-				continue
-			}
-		}
-		err = socks.PublishFromServer(routes.GetChannelFromUserId(port.UserId), "RELOAD_CURRENT_PORT_VALUES", res)
+		portCtx, cancel := context.WithTimeout(ctx, reloadTimeout)
+		reloadCurrentDayPortfolio(portCtx, i, now, portId)
+		cancel()
+	}
+	doneChan <- true
+}
+
+func reloadCurrentDayPortfolio(ctx context.Context, i int, now time.Time, portId int) {
+	port, err := wardrobe.FetchPortfolioById(ctx, portId)
+	if err != nil {
+		log.Printf("error fetching portfolio %d: %v", portId, err)
+		return
+	}
+	pv, err := portfolios.ReloadCurrentDay(ctx, wardrobe.Default(), *port)
+	if err != nil {
+		log.Printf("error reloading current day portfolio: %v", err)
+		return
+	}
+	err = alertEngine.EvaluatePortfolio(ctx, portId, *pv, now)
+	if err != nil {
+		log.Printf("error evaluating alerts for portfolio %d: %v", portId, err)
+	}
+	res := make(map[int]portfolios.PortValueDiff)
+	res[portId] = *pv
+	// Update minutely portfolio values
+	if now.Minute()%5 == 0 {
+		log.Printf("Worker %d saving daily portfolio value snapshots for portfolio %d", i, portId)
+		err = wardrobe.InsertDailyPortValue(ctx, wardrobe.DailyPortVal{
+			PortId: portId,
+			Date:   now,
+			Value:  (*pv).CurrVal,
+		})
 		if err != nil {
-			log.Printf("error publishing current port values: %v", err)
-			continue
-		}
-		// Reload positions data
-		err = positions.Reload(portId, stockings.FingoPack{})
-		if err != nil {
-			log.Printf("error reloading portfolio positions: %v", err)
-			continue
+			log.Printf("error saving daily port value: %v", err)
+			return
 		}
 	}
-	doneChan <- true
+	err = socks.PublishToPortfolio(ctx, wardrobe.Default(), portId, socks.TopicPortValues, "RELOAD_CURRENT_PORT_VALUES", func(int) (interface{}, error) {
+		return res, nil
+	})
+	if err != nil {
+		log.Printf("error publishing current port values: %v", err)
+		return
+	}
+	err = webhooks.EmitToPortfolio(ctx, wardrobe.Default(), portId, webhooks.EventPortValuesComputed, webhooks.PortValuesComputed{
+		PortId: portId,
+		Date:   now,
+		Values: *pv,
+	})
+	if err != nil {
+		log.Printf("error queueing port values webhooks: %v", err)
+	}
+	// Reload positions data
+	err = positions.Reload(ctx, wardrobe.Default(), portId, stockings.FingoPack{})
+	if err != nil {
+		log.Printf("error reloading portfolio positions: %v", err)
+		return
+	}
+	ps, err := wardrobe.FetchPortfolioPositions(ctx, portId)
+	if err != nil {
+		log.Printf("error fetching reloaded positions: %v", err)
+		return
+	}
+	err = webhooks.EmitToPortfolio(ctx, wardrobe.Default(), portId, webhooks.EventPositionsReloaded, webhooks.PositionsReloaded{
+		PortId:    portId,
+		Positions: ps,
+	})
+	if err != nil {
+		log.Printf("error queueing positions webhooks: %v", err)
+	}
 }
 
-func reloadCurrentDayPortfolios(parallelism int, now time.Time) {
-	ports, err := wardrobe.FetchAllPortfolioIds()
+func reloadCurrentDayPortfolios(ctx context.Context, parallelism int, now time.Time) {
+	ports, err := wardrobe.FetchAllPortfolioIds(ctx)
 	if err != nil {
 		log.Printf("error fetching portfolio ids: %v", err)
 	}
 	portBuckets := util.PartitionPorts(ports, parallelism)
 	doneChan := make(chan bool)
 	for i, portBucket := range portBuckets {
-		go reloadCurrentDayPortfolioHandler(i, now, portBucket, doneChan)
+		go reloadCurrentDayPortfolioHandler(ctx, i, now, portBucket, doneChan)
 	}
 	done := 0
 L:
@@ -119,17 +166,23 @@ L:
 	}
 }
 
-func reloadStockPrices(parallelism int, now time.Time) {
+func reloadStockPrices(ctx context.Context, parallelism int, now time.Time) {
 	// Reload all current day (relevant) stock prices
-	tickers, err := wardrobe.FetchNonZeroQuantityPositions()
+	tickers, err := wardrobe.FetchNonZeroQuantityPositions(ctx)
 	if err != nil {
 		log.Printf("error fetching non zero ticker positions: %v", err)
 	}
+	// Tickers with price alerts need prices too, whether or not anyone holds them
+	alertTickers, err := wardrobe.FetchAlertTickers(ctx)
+	if err != nil {
+		log.Printf("error fetching alert tickers: %v", err)
+	}
+	tickers = append(tickers, alertTickers...)
 	tickers = removeTickerDuplicates(tickers)
 	tickerPartitions := util.PartitionTickers(tickers, parallelism)
 	doneChan := make(chan bool)
 	for i, part := range tickerPartitions {
-		go reloadCurrentDayStockPrices(i, part, doneChan)
+		go reloadCurrentDayStockPrices(ctx, i, part, doneChan)
 	}
 	doneCount := 0
 L:
@@ -142,6 +195,10 @@ L:
 			}
 		}
 	}
+	err = alertEngine.EvaluatePrices(ctx, now)
+	if err != nil {
+		log.Printf("error evaluating price alerts: %v", err)
+	}
 	// Only check if we have a stale price after 10am EST. The reason for the 10am check is we assume
 	// w/e stock api we use will have all prices up to including the previous day for any given stock after 10am.
 	if now.Hour() > 10 {
@@ -156,7 +213,7 @@ L:
 		// Reload portfolio performances + publish
 	}
 	// Upsert portfolio values + update daily portfolio value if applicable, Update positions
-	reloadCurrentDayPortfolios(parallelism, now)
+	reloadCurrentDayPortfolios(ctx, parallelism, now)
 }
 
 func removeTickerDuplicates(tickers []string) []string {
@@ -185,14 +242,33 @@ func main() {
 	flag.StringVar(&pgPwd, "pg-pwd", "bdc", "postgresql password")
 	flag.StringVar(&pgDb, "pg-db", "wardrobe", "postgresql db")
 	flag.StringVar(&cacheHost, "redis-host", "localhost", "redis host")
+	flag.StringVar(&sqlitePath, "sqlite", "", "path to a sqlite db to use instead of postgresql and redis")
 	flag.BoolVar(&loadBdcKeyFromFile, "load-bdc-key-from-file", false, "flag for whether or not we should get bdc key from file")
 	flag.StringVar(&bdcKeyFile, "bdc-key-file", "", "file location of bdc-key. Required if load-bdc-key-from-file is set")
+	keyProviderConfig = secrets.RegisterKeyProviderFlags(flag.CommandLine)
 	flag.IntVar(&parallelism, "parallelism", 5, "number of go routines to spin up to reload stock prices")
+	flag.DurationVar(&pgStatementTimeout, "pg-statement-timeout", 30*time.Second, "postgresql cancels any single query that runs longer than this")
+	flag.DurationVar(&reloadTimeout, "reload-timeout", time.Minute, "how long we give each portfolio to reload before giving up on it")
+	smtpConfig = mailer.RegisterFlags(flag.CommandLine)
+	flag.BoolVar(&allowPrivateHooks, "allow-private-webhooks", false, "let alert webhooks post to private addresses, i.e. localhost. Only for development")
 	flag.Parse()
+	util.AllowPrivateAddresses = allowPrivateHooks
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
 	// Initialize singleton instances after parsing flag
-	wardrobe.InitDB(fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable",
-		pgHost, pgPort, pgUser, pgPwd, pgDb))
-	wardrobe.InitCache(cacheHost)
-	secrets.InitSundress(loadBdcKeyFromFile, bdcKeyFile)
-	reloadStockPrices(parallelism, now)
+	if sqlitePath != "" {
+		wardrobe.InitSQLite(sqlitePath)
+		wardrobe.InitLocalCache()
+	} else {
+		wardrobe.InitDB(fmt.Sprintf("host=%s port=%d user=%s password=%s dbname=%s sslmode=disable statement_timeout=%d",
+			pgHost, pgPort, pgUser, pgPwd, pgDb, pgStatementTimeout.Milliseconds()))
+		wardrobe.InitCache(cacheHost)
+	}
+	secrets.InitSundress(keyProviderConfig, loadBdcKeyFromFile, bdcKeyFile)
+	m, err := smtpConfig.New()
+	if err != nil {
+		log.Fatalf("error configuring smtp: %v", err)
+	}
+	alertEngine = alerts.New(wardrobe.Default(), stockings.FingoPack{}, alerts.DefaultNotifiers(wardrobe.Default(), m))
+	reloadStockPrices(ctx, parallelism, now)
 }
@@ -1,13 +1,13 @@
 module github.com/bluedresscapital/coattails
 
-go 1.14
+go 1.16
 
 require (
 	astuart.co/go-robinhood v1.5.0
 	github.com/aws/aws-sdk-go v1.30.15
 	github.com/go-redis/redis/v7 v7.2.0
 	github.com/golang/gddo v0.0.0-20200324184333-3c2cc9a6329d
-	github.com/google/uuid v1.1.1
+	github.com/google/uuid v1.3.0
 	github.com/gorilla/mux v1.7.4
 	github.com/gorilla/websocket v1.4.2
 	github.com/joho/godotenv v1.3.0
@@ -15,5 +15,7 @@ require (
 	github.com/piquette/finance-go v1.0.0
 	github.com/rs/cors v1.7.0
 	github.com/shopspring/decimal v1.2.0
-	golang.org/x/net v0.0.0-20200425230154-ff2c4b7c35a0
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
+	golang.org/x/net v0.0.0-20210226172049-e18ecbb05110
+	modernc.org/sqlite v1.14.6
 )
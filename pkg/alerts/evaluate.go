@@ -0,0 +1,237 @@
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/portfolios"
+	"github.com/bluedresscapital/coattails/pkg/stockings"
+	"github.com/bluedresscapital/coattails/pkg/util"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/shopspring/decimal"
+)
+
+// Sent on the user topic when one of their rules triggers, with an Alert
+const TypeAlert = "ALERT"
+
+// A rule triggering
+type Alert struct {
+	RuleId int    `json:"rule_id"`
+	Kind   string `json:"kind"`
+	Ticker string `json:"ticker,omitempty"`
+	PortId int    `json:"port_id,omitempty"`
+	// What the rule matched on: the percent change for price rules, the dollar change for portfolio rules, and
+	// the biggest position's percent of the portfolio for allocation rules
+	Value       decimal.Decimal `json:"value"`
+	Message     string          `json:"message"`
+	TriggeredAt time.Time       `json:"triggered_at"`
+}
+
+// Everything evaluating rules reads
+type Store interface {
+	wardrobe.QuoteStore
+	wardrobe.PositionStore
+	wardrobe.PortfolioStore
+	wardrobe.SharingStore
+	wardrobe.AlertStore
+}
+
+// Evaluates rules, and delivers whichever trigger to the channels they ask for
+type Engine struct {
+	store     Store
+	api       stockings.StockAPI
+	notifiers map[string]Notifier
+}
+
+// notifiers are keyed by channel, see DefaultNotifiers
+func New(store Store, api stockings.StockAPI, notifiers map[string]Notifier) *Engine {
+	return &Engine{store: store, api: api, notifiers: notifiers}
+}
+
+var hundred = decimal.NewFromInt(100)
+
+// How far back we look for a ticker's previous close, enough to get past any weekend and holiday
+const previousCloseLookback = 10
+
+// Evaluates every price rule against today's quotes, which have to already be reloaded
+func (e *Engine) EvaluatePrices(ctx context.Context, now time.Time) error {
+	rules, err := e.store.FetchAlertRulesByKind(ctx, KindPriceChange)
+	if err != nil {
+		return err
+	}
+	byTicker := make(map[string][]wardrobe.AlertRule)
+	for _, r := range rules {
+		byTicker[r.Ticker] = append(byTicker[r.Ticker], r)
+	}
+	today := util.GetTimelessDate(now)
+	for ticker, rules := range byTicker {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		change, price, err := e.dailyChange(ctx, ticker, today)
+		if err != nil {
+			log.Printf("Error computing daily change of %s: %v", ticker, err)
+			continue
+		}
+		if change == nil {
+			continue
+		}
+		for _, r := range rules {
+			if !crossed(r, *change) {
+				continue
+			}
+			e.fire(ctx, r, Alert{
+				Ticker:      ticker,
+				Value:       *change,
+				Message:     fmt.Sprintf("%s is %s %s%% today, at $%s", ticker, direction(*change), change.Abs().StringFixed(2), price.StringFixed(2)),
+				TriggeredAt: now,
+			})
+		}
+	}
+	return nil
+}
+
+// Returns ticker's percent change today from its previous close, along with its price. Returns nil if it has
+// no quote today.
+func (e *Engine) dailyChange(ctx context.Context, ticker string, today time.Time) (*decimal.Decimal, *decimal.Decimal, error) {
+	quotes, err := e.store.FetchStockQuotes(ctx, ticker, today, today)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(quotes) == 0 {
+		return nil, nil, nil
+	}
+	price := quotes[0].Price
+	// Fetched (and cached) from the api if nobody's held the ticker long enough for us to already have it
+	prev, err := stockings.GetHistoricalRange(ctx, e.store, e.api, ticker, today.AddDate(0, 0, -previousCloseLookback), today.AddDate(0, 0, -1))
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(*prev) == 0 {
+		return nil, nil, fmt.Errorf("no previous close for %s", ticker)
+	}
+	prevClose := (*prev)[len(*prev)-1].Price
+	if !prevClose.IsPositive() {
+		return nil, nil, fmt.Errorf("invalid previous close %s for %s", prevClose, ticker)
+	}
+	change := price.Sub(prevClose).Div(prevClose).Mul(hundred).Round(2)
+	return &change, &price, nil
+}
+
+// Evaluates every rule on portId against its freshly reloaded values (see portfolios.ReloadCurrentDay)
+func (e *Engine) EvaluatePortfolio(ctx context.Context, portId int, diff portfolios.PortValueDiff, now time.Time) error {
+	rules, err := e.store.FetchAlertRulesByPortId(ctx, portId)
+	if err != nil || len(rules) == 0 {
+		return err
+	}
+	port, err := e.store.FetchPortfolioById(ctx, portId)
+	if err != nil {
+		return err
+	}
+	var positions []wardrobe.Position
+	for _, r := range rules {
+		// The portfolio could have stopped being shared with them since they made the rule
+		role, err := e.store.FetchPortfolioRole(ctx, portId, r.UserId)
+		if err != nil {
+			return err
+		}
+		if !role.Includes(wardrobe.RoleViewer) {
+			continue
+		}
+		switch r.Kind {
+		case KindPortfolioChange:
+			change := diff.CurrVal.Sub(diff.PrevVal)
+			if !crossed(r, change) {
+				continue
+			}
+			e.fire(ctx, r, Alert{
+				PortId:      portId,
+				Value:       change,
+				Message:     fmt.Sprintf("%s is %s $%s today", port.Name, direction(change), change.Abs().StringFixed(2)),
+				TriggeredAt: now,
+			})
+		case KindAllocation:
+			if positions == nil {
+				positions, err = e.store.FetchPortfolioPositions(ctx, portId)
+				if err != nil {
+					return err
+				}
+			}
+			biggest, allocation := biggestPosition(positions)
+			if biggest == nil || !allocation.GreaterThan(r.Threshold) {
+				continue
+			}
+			e.fire(ctx, r, Alert{
+				Ticker:      biggest.Stock,
+				PortId:      portId,
+				Value:       allocation,
+				Message:     fmt.Sprintf("%s is %s%% of %s", biggest.Stock, allocation.StringFixed(2), port.Name),
+				TriggeredAt: now,
+			})
+		}
+	}
+	return nil
+}
+
+// Returns the position (other than cash) that makes up the most of the portfolio, as of the last time its
+// positions were reloaded, along with its percent of the portfolio
+func biggestPosition(positions []wardrobe.Position) (*wardrobe.Position, decimal.Decimal) {
+	total := decimal.Zero
+	var biggest *wardrobe.Position
+	for i, p := range positions {
+		total = total.Add(p.Value)
+		if p.Stock == portfolios.CASH || p.Quantity.IsZero() {
+			continue
+		}
+		if biggest == nil || p.Value.GreaterThan(biggest.Value) {
+			biggest = &positions[i]
+		}
+	}
+	if biggest == nil || !total.IsPositive() {
+		return nil, decimal.Zero
+	}
+	return biggest, biggest.Value.Div(total).Mul(hundred).Round(2)
+}
+
+// Whether change went at least r's threshold in r's direction
+func crossed(r wardrobe.AlertRule, change decimal.Decimal) bool {
+	if r.Direction == DirectionDown {
+		return change.Neg().GreaterThanOrEqual(r.Threshold)
+	}
+	return change.GreaterThanOrEqual(r.Threshold)
+}
+
+func direction(change decimal.Decimal) string {
+	if change.IsNegative() {
+		return DirectionDown
+	}
+	return DirectionUp
+}
+
+// Delivers a to every one of r's channels, unless r already triggered within its cooldown
+func (e *Engine) fire(ctx context.Context, r wardrobe.AlertRule, a Alert) {
+	claimed, err := e.store.ClaimAlertRule(ctx, r, a.TriggeredAt)
+	if err != nil {
+		log.Printf("Error claiming alert rule %d: %v", r.Id, err)
+		return
+	}
+	if !claimed {
+		return
+	}
+	a.RuleId = r.Id
+	a.Kind = r.Kind
+	log.Printf("Alert rule %d for user %d triggered: %s", r.Id, r.UserId, a.Message)
+	for _, channel := range r.Channels {
+		n, ok := e.notifiers[channel]
+		if !ok {
+			log.Printf("Not delivering alert rule %d over %s, it isn't configured", r.Id, channel)
+			continue
+		}
+		err = n.Notify(ctx, r, a)
+		if err != nil {
+			log.Printf("Error delivering alert rule %d over %s: %v", r.Id, channel, err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package alerts
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/mailer"
+	"github.com/bluedresscapital/coattails/pkg/socks"
+	"github.com/bluedresscapital/coattails/pkg/util"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+)
+
+// Delivers alerts over a channel
+type Notifier interface {
+	Notify(ctx context.Context, rule wardrobe.AlertRule, a Alert) error
+}
+
+// How long a webhook gets to take an alert
+const webhookTimeout = 10 * time.Second
+
+// Notifiers for every channel we can deliver over. Email is left out if m is nil (no smtp server is configured).
+func DefaultNotifiers(emails wardrobe.EmailStore, m *mailer.Mailer) map[string]Notifier {
+	notifiers := map[string]Notifier{
+		ChannelWebsocket: SocketNotifier{},
+		ChannelWebhook:   WebhookNotifier{Client: util.NewPublicClient(webhookTimeout)},
+	}
+	if m != nil {
+		notifiers[ChannelEmail] = EmailNotifier{Mailer: m, Emails: emails}
+	}
+	return notifiers
+}
+
+// Publishes alerts to the user's websockets, on the user topic
+type SocketNotifier struct{}
+
+func (SocketNotifier) Notify(ctx context.Context, rule wardrobe.AlertRule, a Alert) error {
+	return socks.PublishToUser(ctx, rule.UserId, TypeAlert, a)
+}
+
+// Emails alerts to the rule's email, as long as it's still one of the user's verified addresses
+type EmailNotifier struct {
+	Mailer *mailer.Mailer
+	Emails wardrobe.EmailStore
+}
+
+func (n EmailNotifier) Notify(ctx context.Context, rule wardrobe.AlertRule, a Alert) error {
+	verified, err := n.Emails.IsEmailVerified(ctx, rule.UserId, rule.Email)
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return fmt.Errorf("not emailing unverified address %s", rule.Email)
+	}
+	return n.Mailer.Send(ctx, mailer.Message{
+		To:      []string{rule.Email},
+		Subject: fmt.Sprintf("Alert: %s", a.Message),
+		Text: fmt.Sprintf("%s\n\nTriggered at %s by your alert rule %d. It won't trigger again for %s.\n",
+			a.Message, a.TriggeredAt.Format(time.RFC1123), rule.Id, time.Duration(rule.Cooldown)*time.Second),
+	})
+}
+
+// Posts alerts as json to the rule's webhook url. Client should come from util.NewPublicClient, since the url
+// is the user's.
+type WebhookNotifier struct {
+	Client *http.Client
+}
+
+func (n WebhookNotifier) Notify(ctx context.Context, rule wardrobe.AlertRule, a Alert) error {
+	b, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "POST", rule.WebhookURL, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
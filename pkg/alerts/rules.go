@@ -0,0 +1,173 @@
+package alerts
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/mailer"
+	"github.com/bluedresscapital/coattails/pkg/util"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/shopspring/decimal"
+)
+
+// What a rule watches
+const (
+	// A ticker moving Threshold percent from its previous close, i.e. TSLA down 5% today
+	KindPriceChange = "price_change"
+	// A portfolio moving Threshold dollars since yesterday, not counting deposits or withdrawals
+	KindPortfolioChange = "portfolio_change"
+	// Any position in a portfolio making up more than Threshold percent of it
+	KindAllocation = "allocation"
+)
+
+const (
+	DirectionUp   = "up"
+	DirectionDown = "down"
+)
+
+// Where alerts are delivered. Every alert goes to the user's websockets, the others are opt in.
+const (
+	ChannelWebsocket = "websocket"
+	ChannelEmail     = "email"
+	ChannelWebhook   = "webhook"
+)
+
+const (
+	// Rules go quiet this long after triggering unless they say otherwise, which is long enough that an
+	// intraday rule triggers at most once per trading day
+	DefaultCooldown = 12 * time.Hour
+	minCooldown     = 5 * time.Minute
+	maxCooldown     = 30 * 24 * time.Hour
+	// Most rules a user can have
+	maxRules = 100
+)
+
+var tickerRegex = regexp.MustCompile(`^[A-Z][A-Z0-9.\-]{0,9}$`)
+
+type InvalidRuleError struct {
+	msg string
+}
+
+func (e *InvalidRuleError) Error() string {
+	return e.msg
+}
+
+func invalid(format string, a ...interface{}) error {
+	return &InvalidRuleError{fmt.Sprintf(format, a...)}
+}
+
+// Everything creating rules reads and writes
+type RuleStore interface {
+	wardrobe.SharingStore
+	wardrobe.AlertStore
+	wardrobe.EmailStore
+}
+
+// Validates and saves rule for its user, returning it as saved. Only the user, kind, what it watches, direction,
+// threshold, channels (and their email and webhook url) and cooldown are read from rule. Portfolio rules need at
+// least viewer access to the portfolio.
+func CreateRule(ctx context.Context, store RuleStore, rule wardrobe.AlertRule) (*wardrobe.AlertRule, error) {
+	rule.Ticker = strings.ToUpper(strings.TrimSpace(rule.Ticker))
+	switch rule.Kind {
+	case KindPriceChange:
+		if !tickerRegex.MatchString(rule.Ticker) || rule.PortId != 0 {
+			return nil, invalid("%s rules need a ticker, and no port_id", rule.Kind)
+		}
+	case KindPortfolioChange, KindAllocation:
+		if rule.PortId <= 0 || rule.Ticker != "" {
+			return nil, invalid("%s rules need a port_id, and no ticker", rule.Kind)
+		}
+		role, err := store.FetchPortfolioRole(ctx, rule.PortId, rule.UserId)
+		if err != nil {
+			return nil, err
+		}
+		if !role.Includes(wardrobe.RoleViewer) {
+			return nil, invalid("no access to portfolio %d", rule.PortId)
+		}
+	default:
+		return nil, invalid("unknown kind %q, expected %s, %s or %s", rule.Kind, KindPriceChange, KindPortfolioChange, KindAllocation)
+	}
+	if rule.Kind == KindAllocation && rule.Direction == "" {
+		rule.Direction = DirectionUp
+	}
+	if rule.Direction != DirectionUp && (rule.Direction != DirectionDown || rule.Kind == KindAllocation) {
+		return nil, invalid("invalid direction %q for %s rules", rule.Direction, rule.Kind)
+	}
+	if !rule.Threshold.IsPositive() {
+		return nil, invalid("threshold must be positive")
+	}
+	if rule.Kind == KindAllocation && rule.Threshold.GreaterThanOrEqual(decimal.NewFromInt(100)) {
+		return nil, invalid("allocation threshold must be under 100 percent")
+	}
+	channels, err := checkChannels(ctx, store, rule)
+	if err != nil {
+		return nil, err
+	}
+	rule.Channels = channels
+	cooldown := time.Duration(rule.Cooldown) * time.Second
+	if cooldown == 0 {
+		cooldown = DefaultCooldown
+	}
+	if cooldown < minCooldown || cooldown > maxCooldown {
+		return nil, invalid("cooldown_secs must be between %d and %d", int(minCooldown.Seconds()), int(maxCooldown.Seconds()))
+	}
+	rule.Cooldown = int(cooldown.Seconds())
+	existing, err := store.FetchAlertRulesByUserId(ctx, rule.UserId)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) >= maxRules {
+		return nil, invalid("can't have more than %d alert rules", maxRules)
+	}
+	rule.CreatedAt = time.Now()
+	rule.LastTriggeredAt = nil
+	rule.Id, err = store.CreateAlertRule(ctx, rule)
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// Returns rule's channels deduped, and with the websocket one always first. Email has to go to one of the
+// user's verified addresses, and webhooks to a public host.
+func checkChannels(ctx context.Context, emails wardrobe.EmailStore, rule wardrobe.AlertRule) ([]string, error) {
+	channels := []string{ChannelWebsocket}
+	seen := map[string]bool{ChannelWebsocket: true}
+	for _, c := range rule.Channels {
+		if seen[c] {
+			continue
+		}
+		seen[c] = true
+		switch c {
+		case ChannelEmail:
+			if !mailer.ValidAddress(rule.Email) {
+				return nil, invalid("email alerts need a valid email")
+			}
+			verified, err := emails.IsEmailVerified(ctx, rule.UserId, rule.Email)
+			if err != nil {
+				return nil, err
+			}
+			if !verified {
+				return nil, invalid("%s isn't verified, add it under /auth/emails first", rule.Email)
+			}
+		case ChannelWebhook:
+			err := util.CheckPublicURL(ctx, rule.WebhookURL)
+			if err != nil {
+				return nil, invalid("webhook_url %v", err)
+			}
+		default:
+			return nil, invalid("unknown channel %q, expected %s or %s", c, ChannelEmail, ChannelWebhook)
+		}
+		channels = append(channels, c)
+	}
+	if !seen[ChannelEmail] && rule.Email != "" {
+		return nil, invalid("email is only used with the email channel")
+	}
+	if !seen[ChannelWebhook] && rule.WebhookURL != "" {
+		return nil, invalid("webhook_url is only used with the webhook channel")
+	}
+	return channels, nil
+}
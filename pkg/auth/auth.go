@@ -1,33 +1,190 @@
 package auth
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/secrets"
 	"github.com/bluedresscapital/coattails/pkg/wardrobe"
 	"github.com/google/uuid"
 )
 
-// Logs user in with input credentials, and then returns (valid) auth token
-func Login(username string, password [32]byte) (*string, error) {
-	return _login(username, password)
+// Returned for both unknown usernames and wrong passwords, so login doesn't reveal which usernames exist
+var ErrInvalidCredentials = errors.New("invalid username or password")
+
+// Hash of a throwaway password, verified against when the username doesn't exist so that takes as long as a
+// wrong password does. Computed on first use, since hashing isn't free.
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// Where a session is being used from, recorded on the session so users can tell their sessions apart
+type SessionMeta struct {
+	IP        string
+	UserAgent string
+}
+
+// How often a session's last seen time (and sliding expiry) is saved. Saving it on every request would mean
+// a write per request for nothing.
+const sessionTouchInterval = time.Minute
+
+// What a successful password check gets you. Exactly one of the tokens is set.
+type LoginResult struct {
+	// The new session's token, if the user is fully logged in
+	SessionToken *string
+	// Set instead if the user has TOTP enabled, to pass to LoginTOTP along with their code
+	PendingToken *string
 }
 
-func Register(username string, password [32]byte) (*string, error) {
+// Logs user in with input credentials, and then returns (valid) auth token. If the client already had a
+// session (oldToken), it's revoked, so a token planted before login is useless after it. Users with TOTP
+// enabled get a pending token instead, and only get a session once LoginTOTP accepts their code.
+func Login(ctx context.Context, username string, password string, oldToken string, meta SessionMeta) (*LoginResult, error) {
+	userId, err := verifyPassword(ctx, username, password)
+	if err != nil {
+		return nil, err
+	}
+	twoFactor, err := wardrobe.HasTOTPEnabled(ctx, *userId)
+	if err != nil {
+		return nil, err
+	}
+	if twoFactor {
+		pendingToken := uuid.New().String()
+		err = wardrobe.SetPendingLogin(ctx, pendingToken, wardrobe.PendingLogin{UserId: *userId})
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{PendingToken: &pendingToken}, nil
+	}
+	token, err := RotateSession(ctx, oldToken, *userId, false, meta)
+	if err != nil {
+		return nil, err
+	}
+	return &LoginResult{SessionToken: token}, nil
+}
+
+func Register(ctx context.Context, username string, password string, oldToken string, meta SessionMeta) (*LoginResult, error) {
+	hash, err := secrets.HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
 	// Register is basically the same as login, except we need to create user first
-	err := wardrobe.CreateUser(username, password)
+	err = wardrobe.CreateUser(ctx, username, hash)
 	if err != nil {
 		return nil, err
 	}
-	return _login(username, password)
+	return Login(ctx, username, password, oldToken, meta)
 }
 
-func _login(username string, password [32]byte) (*string, error) {
-	userId, err := wardrobe.FetchUser(username, password)
+// Swaps the client's session (if any) for a brand new one for userId. Should be called whenever a session
+// gains privileges, i.e. logging in or turning on TOTP. twoFactor is whether the user has just entered a
+// TOTP code.
+func RotateSession(ctx context.Context, oldToken string, userId int, twoFactor bool, meta SessionMeta) (*string, error) {
+	if oldToken != "" {
+		old, err := wardrobe.FetchSession(ctx, wardrobe.SessionId(oldToken))
+		if err == nil {
+			err = wardrobe.DeleteSession(ctx, old.UserId, old.Id)
+		}
+		if err != nil && !errors.Is(err, wardrobe.ErrNoSession) {
+			return nil, err
+		}
+	}
+	token := uuid.New().String()
+	now := time.Now()
+	err := wardrobe.CreateSession(ctx, wardrobe.Session{
+		Id:         wardrobe.SessionId(token),
+		UserId:     userId,
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  sessionExpiry(now, now),
+		IP:         meta.IP,
+		UserAgent:  meta.UserAgent,
+		TwoFactor:  twoFactor,
+	})
 	if err != nil {
 		return nil, err
 	}
-	authToken := uuid.New().String()
-	err = wardrobe.SetExpiringAuthToken(authToken, userId)
+	return &token, nil
+}
+
+// Returns the session token maps to, sliding its expiry forward. touched is set when the expiry moved, so
+// the caller can push the client's cookie expiry along with it.
+func VerifySession(ctx context.Context, token string, meta SessionMeta) (session *wardrobe.Session, touched bool, err error) {
+	session, err = wardrobe.FetchSession(ctx, wardrobe.SessionId(token))
+	if err != nil {
+		return nil, false, err
+	}
+	now := time.Now()
+	if now.Sub(session.LastSeenAt) < sessionTouchInterval {
+		return session, false, nil
+	}
+	session.LastSeenAt = now
+	session.ExpiresAt = sessionExpiry(session.CreatedAt, now)
+	session.IP = meta.IP
+	session.UserAgent = meta.UserAgent
+	err = wardrobe.TouchSession(ctx, *session)
+	if err != nil {
+		return nil, false, err
+	}
+	return session, true, nil
+}
+
+// Revokes the session token maps to, if it's still around
+func Logout(ctx context.Context, token string) error {
+	session, err := wardrobe.FetchSession(ctx, wardrobe.SessionId(token))
+	if errors.Is(err, wardrobe.ErrNoSession) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return wardrobe.DeleteSession(ctx, session.UserId, session.Id)
+}
+
+// A session idles out after SessionTokenTtl, and dies SessionMaxAge after it was created regardless
+func sessionExpiry(createdAt time.Time, lastSeenAt time.Time) time.Time {
+	expiresAt := lastSeenAt.Add(wardrobe.SessionTokenTtl)
+	if maxExpiresAt := createdAt.Add(wardrobe.SessionMaxAge); expiresAt.After(maxExpiresAt) {
+		return maxExpiresAt
+	}
+	return expiresAt
+}
+
+// Returns the id of the user if password is theirs. Users whose stored hash is legacy (or uses outdated
+// params) get it rehashed on the spot, so they're migrated without having to reset their password.
+func verifyPassword(ctx context.Context, username string, password string) (*int, error) {
+	userId, hash, err := wardrobe.FetchUserPassword(ctx, username)
+	if errors.Is(err, wardrobe.ErrNoUser) {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = secrets.HashPassword(uuid.New().String())
+		})
+		_, _, _ = secrets.VerifyPassword(password, dummyHash)
+		return nil, ErrInvalidCredentials
+	}
 	if err != nil {
 		return nil, err
 	}
-	return &authToken, nil
+	ok, needsRehash, err := secrets.VerifyPassword(password, hash)
+	if err != nil {
+		return nil, fmt.Errorf("error verifying password for user %d: %v", *userId, err)
+	}
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+	if needsRehash {
+		newHash, err := secrets.HashPassword(password)
+		if err == nil {
+			err = wardrobe.UpdateUserPassword(ctx, *userId, newHash)
+		}
+		// They still get logged in, we'll try again next time
+		if err != nil {
+			log.Printf("error rehashing password for user %d: %v", *userId, err)
+		}
+	}
+	return userId, nil
 }
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/mailer"
+	"github.com/bluedresscapital/coattails/pkg/secrets"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+)
+
+const (
+	// How long a user has to enter the code we emailed them
+	emailCodeTtl = 24 * time.Hour
+	// Most addresses a user can have, verified or not
+	maxEmails = 5
+)
+
+var (
+	ErrInvalidEmail     = errors.New("invalid email address")
+	ErrTooManyEmails    = fmt.Errorf("can't have more than %d emails", maxEmails)
+	ErrInvalidEmailCode = errors.New("invalid or expired email verification code")
+)
+
+// Emails a code to email, which the user proves they get mail there with by passing it to VerifyEmail.
+// Alerts and digests only go to verified addresses.
+func StartEmailVerification(ctx context.Context, m *mailer.Mailer, userId int, email string) error {
+	if !mailer.ValidAddress(email) {
+		return ErrInvalidEmail
+	}
+	existing, err := wardrobe.FetchUserEmails(ctx, userId)
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, e := range existing {
+		found = found || e.Email == email
+	}
+	if !found && len(existing) >= maxEmails {
+		return ErrTooManyEmails
+	}
+	code, err := secrets.NewRecoveryCode()
+	if err != nil {
+		return err
+	}
+	err = wardrobe.UpsertEmailCode(ctx, userId, email, secrets.HashRecoveryCode(code), time.Now().Add(emailCodeTtl))
+	if err != nil {
+		return err
+	}
+	return m.Send(ctx, mailer.Message{
+		To:      []string{email},
+		Subject: "Verify your email",
+		Text: fmt.Sprintf("Your coattails verification code is %s\n\nEnter it within %s to start getting alerts and "+
+			"digests here. If you didn't ask for this, you can ignore it.\n", code, emailCodeTtl),
+	})
+}
+
+// Marks the user's email verified if code is the one StartEmailVerification sent it
+func VerifyEmail(ctx context.Context, userId int, email string, code string) error {
+	ok, err := wardrobe.VerifyEmail(ctx, userId, email, secrets.HashRecoveryCode(code), time.Now())
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidEmailCode
+	}
+	return nil
+}
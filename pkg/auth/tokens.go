@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/secrets"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+)
+
+// What an api token is allowed to do. Tokens only work on routes that accept one of their scopes, and never
+// on account routes (sessions, two factor, broker links, other tokens).
+type Scope string
+
+const (
+	ScopeReadPortfolio  Scope = "read:portfolio"
+	ScopeWritePortfolio Scope = "write:portfolio"
+	ScopeReadOrders     Scope = "read:orders"
+	ScopeWriteOrders    Scope = "write:orders"
+	ScopeReadTransfers  Scope = "read:transfers"
+	ScopeWriteTransfers Scope = "write:transfers"
+	ScopeReadAudit      Scope = "read:audit"
+)
+
+var AllScopes = []Scope{
+	ScopeReadPortfolio,
+	ScopeWritePortfolio,
+	ScopeReadOrders,
+	ScopeWriteOrders,
+	ScopeReadTransfers,
+	ScopeWriteTransfers,
+	ScopeReadAudit,
+}
+
+// Every api token starts with this, so they're easy to spot (i.e. by secret scanners) if one gets committed
+const apiTokenPrefix = "cat_"
+
+// Longest a token's name can be
+const maxAPITokenNameLen = 100
+
+var (
+	ErrInvalidAPIToken   = errors.New("invalid api token")
+	ErrInsufficientScope = errors.New("api token is missing a required scope")
+)
+
+// Returned when a token request doesn't make sense, i.e. an unknown scope
+type InvalidAPITokenRequestError struct {
+	msg string
+}
+
+func (e *InvalidAPITokenRequestError) Error() string {
+	return e.msg
+}
+
+// Creates an api token for the user with scopes, expiring after expiresIn (or never, if it's 0). Returns the
+// token, which is the only time it's ever seen, along with what's stored about it.
+func CreateAPIToken(ctx context.Context, userId int, name string, scopes []string, expiresIn time.Duration) (*string, *wardrobe.APIToken, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || len(name) > maxAPITokenNameLen {
+		return nil, nil, &InvalidAPITokenRequestError{fmt.Sprintf("token name must be 1 to %d characters", maxAPITokenNameLen)}
+	}
+	if len(scopes) == 0 {
+		return nil, nil, &InvalidAPITokenRequestError{"token needs at least one scope"}
+	}
+	seen := make(map[string]bool)
+	deduped := make([]string, 0)
+	for _, s := range scopes {
+		if !isScope(s) {
+			return nil, nil, &InvalidAPITokenRequestError{fmt.Sprintf("unknown scope %q", s)}
+		}
+		if !seen[s] {
+			seen[s] = true
+			deduped = append(deduped, s)
+		}
+	}
+	if expiresIn < 0 {
+		return nil, nil, &InvalidAPITokenRequestError{"token expiry can't be in the past"}
+	}
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, nil, fmt.Errorf("error generating api token: %v", err)
+	}
+	token := apiTokenPrefix + base64.RawURLEncoding.EncodeToString(b)
+	t := wardrobe.APIToken{
+		UserId:    userId,
+		Name:      name,
+		Scopes:    deduped,
+		CreatedAt: time.Now(),
+	}
+	if expiresIn > 0 {
+		expiresAt := t.CreatedAt.Add(expiresIn)
+		t.ExpiresAt = &expiresAt
+	}
+	hash := secrets.Hash(token)
+	id, err := wardrobe.CreateAPIToken(ctx, t, hash[:])
+	if err != nil {
+		return nil, nil, err
+	}
+	t.Id = id
+	return &token, &t, nil
+}
+
+// Looks up token, checking it hasn't expired and has every one of scopes. Returns ErrInvalidAPIToken if it
+// isn't a live token, and ErrInsufficientScope if it is but can't be used for scopes.
+func VerifyAPIToken(ctx context.Context, token string, scopes ...Scope) (*wardrobe.APIToken, error) {
+	if !strings.HasPrefix(token, apiTokenPrefix) {
+		return nil, ErrInvalidAPIToken
+	}
+	hash := secrets.Hash(token)
+	t, err := wardrobe.FetchAPITokenByHash(ctx, hash[:])
+	if errors.Is(err, wardrobe.ErrNoAPIToken) {
+		return nil, ErrInvalidAPIToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	if t.ExpiresAt != nil && now.After(*t.ExpiresAt) {
+		return nil, ErrInvalidAPIToken
+	}
+	for _, s := range scopes {
+		if !hasScope(t, s) {
+			return nil, ErrInsufficientScope
+		}
+	}
+	err = wardrobe.TouchAPIToken(ctx, t.Id, now)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func isScope(s string) bool {
+	for _, scope := range AllScopes {
+		if string(scope) == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hasScope(t *wardrobe.APIToken, scope Scope) bool {
+	for _, s := range t.Scopes {
+		if s == string(scope) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,209 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/secrets"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+)
+
+const (
+	// What authenticator apps show the account under
+	totpIssuer = "coattails"
+	// How many recovery codes a user gets at a time
+	recoveryCodeCount = 10
+	// Codes a user can enter within totpAttemptWindow, across every login and session, before they're locked
+	// out for totpLockout. Entering a right one starts the count over.
+	maxTOTPAttempts   = 5
+	totpAttemptWindow = 15 * time.Minute
+	totpLockout       = 30 * time.Minute
+)
+
+var (
+	ErrInvalidCode        = errors.New("invalid two factor code")
+	ErrTOTPAlreadyEnabled = errors.New("two factor authentication is already enabled")
+	ErrTOTPNotEnrolled    = errors.New("two factor authentication enrollment not started")
+	ErrTOTPNotEnabled     = errors.New("two factor authentication is not enabled")
+	ErrTOTPLocked         = errors.New("too many two factor codes entered, try again later")
+)
+
+// What a user needs to add coattails to their authenticator app
+type TOTPEnrollment struct {
+	Secret          string `json:"secret"`
+	ProvisioningURI string `json:"provisioning_uri"`
+}
+
+// Starts TOTP enrollment with a fresh secret. It only takes effect once ConfirmTOTP accepts a code for it.
+func EnrollTOTP(ctx context.Context, userId int) (*TOTPEnrollment, error) {
+	enabled, err := wardrobe.HasTOTPEnabled(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if enabled {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	username, err := wardrobe.FetchUserById(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := secrets.NewTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+	err = wardrobe.UpsertPendingTOTP(ctx, userId, secret)
+	if err != nil {
+		return nil, err
+	}
+	return &TOTPEnrollment{
+		Secret:          secret,
+		ProvisioningURI: secrets.TOTPProvisioningURI(totpIssuer, *username, secret),
+	}, nil
+}
+
+// Turns TOTP on once the user proves their app has the secret, and returns their recovery codes. This is
+// the only time the codes are ever shown.
+func ConfirmTOTP(ctx context.Context, userId int, code string) ([]string, error) {
+	t, err := wardrobe.FetchUserTOTP(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, ErrTOTPNotEnrolled
+	}
+	if t.EnabledAt != nil {
+		return nil, ErrTOTPAlreadyEnabled
+	}
+	step, ok, err := secrets.VerifyTOTP(t.Secret, code, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = wardrobe.EnableTOTP(ctx, userId, step, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// Second step of logging in for users with TOTP enabled. code can be either a TOTP code or one of their
+// recovery codes. Returns the new session's token, and who it's for.
+func LoginTOTP(ctx context.Context, pendingToken string, code string, oldToken string, meta SessionMeta) (*string, int, error) {
+	login, err := wardrobe.FetchPendingLogin(ctx, pendingToken)
+	if err != nil {
+		return nil, 0, err
+	}
+	ok, err := VerifySecondFactor(ctx, login.UserId, code)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !ok {
+		return nil, 0, ErrInvalidCode
+	}
+	err = wardrobe.ClearPendingLogin(ctx, pendingToken)
+	if err != nil {
+		return nil, 0, err
+	}
+	tok, err := RotateSession(ctx, oldToken, login.UserId, true, meta)
+	if err != nil {
+		return nil, 0, err
+	}
+	return tok, login.UserId, nil
+}
+
+// Checks code against the user's TOTP secret, falling back to their unused recovery codes. Either is only
+// accepted once. Returns ErrTOTPLocked if the user has entered too many codes lately.
+func VerifySecondFactor(ctx context.Context, userId int, code string) (bool, error) {
+	t, err := wardrobe.FetchUserTOTP(ctx, userId)
+	if err != nil {
+		return false, err
+	}
+	if t == nil || t.EnabledAt == nil {
+		return false, ErrTOTPNotEnabled
+	}
+	now := time.Now()
+	allowed, err := wardrobe.StartTOTPAttempt(ctx, userId, now, now.Add(-totpAttemptWindow), maxTOTPAttempts, now.Add(totpLockout))
+	if err != nil {
+		return false, err
+	}
+	if !allowed {
+		return false, ErrTOTPLocked
+	}
+	ok, err := verifyCode(ctx, *t, code, now)
+	if err != nil || !ok {
+		return false, err
+	}
+	return true, wardrobe.ResetTOTPAttempts(ctx, userId)
+}
+
+func verifyCode(ctx context.Context, t wardrobe.UserTOTP, code string, now time.Time) (bool, error) {
+	step, ok, err := secrets.VerifyTOTP(t.Secret, code, now)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		return wardrobe.UseTOTPStep(ctx, t.UserId, step)
+	}
+	return wardrobe.UseRecoveryCode(ctx, t.UserId, secrets.HashRecoveryCode(code))
+}
+
+// Turns TOTP off. Takes both the user's password and a code, so a hijacked session alone can't do it.
+func DisableTOTP(ctx context.Context, userId int, password string, code string) error {
+	username, err := wardrobe.FetchUserById(ctx, userId)
+	if err != nil {
+		return err
+	}
+	if _, err = verifyPassword(ctx, *username, password); err != nil {
+		return err
+	}
+	ok, err := VerifySecondFactor(ctx, userId, code)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return ErrInvalidCode
+	}
+	return wardrobe.DeleteTOTP(ctx, userId)
+}
+
+// Replaces the user's recovery codes with new ones, i.e. once they've used up a few
+func RegenerateRecoveryCodes(ctx context.Context, userId int, code string) ([]string, error) {
+	ok, err := VerifySecondFactor(ctx, userId, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidCode
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		return nil, err
+	}
+	err = wardrobe.ReplaceRecoveryCodes(ctx, userId, hashes)
+	if err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+func newRecoveryCodes() ([]string, [][]byte, error) {
+	codes := make([]string, 0)
+	hashes := make([][]byte, 0)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := secrets.NewRecoveryCode()
+		if err != nil {
+			return nil, nil, fmt.Errorf("error generating recovery code: %v", err)
+		}
+		codes = append(codes, code)
+		hashes = append(hashes, secrets.HashRecoveryCode(code))
+	}
+	return codes, hashes, nil
+}
@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/secrets"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+)
+
+// Points wardrobe at a fresh, fully migrated sqlite db
+func newTestDB(t *testing.T) {
+	t.Helper()
+	wardrobe.ConnectSQLite(filepath.Join(t.TempDir(), "wardrobe.db"))
+	t.Cleanup(func() { _ = wardrobe.CloseDB() })
+	wardrobe.InitLocalCache()
+	latest, err := wardrobe.LatestSchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wardrobe.MigrateUp(context.Background(), latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = secrets.SetDataKeys(map[uint32]string{1: "test data key"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Creates a user with TOTP enabled, confirmed with the code for the step before now. Returns their id, secret
+// and recovery codes.
+func newTOTPUser(t *testing.T) (int, string, []string) {
+	t.Helper()
+	ctx := context.Background()
+	newTestDB(t)
+	err := wardrobe.CreateUser(ctx, "alice", []byte("hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	userId, err := wardrobe.FetchUserIdByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	enrollment, err := EnrollTOTP(ctx, *userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, err := ConfirmTOTP(ctx, *userId, totpCode(t, enrollment.Secret, -1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *userId, enrollment.Secret, codes
+}
+
+// The code for secret offset steps from now
+func totpCode(t *testing.T, secret string, offset int64) string {
+	t.Helper()
+	code, err := secrets.TOTPCode(secret, secrets.TOTPStep(time.Now())+offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestVerifySecondFactorSteps(t *testing.T) {
+	ctx := context.Background()
+	userId, secret, _ := newTOTPUser(t)
+	// Run in order against the same user, each code is checked after the ones before it
+	tests := []struct {
+		name   string
+		offset int64
+		wantOk bool
+	}{
+		{"replaying the confirming code", -1, false},
+		{"outside the skew", -2, false},
+		{"current step", 0, true},
+		{"replaying the current step", 0, false},
+		{"earlier step than the last used", -1, false},
+		{"next step", 1, true},
+		{"replaying the next step", 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := VerifySecondFactor(ctx, userId, totpCode(t, secret, tt.offset))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.wantOk {
+				t.Errorf("expected ok to be %v", tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestVerifySecondFactorLockout(t *testing.T) {
+	ctx := context.Background()
+	userId, secret, _ := newTOTPUser(t)
+	wrong := func(t *testing.T) string {
+		// Not a code for any step we'd accept
+		return totpCode(t, secret, -5)
+	}
+	tests := []struct {
+		name    string
+		code    func(t *testing.T) string
+		wantOk  bool
+		wantErr error
+	}{
+		{"1st wrong", wrong, false, nil},
+		{"2nd wrong", wrong, false, nil},
+		{"3rd wrong", wrong, false, nil},
+		{"4th wrong", wrong, false, nil},
+		{"right code starts the count over", func(t *testing.T) string { return totpCode(t, secret, 0) }, true, nil},
+		{"1st wrong again", wrong, false, nil},
+		{"2nd wrong again", wrong, false, nil},
+		{"3rd wrong again", wrong, false, nil},
+		{"4th wrong again", wrong, false, nil},
+		{"5th wrong locks them out", wrong, false, nil},
+		{"right code while locked out", func(t *testing.T) string { return totpCode(t, secret, 1) }, false, ErrTOTPLocked},
+		{"wrong code while locked out", wrong, false, ErrTOTPLocked},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := VerifySecondFactor(ctx, userId, tt.code(t))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if ok != tt.wantOk {
+				t.Errorf("expected ok to be %v", tt.wantOk)
+			}
+		})
+	}
+
+	// Once the lockout's passed they get to try again
+	now := time.Now().Add(totpLockout + time.Second)
+	allowed, err := wardrobe.StartTOTPAttempt(ctx, userId, now, now.Add(-totpAttemptWindow), maxTOTPAttempts, now.Add(totpLockout))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !allowed {
+		t.Error("expected an attempt to be allowed once the lockout's passed")
+	}
+}
+
+func TestVerifySecondFactorRecoveryCodes(t *testing.T) {
+	ctx := context.Background()
+	userId, secret, codes := newTOTPUser(t)
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("expected %d recovery codes, got %d", recoveryCodeCount, len(codes))
+	}
+	tests := []struct {
+		name   string
+		code   string
+		wantOk bool
+	}{
+		{"recovery code", codes[0], true},
+		{"reusing it", codes[0], false},
+		{"retyped in upper case with spaces", strings.ToUpper(strings.ReplaceAll(codes[1], "-", " ")), true},
+		{"reusing the retyped one", codes[1], false},
+		{"someone else's code", "aaaa-bbbb-cccc-dddd", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, err := VerifySecondFactor(ctx, userId, tt.code)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok != tt.wantOk {
+				t.Errorf("expected ok to be %v", tt.wantOk)
+			}
+		})
+	}
+	n, err := wardrobe.CountUnusedRecoveryCodes(ctx, userId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != recoveryCodeCount-2 {
+		t.Errorf("expected %d unused recovery codes, got %d", recoveryCodeCount-2, n)
+	}
+
+	// Regenerating them throws out the old ones
+	fresh, err := RegenerateRecoveryCodes(ctx, userId, totpCode(t, secret, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ok, err := VerifySecondFactor(ctx, userId, codes[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("expected an old recovery code to be refused after regenerating them")
+	}
+	ok, err = VerifySecondFactor(ctx, userId, fresh[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Error("expected a new recovery code to be accepted")
+	}
+}
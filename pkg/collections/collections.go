@@ -1,11 +1,15 @@
 package collections
 
-import "github.com/bluedresscapital/coattails/pkg/wardrobe"
+import (
+	"context"
 
-func FetchCollectionCountsFromTickers(tickers []string) (map[string]int, error) {
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+)
+
+func FetchCollectionCountsFromTickers(ctx context.Context, tickers []string) (map[string]int, error) {
 	counts := make(map[string]int)
 	for _, t := range tickers {
-		collections, err := wardrobe.FetchCollectionsFromTicker(t)
+		collections, err := wardrobe.FetchCollectionsFromTicker(ctx, t)
 		if err != nil {
 			return nil, err
 		}
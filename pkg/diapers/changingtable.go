@@ -1,6 +1,7 @@
 package diapers
 
 import (
+	"context"
 	"fmt"
 	"log"
 
@@ -10,6 +11,7 @@ import (
 	"github.com/bluedresscapital/coattails/pkg/socks"
 	"github.com/bluedresscapital/coattails/pkg/stockings"
 	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/bluedresscapital/coattails/pkg/webhooks"
 )
 
 type Data string
@@ -23,6 +25,14 @@ const (
 
 var depMap map[Data][]Data
 
+// Everything reloading dependencies reads and writes
+type Store interface {
+	portfolios.Store
+	positions.Store
+	socks.Store
+	webhooks.Store
+}
+
 func init() {
 	depMap = map[Data][]Data{
 		Order: {
@@ -36,109 +46,127 @@ func init() {
 	}
 }
 
-func ReloadDepsAndPublish(data Data, portId int, userId int, channel string) error {
+func ReloadDepsAndPublish(ctx context.Context, store Store, data Data, portId int) error {
 	deps, found := depMap[data]
 	if !found {
 		return fmt.Errorf("no callbacks for data %v", data)
 	}
-	for _, dep := range deps {
-		if dep == Position {
-			return reloadPositionsAndPublish(portId, userId, channel)
-		}
-		if dep == Portfolio {
-			return reloadPortfolioAndPublish(portId, userId, channel)
-		}
-	}
-	switch data {
-	case Order:
-		log.Printf("Committing orders for port %d", portId)
-		err := wardrobe.SetOrdersCommitted(portId)
-		if err != nil {
-			return err
-		}
-	case Transfer:
-		log.Printf("Committing transfers for port %d", portId)
-		err := wardrobe.SetTransfersCommitted(portId)
-		if err != nil {
-			return err
-		}
-	default:
-		return fmt.Errorf("unsupported dep change: %v", data)
+	err := reload(ctx, store, deps, portId)
+	if err != nil {
+		return err
 	}
-	return nil
+	return commit(ctx, store, []Data{data}, portId)
 }
 
 // Given a list of data changes, figures out what downstream data we need to reload (just once)
-func BulkReloadDepsAndPublish(data []Data, portId int, userId int, channel string) error {
+func BulkReloadDepsAndPublish(ctx context.Context, store Store, data []Data, portId int) error {
 	log.Printf("changing table bulk reloading %v", data)
 	depSet := make(map[Data]bool)
+	deps := make([]Data, 0)
 	for _, d := range data {
-		deps, found := depMap[d]
-		if found {
-			for _, dep := range deps {
+		for _, dep := range depMap[d] {
+			if !depSet[dep] {
 				depSet[dep] = true
+				deps = append(deps, dep)
 			}
 		}
 	}
-	for d := range depSet {
-		switch d {
+	err := reload(ctx, store, deps, portId)
+	if err != nil {
+		return err
+	}
+	return commit(ctx, store, data, portId)
+}
+
+// Reloads deps in order, which is the order their events go out in
+func reload(ctx context.Context, store Store, deps []Data, portId int) error {
+	for _, dep := range deps {
+		var err error
+		switch dep {
 		case Position:
-			err := reloadPositionsAndPublish(portId, userId, channel)
-			if err != nil {
-				return err
-			}
+			err = reloadPositionsAndPublish(ctx, store, portId)
 		case Portfolio:
-			err := reloadPortfolioAndPublish(portId, userId, channel)
-			if err != nil {
-				return err
-			}
+			err = reloadPortfolioAndPublish(ctx, store, portId)
 		default:
-			return fmt.Errorf("unsupported data change: %v", d)
+			err = fmt.Errorf("unsupported data change: %v", dep)
+		}
+		if err != nil {
+			return err
 		}
 	}
-	for _, d := range data {
-		switch d {
-		case Order:
-			log.Printf("Committing orders for port %d", portId)
-			err := wardrobe.SetOrdersCommitted(portId)
-			if err != nil {
-				return err
-			}
-		case Transfer:
-			log.Printf("Committing transfers for port %d", portId)
-			err := wardrobe.SetTransfersCommitted(portId)
-			if err != nil {
-				return err
+	return nil
+}
+
+// Marks the orders and/or transfers in data as committed, all or nothing
+func commit(ctx context.Context, store Store, data []Data, portId int) error {
+	err := store.WithTx(ctx, func(tx wardrobe.Store) error {
+		for _, d := range data {
+			switch d {
+			case Order:
+				log.Printf("Committing orders for port %d", portId)
+				err := tx.SetOrdersCommitted(ctx, portId)
+				if err != nil {
+					return err
+				}
+			case Transfer:
+				log.Printf("Committing transfers for port %d", portId)
+				err := tx.SetTransfersCommitted(ctx, portId)
+				if err != nil {
+					return err
+				}
+			default:
+				return fmt.Errorf("unsupported dep change: %v", d)
 			}
-		default:
-			return fmt.Errorf("unsupported dep change: %v", d)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, d := range data {
+		if d == Order {
+			emit(ctx, store, portId, webhooks.EventOrdersImported, webhooks.OrdersImported{PortId: portId})
+		} else {
+			emit(ctx, store, portId, webhooks.EventTransfersImported, webhooks.TransfersImported{PortId: portId})
 		}
 	}
 	return nil
 }
 
-func reloadPositionsAndPublish(portId int, userId int, channel string) error {
-	err := positions.Reload(portId, stockings.FingoPack{})
+// Queues a webhook event. Failing to doesn't fail the reload, it's only logged.
+func emit(ctx context.Context, store Store, portId int, eventType string, data interface{}) {
+	err := webhooks.EmitToPortfolio(ctx, store, portId, eventType, data)
+	if err != nil {
+		log.Printf("Error queueing %s webhooks for port %d: %v", eventType, portId, err)
+	}
+}
+
+func reloadPositionsAndPublish(ctx context.Context, store Store, portId int) error {
+	err := positions.Reload(ctx, store, portId, stockings.FingoPack{})
 	if err != nil {
 		log.Printf("Error reloading positions: %v", err)
 		return err
 	}
-	p, err := wardrobe.FetchPositions(userId)
+	ps, err := store.FetchPortfolioPositions(ctx, portId)
 	if err != nil {
 		return err
 	}
-	return socks.PublishFromServer(channel, "LOADED_POSITIONS", p)
+	emit(ctx, store, portId, webhooks.EventPositionsReloaded, webhooks.PositionsReloaded{PortId: portId, Positions: ps})
+	return socks.PublishToPortfolio(ctx, store, portId, socks.TopicPositions, "LOADED_POSITIONS", func(userId int) (interface{}, error) {
+		return store.FetchPositions(ctx, userId)
+	})
 }
 
-func reloadPortfolioAndPublish(portId int, userId int, channel string) error {
-	port, err := wardrobe.FetchPortfolioById(portId)
+func reloadPortfolioAndPublish(ctx context.Context, store Store, portId int) error {
+	port, err := store.FetchPortfolioById(ctx, portId)
 	if err != nil {
 		return err
 	}
-	err = portfolios.ReloadHistory(*port)
+	err = portfolios.ReloadHistory(ctx, store, *port)
 	if err != nil {
 		return err
 	}
+	emit(ctx, store, portId, webhooks.EventPortfolioReloaded, webhooks.PortfolioReloaded{PortId: portId})
 	// TODO add a socket here!
 	return nil
 }
@@ -0,0 +1,166 @@
+package diapers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/socks"
+	"github.com/bluedresscapital/coattails/pkg/util"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/bluedresscapital/coattails/pkg/webhooks"
+	"github.com/shopspring/decimal"
+)
+
+// Runs an imported order and transfer through the whole reload pipeline: positions, then the portfolio's
+// history, then committing them, all against Memory
+func TestBulkReloadDepsAndPublish(t *testing.T) {
+	ctx := context.Background()
+	store, since := newReloadStore(t)
+	err := BulkReloadDepsAndPublish(ctx, store, []Data{Order, Transfer}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectReloaded(t, store, since)
+	expectCommitted(t, "transfers", store.HasUncommittedTransfers)
+}
+
+// Reloading for an order alone has to reload every one of its deps (not just the first) before committing it
+func TestReloadDepsAndPublish(t *testing.T) {
+	ctx := context.Background()
+	store, since := newReloadStore(t)
+	err := ReloadDepsAndPublish(ctx, store, Order, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectReloaded(t, store, since)
+}
+
+// A portfolio with a deposit and a buy from 4 days ago, whose webhook endpoint wants positions.reloaded,
+// portfolio.reloaded and orders.imported. Quotes are seeded for every day so nothing hits the network. Returns the store along with
+// a stream id from before anything was published.
+func newReloadStore(t *testing.T) (*wardrobe.Memory, wardrobe.StreamId) {
+	ctx := context.Background()
+	store := wardrobe.NewMemory()
+	store.AddPortfolio(wardrobe.Portfolio{Id: 1, Name: "Robinhood", Type: "rh", UserId: 1})
+	_, err := store.CreateWebhookEndpoint(ctx, wardrobe.WebhookEndpoint{
+		UserId:     1,
+		URL:        "https://example.com/hook",
+		EventTypes: []string{webhooks.EventPositionsReloaded, webhooks.EventPortfolioReloaded, webhooks.EventOrdersImported},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	today := util.GetTimelessDate(time.Now())
+	start := today.AddDate(0, 0, -4)
+	for d := start; !d.After(today); d = d.AddDate(0, 0, 1) {
+		err := store.UpsertStockQuotePrice(ctx, "AAPL", d, decimal.NewFromInt(10))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = store.InsertIgnoreTransfer(ctx, wardrobe.Transfer{
+		Uid:       "t1",
+		PortId:    1,
+		Amount:    decimal.NewFromInt(100),
+		IsDeposit: true,
+		Date:      start,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = store.InsertIgnoreOrder(ctx, wardrobe.Order{
+		Uid:      "o1",
+		PortId:   1,
+		Stock:    "AAPL",
+		Quantity: decimal.NewFromInt(2),
+		Value:    decimal.NewFromInt(8),
+		IsBuy:    true,
+		Date:     start,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store, wardrobe.StreamId{Ms: uint64(time.Now().Add(-time.Minute).UnixNano() / int64(time.Millisecond))}
+}
+
+// Checks the positions and portfolio values newReloadStore's order and transfer add up to were saved and
+// published, and the order was committed
+func expectReloaded(t *testing.T, store *wardrobe.Memory, since wardrobe.StreamId) {
+	t.Helper()
+	ctx := context.Background()
+	today := util.GetTimelessDate(time.Now())
+	ps, err := store.FetchPortfolioPositions(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := make(map[string]decimal.Decimal)
+	for _, p := range ps {
+		values[p.Stock] = p.Value
+	}
+	expectDecimal(t, "cash position", values["_CASH"], 84)
+	expectDecimal(t, "AAPL position", values["AAPL"], 20)
+
+	pvs, err := store.FetchPortfolioValuesByPortId(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pvs) != 5 {
+		t.Fatalf("expected 5 days of portfolio values, got %d", len(pvs))
+	}
+	last := pvs[len(pvs)-1]
+	if !last.Date.Equal(today) {
+		t.Errorf("expected the last portfolio value on %s, got %s", today, last.Date)
+	}
+	expectDecimal(t, "cash", last.Cash, 84)
+	expectDecimal(t, "stock value", last.StockValue, 20)
+
+	expectCommitted(t, "orders", store.HasUncommittedOrders)
+
+	var types []string
+	for _, d := range store.WebhookDeliveries(1) {
+		types = append(types, d.EventType)
+	}
+	// Positions before the portfolio's history (which is built from them), and both before committing
+	want := []string{webhooks.EventPositionsReloaded, webhooks.EventPortfolioReloaded, webhooks.EventOrdersImported}
+	if strings.Join(types, " ") != strings.Join(want, " ") {
+		t.Errorf("expected %v deliveries, got %v", want, types)
+	}
+
+	replay, err := store.ReadStream(ctx, socks.EventStream(socks.GetChannelFromUserId(1)), since.String(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replay.Events) != 1 {
+		t.Fatalf("expected 1 event published to the owner, got %d", len(replay.Events))
+	}
+	var msg socks.Msg
+	err = json.Unmarshal(replay.Events[0].Payload, &msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Topic != socks.Topic(socks.TopicPositions, 1) || msg.Type != "LOADED_POSITIONS" {
+		t.Errorf("expected LOADED_POSITIONS on the portfolio's positions topic, got %s on %s", msg.Type, msg.Topic)
+	}
+}
+
+func expectCommitted(t *testing.T, name string, hasUncommitted func(context.Context, int) (bool, error)) {
+	t.Helper()
+	found, err := hasUncommitted(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Errorf("expected %s to be committed", name)
+	}
+}
+
+func expectDecimal(t *testing.T, name string, got decimal.Decimal, want int64) {
+	t.Helper()
+	if !got.Equal(decimal.NewFromInt(want)) {
+		t.Errorf("expected %s to be %d, got %s", name, want, got)
+	}
+}
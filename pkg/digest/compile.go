@@ -0,0 +1,255 @@
+package digest
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/portfolios"
+	"github.com/bluedresscapital/coattails/pkg/stockings"
+	"github.com/bluedresscapital/coattails/pkg/util"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/shopspring/decimal"
+)
+
+const (
+	// Per portfolio
+	maxMovers   = 3
+	maxHoldings = 5
+	// Orders listed, past which they're only counted
+	maxOrders = 20
+	// Audit log entries fetched at a time looking for new orders
+	auditPageSize = 100
+)
+
+// Everything compiling a digest reads
+type Store interface {
+	wardrobe.PortfolioStore
+	wardrobe.PositionStore
+	wardrobe.QuoteStore
+	wardrobe.AuditStore
+}
+
+// One user's digest
+type Digest struct {
+	Frequency string
+	// What the changes in it are since
+	Since      time.Time
+	Portfolios []PortfolioSummary
+	Orders     []NewOrder
+	// Orders past maxOrders that aren't listed
+	MoreOrders int
+}
+
+// How one portfolio did over the digest's period
+type PortfolioSummary struct {
+	Name  string
+	Value decimal.Decimal
+	// Not counting deposits or withdrawals
+	Change        decimal.Decimal
+	ChangePercent decimal.Decimal
+	// The positions that moved the portfolio the most, biggest dollar change first
+	Movers []Mover
+	// Biggest first
+	Holdings []Holding
+}
+
+type Mover struct {
+	Stock         string
+	ChangePercent decimal.Decimal
+	// What the move was worth to the portfolio
+	Change decimal.Decimal
+}
+
+type Holding struct {
+	Stock string
+	Value decimal.Decimal
+	// Of the portfolio
+	Percent decimal.Decimal
+}
+
+// An order imported (i.e. synced from a broker) during the digest's period
+type NewOrder struct {
+	Portfolio string
+	wardrobe.Order
+}
+
+// Whether there's anything worth sending
+func (d *Digest) Empty() bool {
+	return len(d.Portfolios) == 0 && len(d.Orders) == 0
+}
+
+var hundred = decimal.NewFromInt(100)
+
+// Compiles userId's digest as of now. Portfolios are summarized from their daily values, so they're as fresh as
+// the last stock reload.
+func Compile(ctx context.Context, store Store, api stockings.StockAPI, userId int, frequency string, now time.Time) (*Digest, error) {
+	days := 1
+	if frequency == FrequencyWeekly {
+		days = 7
+	}
+	d := &Digest{Frequency: frequency, Since: now.AddDate(0, 0, -days)}
+	ports, err := store.FetchPortfoliosByUserId(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	names := make(map[int]string)
+	for _, port := range ports {
+		names[port.Id] = port.Name
+		summary, err := summarize(ctx, store, api, port, days, now)
+		if err != nil {
+			return nil, err
+		}
+		if summary != nil {
+			d.Portfolios = append(d.Portfolios, *summary)
+		}
+	}
+	err = d.addOrders(ctx, store, userId, names)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Returns nil if port doesn't have enough history to say how it did
+func summarize(ctx context.Context, store Store, api stockings.StockAPI, port wardrobe.Portfolio, days int, now time.Time) (*PortfolioSummary, error) {
+	pvs, err := store.FetchPortfolioValuesByPortId(ctx, port.Id)
+	if err != nil {
+		return nil, err
+	}
+	today := util.GetTimelessDate(now)
+	end := -1
+	for i := range pvs {
+		if !pvs[i].Date.After(today) {
+			end = i
+		}
+	}
+	if end < 1 {
+		return nil, nil
+	}
+	// Daily digests compare against the trading day before, weekly ones against a week before
+	start := end - 1
+	if days > 1 {
+		cutoff := pvs[end].Date.AddDate(0, 0, -days)
+		for start > 0 && pvs[start].Date.After(cutoff) {
+			start--
+		}
+	}
+	first, last := pvs[start], pvs[end]
+	deposited := decimal.Zero
+	for _, pv := range pvs[start+1 : end+1] {
+		deposited = deposited.Add(pv.DailyNetDeposited)
+	}
+	s := &PortfolioSummary{
+		Name:  port.Name,
+		Value: last.Cash.Add(last.StockValue),
+	}
+	s.Change = s.Value.Sub(first.Cash.Add(first.StockValue)).Sub(deposited)
+	// Cumulative changes are already normalized for deposits and withdrawals
+	if first.CumChange.IsPositive() {
+		s.ChangePercent = last.CumChange.Div(first.CumChange).Sub(decimal.NewFromInt(1)).Mul(hundred).Round(2)
+	}
+	positions, err := store.FetchPortfolioPositions(ctx, port.Id)
+	if err != nil {
+		return nil, err
+	}
+	s.Holdings = holdings(positions)
+	s.Movers = movers(ctx, store, api, positions, first.Date, last.Date)
+	return s, nil
+}
+
+func holdings(positions []wardrobe.Position) []Holding {
+	total := decimal.Zero
+	hs := make([]Holding, 0)
+	for _, p := range positions {
+		total = total.Add(p.Value)
+		if p.Stock == portfolios.CASH || p.Quantity.IsZero() {
+			continue
+		}
+		hs = append(hs, Holding{Stock: p.Stock, Value: p.Value})
+	}
+	if !total.IsPositive() {
+		return nil
+	}
+	sort.Slice(hs, func(i, j int) bool {
+		return hs[i].Value.GreaterThan(hs[j].Value)
+	})
+	if len(hs) > maxHoldings {
+		hs = hs[:maxHoldings]
+	}
+	for i := range hs {
+		hs[i].Percent = hs[i].Value.Div(total).Mul(hundred).Round(2)
+	}
+	return hs
+}
+
+// Stock positions' price changes from start to end. Options are left out, we don't keep their history.
+func movers(ctx context.Context, store Store, api stockings.StockAPI, positions []wardrobe.Position, start time.Time, end time.Time) []Mover {
+	ms := make([]Mover, 0)
+	for _, p := range positions {
+		if p.Stock == portfolios.CASH || p.Option != nil || p.Quantity.IsZero() {
+			continue
+		}
+		prices, err := stockings.GetHistoricalRange(ctx, store, api, p.Stock, start, end)
+		if err != nil {
+			log.Printf("Error fetching prices of %s for digest: %v", p.Stock, err)
+			continue
+		}
+		if len(*prices) == 0 {
+			continue
+		}
+		from, to := (*prices)[0].Price, (*prices)[len(*prices)-1].Price
+		if !from.IsPositive() {
+			continue
+		}
+		ms = append(ms, Mover{
+			Stock:         p.Stock,
+			ChangePercent: to.Sub(from).Div(from).Mul(hundred).Round(2),
+			Change:        to.Sub(from).Mul(p.Quantity),
+		})
+	}
+	sort.Slice(ms, func(i, j int) bool {
+		return ms[i].Change.Abs().GreaterThan(ms[j].Change.Abs())
+	})
+	if len(ms) > maxMovers {
+		ms = ms[:maxMovers]
+	}
+	return ms
+}
+
+// Adds the orders imported into the user's portfolios since the digest's start, from the audit log. Orders they
+// added by hand aren't news to them, so are left out.
+func (d *Digest) addOrders(ctx context.Context, store Store, userId int, names map[int]string) error {
+	beforeId := 0
+	for {
+		entries, err := store.FetchAuditLog(ctx, wardrobe.AuditQuery{UserId: userId, BeforeId: beforeId, Limit: auditPageSize})
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.CreatedAt.Before(d.Since) {
+				return nil
+			}
+			// Orders into a portfolio that's since been deleted aren't worth mentioning
+			if e.Entity != wardrobe.AuditOrder || e.Action != wardrobe.AuditCreate || e.Source == wardrobe.SourceManual || e.PortId == nil {
+				continue
+			}
+			if len(d.Orders) >= maxOrders {
+				d.MoreOrders++
+				continue
+			}
+			var o wardrobe.Order
+			err = json.Unmarshal(e.After, &o)
+			if err != nil {
+				return err
+			}
+			d.Orders = append(d.Orders, NewOrder{Portfolio: names[*e.PortId], Order: o})
+		}
+		if len(entries) < auditPageSize {
+			return nil
+		}
+		beforeId = entries[len(entries)-1].Id
+	}
+}
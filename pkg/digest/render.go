@@ -0,0 +1,77 @@
+package digest
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/mailer"
+	"github.com/shopspring/decimal"
+)
+
+//go:embed templates/*
+var templates embed.FS
+
+var funcs = map[string]interface{}{
+	"money":         money,
+	"signedMoney":   signedMoney,
+	"signedPercent": signedPercent,
+	"date":          func(t time.Time) string { return t.Format("Mon Jan 2") },
+	"color": func(d decimal.Decimal) string {
+		if d.IsNegative() {
+			return "#c0392b"
+		}
+		return "#27ae60"
+	},
+}
+
+var (
+	textTemplate = texttemplate.Must(texttemplate.New("digest.txt").Funcs(funcs).ParseFS(templates, "templates/digest.txt"))
+	htmlTemplate = htmltemplate.Must(htmltemplate.New("digest.html").Funcs(funcs).ParseFS(templates, "templates/digest.html"))
+)
+
+func money(d decimal.Decimal) string {
+	if d.IsNegative() {
+		return "-$" + d.Abs().StringFixed(2)
+	}
+	return "$" + d.StringFixed(2)
+}
+
+func signedMoney(d decimal.Decimal) string {
+	if d.IsNegative() {
+		return money(d)
+	}
+	return "+" + money(d)
+}
+
+func signedPercent(d decimal.Decimal) string {
+	if d.IsNegative() {
+		return d.StringFixed(2) + "%"
+	}
+	return "+" + d.StringFixed(2) + "%"
+}
+
+// Renders d as an email to to, in both plain text and html
+func (d *Digest) Message(to string) (*mailer.Message, error) {
+	var text, html bytes.Buffer
+	err := textTemplate.Execute(&text, d)
+	if err != nil {
+		return nil, err
+	}
+	err = htmlTemplate.Execute(&html, d)
+	if err != nil {
+		return nil, err
+	}
+	subject := "Your daily coattails digest"
+	if d.Frequency == FrequencyWeekly {
+		subject = "Your weekly coattails digest"
+	}
+	return &mailer.Message{
+		To:      []string{to},
+		Subject: subject,
+		Text:    text.String(),
+		HTML:    html.String(),
+	}, nil
+}
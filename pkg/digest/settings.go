@@ -0,0 +1,100 @@
+package digest
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/mailer"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+)
+
+const (
+	// Every weekday, about that day
+	FrequencyDaily = "daily"
+	// Once a week, about the week before
+	FrequencyWeekly = "weekly"
+)
+
+// How late a digest can still go out, i.e. when the job missed a run. Anything later waits for the next one.
+const catchUpWindow = 6 * time.Hour
+
+var est, _ = time.LoadLocation("EST")
+
+type InvalidSettingsError struct {
+	msg string
+}
+
+func (e *InvalidSettingsError) Error() string {
+	return e.msg
+}
+
+func invalid(format string, a ...interface{}) error {
+	return &InvalidSettingsError{fmt.Sprintf(format, a...)}
+}
+
+// What saving settings reads and writes
+type SettingsStore interface {
+	wardrobe.DigestStore
+	wardrobe.EmailStore
+}
+
+// Validates and saves s, opting its user in (or changing how they get their digest). s.Email has to be one
+// they've verified.
+func SaveSettings(ctx context.Context, store SettingsStore, s wardrobe.DigestSettings) error {
+	if !mailer.ValidAddress(s.Email) {
+		return invalid("invalid email")
+	}
+	verified, err := store.IsEmailVerified(ctx, s.UserId, s.Email)
+	if err != nil {
+		return err
+	}
+	if !verified {
+		return invalid("%s isn't verified, add it under /auth/emails first", s.Email)
+	}
+	if s.Frequency != FrequencyDaily && s.Frequency != FrequencyWeekly {
+		return invalid("unknown frequency %q, expected %s or %s", s.Frequency, FrequencyDaily, FrequencyWeekly)
+	}
+	if s.SendHour < 0 || s.SendHour > 23 {
+		return invalid("send_hour must be between 0 and 23")
+	}
+	if s.Frequency == FrequencyWeekly && (s.SendWeekday < 0 || s.SendWeekday > 6) {
+		return invalid("send_weekday must be between 0 (sunday) and 6 (saturday)")
+	}
+	if s.Frequency == FrequencyDaily {
+		s.SendWeekday = 0
+	}
+	s.CreatedAt = time.Now()
+	return store.UpsertDigestSettings(ctx, s)
+}
+
+// Returns the latest time s's digest was scheduled for, as of now. Daily digests only go out on weekdays, since
+// there's nothing new to say about the weekend.
+func Slot(s wardrobe.DigestSettings, now time.Time) time.Time {
+	now = now.In(est)
+	y, m, d := now.Date()
+	slot := time.Date(y, m, d, s.SendHour, 0, 0, 0, est)
+	if slot.After(now) {
+		slot = slot.AddDate(0, 0, -1)
+	}
+	for !scheduledOn(s, slot.Weekday()) {
+		slot = slot.AddDate(0, 0, -1)
+	}
+	return slot
+}
+
+func scheduledOn(s wardrobe.DigestSettings, day time.Weekday) bool {
+	if s.Frequency == FrequencyWeekly {
+		return day == time.Weekday(s.SendWeekday)
+	}
+	return day != time.Saturday && day != time.Sunday
+}
+
+// Returns whether s's digest should go out now, along with the slot it's for
+func Due(s wardrobe.DigestSettings, now time.Time) (time.Time, bool) {
+	slot := Slot(s, now)
+	if now.Sub(slot) > catchUpWindow {
+		return slot, false
+	}
+	return slot, s.LastSentAt == nil || s.LastSentAt.Before(slot)
+}
@@ -0,0 +1,56 @@
+package links
+
+import (
+	"context"
+	"log"
+
+	"github.com/bluedresscapital/coattails/pkg/socks"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+)
+
+type LinkBrokenEvent struct {
+	Broker    wardrobe.Broker `json:"broker"`
+	AccountId int             `json:"account_id"`
+	PortId    int             `json:"port_id"`
+	Error     string          `json:"error"`
+}
+
+// Records that we successfully used the account's tokens. Errors are only logged, since failing to record
+// link health should never fail the request that just succeeded.
+func Healthy(ctx context.Context, broker wardrobe.Broker, accountId int) {
+	err := wardrobe.MarkLinkHealthy(ctx, broker, accountId)
+	if err != nil {
+		log.Printf("Error marking %s account %d healthy: %v", broker, accountId, err)
+	}
+}
+
+// Records that the account's tokens stopped working, and tells the user (once) that they need to re-link it
+func Broken(ctx context.Context, broker wardrobe.Broker, accountId int, userId int, linkErr error) {
+	log.Printf("%s account %d link broken: %v", broker, accountId, linkErr)
+	transitioned, err := wardrobe.MarkLinkBroken(ctx, broker, accountId, linkErr)
+	if err != nil {
+		log.Printf("Error marking %s account %d broken: %v", broker, accountId, err)
+		return
+	}
+	if !transitioned {
+		return
+	}
+	event := LinkBrokenEvent{
+		Broker:    broker,
+		AccountId: accountId,
+		Error:     linkErr.Error(),
+	}
+	var port *wardrobe.Portfolio
+	if broker == wardrobe.TDA {
+		port, err = wardrobe.FetchPortfolioByTDAccountId(ctx, accountId)
+	} else {
+		port, err = wardrobe.FetchPortfolioByRHAccountId(ctx, accountId)
+	}
+	if err == nil {
+		event.PortId = port.Id
+	}
+	err = socks.PublishToUser(ctx, userId, "LINK_BROKEN", event)
+	if err != nil {
+		log.Printf("Error publishing broken link for %s account %d: %v", broker, accountId, err)
+	}
+}
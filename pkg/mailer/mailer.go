@@ -0,0 +1,200 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// How long we give the smtp server to take a message when the caller's context has no deadline
+const sendTimeout = 30 * time.Second
+
+// Which smtp server to send through. The password only ever comes from $SMTP_PASSWORD, so it doesn't show
+// up in ps.
+type Config struct {
+	Host     string
+	Port     int
+	Username string
+	From     string
+}
+
+// Registers the flags every binary that sends email configures its smtp server with
+func RegisterFlags(fs *flag.FlagSet) *Config {
+	c := &Config{}
+	fs.StringVar(&c.Host, "smtp-host", "", "smtp server to send email through. Email is disabled if unset")
+	fs.IntVar(&c.Port, "smtp-port", 587, "smtp server port")
+	fs.StringVar(&c.Username, "smtp-user", "", "smtp username, authenticated with $SMTP_PASSWORD. No auth if unset")
+	fs.StringVar(&c.From, "smtp-from", "coattails <noreply@bluedresscapital.com>", "address email is sent from")
+	return c
+}
+
+// Returns nil if no smtp server is configured
+func (c *Config) New() (*Mailer, error) {
+	if c.Host == "" {
+		return nil, nil
+	}
+	from, err := mail.ParseAddress(c.From)
+	if err != nil {
+		return nil, fmt.Errorf("invalid smtp-from %q: %v", c.From, err)
+	}
+	m := &Mailer{addr: net.JoinHostPort(c.Host, strconv.Itoa(c.Port)), host: c.Host, from: from}
+	if c.Username != "" {
+		m.auth = smtp.PlainAuth("", c.Username, os.Getenv("SMTP_PASSWORD"), c.Host)
+	}
+	return m, nil
+}
+
+type Mailer struct {
+	addr string
+	host string
+	from *mail.Address
+	auth smtp.Auth
+}
+
+// An email, sent as plain text, or as both plain text and html when HTML is set
+type Message struct {
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+}
+
+// Checks addr is a single plain address we can send to
+func ValidAddress(addr string) bool {
+	a, err := mail.ParseAddress(addr)
+	return err == nil && a.Address == addr
+}
+
+func (m *Mailer) Send(ctx context.Context, msg Message) error {
+	if len(msg.To) == 0 {
+		return errors.New("email has no recipients")
+	}
+	for _, to := range msg.To {
+		if !ValidAddress(to) {
+			return fmt.Errorf("invalid recipient %q", to)
+		}
+	}
+	body, err := m.render(msg)
+	if err != nil {
+		return err
+	}
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sendTimeout)
+		defer cancel()
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", m.addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	c, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		_ = conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		err = c.StartTLS(&tls.Config{ServerName: m.host})
+		if err != nil {
+			return err
+		}
+	}
+	if m.auth != nil {
+		err = c.Auth(m.auth)
+		if err != nil {
+			return err
+		}
+	}
+	err = c.Mail(m.from.Address)
+	if err != nil {
+		return err
+	}
+	for _, to := range msg.To {
+		err = c.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// Renders msg as a MIME message
+func (m *Mailer) render(msg Message) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", m.from.String())
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(msg.To, ", "))
+	// Encoding the subject also keeps anything in it from being read as another header
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	if msg.HTML == "" {
+		err := writePart(&b, "text/plain", msg.Text)
+		return b.Bytes(), err
+	}
+	boundary, err := newBoundary()
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(&b, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", boundary)
+	// Clients show the last part they can render, so html goes last
+	for _, part := range []struct{ contentType, body string }{{"text/plain", msg.Text}, {"text/html", msg.HTML}} {
+		fmt.Fprintf(&b, "--%s\r\n", boundary)
+		err = writePart(&b, part.contentType, part.body)
+		if err != nil {
+			return nil, err
+		}
+		b.WriteString("\r\n")
+	}
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+	return b.Bytes(), nil
+}
+
+func writePart(b *bytes.Buffer, contentType string, body string) error {
+	fmt.Fprintf(b, "Content-Type: %s; charset=utf-8\r\n", contentType)
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	w := quotedprintable.NewWriter(b)
+	_, err := w.Write([]byte(body))
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+func newBoundary() (string, error) {
+	buf := make([]byte, 16)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
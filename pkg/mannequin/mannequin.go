@@ -0,0 +1,249 @@
+// Mannequin stands in for the broker apis. It records real broker responses into sanitized json fixtures,
+// and replays them later so we can run order and transfer reloads without live credentials.
+package mannequin
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type Mode string
+
+const (
+	Off    Mode = ""
+	Record Mode = "record"
+	Replay Mode = "replay"
+)
+
+const redacted = "REDACTED"
+
+// Json fields we never write to disk. Token fields are redacted rather than dropped, since callers check
+// that they're non-empty.
+var sensitiveFields = map[string]bool{
+	"access_token":   true,
+	"refresh_token":  true,
+	"id_token":       true,
+	"password":       true,
+	"username":       true,
+	"device_token":   true,
+	"mfa_code":       true,
+	"accountId":      true,
+	"account_number": true,
+	"accountNumber":  true,
+	"email":          true,
+}
+
+var (
+	// Account numbers show up in TD paths, so we mask any long run of digits
+	accountNumberRegex = regexp.MustCompile(`[0-9]{6,}`)
+	unsafeFileRegex    = regexp.MustCompile(`[^a-zA-Z0-9]+`)
+)
+
+type Fixture struct {
+	Method      string          `json:"method"`
+	Url         string          `json:"url"`
+	Status      int             `json:"status"`
+	ContentType string          `json:"content_type"`
+	Body        json.RawMessage `json:"body"`
+}
+
+// Transport is an http.RoundTripper that either records responses from Next into Dir, or replays
+// previously recorded responses from Dir without touching the network.
+type Transport struct {
+	Dir  string
+	Mode Mode
+	Next http.RoundTripper
+}
+
+// Returns the transport for mode, or nil (i.e. the default transport) if mode is Off
+func NewTransport(dir string, mode Mode) (http.RoundTripper, error) {
+	switch mode {
+	case Off:
+		return nil, nil
+	case Record:
+		err := os.MkdirAll(dir, 0755)
+		if err != nil {
+			return nil, err
+		}
+	case Replay:
+		if _, err := os.Stat(dir); err != nil {
+			return nil, fmt.Errorf("fixtures dir %s: %v", dir, err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid fixtures mode %s", mode)
+	}
+	return &Transport{Dir: dir, Mode: mode, Next: http.DefaultTransport}, nil
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := filepath.Join(t.Dir, FixtureName(req))
+	if t.Mode == Replay {
+		return t.replay(req, path)
+	}
+	resp, err := t.Next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	// Hand the caller the real body, only the fixture gets sanitized
+	resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+	err = t.record(req, resp, body, path)
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (t *Transport) record(req *http.Request, resp *http.Response, body []byte, path string) error {
+	f := Fixture{
+		Method:      req.Method,
+		Url:         sanitizeUrl(req),
+		Status:      resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        Sanitize(body),
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
+
+func (t *Transport) replay(req *http.Request, path string) (*http.Response, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("no fixture recorded for %s %s: %v", req.Method, sanitizeUrl(req), err)
+	}
+	var f Fixture
+	err = json.Unmarshal(data, &f)
+	if err != nil {
+		return nil, err
+	}
+	body := []byte(f.Body)
+	// Non json bodies are stored as json strings
+	var s string
+	if json.Unmarshal(body, &s) == nil {
+		body = []byte(s)
+	}
+	header := make(http.Header)
+	if f.ContentType != "" {
+		header.Set("Content-Type", f.ContentType)
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", f.Status, http.StatusText(f.Status)),
+		StatusCode:    f.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// Fixtures are keyed on the method, path and query, ignoring the host (so absolute urls handed back by the
+// broker still match). Anything but a GET is keyed on its sanitized body too, so i.e. a refresh token grant
+// and a code grant to the same token endpoint get their own fixtures, while the tokens in them (which change on
+// every call) don't matter.
+func FixtureName(req *http.Request) string {
+	u := sanitizeUrl(req)
+	name := accountNumberRegex.ReplaceAllString(req.Method+"_"+req.URL.Path, "N")
+	name = strings.Trim(unsafeFileRegex.ReplaceAllString(name, "_"), "_")
+	// Paths alone collide across pages, so the query goes into a short hash
+	key := req.Method + " " + u
+	if req.Method != http.MethodGet {
+		key += " " + sanitizeRequestBody(req)
+	}
+	hash := sha1.Sum([]byte(key))
+	return fmt.Sprintf("%s_%x.json", name, hash[:5])
+}
+
+// Returns req's body with every sensitive field redacted, leaving req's body to be read again
+func sanitizeRequestBody(req *http.Request) string {
+	if req.Body == nil || req.Body == http.NoBody {
+		return ""
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	if err != nil || len(body) == 0 {
+		return ""
+	}
+	if strings.HasPrefix(req.Header.Get("Content-Type"), "application/x-www-form-urlencoded") {
+		form, err := url.ParseQuery(string(body))
+		if err == nil {
+			for k := range form {
+				if sensitiveFields[k] {
+					form.Set(k, redacted)
+				}
+			}
+			// Encode sorts by key, so the same form always hashes the same
+			return form.Encode()
+		}
+	}
+	return string(Sanitize(body))
+}
+
+func sanitizeUrl(req *http.Request) string {
+	u := accountNumberRegex.ReplaceAllString(req.URL.Path, "N")
+	q := req.URL.Query()
+	for k := range q {
+		if sensitiveFields[k] {
+			q.Set(k, redacted)
+		}
+	}
+	if len(q) > 0 {
+		u = fmt.Sprintf("%s?%s", u, q.Encode())
+	}
+	return u
+}
+
+// Returns body with every sensitive field redacted. Bodies that aren't json get stored as a json string.
+func Sanitize(body []byte) json.RawMessage {
+	var v interface{}
+	if err := json.Unmarshal(body, &v); err != nil {
+		s, _ := json.Marshal(string(body))
+		return s
+	}
+	ret, err := json.Marshal(sanitizeValue(v))
+	if err != nil {
+		s, _ := json.Marshal(string(body))
+		return s
+	}
+	return ret
+}
+
+func sanitizeValue(v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, child := range val {
+			if sensitiveFields[k] {
+				val[k] = redacted
+			} else {
+				val[k] = sanitizeValue(child)
+			}
+		}
+		return val
+	case []interface{}:
+		for i, child := range val {
+			val[i] = sanitizeValue(child)
+		}
+		return val
+	default:
+		return v
+	}
+}
@@ -0,0 +1,212 @@
+package mannequin
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"nothing sensitive", `{"symbol":"AAPL","quantity":2}`, `{"quantity":2,"symbol":"AAPL"}`},
+		{"tokens", `{"access_token":"abc","refresh_token":"def","expires_in":300}`, `{"access_token":"REDACTED","expires_in":300,"refresh_token":"REDACTED"}`},
+		{"numeric account id", `{"accountId":123456789}`, `{"accountId":"REDACTED"}`},
+		{"nested", `{"securitiesAccount":{"accountId":"123456789","type":"MARGIN"}}`, `{"securitiesAccount":{"accountId":"REDACTED","type":"MARGIN"}}`},
+		{"in an array", `[{"email":"a@b.c"},{"email":"d@e.f","id":1}]`, `[{"email":"REDACTED"},{"email":"REDACTED","id":1}]`},
+		{"sensitive object", `{"username":{"first":"a"}}`, `{"username":"REDACTED"}`},
+		{"not json", `Service Unavailable`, `"Service Unavailable"`},
+		{"empty", ``, `""`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(Sanitize([]byte(tt.body)))
+			if got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestSanitizeUrl(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"plain", "https://api.example.com/v1/orders", "/v1/orders"},
+		{"account number in the path", "https://api.example.com/v1/accounts/123456789/orders", "/v1/accounts/N/orders"},
+		{"short numbers stay", "https://api.example.com/v1/orders?page=12345", "/v1/orders?page=12345"},
+		{"sensitive query", "https://api.example.com/v1/orders?accountId=42&page=2", "/v1/orders?accountId=REDACTED&page=2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			if got := sanitizeUrl(req); got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestFixtureName(t *testing.T) {
+	form := func(body string) *http.Request {
+		req := httptest.NewRequest("POST", "https://api.example.com/v1/oauth2/token", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		return req
+	}
+	tests := []struct {
+		name     string
+		a        *http.Request
+		b        *http.Request
+		wantSame bool
+	}{
+		{
+			"other host",
+			httptest.NewRequest("GET", "https://api.example.com/v1/orders", nil),
+			httptest.NewRequest("GET", "https://other.example.com/v1/orders", nil),
+			true,
+		},
+		{
+			"other account",
+			httptest.NewRequest("GET", "https://api.example.com/v1/accounts/123456789/orders", nil),
+			httptest.NewRequest("GET", "https://api.example.com/v1/accounts/987654321/orders", nil),
+			true,
+		},
+		{
+			"other page",
+			httptest.NewRequest("GET", "https://api.example.com/v1/orders?page=1", nil),
+			httptest.NewRequest("GET", "https://api.example.com/v1/orders?page=2", nil),
+			false,
+		},
+		{
+			"other method",
+			httptest.NewRequest("GET", "https://api.example.com/v1/orders", nil),
+			httptest.NewRequest("DELETE", "https://api.example.com/v1/orders", nil),
+			false,
+		},
+		{
+			"other refresh token",
+			form("grant_type=refresh_token&refresh_token=abc"),
+			form("refresh_token=def&grant_type=refresh_token"),
+			true,
+		},
+		{
+			"other grant",
+			form("grant_type=refresh_token&refresh_token=abc"),
+			form("grant_type=authorization_code&code=abc"),
+			false,
+		},
+		{
+			"other json password",
+			httptest.NewRequest("POST", "https://api.example.com/v1/login", strings.NewReader(`{"username":"a","password":"b"}`)),
+			httptest.NewRequest("POST", "https://api.example.com/v1/login", strings.NewReader(`{"username":"c","password":"d"}`)),
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := FixtureName(tt.a), FixtureName(tt.b)
+			if (a == b) != tt.wantSame {
+				t.Errorf("expected names to match to be %v, got %s and %s", tt.wantSame, a, b)
+			}
+			if strings.Contains(a, "123456789") || strings.Contains(b, "987654321") {
+				t.Errorf("expected account numbers to be masked, got %s and %s", a, b)
+			}
+		})
+	}
+
+	// Naming a request leaves its body for the request itself
+	req := form("grant_type=refresh_token&refresh_token=abc")
+	FixtureName(req)
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "grant_type=refresh_token&refresh_token=abc" {
+		t.Errorf("expected the body to be untouched, got %s", body)
+	}
+}
+
+func TestTransportRecordReplay(t *testing.T) {
+	const body = `{"access_token":"live token","accountId":"123456789","orders":[{"symbol":"AAPL"}]}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte(body))
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	get := func(t *testing.T, mode Mode) (*http.Response, string) {
+		t.Helper()
+		transport, err := NewTransport(dir, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL + "/v1/accounts/123456789/orders")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(b)
+	}
+
+	// Recording hands back the real response, and only the fixture is sanitized
+	_, got := get(t, Record)
+	if got != body {
+		t.Errorf("expected the live body while recording, got %s", got)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(paths) != 1 {
+		t.Fatalf("expected 1 fixture, got %v", paths)
+	}
+	fixture, err := ioutil.ReadFile(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"live token", "123456789"} {
+		if strings.Contains(string(fixture), secret) || strings.Contains(paths[0], secret) {
+			t.Errorf("expected %q to be sanitized out of %s: %s", secret, paths[0], fixture)
+		}
+	}
+
+	// Replaying doesn't need the server
+	server.Close()
+	resp, got := get(t, Replay)
+	var compact bytes.Buffer
+	err = json.Compact(&compact, []byte(got))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"access_token":"REDACTED","accountId":"REDACTED","orders":[{"symbol":"AAPL"}]}`
+	if compact.String() != want {
+		t.Errorf("expected %s, got %s", want, compact.String())
+	}
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("expected the recorded status and content type, got %d and %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	transport, err := NewTransport(dir, Replay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = (&http.Client{Transport: transport}).Get(server.URL + "/v1/transfers")
+	if err == nil || !strings.Contains(err.Error(), "no fixture recorded for GET /v1/transfers") {
+		t.Errorf("expected a missing fixture error, got %v", err)
+	}
+}
@@ -1,44 +1,70 @@
 package orders
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"time"
 
 	"github.com/bluedresscapital/coattails/pkg/stockings"
 	"github.com/bluedresscapital/coattails/pkg/util"
 	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/shopspring/decimal"
 )
 
 type OrderAPI interface {
-	GetOrders() ([]wardrobe.Order, error)
+	GetOrders(ctx context.Context) ([]wardrobe.Order, error)
+}
+
+// Implemented by order apis that only fetch what's new since their last sync. CommitOrderSync is called once
+// the orders returned by GetOrders are saved, so a failed reload gets retried from the same spot.
+type SyncedOrderAPI interface {
+	OrderAPI
+	CommitOrderSync(ctx context.Context) error
+}
+
+// Everything ReloadOrders reads and writes
+type Store interface {
+	wardrobe.OrderStore
+	wardrobe.TransferStore
+	wardrobe.OptionStore
+	wardrobe.QuoteStore
 }
 
 // Reloads orders via orderAPI, and reloads order dependents if there are changes
 // in the orders
-func ReloadOrders(order OrderAPI, stock stockings.StockAPI) (bool, error) {
-	orders, err := order.GetOrders()
+func ReloadOrders(ctx context.Context, store Store, order OrderAPI, stock stockings.StockAPI) (bool, error) {
+	orders, err := order.GetOrders(ctx)
 	if err != nil {
 		return false, err
 	}
 	if orders == nil || len(orders) == 0 {
-		return false, nil
+		return false, commitSync(ctx, order)
 	}
 	var portId int
 	for _, o := range orders {
 		portId = o.PortId
-		err = wardrobe.InsertIgnoreOrder(o)
+		err = store.InsertIgnoreOrder(ctx, o)
 		if err != nil {
 			return false, err
 		}
 	}
-	zeroValOrders, err := wardrobe.FetchZeroPriceOrdersByPortfolioId(portId)
+	err = commitSync(ctx, order)
+	if err != nil {
+		return false, err
+	}
+	zeroValOrders, err := store.FetchZeroPriceOrdersByPortfolioId(ctx, portId)
 	if len(zeroValOrders) > 0 {
 		log.Print("Detected NEW orders with zero values, fetching stock prices for them...")
 		for _, o := range zeroValOrders {
+			// Expired/assigned/exercised option contracts are legitimately closed out at zero
+			if o.OptionEvent.ClosesAtZero() {
+				continue
+			}
 			// NOTE - assume if our order price is ZERO, that must indicate we transferred the asset
 			// If this assumption ever changes, PLEASE UPDATE THIS CODE!!
 			if o.Value.IsZero() {
-				price, err := stockings.GetHistoricalPrice(stock, o.Stock, o.Date)
+				price, err := getHistoricalPrice(ctx, store, stock, o.Stock, o.Date)
 				if err != nil {
 					return false, fmt.Errorf("unable to get a price for stock %s at date %s: %v", o.Stock, o.Date, err)
 				}
@@ -46,25 +72,60 @@ func ReloadOrders(order OrderAPI, stock stockings.StockAPI) (bool, error) {
 					return false, fmt.Errorf("price for %s on %s is still 0, erroring out", o.Stock, o.Date)
 				}
 				o.Value = *price
+				multiplier, err := getMultiplier(ctx, store, o.Stock)
+				if err != nil {
+					return false, err
+				}
 				// Treat transferred assets as a simple deposit of $(price), and then buying the asset at $(price).
 				t := wardrobe.Transfer{
 					Uid:           fmt.Sprintf("TRANSFER_FROM_ASSETS__%s", o.Uid),
 					PortId:        portId,
-					Amount:        o.Quantity.Mul(*price),
+					Amount:        o.Quantity.Mul(*price).Mul(multiplier),
 					IsDeposit:     true,
 					ManuallyAdded: false, // Not sure if this counts as manually adding, but oh well :D
 					Date:          util.GetTimelessDate(o.Date),
 				}
-				err = wardrobe.InsertIgnoreTransfer(t)
+				err = store.InsertIgnoreTransfer(ctx, t)
 				if err != nil {
 					log.Printf("Error upserting transfer: %v, not erroring out tho", err)
 				}
-				err = wardrobe.UpsertOrder(o)
+				err = store.UpsertOrder(ctx, o)
 				if err != nil {
 					log.Printf("Error upserting order: %v", err)
 				}
 			}
 		}
 	}
-	return wardrobe.HasUncommittedOrders(portId)
+	return store.HasUncommittedOrders(ctx, portId)
+}
+
+// Prices ticker on date, whether it's a stock or an option contract
+func getHistoricalPrice(ctx context.Context, store Store, stock stockings.StockAPI, ticker string, date time.Time) (*decimal.Decimal, error) {
+	opt, err := store.FetchOption(ctx, ticker)
+	if err != nil {
+		return nil, err
+	}
+	if opt != nil {
+		return stockings.GetOptionPrice(ctx, store, stock, *opt, date)
+	}
+	return stockings.GetHistoricalPrice(ctx, store, stock, ticker, date)
+}
+
+func getMultiplier(ctx context.Context, store wardrobe.OptionStore, ticker string) (decimal.Decimal, error) {
+	opt, err := store.FetchOption(ctx, ticker)
+	if err != nil {
+		return decimal.Zero, err
+	}
+	if opt == nil {
+		return decimal.NewFromInt(1), nil
+	}
+	return wardrobe.Multiplier(map[string]wardrobe.Option{ticker: *opt}, ticker), nil
+}
+
+func commitSync(ctx context.Context, order OrderAPI) error {
+	synced, ok := order.(SyncedOrderAPI)
+	if !ok {
+		return nil
+	}
+	return synced.CommitOrderSync(ctx)
 }
@@ -1,6 +1,7 @@
 package portfolios
 
 import (
+	"context"
 	"errors"
 	"log"
 	"time"
@@ -17,13 +18,27 @@ const (
 	DAILY_NET_DEPOSITED = "_DAILY_NET_DEPOSITED"
 )
 
-func ReloadHistory(portfolio wardrobe.Portfolio) error {
+// Everything the portfolio reloads read and write
+type Store interface {
+	wardrobe.OrderStore
+	wardrobe.TransferStore
+	wardrobe.OptionStore
+	wardrobe.PositionStore
+	wardrobe.PortfolioStore
+	wardrobe.QuoteStore
+}
+
+func ReloadHistory(ctx context.Context, store Store, portfolio wardrobe.Portfolio) error {
 	log.Printf("Reloading portfolio history for portfolio %d", portfolio.Id)
-	orders, err := wardrobe.FetchOrdersByPortfolioId(portfolio.Id)
+	orders, err := store.FetchOrdersByPortfolioId(ctx, portfolio.Id)
 	if err != nil {
 		return err
 	}
-	transfers, err := wardrobe.FetchTransfersByPortfolioId(portfolio.Id)
+	transfers, err := store.FetchTransfersByPortfolioId(ctx, portfolio.Id)
+	if err != nil {
+		return err
+	}
+	options, err := store.FetchOptionsByPortfolioId(ctx, portfolio.Id)
 	if err != nil {
 		return err
 	}
@@ -32,11 +47,14 @@ func ReloadHistory(portfolio wardrobe.Portfolio) error {
 	// in this list!!
 	dates := util.GetMarketDates(start, time.Now())
 	// Computes portfolio (mapping of stock to quantity) snapshot per day
-	portSnapshots := getPortfolioSnapshots(orders, transfers, dates)
+	portSnapshots := getPortfolioSnapshots(orders, transfers, dates, options)
 	// Computes portfolio values (cash, stock_values, daily_net_deposited, cum_change, daily_change) per day
-	portValues := computePortValues(dates, portSnapshots, portfolio.Id)
+	portValues, err := computePortValues(ctx, store, dates, portSnapshots, portfolio.Id, options)
+	if err != nil {
+		return err
+	}
 	log.Printf("Bulk upserting portfolio values...")
-	err = wardrobe.BulkUpsertPortfolioValuesByPortId(portValues, portfolio.Id)
+	err = store.BulkUpsertPortfolioValuesByPortId(ctx, portValues, portfolio.Id)
 	log.Printf("Done bulk upserting!")
 	return err
 }
@@ -49,28 +67,36 @@ type PortValueDiff struct {
 
 // Basically calculates stock value for current day, then daily_change and cum_change
 // Assume no changes in cash, otherwise we'd be reloading entire portfolio history due to new transfer
-func ReloadCurrentDay(portfolio wardrobe.Portfolio) (*PortValueDiff, error) {
+func ReloadCurrentDay(ctx context.Context, store Store, portfolio wardrobe.Portfolio) (*PortValueDiff, error) {
 	now := util.GetTimelessESTOpenNow()
 	log.Printf("Reloading current day portfolio for %d on %s", portfolio.Id, now)
-	positions, err := wardrobe.FetchPortfolioPositions(portfolio.Id)
+	positions, err := store.FetchPortfolioPositions(ctx, portfolio.Id)
 	if err != nil {
 		return nil, err
 	}
 	stockVal := decimal.Zero
 	for _, p := range positions {
 		if !p.Quantity.IsZero() && p.Stock != CASH {
-			stockPrice, err := stockings.GetCurrentPrice(stockings.FingoPack{}, p.Stock)
+			if p.Option != nil {
+				optionPrice, err := stockings.GetOptionPrice(ctx, store, stockings.FingoPack{}, *p.Option, time.Now())
+				if err != nil {
+					return nil, err
+				}
+				stockVal = stockVal.Add(optionPrice.Mul(p.Quantity).Mul(p.Option.Multiplier))
+				continue
+			}
+			stockPrice, err := stockings.GetCurrentPrice(ctx, store, stockings.FingoPack{}, p.Stock)
 			if err != nil {
 				return nil, err
 			}
 			stockVal = stockVal.Add(stockPrice.Mul(p.Quantity))
 		}
 	}
-	prevPv, err := wardrobe.FetchPortfolioValueOnDay(portfolio.Id, now.AddDate(0, 0, -1))
+	prevPv, err := store.FetchPortfolioValueOnDay(ctx, portfolio.Id, now.AddDate(0, 0, -1))
 	if err != nil {
 		return nil, err
 	}
-	currPv, err := wardrobe.FetchPortfolioValueOnDay(portfolio.Id, now)
+	currPv, err := store.FetchPortfolioValueOnDay(ctx, portfolio.Id, now)
 	if err != nil {
 		// if there isn't a current day portfolio value yet, assume its the same as yesterday in terms
 		// of cash
@@ -91,7 +117,7 @@ func ReloadCurrentDay(portfolio wardrobe.Portfolio) (*PortValueDiff, error) {
 		CumChange:         cumChange,
 		DailyChange:       dailyChange,
 	}
-	err = wardrobe.UpsertPortfolioValue(newPv)
+	err = store.UpsertPortfolioValue(ctx, newPv)
 	if err != nil {
 		return nil, err
 	}
@@ -138,7 +164,7 @@ func computePortfolioPerformance(pvs []wardrobe.PortValue) {
 	}
 }
 
-func computePortValues(dates []time.Time, snapshots portSnapshots, portId int) []wardrobe.PortValue {
+func computePortValues(ctx context.Context, quotes wardrobe.QuoteStore, dates []time.Time, snapshots portSnapshots, portId int, options map[string]wardrobe.Option) ([]wardrobe.PortValue, error) {
 	portValues := make(map[time.Time]wardrobe.PortValue)
 	for date := range snapshots {
 		portValues[date] = wardrobe.PortValue{
@@ -156,7 +182,13 @@ func computePortValues(dates []time.Time, snapshots portSnapshots, portId int) [
 	sr := computeStockRanges(dates, snapshots)
 	for s, v := range sr {
 		log.Printf("Processing %s: %s -> %s", s, v.start, v.end)
-		prices, err := stockings.GetHistoricalRange(stockings.FingoPack{}, s, v.start, v.end)
+		var prices *stockings.HistoricalStocks
+		var err error
+		if opt, found := options[s]; found {
+			prices, err = stockings.GetOptionHistoricalRange(ctx, quotes, stockings.FingoPack{}, opt, v.start, v.end)
+		} else {
+			prices, err = stockings.GetHistoricalRange(ctx, quotes, stockings.FingoPack{}, s, v.start, v.end)
+		}
 		if err != nil {
 			log.Printf("Errored out fetching stock prices for %s from %s to %s: %v", s, v.start, v.end, err)
 			continue
@@ -178,10 +210,14 @@ func computePortValues(dates []time.Time, snapshots portSnapshots, portId int) [
 				log.Printf("[ERROR] Couldn't find portValue for date %s", price.Date)
 				continue
 			}
-			portValue.StockValue = portValue.StockValue.Add(price.Price.Mul(quantity))
+			portValue.StockValue = portValue.StockValue.Add(price.Price.Mul(quantity).Mul(wardrobe.Multiplier(options, s)))
 			portValues[price.Date] = portValue
 		}
 	}
+	// Price errors are skipped above, which we don't want to save just because we got cancelled
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
 	log.Printf("DONE computing port cash and stock values")
 
 	pvs := make([]wardrobe.PortValue, 0)
@@ -193,7 +229,7 @@ func computePortValues(dates []time.Time, snapshots portSnapshots, portId int) [
 	}
 	// WARNING: destructively modifies pvs to also include performance.
 	computePortfolioPerformance(pvs)
-	return pvs
+	return pvs, nil
 }
 
 type dateRange struct {
@@ -212,7 +248,7 @@ func copyPort(port portSnapshot) portSnapshot {
 	return clonePort
 }
 
-func getPortfolioSnapshots(orders []wardrobe.Order, transfers []wardrobe.Transfer, dates []time.Time) portSnapshots {
+func getPortfolioSnapshots(orders []wardrobe.Order, transfers []wardrobe.Transfer, dates []time.Time, options map[string]wardrobe.Option) portSnapshots {
 	transferBuckets := getTransferBuckets(transfers)
 	orderBuckets := getOrderBuckets(orders)
 	portSnapshots := make(portSnapshots)
@@ -223,7 +259,7 @@ func getPortfolioSnapshots(orders []wardrobe.Order, transfers []wardrobe.Transfe
 		port[DAILY_NET_DEPOSITED] = decimal.Zero
 		dayOrders, found := orderBuckets[date]
 		if found {
-			processDayOrders(port, dayOrders)
+			processDayOrders(port, dayOrders, options)
 		}
 		dayTransfers, found := transferBuckets[date]
 		if found {
@@ -235,7 +271,7 @@ func getPortfolioSnapshots(orders []wardrobe.Order, transfers []wardrobe.Transfe
 	return portSnapshots
 }
 
-func processDayOrders(currPort portSnapshot, dayOrders []wardrobe.Order) {
+func processDayOrders(currPort portSnapshot, dayOrders []wardrobe.Order, options map[string]wardrobe.Option) {
 	for _, o := range dayOrders {
 		cash, _ := currPort[CASH]
 		normCash, _ := currPort[NORMALIZED_CASH]
@@ -243,14 +279,15 @@ func processDayOrders(currPort portSnapshot, dayOrders []wardrobe.Order) {
 		if !found {
 			quantity = decimal.Zero
 		}
+		cost := o.Quantity.Mul(o.Value).Mul(wardrobe.Multiplier(options, o.Stock))
 		if o.IsBuy {
 			quantity = quantity.Add(o.Quantity)
-			cash = cash.Sub(o.Quantity.Mul(o.Value))
-			normCash = normCash.Sub(o.Quantity.Mul(o.Value))
+			cash = cash.Sub(cost)
+			normCash = normCash.Sub(cost)
 		} else {
 			quantity = quantity.Sub(o.Quantity)
-			cash = cash.Add(o.Quantity.Mul(o.Value))
-			normCash = normCash.Add(o.Quantity.Mul(o.Value))
+			cash = cash.Add(cost)
+			normCash = normCash.Add(cost)
 		}
 		currPort[CASH] = cash
 		currPort[NORMALIZED_CASH] = normCash
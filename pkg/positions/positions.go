@@ -1,22 +1,38 @@
 package positions
 
 import (
+	"context"
 	"log"
+	"time"
 
 	"github.com/bluedresscapital/coattails/pkg/stockings"
 	"github.com/bluedresscapital/coattails/pkg/wardrobe"
 	"github.com/shopspring/decimal"
 )
 
+// Everything Reload reads and writes
+type Store interface {
+	wardrobe.OrderStore
+	wardrobe.TransferStore
+	wardrobe.OptionStore
+	wardrobe.PositionStore
+	wardrobe.QuoteStore
+	wardrobe.Transactor
+}
+
 // Reloads positions for portId
 // Will also update the portfolio's "positions" and "orders" updated at field
-func Reload(portId int, stockAPI stockings.StockAPI) error {
+func Reload(ctx context.Context, store Store, portId int, stockAPI stockings.StockAPI) error {
 	log.Printf("Reloading positions for port %d", portId)
-	orders, err := wardrobe.FetchOrdersByPortfolioId(portId)
+	orders, err := store.FetchOrdersByPortfolioId(ctx, portId)
 	if err != nil {
 		return err
 	}
-	transfers, err := wardrobe.FetchTransfersByPortfolioId(portId)
+	transfers, err := store.FetchTransfersByPortfolioId(ctx, portId)
+	if err != nil {
+		return err
+	}
+	options, err := store.FetchOptionsByPortfolioId(ctx, portId)
 	if err != nil {
 		return err
 	}
@@ -36,56 +52,65 @@ func Reload(portId int, stockAPI stockings.StockAPI) error {
 		if !found {
 			port[o.Stock] = decimal.Zero
 		}
+		cost := o.Quantity.Mul(o.Value).Mul(wardrobe.Multiplier(options, o.Stock))
 		if o.IsBuy {
 			port[o.Stock] = port[o.Stock].Add(o.Quantity)
-			cash = cash.Sub(o.Quantity.Mul(o.Value))
+			cash = cash.Sub(cost)
 		} else {
 			port[o.Stock] = port[o.Stock].Sub(o.Quantity)
-			cash = cash.Add(o.Quantity.Mul(o.Value))
+			cash = cash.Add(cost)
 		}
 	}
-	// Delete ALL positions for this portfolio, and re-insert
-	// We need to do this in case we delete an order, and we don't track that anymore in the previous portfolio's
-	// positions.
-	err = wardrobe.DeletePositions(portId)
-	if err != nil {
-		return err
-	}
-
-	// Upsert cash position
-	err = wardrobe.InsertPosition(wardrobe.Position{
+	// Price everything before touching the db, since pricing can hit the network
+	positions := []wardrobe.Position{{
 		PortId:   portId,
 		Quantity: decimal.NewFromInt(1),
 		Value:    cash,
 		Stock:    "_CASH",
-	})
-	if err != nil {
-		return err
-	}
-	// Upsert stock positions
+	}}
 	for stock, quantity := range port {
 		value := decimal.Zero
 		if !quantity.IsZero() {
 			var price decimal.Decimal
-			priceP, err := stockings.GetCurrentPrice(stockAPI, stock)
+			var priceP *decimal.Decimal
+			if opt, found := options[stock]; found {
+				priceP, err = stockings.GetOptionPrice(ctx, store, stockAPI, opt, time.Now())
+			} else {
+				priceP, err = stockings.GetCurrentPrice(ctx, store, stockAPI, stock)
+			}
 			if err != nil {
 				log.Printf("Errored in finding current price for %s: %v", stock, err)
 				price = decimal.Zero
 			} else {
 				price = *priceP
 			}
-			value = quantity.Mul(price)
+			value = quantity.Mul(price).Mul(wardrobe.Multiplier(options, stock))
 		}
-		p := wardrobe.Position{
+		positions = append(positions, wardrobe.Position{
 			PortId:   portId,
 			Quantity: quantity,
 			Value:    value,
 			Stock:    stock,
-		}
-		err = wardrobe.InsertPosition(p)
+		})
+	}
+	// Price errors are logged and priced at zero above, which we don't want to save just because we got cancelled
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	// Delete ALL positions for this portfolio, and re-insert
+	// We need to do this in case we delete an order, and we don't track that anymore in the previous portfolio's
+	// positions. Both happen in one transaction so readers never see a partially reloaded portfolio.
+	return store.WithTx(ctx, func(tx wardrobe.Store) error {
+		err := tx.DeletePositions(ctx, portId)
 		if err != nil {
 			return err
 		}
-	}
-	return nil
+		for _, p := range positions {
+			err = tx.InsertPosition(ctx, p)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
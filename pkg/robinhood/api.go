@@ -1,11 +1,11 @@
 package robinhood
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
-	"net/http"
 	"time"
 
 	"github.com/bluedresscapital/coattails/pkg/wardrobe"
@@ -16,14 +16,37 @@ import (
 )
 
 const (
-	ClientId               = "c82SH0WZOsabOXGP2sxqcj34FxkvfnWRZBKlBjFS"
-	AuthUrl                = "https://api.robinhood.com/oauth2/token/"
-	OrdersUrl              = "https://api.robinhood.com/orders/"
-	TransfersUrl           = "https://api.robinhood.com/ach/transfers/"
-	ReceivedTransfersUrl   = "https://api.robinhood.com/ach/received/transfers/"
-	SettledTransactionsUrl = "https://minerva.robinhood.com/history/settled_transactions/"
+	ClientId              = "c82SH0WZOsabOXGP2sxqcj34FxkvfnWRZBKlBjFS"
+	DefaultBaseURL        = "https://api.robinhood.com"
+	DefaultMinervaBaseURL = "https://minerva.robinhood.com"
+	// Paths below are relative to the base url, except settled transactions which lives on minerva
+	AuthPath                = "/oauth2/token/"
+	ChallengePath           = "/challenge/%s/respond/"
+	OrdersPath              = "/orders/"
+	TransfersPath           = "/ach/transfers/"
+	ReceivedTransfersPath   = "/ach/received/transfers/"
+	SettledTransactionsPath = "/history/settled_transactions/"
 )
 
+var (
+	client        = util.NewClient(util.ClientConfig{BaseURL: DefaultBaseURL, Retries: util.DefaultRetries})
+	minervaClient = util.NewClient(util.ClientConfig{BaseURL: DefaultMinervaBaseURL, Retries: util.DefaultRetries})
+)
+
+// Points every robinhood request at cfg.BaseURL, and banking requests at minervaBaseURL (either falls back
+// to the real api if empty). Must be called before any requests are made.
+func Configure(cfg util.ClientConfig, minervaBaseURL string) {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = DefaultBaseURL
+	}
+	client = util.NewClient(cfg)
+	if minervaBaseURL == "" {
+		minervaBaseURL = DefaultMinervaBaseURL
+	}
+	cfg.BaseURL = minervaBaseURL
+	minervaClient = util.NewClient(cfg)
+}
+
 type RHOrdersResponse struct {
 	Next    string            `json:"next"`
 	Results []RHOrdersResults `json:"results"`
@@ -44,12 +67,70 @@ type RHOrdersExecutions struct {
 }
 
 type RHAuthResponse struct {
-	BearerTok  string `json:"access_token"`
-	RefreshTok string `json:"refresh_token"`
+	BearerTok   string       `json:"access_token"`
+	RefreshTok  string       `json:"refresh_token"`
+	MFARequired bool         `json:"mfa_required"`
+	Challenge   *RHChallenge `json:"challenge"`
 }
 
-func Login(username string, password string, deviceTok string) (*RHAuthResponse, error) {
-	reqBody, err := json.Marshal(map[string]string{
+type RHChallenge struct {
+	Id                string `json:"id"`
+	Type              string `json:"type"`
+	Status            string `json:"status"`
+	RemainingAttempts int    `json:"remaining_attempts"`
+}
+
+const (
+	// Code sent to the user's phone or email
+	SMSChallenge   = "sms"
+	EmailChallenge = "email"
+	// Code generated by the user's authenticator app
+	TOTPChallenge = "app"
+)
+
+// ChallengeError is returned instead of tokens whenever robinhood wants the user to verify the login,
+// which happens on every password grant for accounts with MFA enabled.
+type ChallengeError struct {
+	Type        string
+	ChallengeId string
+}
+
+func (e *ChallengeError) Error() string {
+	return fmt.Sprintf("robinhood requires %s verification to login", e.Type)
+}
+
+// Logs in with a password grant. If robinhood wants the login verified, this returns a *ChallengeError,
+// and the code the user receives should be passed along to VerifyLogin.
+func Login(ctx context.Context, username string, password string, deviceTok string) (*RHAuthResponse, error) {
+	reqBody, err := json.Marshal(passwordGrant(username, password, deviceTok))
+	if err != nil {
+		return nil, err
+	}
+	return fetchRHAuthResponse(ctx, reqBody, nil)
+}
+
+// Finishes a login that Login answered with challenge, using the code the user received
+func VerifyLogin(ctx context.Context, username string, password string, deviceTok string, challenge ChallengeError, code string) (*RHAuthResponse, error) {
+	grant := passwordGrant(username, password, deviceTok)
+	headers := make(map[string]string)
+	if challenge.Type == TOTPChallenge {
+		grant["mfa_code"] = code
+	} else {
+		err := respondToChallenge(ctx, challenge.ChallengeId, code)
+		if err != nil {
+			return nil, err
+		}
+		headers["X-ROBINHOOD-CHALLENGE-RESPONSE-ID"] = challenge.ChallengeId
+	}
+	reqBody, err := json.Marshal(grant)
+	if err != nil {
+		return nil, err
+	}
+	return fetchRHAuthResponse(ctx, reqBody, headers)
+}
+
+func passwordGrant(username string, password string, deviceTok string) map[string]string {
+	return map[string]string{
 		"username":     username,
 		"password":     password,
 		"device_token": deviceTok,
@@ -57,16 +138,37 @@ func Login(username string, password string, deviceTok string) (*RHAuthResponse,
 		"expires_in":   "86400",
 		"grant_type":   "password",
 		"scope":        "internal",
-	})
+	}
+}
+
+func respondToChallenge(ctx context.Context, challengeId string, code string) error {
+	reqBody, err := json.Marshal(map[string]string{"response": code})
 	if err != nil {
-		return nil, err
+		return err
 	}
-	return fetchRHAuthResponse(reqBody)
+	resp, err := client.Post(ctx, fmt.Sprintf(ChallengePath, challengeId), "application/json", reqBody, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	var challenge RHChallenge
+	err = json.Unmarshal(body, &challenge)
+	if err != nil {
+		return err
+	}
+	if challenge.Status != "validated" {
+		return fmt.Errorf("invalid challenge response, %d attempts remaining", challenge.RemainingAttempts)
+	}
+	return nil
 }
 
 // Fetches bearer token using refresh token, HOWEVER this immediately invalidates the current
 // refresh token. MUST use the new one returned by this call!
-func FetchBearerToken(refreshTok string) (*RHAuthResponse, error) {
+func FetchBearerToken(ctx context.Context, refreshTok string) (*RHAuthResponse, error) {
 	reqBody, err := json.Marshal(map[string]string{
 		"refresh_token": refreshTok,
 		"client_id":     ClientId,
@@ -77,11 +179,11 @@ func FetchBearerToken(refreshTok string) (*RHAuthResponse, error) {
 	if err != nil {
 		return nil, err
 	}
-	return fetchRHAuthResponse(reqBody)
+	return fetchRHAuthResponse(ctx, reqBody, nil)
 }
 
-func fetchRHAuthResponse(reqBody []byte) (*RHAuthResponse, error) {
-	resp, err := http.Post(AuthUrl, "application/json", bytes.NewBuffer(reqBody))
+func fetchRHAuthResponse(ctx context.Context, reqBody []byte, headers map[string]string) (*RHAuthResponse, error) {
+	resp, err := client.Post(ctx, AuthPath, "application/json", reqBody, headers)
 	if err != nil {
 		return nil, err
 	}
@@ -92,17 +194,23 @@ func fetchRHAuthResponse(reqBody []byte) (*RHAuthResponse, error) {
 	if err != nil {
 		return nil, err
 	}
+	if res.MFARequired {
+		return nil, &ChallengeError{Type: TOTPChallenge}
+	}
+	if res.Challenge != nil {
+		return nil, &ChallengeError{Type: res.Challenge.Type, ChallengeId: res.Challenge.Id}
+	}
 	if res.BearerTok == "" || res.RefreshTok == "" {
 		return nil, errors.New("invalid auth grant")
 	}
 	return &res, nil
 }
 
-func ScrapeOrders(bearerTok string) ([]RHOrdersResults, error) {
+func ScrapeOrders(ctx context.Context, bearerTok string) ([]RHOrdersResults, error) {
 	res := make([]RHOrdersResults, 0)
-	url := OrdersUrl
+	url := OrdersPath
 	for {
-		resp, err := util.MakeGetRequest(bearerTok, url)
+		resp, err := client.Get(ctx, bearerTok, url)
 		if err != nil {
 			return nil, err
 		}
@@ -128,12 +236,12 @@ type InstrumentResponse struct {
 	Symbol string `json:"symbol"`
 }
 
-func FetchStockFromInstrumentId(instrument string) (*string, error) {
-	stock, err := wardrobe.GetStockFromInstrumentId(instrument)
+func FetchStockFromInstrumentId(ctx context.Context, instrument string) (*string, error) {
+	stock, err := wardrobe.GetStockFromInstrumentId(ctx, instrument)
 	if err == nil {
 		return stock, nil
 	}
-	resp, err := http.Get(instrument)
+	resp, err := client.Get(ctx, "", instrument)
 	if err != nil {
 		return nil, err
 	}
@@ -144,7 +252,7 @@ func FetchStockFromInstrumentId(instrument string) (*string, error) {
 	if err != nil {
 		return nil, err
 	}
-	wardrobe.SetStockFromInstrument(instrument, res.Symbol)
+	wardrobe.SetStockFromInstrument(ctx, instrument, res.Symbol)
 	return &res.Symbol, nil
 }
 
@@ -155,10 +263,10 @@ type RHTransfersResults struct {
 	Date      time.Time       `json:"date"`
 }
 
-func ScrapeTransfers(bearerTok string) ([]RHTransfersResults, error) {
+func ScrapeTransfers(ctx context.Context, bearerTok string) ([]RHTransfersResults, error) {
 	res := make([]RHTransfersResults, 0)
 	// Normal bank transfers, where you directly withdraw from
-	bankTransfers, err := scrapeBankTransfers(bearerTok)
+	bankTransfers, err := scrapeBankTransfers(ctx, bearerTok)
 	if err != nil {
 		return nil, err
 	}
@@ -173,7 +281,7 @@ func ScrapeTransfers(bearerTok string) ([]RHTransfersResults, error) {
 		}
 	}
 	// Transfers related to RH Checking Account (i.e. Direct deposit, Venmo)
-	receivedTransfers, err := scrapeReceviedTransfers(bearerTok)
+	receivedTransfers, err := scrapeReceviedTransfers(ctx, bearerTok)
 	if err != nil {
 		return nil, err
 	}
@@ -188,7 +296,7 @@ func ScrapeTransfers(bearerTok string) ([]RHTransfersResults, error) {
 		}
 	}
 	// Transfers related to RH debit card usage (Spending, ATM withdrawals)
-	settledTransactions, err := scrapeSettledTransactions(bearerTok)
+	settledTransactions, err := scrapeSettledTransactions(ctx, bearerTok)
 	if err != nil {
 		return nil, err
 	}
@@ -216,12 +324,12 @@ type RHBankTransfersResults struct {
 	CreatedAt time.Time       `json:"created_at"`
 }
 
-func scrapeBankTransfers(bearerTok string) ([]RHBankTransfersResults, error) {
+func scrapeBankTransfers(ctx context.Context, bearerTok string) ([]RHBankTransfersResults, error) {
 	//log.Print("bank transfers")
 	res := make([]RHBankTransfersResults, 0)
-	url := TransfersUrl
+	url := TransfersPath
 	for {
-		resp, err := util.MakeGetRequest(bearerTok, url)
+		resp, err := client.Get(ctx, bearerTok, url)
 		if err != nil {
 			return nil, err
 		}
@@ -261,11 +369,11 @@ type RHReceivedTransfersAmount struct {
 	Amount decimal.Decimal `json:"amount"`
 }
 
-func scrapeReceviedTransfers(bearerTok string) ([]RHReceivedTransfersResults, error) {
+func scrapeReceviedTransfers(ctx context.Context, bearerTok string) ([]RHReceivedTransfersResults, error) {
 	res := make([]RHReceivedTransfersResults, 0)
-	url := ReceivedTransfersUrl
+	url := ReceivedTransfersPath
 	for {
-		resp, err := util.MakeGetRequest(bearerTok, url)
+		resp, err := client.Get(ctx, bearerTok, url)
 		if err != nil {
 			return nil, err
 		}
@@ -304,11 +412,11 @@ type RHSettledTransactionsAmount struct {
 	Amount decimal.Decimal `json:"amount"`
 }
 
-func scrapeSettledTransactions(bearerTok string) ([]RHSettledTransactionsResults, error) {
+func scrapeSettledTransactions(ctx context.Context, bearerTok string) ([]RHSettledTransactionsResults, error) {
 	res := make([]RHSettledTransactionsResults, 0)
-	url := SettledTransactionsUrl
+	url := SettledTransactionsPath
 	for {
-		resp, err := util.MakeGetRequest(bearerTok, url)
+		resp, err := minervaClient.Get(ctx, bearerTok, url)
 		if err != nil {
 			return nil, err
 		}
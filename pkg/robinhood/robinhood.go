@@ -1,11 +1,18 @@
 package robinhood
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log"
 
+	"github.com/bluedresscapital/coattails/pkg/links"
 	"github.com/bluedresscapital/coattails/pkg/orders"
+	"github.com/bluedresscapital/coattails/pkg/socks"
 	"github.com/bluedresscapital/coattails/pkg/transfers"
+	"github.com/bluedresscapital/coattails/pkg/util"
 	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/google/uuid"
 	"github.com/shopspring/decimal"
 )
 
@@ -16,17 +23,17 @@ type API struct {
 var _ orders.OrderAPI = (*API)(nil)
 var _ transfers.TransferAPI = (*API)(nil)
 
-func (api API) GetOrders() ([]wardrobe.Order, error) {
-	bearerTok, err := api.getAuthToken()
+func (api API) GetOrders(ctx context.Context) ([]wardrobe.Order, error) {
+	bearerTok, err := api.getAuthToken(ctx)
 	if err != nil {
 		return nil, err
 	}
-	port, err := wardrobe.FetchPortfolioByRHAccountId(api.AccountId)
+	port, err := wardrobe.FetchPortfolioByRHAccountId(ctx, api.AccountId)
 	if err != nil {
 		return nil, err
 	}
 
-	res, err := ScrapeOrders(*bearerTok)
+	res, err := ScrapeOrders(ctx, *bearerTok)
 	if err != nil {
 		return nil, err
 	}
@@ -42,7 +49,7 @@ func (api API) GetOrders() ([]wardrobe.Order, error) {
 			amount = amount.Add(e.Price.Mul(e.Quantity))
 		}
 		avgPrice := amount.Div(shares)
-		stockP, err := FetchStockFromInstrumentId(o.Instrument)
+		stockP, err := FetchStockFromInstrumentId(ctx, o.Instrument)
 		if err != nil {
 			return nil, err
 		}
@@ -60,16 +67,16 @@ func (api API) GetOrders() ([]wardrobe.Order, error) {
 	return ret, nil
 }
 
-func (api API) GetTransfers() ([]wardrobe.Transfer, error) {
-	bearerTok, err := api.getAuthToken()
+func (api API) GetTransfers(ctx context.Context) ([]wardrobe.Transfer, error) {
+	bearerTok, err := api.getAuthToken(ctx)
 	if err != nil {
 		return nil, err
 	}
-	port, err := wardrobe.FetchPortfolioByRHAccountId(api.AccountId)
+	port, err := wardrobe.FetchPortfolioByRHAccountId(ctx, api.AccountId)
 	if err != nil {
 		return nil, err
 	}
-	res, err := ScrapeTransfers(*bearerTok)
+	res, err := ScrapeTransfers(ctx, *bearerTok)
 	if err != nil {
 		return nil, err
 	}
@@ -88,24 +95,75 @@ func (api API) GetTransfers() ([]wardrobe.Transfer, error) {
 	return ret, nil
 }
 
-func (api API) getAuthToken() (*string, error) {
-	acc, err := wardrobe.FetchRHAccount(api.AccountId)
+func (api API) getAuthToken(ctx context.Context) (*string, error) {
+	acc, err := wardrobe.FetchRHAccount(ctx, api.AccountId)
 	if err != nil {
 		return nil, err
 	}
+	// Robinhood rotates the refresh token whenever it hands us a bearer token, so once we've asked for one we
+	// finish saving the new refresh token even if we're cancelled. The broker client's timeout still applies.
+	tokCtx := util.Detach(ctx)
 	var auth *RHAuthResponse
-	auth, err = FetchBearerToken(acc.RefreshTok)
+	auth, err = FetchBearerToken(tokCtx, acc.RefreshTok)
 	if err != nil {
+		// Don't trigger another challenge while the user still has to answer the last one
+		pending, pendingErr := wardrobe.HasPendingRHRelink(tokCtx, api.AccountId)
+		if pendingErr != nil {
+			return nil, pendingErr
+		}
+		if pending {
+			return nil, fmt.Errorf("rh account %d is waiting on the user to verify their login", api.AccountId)
+		}
 		// Only login when absolutely necessary (i.e. refresh token expired)
 		log.Print("Fetching bearer token failed with refresh token, logging in instead")
-		auth, err = Login(acc.Username, acc.Password, acc.DeviceTok)
+		auth, err = Login(tokCtx, acc.Username, acc.Password, acc.DeviceTok)
 		if err != nil {
+			links.Broken(tokCtx, wardrobe.Robinhood, api.AccountId, acc.UserId, err)
+			var challenge *ChallengeError
+			if errors.As(err, &challenge) {
+				promptErr := promptRelink(tokCtx, *acc, *challenge)
+				if promptErr != nil {
+					log.Printf("Error prompting user %d to re-link rh account %d: %v", acc.UserId, acc.Id, promptErr)
+				}
+			}
 			return nil, err
 		}
 	}
-	err = wardrobe.UpdateRHRefreshToken(api.AccountId, auth.RefreshTok)
+	err = wardrobe.UpdateRHRefreshToken(tokCtx, api.AccountId, auth.RefreshTok)
 	if err != nil {
+		// The old refresh token is already invalid, so we'll have to login again next time
+		links.Broken(tokCtx, wardrobe.Robinhood, api.AccountId, acc.UserId, err)
 		return nil, err
 	}
+	links.Healthy(tokCtx, wardrobe.Robinhood, api.AccountId)
 	return &(auth.BearerTok), nil
 }
+
+type RelinkPrompt struct {
+	LinkToken     string `json:"link_token"`
+	AccountId     int    `json:"account_id"`
+	ChallengeType string `json:"challenge_type"`
+}
+
+// Robinhood wants the user to verify a login we did on their behalf, so we hold onto the challenge and
+// ask the user (over the websocket) for the code robinhood sent them
+func promptRelink(ctx context.Context, acc wardrobe.RHAccount, challenge ChallengeError) error {
+	token := uuid.New().String()
+	err := wardrobe.SetPendingRHLink(ctx, token, wardrobe.PendingRHLink{
+		UserId:        acc.UserId,
+		AccountId:     acc.Id,
+		Username:      acc.Username,
+		Password:      acc.Password,
+		DeviceTok:     acc.DeviceTok,
+		ChallengeType: challenge.Type,
+		ChallengeId:   challenge.ChallengeId,
+	})
+	if err != nil {
+		return err
+	}
+	return socks.PublishToUser(ctx, acc.UserId, "RH_CHALLENGE_REQUIRED", RelinkPrompt{
+		LinkToken:     token,
+		AccountId:     acc.Id,
+		ChallengeType: challenge.Type,
+	})
+}
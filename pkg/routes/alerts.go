@@ -0,0 +1,101 @@
+package routes
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/bluedresscapital/coattails/pkg/alerts"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/gorilla/mux"
+	"github.com/shopspring/decimal"
+)
+
+// All alert routes should be under /auth prefix. Alerts send email and webhooks, so managing them takes a
+// session.
+func registerAlertRoutes(r *mux.Router) {
+	log.Printf("Registering alert routes")
+	r.HandleFunc("/alerts", authMiddleware(fetchAlertRulesHandler)).Methods("GET")
+	r.HandleFunc("/alerts/create", authMiddleware(createAlertRuleHandler)).Methods("POST")
+	r.HandleFunc("/alerts/delete", authMiddleware(deleteAlertRuleHandler)).Methods("POST")
+}
+
+func fetchAlertRulesHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	rules, err := wardrobe.FetchAlertRulesByUserId(r.Context(), *userId)
+	if err != nil {
+		log.Printf("Error fetching alert rules for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJsonResponse(w, rules)
+}
+
+type createAlertRuleRequest struct {
+	Kind      string          `json:"kind"`
+	Ticker    string          `json:"ticker"`
+	PortId    int             `json:"port_id"`
+	Direction string          `json:"direction"`
+	Threshold decimal.Decimal `json:"threshold"`
+	// Besides the websocket, which always gets alerts
+	Channels   []string `json:"channels"`
+	Email      string   `json:"email"`
+	WebhookURL string   `json:"webhook_url"`
+	// 0 means alerts.DefaultCooldown
+	CooldownSecs int `json:"cooldown_secs"`
+}
+
+func createAlertRuleHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req createAlertRuleRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	rule, err := alerts.CreateRule(r.Context(), wardrobe.Default(), wardrobe.AlertRule{
+		UserId:     *userId,
+		Kind:       req.Kind,
+		Ticker:     req.Ticker,
+		PortId:     req.PortId,
+		Direction:  req.Direction,
+		Threshold:  req.Threshold,
+		Channels:   req.Channels,
+		Email:      req.Email,
+		WebhookURL: req.WebhookURL,
+		Cooldown:   req.CooldownSecs,
+	})
+	if err != nil {
+		var invalid *alerts.InvalidRuleError
+		if errors.As(err, &invalid) {
+			http.Error(w, invalid.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error creating alert rule for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJsonResponse(w, rule)
+}
+
+type deleteAlertRuleRequest struct {
+	Id int `json:"id"`
+}
+
+func deleteAlertRuleHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req deleteAlertRuleRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	err = wardrobe.DeleteAlertRule(r.Context(), *userId, req.Id)
+	if err != nil {
+		if errors.Is(err, wardrobe.ErrNoAlertRule) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error deleting alert rule for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeStatusResponseJson(w, "success")
+}
@@ -11,14 +11,14 @@ import (
 
 func apiCheck(w http.ResponseWriter, r *http.Request) {
 	api := stockings.IexApi{}
-	aObj, err := api.GetCurrentPrice("MELI")
+	aObj, err := api.GetCurrentPrice(r.Context(), "MELI")
 	fmt.Fprintln(w, aObj.LatestPrice)
 	if err != nil {
 		fmt.Println(err)
 	}
 	start, _ := time.Parse(stockings.DateLayout, "20200101")
 	end, _ := time.Parse(stockings.DateLayout, "20200105")
-	bObj, err := api.GetHistoricalRange("MELI", start, end)
+	bObj, err := api.GetHistoricalRange(r.Context(), "MELI", start, end)
 	b, _ := json.Marshal(bObj)
 	fmt.Fprintln(w, string(b))
 	if err != nil {
@@ -26,7 +26,7 @@ func apiCheck(w http.ResponseWriter, r *http.Request) {
 	}
 
 	date, _ := time.Parse(stockings.DateLayout, "20200102")
-	cObj, err := api.GetHistoricalPrice("MELI", date)
+	cObj, err := api.GetHistoricalPrice(r.Context(), "MELI", date)
 	c, _ := json.Marshal(cObj)
 	fmt.Fprintln(w, string(c))
 	if err != nil {
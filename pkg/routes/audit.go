@@ -0,0 +1,52 @@
+package routes
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/bluedresscapital/coattails/pkg/auth"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultAuditLimit = 100
+	maxAuditLimit     = 500
+)
+
+// All audit routes should be under /auth prefix
+func registerAuditRoutes(r *mux.Router) {
+	log.Printf("Registering audit routes")
+	r.HandleFunc("/audit", authMiddleware(fetchAuditLogHandler, auth.ScopeReadAudit)).Methods("GET")
+}
+
+// Returns the user's audit log, newest first. Optional query params: port_id to only show one portfolio,
+// before_id to page back from the last entry of the previous page, and limit.
+func fetchAuditLogHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	q := wardrobe.AuditQuery{UserId: *userId, Limit: defaultAuditLimit}
+	params := map[string]*int{"port_id": &q.PortId, "before_id": &q.BeforeId, "limit": &q.Limit}
+	for name, dst := range params {
+		str := r.URL.Query().Get(name)
+		if str == "" {
+			continue
+		}
+		val, err := strconv.Atoi(str)
+		if err != nil || val < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			log.Printf("Invalid %s %s", name, str)
+			return
+		}
+		*dst = val
+	}
+	if q.Limit == 0 || q.Limit > maxAuditLimit {
+		q.Limit = maxAuditLimit
+	}
+	entries, err := wardrobe.FetchAuditLog(r.Context(), q)
+	if err != nil {
+		log.Printf("Error fetching audit log for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJsonResponse(w, entries)
+}
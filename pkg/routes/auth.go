@@ -7,7 +7,6 @@ import (
 	"time"
 
 	"github.com/bluedresscapital/coattails/pkg/auth"
-	"github.com/bluedresscapital/coattails/pkg/secrets"
 	"github.com/bluedresscapital/coattails/pkg/wardrobe"
 	"github.com/gorilla/mux"
 )
@@ -22,6 +21,7 @@ func registerAuthRoutes(r *mux.Router) {
 	s.HandleFunc("/register", registerHandler).Methods("POST")
 	s.HandleFunc("/user", authMiddleware(userHandler)).Methods("POST")
 	s.HandleFunc("/websocket", authMiddleware(testWebSocket))
+	s.HandleFunc("/events", authMiddleware(eventStreamHandler)).Methods("GET")
 
 	// Register all of the following routes under /auth because they require
 	// user auth
@@ -31,6 +31,15 @@ func registerAuthRoutes(r *mux.Router) {
 	registerTDARoutes(s)
 	registerRobinhoodRoutes(s)
 	registerPositionRoutes(s)
+	registerAuditRoutes(s)
+	registerSessionRoutes(s)
+	registerTOTPRoutes(s)
+	registerAPITokenRoutes(s)
+	registerHouseholdRoutes(s)
+	registerEmailRoutes(s)
+	registerAlertRoutes(s)
+	registerWebhookRoutes(s)
+	registerDigestRoutes(s)
 }
 
 type loginRegisterRequest struct {
@@ -49,20 +58,17 @@ func logoutHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(statusCode)
 		return
 	}
-	err = wardrobe.ClearAuthToken(c.Value)
+	err = auth.Logout(r.Context(), c.Value)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:   "session_token",
-		MaxAge: -1,
-	})
+	clearSessionCookie(w)
 	writeStatusResponseJson(w, "success")
 }
 
 func userHandler(userId *int, w http.ResponseWriter, r *http.Request) {
-	username, err := wardrobe.FetchUserById(*userId)
+	username, err := wardrobe.FetchUserById(r.Context(), *userId)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -86,27 +92,26 @@ func loginRegisterHelper(w http.ResponseWriter, r *http.Request, loginMode bool)
 		handleDecodeErr(w, err)
 		return
 	}
-	// Immediately encrypt password so we don't run it down later
-	cipherPwd := secrets.Hash(l.Password)
-	tok := new(string)
+	var res *auth.LoginResult
 	if loginMode {
-		tok, err = auth.Login(l.Username, cipherPwd)
+		res, err = auth.Login(r.Context(), l.Username, l.Password, sessionToken(r), sessionMeta(r))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
 	} else {
-		tok, err = auth.Register(l.Username, cipherPwd)
+		res, err = auth.Register(r.Context(), l.Username, l.Password, sessionToken(r), sessionMeta(r))
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusUnauthorized)
 			return
 		}
 	}
-	http.SetCookie(w, &http.Cookie{
-		Name:    "session_token",
-		Value:   *tok,
-		Expires: time.Now().Add(wardrobe.SessionTokenTtl),
-	})
+	if res.PendingToken != nil {
+		// The client has to follow up with the user's code at /auth/login/totp
+		writeJsonResponse(w, TOTPRequiredResponse{Username: l.Username, TOTPRequired: true, PendingToken: *res.PendingToken})
+		return
+	}
+	setSessionCookie(w, *res.SessionToken, time.Now().Add(wardrobe.SessionTokenTtl))
 	writeUserResponseJson(w, l.Username)
 }
 
@@ -124,7 +129,7 @@ func writeUserResponseJson(w http.ResponseWriter, username string) {
 
 // Fetches cookie from request header, if present
 func fetchCookie(r *http.Request) (*http.Cookie, int, error) {
-	c, err := r.Cookie("session_token")
+	c, err := r.Cookie(sessionCookieName)
 	if err != nil {
 		if err == http.ErrNoCookie {
 			// If the cookie is not set, return an unauthorized status
@@ -32,7 +32,7 @@ func portCollectionCountHandler(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	positions, err := wardrobe.FetchPortfolioPositions(portId)
+	positions, err := wardrobe.FetchPortfolioPositions(r.Context(), portId)
 	if err != nil {
 		log.Printf("error fetching portfolio positions: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -42,7 +42,7 @@ func portCollectionCountHandler(w http.ResponseWriter, r *http.Request) {
 	for _, p := range positions {
 		tickers = append(tickers, p.Stock)
 	}
-	counts, err := collections.FetchCollectionCountsFromTickers(tickers)
+	counts, err := collections.FetchCollectionCountsFromTickers(r.Context(), tickers)
 	if err != nil {
 		log.Printf("error fetching collection counts: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -11,8 +11,8 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/bluedresscapital/coattails/pkg/auth"
 	"github.com/bluedresscapital/coattails/pkg/wardrobe"
-	"github.com/go-redis/redis/v7"
 	"github.com/golang/gddo/httputil/header"
 )
 
@@ -26,34 +26,22 @@ func (mr *malformedRequest) Error() string {
 }
 
 // Middleware wrapper function to fetch userId given cookie. If cookie is either absent or
-// invalid, returns a StatusUnauthorized error
-func authMiddleware(handler func(*int, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+// invalid, returns a StatusUnauthorized error. Routes that list scopes also take an api token with all of
+// them, sent as a bearer token, in place of the cookie.
+func authMiddleware(handler func(*int, http.ResponseWriter, *http.Request), scopes ...auth.Scope) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		// given auth token, finds user info
-		c, statusCode, err := fetchCookie(r)
-		if err != nil {
-			w.WriteHeader(statusCode)
-			_, _ = fmt.Fprintf(w, err.Error())
+		if token, ok := bearerToken(r); ok {
+			apiTokenAuth(handler, token, scopes, w, r)
 			return
 		}
-		userId, err := wardrobe.VerifyCookie(c.Value)
-		if err != nil {
-			if err == redis.Nil {
-				// This means there wasn't a valid user id mapped by the cookie
-				w.WriteHeader(http.StatusUnauthorized)
-				_, _ = fmt.Fprintf(w, "Invalid session_token cookie: %s", c.Value)
-				return
-			}
-			// If there is an error fetching from cache, return an internal server error status
-			w.WriteHeader(http.StatusInternalServerError)
-			_, _ = fmt.Fprintf(w, err.Error())
-			return
-		}
-		if userId == nil {
-			// If the session token is not present in cache, return an unauthorized error
-			w.WriteHeader(http.StatusUnauthorized)
+		session := verifySession(w, r)
+		if session == nil {
 			return
 		}
+		userId := &session.UserId
+		r = withSession(r, session)
+		// Anything the handler changes is on behalf of the user, unless it says otherwise
+		r = r.WithContext(wardrobe.WithActor(r.Context(), *userId, wardrobe.SourceManual))
 		handler(userId, w, r)
 	}
 }
@@ -62,7 +50,9 @@ type GenericPortIdRequest struct {
 	PortId int `json:"port_id"`
 }
 
-func portAuthMiddleware(handler func(*int, *wardrobe.Portfolio, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+// Like authMiddleware, but for routes about the portfolio with the request's port_id, which the user needs at
+// least role on (see wardrobe.PortfolioRole)
+func portAuthMiddleware(handler func(*int, *wardrobe.Portfolio, http.ResponseWriter, *http.Request), role wardrobe.PortfolioRole, scopes ...auth.Scope) http.HandlerFunc {
 	return authMiddleware(func(userId *int, w http.ResponseWriter, r *http.Request) {
 		req := new(GenericPortIdRequest)
 		// NOTE(ma): this is some interesting tech - not sure if this can be improved LOL
@@ -80,19 +70,29 @@ func portAuthMiddleware(handler func(*int, *wardrobe.Portfolio, http.ResponseWri
 		// We re-insert the request body here
 		r.Body = ioutil.NopCloser(bytes.NewBuffer(bodyBytes))
 
-		port, err := wardrobe.FetchPortfolioById(req.PortId)
+		port, err := wardrobe.FetchPortfolioById(r.Context(), req.PortId)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
 			log.Printf("Unable to fetch portfolio with id %d", req.PortId)
 			return
 		}
-		if port.UserId != *userId {
+		userRole, err := wardrobe.FetchPortfolioRole(r.Context(), port.Id, *userId)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("Unable to fetch user %d's role on port id %d: %v", *userId, req.PortId, err)
+			return
+		}
+		if userRole == wardrobe.RoleNone {
 			w.WriteHeader(http.StatusUnauthorized)
 			log.Printf("Unauthorized access of port id %d by user %d", req.PortId, *userId)
 			return
 		}
+		if !userRole.Includes(role) {
+			http.Error(w, fmt.Sprintf("%s access to portfolio required", role), http.StatusForbidden)
+			return
+		}
 		handler(userId, port, w, r)
-	})
+	}, scopes...)
 }
 
 func handleDecodeErr(w http.ResponseWriter, err error) {
@@ -174,12 +174,16 @@ func writeStatusResponseJson(w http.ResponseWriter, status string) {
 }
 
 func writeJsonResponse(w http.ResponseWriter, v interface{}) {
+	writeJsonResponseWithStatus(w, http.StatusOK, v)
+}
+
+func writeJsonResponseWithStatus(w http.ResponseWriter, status int, v interface{}) {
 	js, err := json.Marshal(v)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
+	w.WriteHeader(status)
 	_, _ = w.Write(js)
 }
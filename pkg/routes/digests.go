@@ -0,0 +1,86 @@
+package routes
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/bluedresscapital/coattails/pkg/digest"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/gorilla/mux"
+)
+
+// All digest routes should be under /auth prefix. Digests only go to an email the user has verified, and
+// managing them takes a session.
+func registerDigestRoutes(r *mux.Router) {
+	log.Printf("Registering digest routes")
+	r.HandleFunc("/digest", authMiddleware(fetchDigestSettingsHandler)).Methods("GET")
+	r.HandleFunc("/digest/update", authMiddleware(updateDigestSettingsHandler)).Methods("POST")
+	r.HandleFunc("/digest/delete", authMiddleware(deleteDigestSettingsHandler)).Methods("POST")
+}
+
+func fetchDigestSettingsHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	settings, err := wardrobe.FetchDigestSettings(r.Context(), *userId)
+	if err != nil {
+		if errors.Is(err, wardrobe.ErrNoDigestSettings) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error fetching digest settings for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJsonResponse(w, settings)
+}
+
+type updateDigestSettingsRequest struct {
+	Email     string `json:"email"`
+	Frequency string `json:"frequency"`
+	// EST
+	SendHour int `json:"send_hour"`
+	// Only for weekly digests, 0 is sunday
+	SendWeekday int `json:"send_weekday"`
+}
+
+// Opts the user into digests, or changes how they get them
+func updateDigestSettingsHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req updateDigestSettingsRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	err = digest.SaveSettings(r.Context(), wardrobe.Default(), wardrobe.DigestSettings{
+		UserId:      *userId,
+		Email:       req.Email,
+		Frequency:   req.Frequency,
+		SendHour:    req.SendHour,
+		SendWeekday: req.SendWeekday,
+	})
+	if err != nil {
+		var invalid *digest.InvalidSettingsError
+		if errors.As(err, &invalid) {
+			http.Error(w, invalid.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error saving digest settings for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	fetchDigestSettingsHandler(userId, w, r)
+}
+
+// Opts the user out of digests
+func deleteDigestSettingsHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	err := wardrobe.DeleteDigestSettings(r.Context(), *userId)
+	if err != nil {
+		if errors.Is(err, wardrobe.ErrNoDigestSettings) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error deleting digest settings for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeStatusResponseJson(w, "success")
+}
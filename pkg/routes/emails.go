@@ -0,0 +1,112 @@
+package routes
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/bluedresscapital/coattails/pkg/auth"
+	"github.com/bluedresscapital/coattails/pkg/mailer"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/gorilla/mux"
+)
+
+// Sends email verification codes. Nil if no smtp server is configured, in which case emails can't be added.
+var Mailer *mailer.Mailer
+
+// All email routes should be under /auth prefix. Alerts and digests only go to addresses verified here.
+func registerEmailRoutes(r *mux.Router) {
+	log.Printf("Registering email routes")
+	r.HandleFunc("/emails", authMiddleware(fetchEmailsHandler)).Methods("GET")
+	r.HandleFunc("/emails/add", authMiddleware(addEmailHandler)).Methods("POST")
+	r.HandleFunc("/emails/verify", authMiddleware(verifyEmailHandler)).Methods("POST")
+	r.HandleFunc("/emails/delete", authMiddleware(deleteEmailHandler)).Methods("POST")
+}
+
+func fetchEmailsHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	emails, err := wardrobe.FetchUserEmails(r.Context(), *userId)
+	if err != nil {
+		log.Printf("Error fetching emails for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJsonResponse(w, emails)
+}
+
+type addEmailRequest struct {
+	Email string `json:"email"`
+}
+
+// Adds an email (or resends its code), which the user then verifies with the code we send it
+func addEmailHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req addEmailRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	if Mailer == nil {
+		http.Error(w, "email isn't configured on this server", http.StatusServiceUnavailable)
+		return
+	}
+	err = auth.StartEmailVerification(r.Context(), Mailer, *userId, req.Email)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidEmail) || errors.Is(err, auth.ErrTooManyEmails) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error sending email verification for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeStatusResponseJson(w, "success")
+}
+
+type verifyEmailRequest struct {
+	Email string `json:"email"`
+	Code  string `json:"code"`
+}
+
+func verifyEmailHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req verifyEmailRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	err = auth.VerifyEmail(r.Context(), *userId, req.Email, req.Code)
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidEmailCode) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error verifying email for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	fetchEmailsHandler(userId, w, r)
+}
+
+type deleteEmailRequest struct {
+	Email string `json:"email"`
+}
+
+func deleteEmailHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req deleteEmailRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	err = wardrobe.DeleteUserEmail(r.Context(), *userId, req.Email)
+	if err != nil {
+		if errors.Is(err, wardrobe.ErrNoUserEmail) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error deleting email for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeStatusResponseJson(w, "success")
+}
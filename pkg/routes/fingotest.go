@@ -10,14 +10,14 @@ import (
 
 func checkPiquette(w http.ResponseWriter, r *http.Request) {
 	api := stockings.FingoPack{}
-	testQuote, err := api.GetCurrentPrice("MELI")
+	testQuote, err := api.GetCurrentPrice(r.Context(), "MELI")
 	if err != nil {
 		fmt.Println(err)
 	}
 	fmt.Fprintln(w, testQuote.LatestPrice)
 
 	date, _ := time.Parse(stockings.DateLayout, "20200102")
-	testHistoric, err := api.GetHistoricalPrice("meli", date)
+	testHistoric, err := api.GetHistoricalPrice(r.Context(), "meli", date)
 
 	if err != nil {
 		fmt.Println(err)
@@ -26,7 +26,7 @@ func checkPiquette(w http.ResponseWriter, r *http.Request) {
 
 	start, _ := time.Parse(stockings.DateLayout, "20200101")
 	end, _ := time.Parse(stockings.DateLayout, "20200107")
-	testHistoricRange, err := api.GetHistoricalRange("meli", start, end)
+	testHistoricRange, err := api.GetHistoricalRange(r.Context(), "meli", start, end)
 	if err != nil {
 		fmt.Println(err)
 	}
@@ -1,10 +1,13 @@
 package routes
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
 
+	"github.com/bluedresscapital/coattails/pkg/auth"
 	"github.com/bluedresscapital/coattails/pkg/robinhood"
 
 	"github.com/bluedresscapital/coattails/pkg/diapers"
@@ -26,6 +29,9 @@ type UpsertOrderRequest struct {
 	IsBuy         bool            `json:"is_buy"`
 	ManuallyAdded bool            `json:"manually_added"`
 	Date          time.Time       `json:"date"`
+	// Only set for option orders. Option.Ticker must match Stock
+	Option      *wardrobe.Option     `json:"option"`
+	OptionEvent wardrobe.OptionEvent `json:"option_event"`
 }
 
 type DeleteOrderRequest struct {
@@ -33,17 +39,23 @@ type DeleteOrderRequest struct {
 	Uid    string `json:"uid"`
 }
 
+type RestoreOrderRequest struct {
+	PortId int    `json:"port_id"`
+	Uid    string `json:"uid"`
+}
+
 func registerOrderRoutes(r *mux.Router) {
 	log.Printf("Registering order routes")
 	s := r.PathPrefix("/order").Subrouter()
-	s.HandleFunc("", authMiddleware(fetchOrdersHandler)).Methods("GET")
-	s.HandleFunc("/upsert", portAuthMiddleware(upsertOrderHandler)).Methods("POST")
-	s.HandleFunc("/delete", portAuthMiddleware(deleteOrderHandler)).Methods("POST")
-	s.HandleFunc("/reload", portAuthMiddleware(reloadOrderHandler)).Methods("POST")
+	s.HandleFunc("", authMiddleware(fetchOrdersHandler, auth.ScopeReadOrders)).Methods("GET")
+	s.HandleFunc("/upsert", portAuthMiddleware(upsertOrderHandler, wardrobe.RoleEditor, auth.ScopeWriteOrders)).Methods("POST")
+	s.HandleFunc("/delete", portAuthMiddleware(deleteOrderHandler, wardrobe.RoleEditor, auth.ScopeWriteOrders)).Methods("POST")
+	s.HandleFunc("/restore", portAuthMiddleware(restoreOrderHandler, wardrobe.RoleEditor, auth.ScopeWriteOrders)).Methods("POST")
+	s.HandleFunc("/reload", portAuthMiddleware(reloadOrderHandler, wardrobe.RoleEditor, auth.ScopeWriteOrders)).Methods("POST")
 }
 
 func fetchOrdersHandler(userId *int, w http.ResponseWriter, r *http.Request) {
-	orders, err := wardrobe.FetchOrdersByUserId(*userId)
+	orders, err := wardrobe.FetchOrdersByUserId(r.Context(), *userId)
 	if err != nil {
 		log.Printf("Error fetching orders: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -60,7 +72,19 @@ func upsertOrderHandler(userId *int, port *wardrobe.Portfolio, w http.ResponseWr
 		log.Printf("Bad request: %v", err)
 		return
 	}
-	err = wardrobe.UpsertOrder(wardrobe.Order{
+	if u.Option != nil {
+		u.Option.Ticker = u.Stock
+		if u.Option.Multiplier.IsZero() {
+			u.Option.Multiplier = wardrobe.DefaultMultiplier
+		}
+		err = wardrobe.UpsertOption(r.Context(), *u.Option)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			log.Printf("Error in upserting option: %v", err)
+			return
+		}
+	}
+	err = wardrobe.UpsertOrder(r.Context(), wardrobe.Order{
 		Uid:           u.Uid,
 		PortId:        u.PortId,
 		Stock:         u.Stock,
@@ -69,17 +93,23 @@ func upsertOrderHandler(userId *int, port *wardrobe.Portfolio, w http.ResponseWr
 		IsBuy:         u.IsBuy,
 		ManuallyAdded: u.ManuallyAdded,
 		Date:          u.Date,
+		OptionEvent:   u.OptionEvent,
 	})
+	if errors.Is(err, wardrobe.ErrDeleted) || errors.Is(err, wardrobe.ErrOtherPortfolio) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprintf(w, "order %v", err)
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		log.Printf("Error in upserting order: %v", err)
 		return
 	}
-	err = diapers.ReloadDepsAndPublish(diapers.Order, port.Id, *userId, GetChannelFromUserId(*userId))
+	err = diapers.ReloadDepsAndPublish(r.Context(), wardrobe.Default(), diapers.Order, port.Id)
 	if err != nil {
 		return
 	}
-	orders, err := wardrobe.FetchOrdersByUserId(*userId)
+	orders, err := wardrobe.FetchOrdersByUserId(r.Context(), *userId)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		log.Printf("Error in fetching orders: %v", err)
@@ -96,17 +126,50 @@ func deleteOrderHandler(userId *int, port *wardrobe.Portfolio, w http.ResponseWr
 		log.Printf("Bad request: %v", err)
 		return
 	}
-	err = wardrobe.DeleteOrder(deleteOrderRequest.Uid, deleteOrderRequest.PortId)
+	err = wardrobe.DeleteOrder(r.Context(), deleteOrderRequest.Uid, deleteOrderRequest.PortId)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		log.Printf("Error in deleting order: %v", err)
 		return
 	}
-	err = diapers.ReloadDepsAndPublish(diapers.Order, port.Id, *userId, GetChannelFromUserId(*userId))
+	err = diapers.ReloadDepsAndPublish(r.Context(), wardrobe.Default(), diapers.Order, port.Id)
 	if err != nil {
 		return
 	}
-	orders, err := wardrobe.FetchOrdersByUserId(*userId)
+	orders, err := wardrobe.FetchOrdersByUserId(r.Context(), *userId)
+	if err != nil {
+		log.Printf("Error fetching orders: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJsonResponse(w, orders)
+}
+
+// Brings back a deleted order
+func restoreOrderHandler(userId *int, port *wardrobe.Portfolio, w http.ResponseWriter, r *http.Request) {
+	var req RestoreOrderRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Printf("Bad request: %v", err)
+		return
+	}
+	err = wardrobe.RestoreOrder(r.Context(), req.Uid, req.PortId)
+	if errors.Is(err, wardrobe.ErrNotDeleted) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprintf(w, "%v", err)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Error in restoring order: %v", err)
+		return
+	}
+	err = diapers.ReloadDepsAndPublish(r.Context(), wardrobe.Default(), diapers.Order, port.Id)
+	if err != nil {
+		return
+	}
+	orders, err := wardrobe.FetchOrdersByUserId(r.Context(), *userId)
 	if err != nil {
 		log.Printf("Error fetching orders: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -119,37 +182,40 @@ func reloadOrderHandler(userId *int, port *wardrobe.Portfolio, w http.ResponseWr
 	var order orders.OrderAPI
 	if port.Type == "tda" {
 		log.Print("Reloading tda orders...")
-		err := validateTdaUsage(*port, *userId)
+		err := validateTdaUsage(r.Context(), *port, *userId)
 		if err != nil {
 			log.Printf("Unable to validate td account usage: %v", err)
 			return
 		}
+		r = r.WithContext(wardrobe.WithSource(r.Context(), wardrobe.SourceTDA))
 		order = tda.API{AccountId: port.TDAccountId}
 	} else if port.Type == "rh" {
 		log.Print("Reloading rh orders...")
-		err := validateRhUsage(*port, *userId)
+		err := validateRhUsage(r.Context(), *port, *userId)
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
+		r = r.WithContext(wardrobe.WithSource(r.Context(), wardrobe.SourceRH))
 		order = robinhood.API{AccountId: port.RHAccountId}
 	}
 	if order != nil {
-		needsUpdate, err := orders.ReloadOrders(order, stockings.FingoPack{})
+		needsUpdate, err := orders.ReloadOrders(r.Context(), wardrobe.Default(), order, stockings.FingoPack{})
 		if err != nil {
 			log.Printf("Encountered error while reloading orders: %v", err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
 		if needsUpdate {
-			err = diapers.ReloadDepsAndPublish(diapers.Order, port.Id, *userId, GetChannelFromUserId(*userId))
+			err = diapers.ReloadDepsAndPublish(r.Context(), wardrobe.Default(), diapers.Order, port.Id)
 			if err != nil {
 				return
 			}
 		}
 	}
-	orders, err := wardrobe.FetchOrdersByUserId(*userId)
-	err = socks.PublishFromServer(GetChannelFromUserId(*userId), "RELOADED_ORDERS", orders)
+	err := socks.PublishToPortfolio(r.Context(), wardrobe.Default(), port.Id, socks.TopicOrders, "RELOADED_ORDERS", func(userId int) (interface{}, error) {
+		return wardrobe.FetchOrdersByUserId(r.Context(), userId)
+	})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
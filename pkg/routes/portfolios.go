@@ -5,6 +5,7 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/bluedresscapital/coattails/pkg/auth"
 	"github.com/bluedresscapital/coattails/pkg/util"
 
 	"github.com/bluedresscapital/coattails/pkg/portfolios"
@@ -17,12 +18,13 @@ import (
 func registerPortfolioRoutes(r *mux.Router) {
 	log.Printf("Registering portfolio routes")
 	s := r.PathPrefix("/portfolio").Subrouter()
-	s.HandleFunc("", authMiddleware(fetchPortfoliosHandler)).Methods("GET")
-	s.HandleFunc("/create", authMiddleware(createPortfolioHandler)).Methods("POST")
-	s.HandleFunc("/history", authMiddleware(fetchPortfolioHistoryHandler)).Methods("GET")
-	s.HandleFunc("/values", authMiddleware(fetchPortfolioValuesHandler)).Methods("GET")
-	s.HandleFunc("/daily_values", authMiddleware(fetchDailyPortfolioValuesHandler)).Methods("GET")
-	s.HandleFunc("/history/reload", portAuthMiddleware(reloadPortfolioHistoryHandler)).Methods("POST")
+	s.HandleFunc("", authMiddleware(fetchPortfoliosHandler, auth.ScopeReadPortfolio)).Methods("GET")
+	s.HandleFunc("/create", authMiddleware(createPortfolioHandler, auth.ScopeWritePortfolio)).Methods("POST")
+	s.HandleFunc("/history", authMiddleware(fetchPortfolioHistoryHandler, auth.ScopeReadPortfolio)).Methods("GET")
+	s.HandleFunc("/values", authMiddleware(fetchPortfolioValuesHandler, auth.ScopeReadPortfolio)).Methods("GET")
+	s.HandleFunc("/daily_values", authMiddleware(fetchDailyPortfolioValuesHandler, auth.ScopeReadPortfolio)).Methods("GET")
+	s.HandleFunc("/history/reload", portAuthMiddleware(reloadPortfolioHistoryHandler, wardrobe.RoleEditor, auth.ScopeWritePortfolio)).Methods("POST")
+	registerPortfolioMemberRoutes(s)
 }
 
 type CreatePortfolioRequest struct {
@@ -31,7 +33,7 @@ type CreatePortfolioRequest struct {
 }
 
 func fetchDailyPortfolioValuesHandler(userId *int, w http.ResponseWriter, r *http.Request) {
-	ports, err := wardrobe.FetchPortfoliosByUserId(*userId)
+	ports, err := wardrobe.FetchPortfoliosByUserId(r.Context(), *userId)
 	if err != nil {
 		log.Printf("Fetching portfolios for user %d failed: %v", *userId, err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -39,7 +41,7 @@ func fetchDailyPortfolioValuesHandler(userId *int, w http.ResponseWriter, r *htt
 	}
 	ret := make(map[int][]wardrobe.DailyPortVal)
 	for _, port := range ports {
-		dpvs, err := wardrobe.FetchDailyPortValuesByPortfolioId(port.Id)
+		dpvs, err := wardrobe.FetchDailyPortValuesByPortfolioId(r.Context(), port.Id)
 		if err != nil {
 			log.Printf("Error fetching daily port values for port %d: %v", port.Id, err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -51,7 +53,7 @@ func fetchDailyPortfolioValuesHandler(userId *int, w http.ResponseWriter, r *htt
 }
 
 func fetchPortfolioValuesHandler(userId *int, w http.ResponseWriter, r *http.Request) {
-	ports, err := wardrobe.FetchPortfoliosByUserId(*userId)
+	ports, err := wardrobe.FetchPortfoliosByUserId(r.Context(), *userId)
 	if err != nil {
 		log.Printf("Fetching portfolios for user %d failed: %v", *userId, err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -61,13 +63,13 @@ func fetchPortfolioValuesHandler(userId *int, w http.ResponseWriter, r *http.Req
 	res := make(map[int]portfolios.PortValueDiff)
 	// TODO - look into fixing edge case where portfolio values len is < 2??
 	for _, port := range ports {
-		currPv, err := wardrobe.FetchPortfolioValueOnDay(port.Id, now)
+		currPv, err := wardrobe.FetchPortfolioValueOnDay(r.Context(), port.Id, now)
 		if err != nil {
 			log.Printf("fetching port value on day %s failed: %v", now, err)
 			w.WriteHeader(http.StatusInternalServerError)
 			return
 		}
-		prevPv, err := wardrobe.FetchPortfolioValueOnDay(port.Id, now.AddDate(0, 0, -1))
+		prevPv, err := wardrobe.FetchPortfolioValueOnDay(r.Context(), port.Id, now.AddDate(0, 0, -1))
 		if err != nil {
 			log.Printf("fetching prev port value on day %s failed: %v", now.AddDate(0, 0, -1), err)
 			w.WriteHeader(http.StatusInternalServerError)
@@ -83,7 +85,7 @@ func fetchPortfolioValuesHandler(userId *int, w http.ResponseWriter, r *http.Req
 }
 
 func fetchPortfoliosHandler(userId *int, w http.ResponseWriter, r *http.Request) {
-	ports, err := wardrobe.FetchPortfoliosByUserId(*userId)
+	ports, err := wardrobe.FetchPortfoliosByUserId(r.Context(), *userId)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		log.Printf("Internal server error: %v", err)
@@ -105,13 +107,13 @@ func createPortfolioHandler(userId *int, w http.ResponseWriter, r *http.Request)
 		_, _ = fmt.Fprintf(w, "invalid portfolio type: %s", createPortRequest.Type)
 		return
 	}
-	err = wardrobe.CreatePortfolio(*userId, createPortRequest.Name, createPortRequest.Type)
+	err = wardrobe.CreatePortfolio(r.Context(), *userId, createPortRequest.Name, createPortRequest.Type)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		_, _ = fmt.Fprintf(w, "unable to create portfolio: %v", err)
 		return
 	}
-	portfolios, err := wardrobe.FetchPortfoliosByUserId(*userId)
+	portfolios, err := wardrobe.FetchPortfoliosByUserId(r.Context(), *userId)
 	if err != nil {
 		log.Printf("Error fetching all portfolios by user: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -121,13 +123,13 @@ func createPortfolioHandler(userId *int, w http.ResponseWriter, r *http.Request)
 }
 
 func fetchPortfolioHistoryHandler(userId *int, w http.ResponseWriter, r *http.Request) {
-	ps, err := wardrobe.FetchPortfoliosByUserId(*userId)
+	ps, err := wardrobe.FetchPortfoliosByUserId(r.Context(), *userId)
 	if err != nil {
 		log.Printf("Error fetching portfolios: %v", err)
 	}
 	perfMap := make(map[int][]wardrobe.PortValue)
 	for _, portfolio := range ps {
-		pvs, err := wardrobe.FetchPortfolioValuesByPortId(portfolio.Id)
+		pvs, err := wardrobe.FetchPortfolioValuesByPortId(r.Context(), portfolio.Id)
 		if err != nil {
 			log.Printf("Error fetching portfolio values: %v", err)
 		}
@@ -137,7 +139,7 @@ func fetchPortfolioHistoryHandler(userId *int, w http.ResponseWriter, r *http.Re
 }
 
 func reloadPortfolioHistoryHandler(userId *int, portfolio *wardrobe.Portfolio, w http.ResponseWriter, r *http.Request) {
-	err := portfolios.ReloadHistory(*portfolio)
+	err := portfolios.ReloadHistory(r.Context(), wardrobe.Default(), *portfolio)
 	if err != nil {
 		log.Printf("Error reloading portfolio: %v", err)
 		return
@@ -4,6 +4,7 @@ import (
 	"log"
 	"net/http"
 
+	"github.com/bluedresscapital/coattails/pkg/auth"
 	"github.com/bluedresscapital/coattails/pkg/wardrobe"
 	"github.com/gorilla/mux"
 )
@@ -11,12 +12,12 @@ import (
 func registerPositionRoutes(r *mux.Router) {
 	log.Printf("Registering tda routes")
 	s := r.PathPrefix("/positions").Subrouter()
-	s.HandleFunc("", authMiddleware(fetchPositionsHandler)).Methods("GET")
-	s.HandleFunc("/portfolio", portAuthMiddleware(fetchPortfolioPositionsHandler)).Methods("GET")
+	s.HandleFunc("", authMiddleware(fetchPositionsHandler, auth.ScopeReadPortfolio)).Methods("GET")
+	s.HandleFunc("/portfolio", portAuthMiddleware(fetchPortfolioPositionsHandler, wardrobe.RoleViewer, auth.ScopeReadPortfolio)).Methods("GET")
 }
 
 func fetchPositionsHandler(userId *int, w http.ResponseWriter, r *http.Request) {
-	positions, err := wardrobe.FetchPositions(*userId)
+	positions, err := wardrobe.FetchPositions(r.Context(), *userId)
 	if err != nil {
 		log.Printf("Error fetching positions: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -28,7 +29,7 @@ func fetchPositionsHandler(userId *int, w http.ResponseWriter, r *http.Request)
 
 func fetchPortfolioPositionsHandler(userId *int, port *wardrobe.Portfolio, w http.ResponseWriter, r *http.Request) {
 	// TODO fetch all positions tied to this portfolio
-	positions, err := wardrobe.FetchPortfolioPositions(port.Id)
+	positions, err := wardrobe.FetchPortfolioPositions(r.Context(), port.Id)
 	if err != nil {
 		return
 	}
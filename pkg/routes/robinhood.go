@@ -1,12 +1,15 @@
 package routes
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 
 	"github.com/bluedresscapital/coattails/pkg/robinhood"
 	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 )
 
@@ -14,12 +17,33 @@ func registerRobinhoodRoutes(r *mux.Router) {
 	log.Printf("Registering rh routes")
 	s := r.PathPrefix("/rh").Subrouter()
 	s.HandleFunc("", authMiddleware(fetchRHAccountsHandler)).Methods("GET")
-	s.HandleFunc("/portfolio/create", authMiddleware(createRHPortfolioHandler)).Methods("POST")
+	s.HandleFunc("/portfolio/create", twoFactorMiddleware(createRHPortfolioHandler)).Methods("POST")
+	s.HandleFunc("/portfolio/verify", twoFactorMiddleware(verifyRHPortfolioHandler)).Methods("POST")
+}
+
+// What we show users about their rh accounts - we never send their robinhood credentials back
+type RHAccountResponse struct {
+	Id       int                 `json:"id"`
+	Username string              `json:"username"`
+	Link     wardrobe.LinkHealth `json:"link"`
 }
 
 func fetchRHAccountsHandler(userId *int, w http.ResponseWriter, r *http.Request) {
-	// TODO?
-	return
+	accounts, err := wardrobe.FetchRHAccountsByUserId(r.Context(), *userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("error in fetching rh accounts: %v", err)
+		return
+	}
+	res := make([]RHAccountResponse, 0)
+	for _, acc := range accounts {
+		res = append(res, RHAccountResponse{
+			Id:       acc.Id,
+			Username: acc.Username,
+			Link:     acc.Link,
+		})
+	}
+	writeJsonResponse(w, res)
 }
 
 type CreateRHPortRequest struct {
@@ -36,19 +60,106 @@ func createRHPortfolioHandler(userId *int, w http.ResponseWriter, r *http.Reques
 		return
 	}
 	// Verify that the refresh token is valid by using it
-	auth, err := robinhood.Login(req.Username, req.Password, req.DeviceTok)
+	auth, err := robinhood.Login(r.Context(), req.Username, req.Password, req.DeviceTok)
 	if err != nil {
+		var challenge *robinhood.ChallengeError
+		if errors.As(err, &challenge) {
+			writeRHChallengeResponse(r.Context(), w, wardrobe.PendingRHLink{
+				UserId:        *userId,
+				Name:          req.Name,
+				Username:      req.Username,
+				Password:      req.Password,
+				DeviceTok:     req.DeviceTok,
+				ChallengeType: challenge.Type,
+				ChallengeId:   challenge.ChallengeId,
+			})
+			return
+		}
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 	// IMPORTANT: Use the NEW auth refresh token, not the request refresh token.
 	// The request token is now invalid
-	err = wardrobe.CreateRHPortfolio(*userId, req.Name, req.Username, req.Password, req.DeviceTok, auth.RefreshTok)
+	err = wardrobe.CreateRHPortfolio(r.Context(), *userId, req.Name, req.Username, req.Password, req.DeviceTok, auth.RefreshTok)
 	if err != nil {
 		log.Printf("Error creating rh portfolio: %v", err)
 		return
 	}
-	portfolios, err := wardrobe.FetchPortfoliosByUserId(*userId)
+	portfolios, err := wardrobe.FetchPortfoliosByUserId(r.Context(), *userId)
+	if err != nil {
+		log.Printf("Error fetching all portfolios by user: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJsonResponse(w, portfolios)
+}
+
+type RHChallengeResponse struct {
+	LinkToken     string `json:"link_token"`
+	ChallengeType string `json:"challenge_type"`
+}
+
+// Holds onto the login robinhood wants verified, and tells the client to ask the user for the code
+func writeRHChallengeResponse(ctx context.Context, w http.ResponseWriter, link wardrobe.PendingRHLink) {
+	token := uuid.New().String()
+	err := wardrobe.SetPendingRHLink(ctx, token, link)
+	if err != nil {
+		log.Printf("Error saving pending rh link: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJsonResponseWithStatus(w, http.StatusAccepted, RHChallengeResponse{
+		LinkToken:     token,
+		ChallengeType: link.ChallengeType,
+	})
+}
+
+type VerifyRHPortRequest struct {
+	LinkToken string `json:"link_token"`
+	Code      string `json:"code"`
+}
+
+// Finishes linking (or re-linking) a robinhood account with the code robinhood sent the user
+func verifyRHPortfolioHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req VerifyRHPortRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	link, err := wardrobe.FetchPendingRHLink(r.Context(), req.LinkToken)
+	if err != nil {
+		log.Printf("Unable to fetch pending rh link: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if link.UserId != *userId {
+		log.Printf("Unauthorized verification of rh link by user %d", *userId)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	challenge := robinhood.ChallengeError{Type: link.ChallengeType, ChallengeId: link.ChallengeId}
+	auth, err := robinhood.VerifyLogin(r.Context(), link.Username, link.Password, link.DeviceTok, challenge, req.Code)
+	if err != nil {
+		log.Printf("Error verifying rh login: %v", err)
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	if link.AccountId != 0 {
+		err = wardrobe.UpdateRHAccount(r.Context(), link.AccountId, *userId, link.Username, link.Password, link.DeviceTok, auth.RefreshTok)
+	} else {
+		err = wardrobe.CreateRHPortfolio(r.Context(), *userId, link.Name, link.Username, link.Password, link.DeviceTok, auth.RefreshTok)
+	}
+	if err != nil {
+		log.Printf("Error saving rh account: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	err = wardrobe.ClearPendingRHLink(r.Context(), req.LinkToken, *link)
+	if err != nil {
+		log.Printf("Error clearing pending rh link: %v", err)
+	}
+	portfolios, err := wardrobe.FetchPortfoliosByUserId(r.Context(), *userId)
 	if err != nil {
 		log.Printf("Error fetching all portfolios by user: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -58,8 +169,8 @@ func createRHPortfolioHandler(userId *int, w http.ResponseWriter, r *http.Reques
 }
 
 // Verifies that the portfolio's rh_account is in fact owned by the user
-func validateRhUsage(port wardrobe.Portfolio, userId int) error {
-	acc, err := wardrobe.FetchRHAccount(port.RHAccountId)
+func validateRhUsage(ctx context.Context, port wardrobe.Portfolio, userId int) error {
+	acc, err := wardrobe.FetchRHAccount(ctx, port.RHAccountId)
 	if err != nil {
 		return fmt.Errorf("unable to fetch rh account %d", port.RHAccountId)
 	}
@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -9,6 +10,17 @@ import (
 	"github.com/gorilla/mux"
 )
 
+// How long a request gets before everything it's doing (queries, broker calls, reloads) is cancelled
+var RequestTimeout = 15 * time.Second
+
+func timeoutMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), RequestTimeout)
+		defer cancel()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
 func testHandler(w http.ResponseWriter, r *http.Request) {
 	log.Print("Sleeping 5s..")
 	time.Sleep(time.Duration(5) * time.Second)
@@ -21,6 +33,7 @@ func healthHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func RegisterAllRoutes(r *mux.Router) {
+	r.Use(timeoutMiddleware)
 	r.HandleFunc("/health", healthHandler)
 	r.HandleFunc("/test", testHandler)
 	r.HandleFunc("/apitest", apiCheck)
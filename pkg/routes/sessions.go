@@ -0,0 +1,195 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/auth"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/gorilla/mux"
+)
+
+var (
+	// Whether session cookies are only sent over https. Only turn off when serving over plain http, i.e.
+	// self-hosting on a home network.
+	SecureCookies = true
+	// Whether to take the client's ip from X-Forwarded-For instead of the connection. Only turn on behind a
+	// proxy that sets it, otherwise clients can claim any ip they like.
+	TrustProxyHeaders = false
+)
+
+const sessionCookieName = "session_token"
+
+// Every authed route lives under /auth, so that's the only place the cookie needs to go
+const sessionCookiePath = "/auth"
+
+// Where session cookies used to go. Browsers can still have one there, which is expired whenever we set or
+// clear the current one.
+const legacySessionCookiePath = "/"
+
+// All session routes should be under /auth prefix
+func registerSessionRoutes(r *mux.Router) {
+	log.Printf("Registering session routes")
+	r.HandleFunc("/sessions", authMiddleware(fetchSessionsHandler)).Methods("GET")
+	r.HandleFunc("/sessions/revoke", authMiddleware(revokeSessionHandler)).Methods("POST")
+	r.HandleFunc("/sessions/revoke-all", authMiddleware(revokeOtherSessionsHandler)).Methods("POST")
+}
+
+func setSessionCookie(w http.ResponseWriter, token string, expiresAt time.Time) {
+	expireSessionCookie(w, legacySessionCookiePath)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Value:    token,
+		Path:     sessionCookiePath,
+		Expires:  expiresAt,
+		Secure:   SecureCookies,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func clearSessionCookie(w http.ResponseWriter) {
+	expireSessionCookie(w, legacySessionCookiePath)
+	expireSessionCookie(w, sessionCookiePath)
+}
+
+func expireSessionCookie(w http.ResponseWriter, path string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookieName,
+		Path:     path,
+		MaxAge:   -1,
+		Secure:   SecureCookies,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// The session token the client sent, or "" if it didn't send one
+func sessionToken(r *http.Request) string {
+	c, err := r.Cookie(sessionCookieName)
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
+
+func sessionMeta(r *http.Request) auth.SessionMeta {
+	return auth.SessionMeta{IP: clientIP(r), UserAgent: r.UserAgent()}
+}
+
+func clientIP(r *http.Request) string {
+	if TrustProxyHeaders {
+		// The first hop is the client, the rest are proxies
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			return strings.TrimSpace(strings.Split(fwd, ",")[0])
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type sessionKey struct{}
+
+func withSession(r *http.Request, s *wardrobe.Session) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), sessionKey{}, s))
+}
+
+// The session authMiddleware verified the request with, or nil if it was made with an api token
+func currentSession(r *http.Request) *wardrobe.Session {
+	s, _ := r.Context().Value(sessionKey{}).(*wardrobe.Session)
+	return s
+}
+
+type SessionResponse struct {
+	wardrobe.Session
+	// Whether this is the session the request was made with
+	Current bool `json:"current"`
+}
+
+func fetchSessionsHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	sessions, err := wardrobe.FetchSessionsByUserId(r.Context(), *userId)
+	if err != nil {
+		log.Printf("Error fetching sessions for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	current := currentSession(r)
+	res := make([]SessionResponse, 0)
+	for _, s := range sessions {
+		res = append(res, SessionResponse{Session: s, Current: s.Id == current.Id})
+	}
+	writeJsonResponse(w, res)
+}
+
+type revokeSessionRequest struct {
+	Id string `json:"id"`
+}
+
+// Revokes one of the user's sessions. Revoking the current session is the same as logging out.
+func revokeSessionHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req revokeSessionRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	err = wardrobe.DeleteSession(r.Context(), *userId, req.Id)
+	if err != nil {
+		log.Printf("Error revoking session for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if req.Id == currentSession(r).Id {
+		clearSessionCookie(w)
+	}
+	writeStatusResponseJson(w, "success")
+}
+
+// Revokes every one of the user's sessions but the one making the request, i.e. to log out everywhere else
+// after noticing a session you don't recognize
+func revokeOtherSessionsHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	err := wardrobe.DeleteSessionsByUserId(r.Context(), *userId, currentSession(r).Id)
+	if err != nil {
+		log.Printf("Error revoking sessions for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeStatusResponseJson(w, "success")
+}
+
+// Verifies the request's session, sliding it (and its cookie) forward. Returns nil, with the response
+// already written, if the request isn't logged in.
+func verifySession(w http.ResponseWriter, r *http.Request) *wardrobe.Session {
+	c, statusCode, err := fetchCookie(r)
+	if err != nil {
+		w.WriteHeader(statusCode)
+		_, _ = w.Write([]byte(err.Error()))
+		return nil
+	}
+	session, touched, err := auth.VerifySession(r.Context(), c.Value, sessionMeta(r))
+	if err != nil {
+		if errors.Is(err, wardrobe.ErrNoSession) {
+			// This means there wasn't a live session for the cookie
+			clearSessionCookie(w)
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte("Invalid session_token cookie"))
+			return nil
+		}
+		// If there is an error fetching from cache, return an internal server error status
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte(err.Error()))
+		return nil
+	}
+	if touched {
+		setSessionCookie(w, c.Value, session.ExpiresAt)
+	}
+	return session
+}
@@ -0,0 +1,391 @@
+package routes
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/gorilla/mux"
+)
+
+// Longest a household's name can be
+const maxHouseholdNameLen = 100
+
+// All portfolio member routes should be under /auth/portfolio prefix
+func registerPortfolioMemberRoutes(s *mux.Router) {
+	log.Printf("Registering portfolio member routes")
+	s.HandleFunc("/members", portAuthMiddleware(fetchPortfolioMembersHandler, wardrobe.RoleOwner)).Methods("POST")
+	s.HandleFunc("/members/share", portAuthMiddleware(sharePortfolioHandler, wardrobe.RoleOwner)).Methods("POST")
+	s.HandleFunc("/members/remove", portAuthMiddleware(removePortfolioMemberHandler, wardrobe.RoleViewer)).Methods("POST")
+}
+
+// All household routes should be under /auth prefix
+func registerHouseholdRoutes(r *mux.Router) {
+	log.Printf("Registering household routes")
+	s := r.PathPrefix("/household").Subrouter()
+	s.HandleFunc("", authMiddleware(fetchHouseholdsHandler)).Methods("GET")
+	s.HandleFunc("/create", authMiddleware(createHouseholdHandler)).Methods("POST")
+	s.HandleFunc("/invite", authMiddleware(inviteHouseholdMemberHandler)).Methods("POST")
+	s.HandleFunc("/accept", authMiddleware(acceptHouseholdInviteHandler)).Methods("POST")
+	s.HandleFunc("/leave", authMiddleware(leaveHouseholdHandler)).Methods("POST")
+	s.HandleFunc("/remove", authMiddleware(removeHouseholdMemberHandler)).Methods("POST")
+}
+
+// Looks up the usernames of userIds, since the store only knows users by id
+func fetchUsernames(ctx context.Context, userIds []int) (map[int]string, error) {
+	usernames := make(map[int]string)
+	for _, id := range userIds {
+		if _, found := usernames[id]; found {
+			continue
+		}
+		username, err := wardrobe.FetchUserById(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		usernames[id] = *username
+	}
+	return usernames, nil
+}
+
+// Writes the response for a request naming a user that doesn't exist. Returns false if err is something else.
+func writeNoUserError(w http.ResponseWriter, err error) bool {
+	if errors.Is(err, wardrobe.ErrNoUser) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return true
+	}
+	return false
+}
+
+type PortfolioMemberResponse struct {
+	wardrobe.PortfolioMember
+	Username string `json:"username"`
+}
+
+func writePortfolioMembers(w http.ResponseWriter, r *http.Request, portId int) {
+	members, err := wardrobe.FetchPortfolioMembers(r.Context(), portId)
+	if err != nil {
+		log.Printf("Error fetching members of port %d: %v", portId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	userIds := make([]int, 0)
+	for _, m := range members {
+		userIds = append(userIds, m.UserId)
+	}
+	usernames, err := fetchUsernames(r.Context(), userIds)
+	if err != nil {
+		log.Printf("Error fetching usernames of port %d's members: %v", portId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res := make([]PortfolioMemberResponse, 0)
+	for _, m := range members {
+		res = append(res, PortfolioMemberResponse{PortfolioMember: m, Username: usernames[m.UserId]})
+	}
+	writeJsonResponse(w, res)
+}
+
+// Returns who the portfolio is shared with, not counting anyone who can only see it through a household
+func fetchPortfolioMembersHandler(userId *int, port *wardrobe.Portfolio, w http.ResponseWriter, r *http.Request) {
+	writePortfolioMembers(w, r, port.Id)
+}
+
+type sharePortfolioRequest struct {
+	PortId   int                    `json:"port_id"`
+	Username string                 `json:"username"`
+	Role     wardrobe.PortfolioRole `json:"role"`
+}
+
+// Shares the portfolio with another user as a viewer or editor, or changes the role of someone it's already
+// shared with
+func sharePortfolioHandler(userId *int, port *wardrobe.Portfolio, w http.ResponseWriter, r *http.Request) {
+	var req sharePortfolioRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	if !wardrobe.IsShareableRole(req.Role) {
+		http.Error(w, fmt.Sprintf("invalid role: %s", req.Role), http.StatusBadRequest)
+		return
+	}
+	memberId, err := wardrobe.FetchUserIdByUsername(r.Context(), req.Username)
+	if err != nil {
+		if !writeNoUserError(w, err) {
+			log.Printf("Error fetching user %s: %v", req.Username, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	err = wardrobe.UpsertPortfolioMember(r.Context(), wardrobe.PortfolioMember{
+		PortId:    port.Id,
+		UserId:    *memberId,
+		Role:      req.Role,
+		CreatedAt: time.Now(),
+	})
+	if errors.Is(err, wardrobe.ErrAlreadyMember) {
+		http.Error(w, "can't share a portfolio with its owner", http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error sharing port %d with user %d: %v", port.Id, *memberId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writePortfolioMembers(w, r, port.Id)
+}
+
+type removePortfolioMemberRequest struct {
+	PortId   int    `json:"port_id"`
+	Username string `json:"username"`
+}
+
+// Stops sharing the portfolio with a user. Only the owner can remove others, but anyone it's shared with can
+// remove themselves.
+func removePortfolioMemberHandler(userId *int, port *wardrobe.Portfolio, w http.ResponseWriter, r *http.Request) {
+	var req removePortfolioMemberRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	memberId, err := wardrobe.FetchUserIdByUsername(r.Context(), req.Username)
+	if err != nil {
+		if !writeNoUserError(w, err) {
+			log.Printf("Error fetching user %s: %v", req.Username, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	if *memberId != *userId && port.UserId != *userId {
+		http.Error(w, "owner access to portfolio required", http.StatusForbidden)
+		return
+	}
+	err = wardrobe.DeletePortfolioMember(r.Context(), port.Id, *memberId)
+	if err != nil {
+		log.Printf("Error removing user %d from port %d: %v", *memberId, port.Id, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeStatusResponseJson(w, "success")
+}
+
+type HouseholdUserResponse struct {
+	wardrobe.HouseholdUser
+	Username string `json:"username"`
+}
+
+type HouseholdResponse struct {
+	wardrobe.Household
+	Members []HouseholdUserResponse `json:"members"`
+}
+
+func writeHouseholds(w http.ResponseWriter, r *http.Request, userId int) {
+	households, err := wardrobe.FetchHouseholdsByUserId(r.Context(), userId)
+	if err != nil {
+		log.Printf("Error fetching households for user %d: %v", userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	userIds := make([]int, 0)
+	for _, h := range households {
+		for _, m := range h.Members {
+			userIds = append(userIds, m.UserId)
+		}
+	}
+	usernames, err := fetchUsernames(r.Context(), userIds)
+	if err != nil {
+		log.Printf("Error fetching usernames of user %d's households: %v", userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res := make([]HouseholdResponse, 0)
+	for _, h := range households {
+		hr := HouseholdResponse{Household: h, Members: make([]HouseholdUserResponse, 0)}
+		for _, m := range h.Members {
+			hr.Members = append(hr.Members, HouseholdUserResponse{HouseholdUser: m, Username: usernames[m.UserId]})
+		}
+		res = append(res, hr)
+	}
+	writeJsonResponse(w, res)
+}
+
+// Returns the households the user is in, along with any they've been invited to
+func fetchHouseholdsHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	writeHouseholds(w, r, *userId)
+}
+
+type createHouseholdRequest struct {
+	Name string `json:"name"`
+}
+
+func createHouseholdHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req createHouseholdRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	name := strings.TrimSpace(req.Name)
+	if name == "" || len(name) > maxHouseholdNameLen {
+		http.Error(w, fmt.Sprintf("household name must be 1 to %d characters", maxHouseholdNameLen), http.StatusBadRequest)
+		return
+	}
+	_, err = wardrobe.CreateHousehold(r.Context(), name, *userId)
+	if err != nil {
+		log.Printf("Error creating household for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeHouseholds(w, r, *userId)
+}
+
+type householdUserRequest struct {
+	HouseholdId int    `json:"household_id"`
+	Username    string `json:"username"`
+}
+
+// Returns the user's membership of the household if they're its owner, otherwise writes an error response
+// and returns nil
+func requireHouseholdOwner(w http.ResponseWriter, r *http.Request, householdId int, userId int) *wardrobe.HouseholdUser {
+	hu, err := wardrobe.FetchHouseholdUser(r.Context(), householdId, userId)
+	if err != nil {
+		log.Printf("Error fetching user %d's membership of household %d: %v", userId, householdId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil
+	}
+	if hu == nil || hu.AcceptedAt == nil {
+		http.Error(w, "no such household", http.StatusNotFound)
+		return nil
+	}
+	if hu.Role != wardrobe.HouseholdOwner {
+		http.Error(w, "only the household's owner can do that", http.StatusForbidden)
+		return nil
+	}
+	return hu
+}
+
+// Invites a user to the household. Nobody's portfolios are shared with them (or theirs with anyone) until
+// they accept.
+func inviteHouseholdMemberHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req householdUserRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	if requireHouseholdOwner(w, r, req.HouseholdId, *userId) == nil {
+		return
+	}
+	memberId, err := wardrobe.FetchUserIdByUsername(r.Context(), req.Username)
+	if err != nil {
+		if !writeNoUserError(w, err) {
+			log.Printf("Error fetching user %s: %v", req.Username, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	err = wardrobe.InviteHouseholdMember(r.Context(), req.HouseholdId, *memberId)
+	if errors.Is(err, wardrobe.ErrAlreadyMember) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Error inviting user %d to household %d: %v", *memberId, req.HouseholdId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeHouseholds(w, r, *userId)
+}
+
+type householdRequest struct {
+	HouseholdId int `json:"household_id"`
+}
+
+func acceptHouseholdInviteHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req householdRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	err = wardrobe.AcceptHouseholdInvite(r.Context(), req.HouseholdId, *userId)
+	if errors.Is(err, wardrobe.ErrNoHouseholdInvite) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Error accepting user %d's invite to household %d: %v", *userId, req.HouseholdId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeHouseholds(w, r, *userId)
+}
+
+// Leaves the household, or declines an invite to it. The owner leaving deletes the household for everyone.
+func leaveHouseholdHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req householdRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	hu, err := wardrobe.FetchHouseholdUser(r.Context(), req.HouseholdId, *userId)
+	if err != nil {
+		log.Printf("Error fetching user %d's membership of household %d: %v", *userId, req.HouseholdId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if hu == nil {
+		http.Error(w, "no such household", http.StatusNotFound)
+		return
+	}
+	if hu.Role == wardrobe.HouseholdOwner {
+		err = wardrobe.DeleteHousehold(r.Context(), req.HouseholdId)
+	} else {
+		err = wardrobe.DeleteHouseholdMember(r.Context(), req.HouseholdId, *userId)
+	}
+	if err != nil {
+		log.Printf("Error removing user %d from household %d: %v", *userId, req.HouseholdId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeHouseholds(w, r, *userId)
+}
+
+// Removes someone else from the household (or takes back their invite)
+func removeHouseholdMemberHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req householdUserRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	if requireHouseholdOwner(w, r, req.HouseholdId, *userId) == nil {
+		return
+	}
+	memberId, err := wardrobe.FetchUserIdByUsername(r.Context(), req.Username)
+	if err != nil {
+		if !writeNoUserError(w, err) {
+			log.Printf("Error fetching user %s: %v", req.Username, err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	if *memberId == *userId {
+		http.Error(w, "use /household/leave to leave a household", http.StatusBadRequest)
+		return
+	}
+	err = wardrobe.DeleteHouseholdMember(r.Context(), req.HouseholdId, *memberId)
+	if err != nil {
+		log.Printf("Error removing user %d from household %d: %v", *memberId, req.HouseholdId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeHouseholds(w, r, *userId)
+}
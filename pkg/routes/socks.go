@@ -1,13 +1,12 @@
 package routes
 
 import (
-	"fmt"
 	"log"
 	"net/http"
+	"strings"
 
 	"github.com/bluedresscapital/coattails/pkg/socks"
 	"github.com/bluedresscapital/coattails/pkg/wardrobe"
-	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
@@ -17,27 +16,81 @@ var upgrader = websocket.Upgrader{
 }
 
 func GetChannelFromUserId(userId int) string {
-	return fmt.Sprintf("chanel_user_id_%d", userId)
+	return socks.GetChannelFromUserId(userId)
 }
 
+// Takes two optional query params: since, the cursor of the last event the client saw (to replay everything
+// it missed while disconnected), and topics, a comma separated list of topics to start out subscribed to.
 func testWebSocket(userId *int, w http.ResponseWriter, r *http.Request) {
 	upgrader.CheckOrigin = func(r *http.Request) bool {
 		return true
 	}
+	since, topics, ok := parseStreamParams(userId, w, r, r.URL.Query().Get("since"))
+	if !ok {
+		return
+	}
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		log.Printf("err upgrading: %v", err)
 		return
 	}
-	channel := GetChannelFromUserId(*userId)
-	log.Printf("Creating web socket connection for channel %s!", channel)
-	sub := wardrobe.Sub(channel)
-	client := socks.Client{
-		Uid:     uuid.New().String(),
-		Channel: channel,
-		Conn:    conn,
-		Sub:     sub,
-	}
+	client := socks.NewClient(*userId, conn, wardrobe.Default(), since)
+	subscribeAll(r, client, topics)
+	log.Printf("Creating web socket connection for channel %s!", client.Channel)
 	go client.ReadFromClient()
 	go client.WriteToClient()
 }
+
+// The same events as the websocket, as a server-sent event stream. Takes the same query params, and resumes
+// from the Last-Event-ID header (which EventSources set when they reconnect) over since.
+func eventStreamHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	since := r.Header.Get("Last-Event-ID")
+	if since == "" {
+		since = r.URL.Query().Get("since")
+	}
+	since, topics, ok := parseStreamParams(userId, w, r, since)
+	if !ok {
+		return
+	}
+	client, err := socks.NewEventStreamClient(*userId, w, wardrobe.Default(), since)
+	if err != nil {
+		log.Printf("Error starting event stream: %v", err)
+		return
+	}
+	subscribeAll(r, client, topics)
+	log.Printf("Creating event stream for channel %s!", client.Channel)
+	// The request's context is cancelled when the peer hangs up, or when the server gives up waiting on
+	// requests during shutdown
+	client.ServeEventStream(r.Context())
+}
+
+// Checks the since cursor and topics query param, before the stream starts, so a bad one fails the whole request
+func parseStreamParams(userId *int, w http.ResponseWriter, r *http.Request, since string) (string, []string, bool) {
+	if since != "" {
+		if _, err := wardrobe.ParseStreamId(since); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return "", nil, false
+		}
+	}
+	topics := make([]string, 0)
+	if t := r.URL.Query().Get("topics"); t != "" {
+		for _, topic := range strings.Split(t, ",") {
+			topic, err := socks.CheckTopic(r.Context(), wardrobe.Default(), *userId, topic)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return "", nil, false
+			}
+			topics = append(topics, topic)
+		}
+	}
+	return since, topics, true
+}
+
+func subscribeAll(r *http.Request, client *socks.Client, topics []string) {
+	for _, topic := range topics {
+		err := client.Subscribe(r.Context(), topic)
+		if err != nil {
+			log.Printf("Error subscribing to %s: %v", topic, err)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"time"
 
 	"github.com/bluedresscapital/coattails/pkg/stockings"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
 	"github.com/gorilla/mux"
 )
 
@@ -29,10 +30,10 @@ func stockQuoteHandler(w http.ResponseWriter, r *http.Request) {
 		log.Printf("Invalid end string: %s", endStr)
 		return
 	}
-	prices, err := stockings.GetHistoricalRange(stockings.FingoPack{}, vars["ticker"], start, end)
+	prices, err := stockings.GetHistoricalRange(r.Context(), wardrobe.Default(), stockings.FingoPack{}, vars["ticker"], start, end)
 	if err != nil {
 		log.Printf("Error in getting historical range with fingoPack: %v, falling back to iex!", err)
-		prices, err = stockings.GetHistoricalRange(stockings.IexApi{}, vars["ticker"], start, end)
+		prices, err = stockings.GetHistoricalRange(r.Context(), wardrobe.Default(), stockings.IexApi{}, vars["ticker"], start, end)
 		if err != nil {
 			log.Printf("Error in getting historical range with iex: %v, failing request(", err)
 			return
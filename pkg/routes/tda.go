@@ -1,6 +1,7 @@
 package routes
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"net/http"
@@ -18,12 +19,12 @@ func registerTDARoutes(r *mux.Router) {
 	log.Printf("Registering tda routes")
 	s := r.PathPrefix("/tda").Subrouter()
 	s.HandleFunc("", authMiddleware(fetchTDAccountsHandler)).Methods("GET")
-	s.HandleFunc("/portfolio/create", authMiddleware(createTDPortfolioHandler)).Methods("POST")
-	s.HandleFunc("/portfolio/update", authMiddleware(updateTDPortfolioHandler)).Methods("POST")
+	s.HandleFunc("/portfolio/create", twoFactorMiddleware(createTDPortfolioHandler)).Methods("POST")
+	s.HandleFunc("/portfolio/update", twoFactorMiddleware(updateTDPortfolioHandler)).Methods("POST")
 }
 
 func fetchTDAccountsHandler(userId *int, w http.ResponseWriter, r *http.Request) {
-	accounts, err := wardrobe.FetchTDAccountsByUserId(*userId)
+	accounts, err := wardrobe.FetchTDAccountsByUserId(r.Context(), *userId)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		log.Printf("error in fetching td accounts: %v", err)
@@ -47,25 +48,25 @@ func updateTDPortfolioHandler(userId *int, w http.ResponseWriter, r *http.Reques
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	port, err := wardrobe.FetchPortfolioById(req.PortId)
-	err = validateTdaUsage(*port, *userId)
+	port, err := wardrobe.FetchPortfolioById(r.Context(), req.PortId)
+	err = validateTdaUsage(r.Context(), *port, *userId)
 	if err != nil {
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
-	auth, err := tda.FetchRefreshTokenUsingAuthCode(req.Code, tda.ClientId)
+	auth, err := tda.FetchRefreshTokenUsingAuthCode(r.Context(), req.Code, tda.ClientId)
 	if err != nil {
 		log.Printf("Error fetching refresh token: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	err = wardrobe.UpdateTDPortfolio(port.TDAccountId, *userId, req.AccountNum, auth.RefreshToken)
+	err = wardrobe.UpdateTDPortfolio(r.Context(), port.TDAccountId, *userId, req.AccountNum, auth.RefreshToken)
 	if err != nil {
 		log.Printf("Error updating td port: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	portfolios, err := wardrobe.FetchPortfoliosByUserId(*userId)
+	portfolios, err := wardrobe.FetchPortfoliosByUserId(r.Context(), *userId)
 	if err != nil {
 		log.Printf("Error fetching all portfolios by user: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -88,19 +89,19 @@ func createTDPortfolioHandler(userId *int, w http.ResponseWriter, r *http.Reques
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	auth, err := tda.FetchRefreshTokenUsingAuthCode(req.Code, tda.ClientId)
+	auth, err := tda.FetchRefreshTokenUsingAuthCode(r.Context(), req.Code, tda.ClientId)
 	if err != nil {
 		log.Printf("Error fetching refresh token: %v", err)
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
-	err = wardrobe.CreateTDPortfolio(*userId, req.Name, req.AccountNum, auth.RefreshToken)
+	err = wardrobe.CreateTDPortfolio(r.Context(), *userId, req.Name, req.AccountNum, auth.RefreshToken)
 	if err != nil {
 		log.Printf("Error creating td portfolio: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	portfolios, err := wardrobe.FetchPortfoliosByUserId(*userId)
+	portfolios, err := wardrobe.FetchPortfoliosByUserId(r.Context(), *userId)
 	if err != nil {
 		log.Printf("Error fetching all portfolios by user: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -110,8 +111,8 @@ func createTDPortfolioHandler(userId *int, w http.ResponseWriter, r *http.Reques
 }
 
 // Verifies that the portfolio's tda_account is in fact owned by the user id
-func validateTdaUsage(port wardrobe.Portfolio, userId int) error {
-	auth, err := wardrobe.FetchTDAccount(port.TDAccountId)
+func validateTdaUsage(ctx context.Context, port wardrobe.Portfolio, userId int) error {
+	auth, err := wardrobe.FetchTDAccount(ctx, port.TDAccountId)
 	if err != nil {
 		return fmt.Errorf("unable to fetch td account %d: %v", port.TDAccountId, err)
 	}
@@ -0,0 +1,134 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/auth"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/gorilla/mux"
+)
+
+// Longest a token can be set to last
+const maxAPITokenExpiryDays = 365
+
+// All api token routes should be under /auth prefix. Managing tokens takes a session, so a leaked token
+// can't be used to mint more.
+func registerAPITokenRoutes(r *mux.Router) {
+	log.Printf("Registering api token routes")
+	r.HandleFunc("/tokens", authMiddleware(fetchAPITokensHandler)).Methods("GET")
+	r.HandleFunc("/tokens/create", authMiddleware(createAPITokenHandler)).Methods("POST")
+	r.HandleFunc("/tokens/revoke", authMiddleware(revokeAPITokenHandler)).Methods("POST")
+}
+
+// The token from an Authorization: Bearer header, if the request has one
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(h) < len(prefix) || !strings.EqualFold(h[:len(prefix)], prefix) {
+		return "", false
+	}
+	return strings.TrimSpace(h[len(prefix):]), true
+}
+
+// Runs handler as the owner of api token, if it has every one of scopes. Routes that don't list any scopes
+// never take api tokens.
+func apiTokenAuth(handler func(*int, http.ResponseWriter, *http.Request), token string, scopes []auth.Scope, w http.ResponseWriter, r *http.Request) {
+	if len(scopes) == 0 {
+		http.Error(w, "api tokens can't be used for this route", http.StatusForbidden)
+		return
+	}
+	t, err := auth.VerifyAPIToken(r.Context(), token, scopes...)
+	if err != nil {
+		switch {
+		case errors.Is(err, auth.ErrInvalidAPIToken):
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+		case errors.Is(err, auth.ErrInsufficientScope):
+			http.Error(w, err.Error(), http.StatusForbidden)
+		default:
+			log.Printf("Error verifying api token: %v", err)
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		return
+	}
+	userId := &t.UserId
+	r = r.WithContext(wardrobe.WithActor(r.Context(), *userId, wardrobe.SourceAPI))
+	handler(userId, w, r)
+}
+
+func fetchAPITokensHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	tokens, err := wardrobe.FetchAPITokensByUserId(r.Context(), *userId)
+	if err != nil {
+		log.Printf("Error fetching api tokens for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJsonResponse(w, tokens)
+}
+
+type createAPITokenRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+	// 0 means the token never expires
+	ExpiresInDays int `json:"expires_in_days"`
+}
+
+type CreateAPITokenResponse struct {
+	wardrobe.APIToken
+	// Only ever returned here, the user has to copy it now
+	Token string `json:"token"`
+}
+
+func createAPITokenHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req createAPITokenRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	if req.ExpiresInDays < 0 || req.ExpiresInDays > maxAPITokenExpiryDays {
+		http.Error(w, fmt.Sprintf("expires_in_days must be between 0 and %d", maxAPITokenExpiryDays), http.StatusBadRequest)
+		return
+	}
+	expiresIn := time.Duration(req.ExpiresInDays*24) * time.Hour
+	token, t, err := auth.CreateAPIToken(r.Context(), *userId, req.Name, req.Scopes, expiresIn)
+	if err != nil {
+		var invalid *auth.InvalidAPITokenRequestError
+		if errors.As(err, &invalid) {
+			http.Error(w, invalid.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error creating api token for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJsonResponse(w, CreateAPITokenResponse{APIToken: *t, Token: *token})
+}
+
+type revokeAPITokenRequest struct {
+	Id int `json:"id"`
+}
+
+func revokeAPITokenHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req revokeAPITokenRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	err = wardrobe.DeleteAPIToken(r.Context(), *userId, req.Id)
+	if err != nil {
+		if errors.Is(err, wardrobe.ErrNoAPIToken) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error revoking api token for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeStatusResponseJson(w, "success")
+}
@@ -0,0 +1,128 @@
+package routes
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/auth"
+	"github.com/bluedresscapital/coattails/pkg/secrets"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+)
+
+// Points wardrobe at a fresh, fully migrated sqlite db, and returns the id of a user in it
+func newTestUser(t *testing.T) int {
+	t.Helper()
+	ctx := context.Background()
+	wardrobe.ConnectSQLite(filepath.Join(t.TempDir(), "wardrobe.db"))
+	t.Cleanup(func() { _ = wardrobe.CloseDB() })
+	wardrobe.InitLocalCache()
+	latest, err := wardrobe.LatestSchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wardrobe.MigrateUp(ctx, latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wardrobe.CreateUser(ctx, "alice", []byte("hash"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	userId, err := wardrobe.FetchUserIdByUsername(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *userId
+}
+
+func newTestAPIToken(t *testing.T, userId int, scopes ...auth.Scope) string {
+	t.Helper()
+	names := make([]string, 0)
+	for _, s := range scopes {
+		names = append(names, string(s))
+	}
+	token, _, err := auth.CreateAPIToken(context.Background(), userId, "test", names, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return *token
+}
+
+func TestAuthMiddlewareScopes(t *testing.T) {
+	ctx := context.Background()
+	userId := newTestUser(t)
+	session, err := auth.RotateSession(ctx, "", userId, false, auth.SessionMeta{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	readOrders := newTestAPIToken(t, userId, auth.ScopeReadOrders)
+	readWriteOrders := newTestAPIToken(t, userId, auth.ScopeReadOrders, auth.ScopeWriteOrders)
+	expired := "cat_expired"
+	hash := secrets.Hash(expired)
+	expiredAt := time.Now().Add(-time.Minute)
+	_, err = wardrobe.CreateAPIToken(ctx, wardrobe.APIToken{
+		UserId:    userId,
+		Name:      "expired",
+		Scopes:    []string{string(auth.ScopeReadOrders)},
+		CreatedAt: expiredAt.Add(-time.Hour),
+		ExpiresAt: &expiredAt,
+	}, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		scopes        []auth.Scope
+		authorization string
+		cookie        string
+		wantStatus    int
+	}{
+		{"nothing", []auth.Scope{auth.ScopeReadOrders}, "", "", http.StatusUnauthorized},
+		{"session on an account route", nil, "", *session, http.StatusOK},
+		{"session on a scoped route", []auth.Scope{auth.ScopeReadOrders}, "", *session, http.StatusOK},
+		{"token on an account route", nil, "Bearer " + readWriteOrders, "", http.StatusForbidden},
+		{"token with the route's scope", []auth.Scope{auth.ScopeReadOrders}, "Bearer " + readOrders, "", http.StatusOK},
+		{"lower case bearer", []auth.Scope{auth.ScopeReadOrders}, "bearer " + readOrders, "", http.StatusOK},
+		{"token without the route's scope", []auth.Scope{auth.ScopeReadTransfers}, "Bearer " + readOrders, "", http.StatusForbidden},
+		{"token with only some of the route's scopes", []auth.Scope{auth.ScopeReadOrders, auth.ScopeWriteOrders}, "Bearer " + readOrders, "", http.StatusForbidden},
+		{"token with all of the route's scopes", []auth.Scope{auth.ScopeReadOrders, auth.ScopeWriteOrders}, "Bearer " + readWriteOrders, "", http.StatusOK},
+		{"token takes precedence over the session", []auth.Scope{auth.ScopeReadTransfers}, "Bearer " + readOrders, *session, http.StatusForbidden},
+		{"unknown token", []auth.Scope{auth.ScopeReadOrders}, "Bearer cat_unknown", "", http.StatusUnauthorized},
+		{"not an api token", []auth.Scope{auth.ScopeReadOrders}, "Bearer " + *session, "", http.StatusUnauthorized},
+		{"expired token", []auth.Scope{auth.ScopeReadOrders}, "Bearer " + expired, "", http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotUserId *int
+			handler := authMiddleware(func(userId *int, w http.ResponseWriter, r *http.Request) {
+				gotUserId = userId
+			}, tt.scopes...)
+			r := httptest.NewRequest("GET", "/auth/orders", nil)
+			if tt.authorization != "" {
+				r.Header.Set("Authorization", tt.authorization)
+			}
+			if tt.cookie != "" {
+				r.AddCookie(&http.Cookie{Name: sessionCookieName, Value: tt.cookie})
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.wantStatus {
+				t.Fatalf("expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				if gotUserId != nil {
+					t.Error("expected the handler not to run")
+				}
+				return
+			}
+			if gotUserId == nil || *gotUserId != userId {
+				t.Errorf("expected the handler to run as user %d, got %v", userId, gotUserId)
+			}
+		})
+	}
+}
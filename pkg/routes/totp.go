@@ -0,0 +1,202 @@
+package routes
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/auth"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/gorilla/mux"
+)
+
+// All totp routes should be under /auth prefix
+func registerTOTPRoutes(r *mux.Router) {
+	log.Printf("Registering totp routes")
+	r.HandleFunc("/login/totp", loginTOTPHandler).Methods("POST")
+	r.HandleFunc("/totp", authMiddleware(fetchTOTPStatusHandler)).Methods("GET")
+	r.HandleFunc("/totp/enroll", authMiddleware(enrollTOTPHandler)).Methods("POST")
+	r.HandleFunc("/totp/confirm", authMiddleware(confirmTOTPHandler)).Methods("POST")
+	r.HandleFunc("/totp/disable", authMiddleware(disableTOTPHandler)).Methods("POST")
+	r.HandleFunc("/totp/recovery-codes", authMiddleware(regenerateRecoveryCodesHandler)).Methods("POST")
+}
+
+// Wraps routes that need a session the user entered a TOTP code for, i.e. anything that hands us broker
+// credentials. Users without TOTP get a 403 telling them to turn it on.
+func twoFactorMiddleware(handler func(*int, http.ResponseWriter, *http.Request)) http.HandlerFunc {
+	return authMiddleware(func(userId *int, w http.ResponseWriter, r *http.Request) {
+		enabled, err := wardrobe.HasTOTPEnabled(r.Context(), *userId)
+		if err != nil {
+			log.Printf("Error checking totp for user %d: %v", *userId, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		// A session from before the user turned TOTP off and on again doesn't count
+		if !enabled || !currentSession(r).TwoFactor {
+			http.Error(w, "two factor authentication required", http.StatusForbidden)
+			return
+		}
+		handler(userId, w, r)
+	})
+}
+
+type TOTPRequiredResponse struct {
+	Username     string `json:"username"`
+	TOTPRequired bool   `json:"totp_required"`
+	// Pass back to /auth/login/totp with the user's code
+	PendingToken string `json:"pending_token"`
+}
+
+type loginTOTPRequest struct {
+	PendingToken string `json:"pending_token"`
+	// Either a TOTP code or a recovery code
+	Code string `json:"code"`
+}
+
+func loginTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var req loginTOTPRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	tok, userId, err := auth.LoginTOTP(r.Context(), req.PendingToken, req.Code, sessionToken(r), sessionMeta(r))
+	if err != nil {
+		if errors.Is(err, auth.ErrInvalidCode) || errors.Is(err, wardrobe.ErrNoPendingLogin) {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if errors.Is(err, auth.ErrTOTPLocked) {
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		}
+		log.Printf("Error verifying totp login: %v", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	username, err := wardrobe.FetchUserById(r.Context(), userId)
+	if err != nil {
+		log.Printf("Error fetching user %d: %v", userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	setSessionCookie(w, *tok, time.Now().Add(wardrobe.SessionTokenTtl))
+	writeUserResponseJson(w, *username)
+}
+
+type TOTPStatusResponse struct {
+	Enabled bool `json:"enabled"`
+	// Whether the current session entered a code
+	Verified               bool `json:"verified"`
+	RecoveryCodesRemaining int  `json:"recovery_codes_remaining"`
+}
+
+func fetchTOTPStatusHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	enabled, err := wardrobe.HasTOTPEnabled(r.Context(), *userId)
+	if err != nil {
+		log.Printf("Error checking totp for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	res := TOTPStatusResponse{Enabled: enabled, Verified: enabled && currentSession(r).TwoFactor}
+	if enabled {
+		res.RecoveryCodesRemaining, err = wardrobe.CountUnusedRecoveryCodes(r.Context(), *userId)
+		if err != nil {
+			log.Printf("Error counting recovery codes for user %d: %v", *userId, err)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
+	writeJsonResponse(w, res)
+}
+
+func enrollTOTPHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	enrollment, err := auth.EnrollTOTP(r.Context(), *userId)
+	if err != nil {
+		writeTOTPError(w, *userId, err)
+		return
+	}
+	writeJsonResponse(w, enrollment)
+}
+
+type totpCodeRequest struct {
+	Code string `json:"code"`
+}
+
+type RecoveryCodesResponse struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// Turns TOTP on, and swaps the current session for one that counts as having entered a code. Every other
+// session stays as it was, so it can't touch broker links until it logs in again with a code.
+func confirmTOTPHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req totpCodeRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	codes, err := auth.ConfirmTOTP(r.Context(), *userId, req.Code)
+	if err != nil {
+		writeTOTPError(w, *userId, err)
+		return
+	}
+	tok, err := auth.RotateSession(r.Context(), sessionToken(r), *userId, true, sessionMeta(r))
+	if err != nil {
+		log.Printf("Error rotating session for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	setSessionCookie(w, *tok, time.Now().Add(wardrobe.SessionTokenTtl))
+	writeJsonResponse(w, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+type disableTOTPRequest struct {
+	Password string `json:"password"`
+	Code     string `json:"code"`
+}
+
+func disableTOTPHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req disableTOTPRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	err = auth.DisableTOTP(r.Context(), *userId, req.Password, req.Code)
+	if err != nil {
+		writeTOTPError(w, *userId, err)
+		return
+	}
+	writeStatusResponseJson(w, "success")
+}
+
+func regenerateRecoveryCodesHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req totpCodeRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	codes, err := auth.RegenerateRecoveryCodes(r.Context(), *userId, req.Code)
+	if err != nil {
+		writeTOTPError(w, *userId, err)
+		return
+	}
+	writeJsonResponse(w, RecoveryCodesResponse{RecoveryCodes: codes})
+}
+
+func writeTOTPError(w http.ResponseWriter, userId int, err error) {
+	switch {
+	case errors.Is(err, auth.ErrInvalidCode), errors.Is(err, auth.ErrInvalidCredentials):
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+	case errors.Is(err, auth.ErrTOTPAlreadyEnabled), errors.Is(err, auth.ErrTOTPNotEnrolled), errors.Is(err, auth.ErrTOTPNotEnabled):
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	case errors.Is(err, auth.ErrTOTPLocked):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+	default:
+		log.Printf("Error updating totp for user %d: %v", userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
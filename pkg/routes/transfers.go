@@ -1,10 +1,13 @@
 package routes
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
 
+	"github.com/bluedresscapital/coattails/pkg/auth"
 	"github.com/bluedresscapital/coattails/pkg/robinhood"
 
 	"github.com/bluedresscapital/coattails/pkg/transfers"
@@ -31,17 +34,23 @@ type DeleteTransferRequest struct {
 	Uid    string `json:"uid"`
 }
 
+type RestoreTransferRequest struct {
+	PortId int    `json:"port_id"`
+	Uid    string `json:"uid"`
+}
+
 func registerTransferRoutes(r *mux.Router) {
 	log.Printf("Registering transfer routes")
 	s := r.PathPrefix("/transfer").Subrouter()
-	s.HandleFunc("", authMiddleware(fetchTransfersHandler)).Methods("GET")
-	s.HandleFunc("/upsert", portAuthMiddleware(upsertTransferHandler)).Methods("POST")
-	s.HandleFunc("/delete", portAuthMiddleware(deleteTransferHandler)).Methods("POST")
-	s.HandleFunc("/reload", portAuthMiddleware(reloadTransferHandler)).Methods("POST")
+	s.HandleFunc("", authMiddleware(fetchTransfersHandler, auth.ScopeReadTransfers)).Methods("GET")
+	s.HandleFunc("/upsert", portAuthMiddleware(upsertTransferHandler, wardrobe.RoleEditor, auth.ScopeWriteTransfers)).Methods("POST")
+	s.HandleFunc("/delete", portAuthMiddleware(deleteTransferHandler, wardrobe.RoleEditor, auth.ScopeWriteTransfers)).Methods("POST")
+	s.HandleFunc("/restore", portAuthMiddleware(restoreTransferHandler, wardrobe.RoleEditor, auth.ScopeWriteTransfers)).Methods("POST")
+	s.HandleFunc("/reload", portAuthMiddleware(reloadTransferHandler, wardrobe.RoleEditor, auth.ScopeWriteTransfers)).Methods("POST")
 }
 
 func fetchTransfersHandler(userId *int, w http.ResponseWriter, r *http.Request) {
-	transfers, err := wardrobe.FetchTransfersbyUserId(*userId)
+	transfers, err := wardrobe.FetchTransfersbyUserId(r.Context(), *userId)
 	if err != nil {
 		log.Printf("Error fetching transfers: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -58,7 +67,7 @@ func upsertTransferHandler(userId *int, port *wardrobe.Portfolio, w http.Respons
 		log.Printf("Bad request: %v", err)
 		return
 	}
-	err = wardrobe.UpsertTransfer(wardrobe.Transfer{
+	err = wardrobe.UpsertTransfer(r.Context(), wardrobe.Transfer{
 		Uid:           req.Uid,
 		PortId:        req.PortId,
 		Amount:        req.Amount,
@@ -66,16 +75,21 @@ func upsertTransferHandler(userId *int, port *wardrobe.Portfolio, w http.Respons
 		ManuallyAdded: true,
 		Date:          req.Date,
 	})
+	if errors.Is(err, wardrobe.ErrDeleted) || errors.Is(err, wardrobe.ErrOtherPortfolio) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprintf(w, "transfer %v", err)
+		return
+	}
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
 		log.Printf("Errored on insert: %v", err)
 		return
 	}
-	err = diapers.ReloadDepsAndPublish(diapers.Transfer, port.Id, *userId, GetChannelFromUserId(*userId))
+	err = diapers.ReloadDepsAndPublish(r.Context(), wardrobe.Default(), diapers.Transfer, port.Id)
 	if err != nil {
 		return
 	}
-	ts, err := wardrobe.FetchTransfersbyUserId(*userId)
+	ts, err := wardrobe.FetchTransfersbyUserId(r.Context(), *userId)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -91,18 +105,51 @@ func deleteTransferHandler(userId *int, port *wardrobe.Portfolio, w http.Respons
 		log.Printf("Bad request: %v", err)
 		return
 	}
-	err = wardrobe.DeleteTransfer(deleteTransferRequest.Uid, deleteTransferRequest.PortId)
+	err = wardrobe.DeleteTransfer(r.Context(), deleteTransferRequest.Uid, deleteTransferRequest.PortId)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		log.Printf("Error in deleting transfer: %v", err)
 		return
 	}
-	err = diapers.ReloadDepsAndPublish(diapers.Transfer, port.Id, *userId, GetChannelFromUserId(*userId))
+	err = diapers.ReloadDepsAndPublish(r.Context(), wardrobe.Default(), diapers.Transfer, port.Id)
+	if err != nil {
+		return
+	}
+	ts, err := wardrobe.FetchTransfersbyUserId(r.Context(), *userId)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJsonResponse(w, ts)
+}
+
+// Brings back a deleted transfer
+func restoreTransferHandler(userId *int, port *wardrobe.Portfolio, w http.ResponseWriter, r *http.Request) {
+	var req RestoreTransferRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		log.Printf("Bad request: %v", err)
+		return
+	}
+	err = wardrobe.RestoreTransfer(r.Context(), req.Uid, req.PortId)
+	if errors.Is(err, wardrobe.ErrNotDeleted) {
+		w.WriteHeader(http.StatusBadRequest)
+		_, _ = fmt.Fprintf(w, "%v", err)
+		return
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		log.Printf("Error in restoring transfer: %v", err)
+		return
+	}
+	err = diapers.ReloadDepsAndPublish(r.Context(), wardrobe.Default(), diapers.Transfer, port.Id)
 	if err != nil {
 		return
 	}
-	ts, err := wardrobe.FetchTransfersbyUserId(*userId)
+	ts, err := wardrobe.FetchTransfersbyUserId(r.Context(), *userId)
 	if err != nil {
+		log.Printf("Error fetching transfers: %v", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
@@ -113,32 +160,35 @@ func reloadTransferHandler(userId *int, port *wardrobe.Portfolio, w http.Respons
 	var transfer transfers.TransferAPI
 	if port.Type == "tda" {
 		log.Println("Reloading tda transfers")
-		err := validateTdaUsage(*port, *userId)
+		err := validateTdaUsage(r.Context(), *port, *userId)
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
+		r = r.WithContext(wardrobe.WithSource(r.Context(), wardrobe.SourceTDA))
 		transfer = tda.API{AccountId: port.TDAccountId}
 	} else if port.Type == "rh" {
 		log.Println("Reloading rh transfers")
-		err := validateRhUsage(*port, *userId)
+		err := validateRhUsage(r.Context(), *port, *userId)
 		if err != nil {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
+		r = r.WithContext(wardrobe.WithSource(r.Context(), wardrobe.SourceRH))
 		transfer = robinhood.API{AccountId: port.RHAccountId}
 	}
 	if transfer != nil {
-		needsUpdate, err := transfers.ReloadTransfers(transfer)
+		needsUpdate, err := transfers.ReloadTransfers(r.Context(), wardrobe.Default(), transfer)
 		if needsUpdate {
-			err = diapers.ReloadDepsAndPublish(diapers.Transfer, port.Id, *userId, GetChannelFromUserId(*userId))
+			err = diapers.ReloadDepsAndPublish(r.Context(), wardrobe.Default(), diapers.Transfer, port.Id)
 			if err != nil {
 				return
 			}
 		}
 	}
-	ts, err := wardrobe.FetchTransfersbyUserId(*userId)
-	err = socks.PublishFromServer(GetChannelFromUserId(*userId), "RELOADED_TRANSFERS", ts)
+	err := socks.PublishToPortfolio(r.Context(), wardrobe.Default(), port.Id, socks.TopicTransfers, "RELOADED_TRANSFERS", func(userId int) (interface{}, error) {
+		return wardrobe.FetchTransfersbyUserId(r.Context(), userId)
+	})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
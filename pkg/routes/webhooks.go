@@ -0,0 +1,136 @@
+package routes
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/bluedresscapital/coattails/pkg/webhooks"
+	"github.com/gorilla/mux"
+)
+
+// Most deliveries returned from the delivery log
+const maxWebhookDeliveries = 100
+
+// All webhook routes should be under /auth prefix. Like alerts, webhooks post anywhere, so managing them takes a
+// session.
+func registerWebhookRoutes(r *mux.Router) {
+	log.Printf("Registering webhook routes")
+	r.HandleFunc("/webhooks", authMiddleware(fetchWebhookEndpointsHandler)).Methods("GET")
+	r.HandleFunc("/webhooks/create", authMiddleware(createWebhookEndpointHandler)).Methods("POST")
+	r.HandleFunc("/webhooks/delete", authMiddleware(deleteWebhookEndpointHandler)).Methods("POST")
+	r.HandleFunc("/webhooks/deliveries", authMiddleware(fetchWebhookDeliveriesHandler)).Methods("GET")
+	r.HandleFunc("/webhooks/redeliver", authMiddleware(redeliverWebhookHandler)).Methods("POST")
+}
+
+func fetchWebhookEndpointsHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	endpoints, err := wardrobe.FetchWebhookEndpointsByUserId(r.Context(), *userId)
+	if err != nil {
+		log.Printf("Error fetching webhook endpoints for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJsonResponse(w, endpoints)
+}
+
+type createWebhookEndpointRequest struct {
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+}
+
+type createWebhookEndpointResponse struct {
+	wardrobe.WebhookEndpoint
+	// Only ever returned here, so the caller needs to hang on to it
+	Secret string `json:"secret"`
+}
+
+func createWebhookEndpointHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req createWebhookEndpointRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	endpoint, secret, err := webhooks.CreateEndpoint(r.Context(), wardrobe.Default(), *userId, req.URL, req.EventTypes)
+	if err != nil {
+		var invalid *webhooks.InvalidEndpointError
+		if errors.As(err, &invalid) {
+			http.Error(w, invalid.Error(), http.StatusBadRequest)
+			return
+		}
+		log.Printf("Error creating webhook endpoint for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJsonResponse(w, createWebhookEndpointResponse{WebhookEndpoint: *endpoint, Secret: secret})
+}
+
+type deleteWebhookEndpointRequest struct {
+	Id int `json:"id"`
+}
+
+func deleteWebhookEndpointHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req deleteWebhookEndpointRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	err = wardrobe.DeleteWebhookEndpoint(r.Context(), *userId, req.Id)
+	if err != nil {
+		if errors.Is(err, wardrobe.ErrNoWebhookEndpoint) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error deleting webhook endpoint for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeStatusResponseJson(w, "success")
+}
+
+// Returns an endpoint's latest deliveries, newest first
+func fetchWebhookDeliveriesHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	endpointId, err := strconv.Atoi(r.URL.Query().Get("endpoint_id"))
+	if err != nil {
+		http.Error(w, "invalid endpoint_id", http.StatusBadRequest)
+		return
+	}
+	deliveries, err := wardrobe.FetchWebhookDeliveries(r.Context(), *userId, endpointId, maxWebhookDeliveries)
+	if err != nil {
+		if errors.Is(err, wardrobe.ErrNoWebhookEndpoint) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error fetching webhook deliveries for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJsonResponse(w, deliveries)
+}
+
+type redeliverWebhookRequest struct {
+	DeliveryId int `json:"delivery_id"`
+}
+
+func redeliverWebhookHandler(userId *int, w http.ResponseWriter, r *http.Request) {
+	var req redeliverWebhookRequest
+	err := decodeJSONBody(w, r, &req)
+	if err != nil {
+		handleDecodeErr(w, err)
+		return
+	}
+	delivery, err := webhooks.Redeliver(r.Context(), wardrobe.Default(), *userId, req.DeliveryId)
+	if err != nil {
+		if errors.Is(err, wardrobe.ErrNoWebhookDelivery) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		log.Printf("Error redelivering webhook for user %d: %v", *userId, err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	writeJsonResponse(w, delivery)
+}
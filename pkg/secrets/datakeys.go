@@ -0,0 +1,146 @@
+package secrets
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Loads the bdc datakeys, unwrapping them with the key provider providerConfig picks unless they're loaded from
+// filePath as is
+func InitSundress(providerConfig *KeyProviderConfig, loadFromFile bool, filePath string) {
+	var provider KeyProvider
+	if !loadFromFile {
+		var err error
+		provider, err = providerConfig.NewKeyProvider()
+		if err != nil {
+			log.Fatalf("Unable to initialize %s key provider: %v", providerConfig.Provider, err)
+		}
+	}
+	initDataKeys(provider, loadFromFile, filePath)
+}
+
+// Loads every data key, either from filePath or unwrapping them with provider from the environment, and makes
+// $BDC_ACTIVE_KEY_VERSION (or the newest key, if it's unset) the active one.
+//
+// The key file is either a single legacy key, exactly as generated, or one <version>:<key> per line. From the
+// environment, $BDC_CIPHER_KEY is the wrapped legacy key (in hex), and $BDC_CIPHER_KEYS has the rest as
+// comma separated <version>:<hex wrapped key> pairs.
+func initDataKeys(provider KeyProvider, loadFromFile bool, filePath string) {
+	var keys map[uint32]string
+	if loadFromFile {
+		log.Printf("Initializing bdc datakeys from file %s", filePath)
+		b, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			log.Fatalf("Unable to read bdc datakeys from file %s", filePath)
+		}
+		keys, err = parseKeyFile(string(b))
+		if err != nil {
+			log.Fatalf("Unable to parse bdc datakeys from file %s: %v", filePath, err)
+		}
+	} else {
+		log.Println("Initializing bdc datakeys from $BDC_CIPHER_KEY and $BDC_CIPHER_KEYS, and unwrapping them")
+		ciphers := make(map[uint32]string)
+		if cipherStr := os.Getenv("BDC_CIPHER_KEY"); cipherStr != "" {
+			ciphers[LegacyKeyVersion] = cipherStr
+		}
+		if pairs := os.Getenv("BDC_CIPHER_KEYS"); pairs != "" {
+			for _, pair := range strings.Split(pairs, ",") {
+				version, cipherStr, err := parseVersionedKey(pair)
+				if err != nil {
+					log.Fatalf("Unable to parse $BDC_CIPHER_KEYS: %v", err)
+				}
+				ciphers[version] = cipherStr
+			}
+		}
+		keys = make(map[uint32]string)
+		for version, cipherStr := range ciphers {
+			cipher, err := hex.DecodeString(cipherStr)
+			if err != nil {
+				log.Fatalf("Unable to decode cipher string for datakey version %d: %v", version, err)
+			}
+			key, err := unwrapDataKey(provider, cipher)
+			if err != nil {
+				log.Fatalf("Unable to unwrap datakey version %d: %v", version, err)
+			}
+			keys[version] = key
+		}
+	}
+	if len(keys) == 0 {
+		log.Fatal(ErrNoDataKeys)
+	}
+	active := newestVersion(keys)
+	if v := os.Getenv("BDC_ACTIVE_KEY_VERSION"); v != "" {
+		parsed, err := strconv.ParseUint(v, 10, 32)
+		if err != nil {
+			log.Fatalf("Unable to parse $BDC_ACTIVE_KEY_VERSION: %v", err)
+		}
+		active = uint32(parsed)
+	}
+	err := SetDataKeys(keys, active)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Loaded bdc datakey versions %v, encrypting under version %d", KeyVersions(), active)
+}
+
+func parseKeyFile(content string) (map[uint32]string, error) {
+	keys := make(map[uint32]string)
+	if !strings.Contains(content, ":") {
+		// Legacy keys were used byte for byte, so we can't trim anything off of them
+		keys[LegacyKeyVersion] = content
+		return keys, nil
+	}
+	for _, line := range strings.Split(content, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		version, key, err := parseVersionedKey(line)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := keys[version]; ok {
+			return nil, fmt.Errorf("datakey version %d listed twice", version)
+		}
+		keys[version] = key
+	}
+	return keys, nil
+}
+
+// Parses <version>:<key>
+func parseVersionedKey(s string) (uint32, string, error) {
+	parts := strings.SplitN(strings.TrimSpace(s), ":", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return 0, "", fmt.Errorf("expected <version>:<key>")
+	}
+	version, err := strconv.ParseUint(parts[0], 10, 32)
+	if err != nil {
+		return 0, "", fmt.Errorf("invalid datakey version %q", parts[0])
+	}
+	return uint32(version), parts[1], nil
+}
+
+func newestVersion(keys map[uint32]string) uint32 {
+	var newest uint32
+	for v := range keys {
+		if v > newest {
+			newest = v
+		}
+	}
+	return newest
+}
+
+func unwrapDataKey(provider KeyProvider, wrapped []byte) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), providerTimeout)
+	defer cancel()
+	key, err := provider.UnwrapKey(ctx, wrapped)
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
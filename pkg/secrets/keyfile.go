@@ -0,0 +1,121 @@
+package secrets
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Returned when a keyfile's passphrase is wrong (or the keyfile has been tampered with)
+var ErrWrongPassphrase = errors.New("wrong keyfile passphrase")
+
+// Length of the master key in a keyfile
+const keyfileKeyLen = 32
+
+// A master key encrypted under a key derived from a passphrase with argon2id. Meant for development and tests,
+// where there's no kms or vault to hold the master key.
+type keyfile struct {
+	Version int            `json:"version"`
+	Params  PasswordParams `json:"params"`
+	Salt    []byte         `json:"salt"`
+	// Nonce followed by the sealed master key
+	Key []byte `json:"key"`
+}
+
+// Wraps datakeys with a master key unlocked from a keyfile
+type localKeyfileProvider struct {
+	key []byte
+}
+
+// Generates a new master key and writes it to path, protected by passphrase. Refuses to overwrite an existing
+// keyfile, since every datakey wrapped by it would be lost.
+func CreateLocalKeyfile(path string, passphrase string) error {
+	if passphrase == "" {
+		return errors.New("keyfile passphrase can't be empty")
+	}
+	master := make([]byte, keyfileKeyLen)
+	if _, err := rand.Read(master); err != nil {
+		return err
+	}
+	kf := keyfile{Version: 1, Params: DefaultPasswordParams}
+	kf.Params.KeyLen = keyfileKeyLen
+	kf.Salt = make([]byte, kf.Params.SaltLen)
+	if _, err := rand.Read(kf.Salt); err != nil {
+		return err
+	}
+	sealed, err := seal(kf.passphraseKey(passphrase), master)
+	if err != nil {
+		return err
+	}
+	kf.Key = sealed
+	b, err := json.MarshalIndent(kf, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Unlocks the keyfile at path with passphrase
+func NewLocalKeyfileProvider(path string, passphrase string) (KeyProvider, error) {
+	if path == "" {
+		return nil, errors.New("no keyfile, set -local-keyfile")
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var kf keyfile
+	err = json.Unmarshal(b, &kf)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing keyfile %s: %v", path, err)
+	}
+	if kf.Version != 1 {
+		return nil, fmt.Errorf("unsupported keyfile version %d", kf.Version)
+	}
+	master, err := open(kf.passphraseKey(passphrase), kf.Key, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return &localKeyfileProvider{key: master}, nil
+}
+
+func (kf *keyfile) passphraseKey(passphrase string) []byte {
+	p := kf.Params
+	return argon2.IDKey([]byte(passphrase), kf.Salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+}
+
+func (p *localKeyfileProvider) WrapKey(ctx context.Context, key []byte) ([]byte, error) {
+	return seal(p.key, key)
+}
+
+func (p *localKeyfileProvider) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	return open(p.key, wrapped, nil)
+}
+
+// AES-GCM encrypts plaintext under key with a random nonce, returning the nonce followed by the ciphertext
+func seal(key []byte, plaintext []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, nil), nil
+}
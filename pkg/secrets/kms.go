@@ -1,9 +1,8 @@
 package secrets
 
 import (
-	"encoding/hex"
-	"io/ioutil"
-	"log"
+	"context"
+	"errors"
 	"os"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -12,80 +11,49 @@ import (
 	"github.com/aws/aws-sdk-go/service/kms"
 )
 
-type secret struct {
+// Wraps datakeys with an AWS KMS key
+type kmsProvider struct {
 	keyId  *string
 	client *kms.KMS
 }
 
-var (
-	sec     *secret
-	dataKey string
-)
-
-func InitSundress(loadFromFile bool, filePath string) {
-	initSecret()
-	initDataKey(loadFromFile, filePath)
-}
-
-func initDataKey(loadFromFile bool, filePath string) {
-	if loadFromFile {
-		log.Printf("Initializing bdc datakey from file %s", filePath)
-		b, err := ioutil.ReadFile(filePath)
-		if err != nil {
-			log.Fatalf("Unable to read bdc datakey from file %s", filePath)
-		}
-		dataKey = string(b)
-	} else {
-		log.Println("Initializing bdc datakey from $BDC_CIPHER_KEY, and decoding it")
-		cipherStr := os.Getenv("BDC_CIPHER_KEY")
-		cipher, err := hex.DecodeString(cipherStr)
-		if err != nil {
-			log.Fatalf("Unable to decode cipher string: %v", err)
-		}
-		dataKey = Decrypt(cipher)
+// Uses the KMS key $AWS_KMS_KEYID in region, authenticating with $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY
+func NewKMSProvider(region string) (KeyProvider, error) {
+	keyId := os.Getenv("AWS_KMS_KEYID")
+	if keyId == "" {
+		return nil, errors.New("$AWS_KMS_KEYID isn't set")
 	}
-}
-
-func initSecret() {
-	log.Println("Initializing Secret...")
 	//for some reason wasnt pulling region from ~/.aws/config
 	sess, err := session.NewSession(&aws.Config{
-		Region:      aws.String("us-east-1"),
+		Region:      aws.String(region),
 		Credentials: credentials.NewStaticCredentials(os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"), ""),
 	})
 	if err != nil {
-		log.Fatal(err)
-	}
-	svc := kms.New(sess)
-	//need to replace the key with bdc stuff
-	sec = &secret{
-		keyId:  aws.String(os.Getenv("AWS_KMS_KEYID")),
-		client: svc,
+		return nil, err
 	}
+	return &kmsProvider{
+		keyId:  aws.String(keyId),
+		client: kms.New(sess),
+	}, nil
 }
 
-func Encrypt(s string) []byte {
-	log.Print("Calling kms to encrpyt string!")
-	// Encrypt the data
-	result, err := sec.client.Encrypt(&kms.EncryptInput{
-		KeyId:     sec.keyId,
-		Plaintext: []byte(s),
+func (p *kmsProvider) WrapKey(ctx context.Context, key []byte) ([]byte, error) {
+	result, err := p.client.EncryptWithContext(ctx, &kms.EncryptInput{
+		KeyId:     p.keyId,
+		Plaintext: key,
 	})
-
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	return result.CiphertextBlob
+	return result.CiphertextBlob, nil
 }
 
-func Decrypt(s []byte) string {
-	log.Print("Calling kms to decrypt string!")
-	result, err := sec.client.Decrypt(&kms.DecryptInput{
-		CiphertextBlob: s,
+func (p *kmsProvider) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	result, err := p.client.DecryptWithContext(ctx, &kms.DecryptInput{
+		CiphertextBlob: wrapped,
 	})
-
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
-	return string(result.Plaintext)
+	return result.Plaintext, nil
 }
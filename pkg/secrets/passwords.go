@@ -0,0 +1,125 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+)
+
+// Returned when a stored password hash isn't in any format we know how to verify
+var ErrUnknownPasswordHash = errors.New("unknown password hash format")
+
+// argon2id cost parameters. They're encoded into every hash, so changing the defaults only affects new hashes
+// (and old ones as their users log in and get rehashed).
+type PasswordParams struct {
+	// In KiB
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// RFC 9106's recommendation for when 2 GiB of memory per hash is too much
+var DefaultPasswordParams = PasswordParams{
+	Memory:  64 * 1024,
+	Time:    3,
+	Threads: 4,
+	SaltLen: 16,
+	KeyLen:  32,
+}
+
+const argon2idPrefix = "$argon2id$"
+
+// Bounds on the params decoded from a stored hash, so a corrupted (or planted) one is an error rather than a panic
+// inside argon2, or minutes of hashing
+const (
+	maxPasswordMemory = 1024 * 1024
+	maxPasswordTime   = 16
+	minPasswordSalt   = 8
+	minPasswordKey    = 16
+	maxPasswordKey    = 128
+)
+
+// Returned when a stored argon2id hash's params are out of bounds
+var ErrInvalidPasswordParams = errors.New("invalid argon2id params")
+
+// Length of a legacy Hash
+const sha256Len = 32
+
+// Hashes password with a fresh salt and the default params, encoded in the standard
+// $argon2id$v=19$m=...,t=...,p=...$<salt>$<key> format
+func HashPassword(password string) ([]byte, error) {
+	p := DefaultPasswordParams
+	salt := make([]byte, p.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+	key := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+	return []byte(fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version, p.Memory, p.Time, p.Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key))), nil
+}
+
+// Checks password against a hash from HashPassword, or a legacy unsalted Hash of it. needsRehash is set when
+// the password matched but the hash is legacy or uses params other than the defaults, in which case the
+// caller should store a fresh HashPassword.
+func VerifyPassword(password string, hash []byte) (ok bool, needsRehash bool, err error) {
+	if bytes.HasPrefix(hash, []byte(argon2idPrefix)) {
+		p, salt, key, err := decodeArgon2id(string(hash))
+		if err != nil {
+			return false, false, err
+		}
+		actual := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, p.KeyLen)
+		if subtle.ConstantTimeCompare(actual, key) != 1 {
+			return false, false, nil
+		}
+		return true, p != DefaultPasswordParams, nil
+	}
+	if len(hash) == sha256Len {
+		legacy := Hash(password)
+		if subtle.ConstantTimeCompare(legacy[:], hash) != 1 {
+			return false, false, nil
+		}
+		return true, true, nil
+	}
+	return false, false, ErrUnknownPasswordHash
+}
+
+func decodeArgon2id(hash string) (p PasswordParams, salt []byte, key []byte, err error) {
+	// "", "argon2id", "v=19", "m=...,t=...,p=...", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return p, nil, nil, ErrUnknownPasswordHash
+	}
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return p, nil, nil, ErrUnknownPasswordHash
+	}
+	if version != argon2.Version {
+		return p, nil, nil, fmt.Errorf("unsupported argon2 version %d", version)
+	}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &p.Memory, &p.Time, &p.Threads); err != nil {
+		return p, nil, nil, ErrUnknownPasswordHash
+	}
+	salt, err = base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return p, nil, nil, ErrUnknownPasswordHash
+	}
+	key, err = base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return p, nil, nil, ErrUnknownPasswordHash
+	}
+	p.SaltLen = uint32(len(salt))
+	p.KeyLen = uint32(len(key))
+	if p.Time < 1 || p.Time > maxPasswordTime || p.Threads < 1 || p.Memory < 8*uint32(p.Threads) ||
+		p.Memory > maxPasswordMemory || p.SaltLen < minPasswordSalt || p.KeyLen < minPasswordKey || p.KeyLen > maxPasswordKey {
+		return p, nil, nil, ErrInvalidPasswordParams
+	}
+	return p, salt, key, nil
+}
@@ -0,0 +1,97 @@
+package secrets
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func argon2idHash(version int, memory uint32, time uint32, threads uint8, saltLen int, keyLen int) string {
+	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s", version, memory, time, threads,
+		base64.RawStdEncoding.EncodeToString(make([]byte, saltLen)),
+		base64.RawStdEncoding.EncodeToString(make([]byte, keyLen)))
+}
+
+func TestDecodeArgon2id(t *testing.T) {
+	tests := []struct {
+		name string
+		hash string
+		// nil when the hash should decode
+		wantErr error
+	}{
+		{"defaults", argon2idHash(19, 64*1024, 3, 4, 16, 32), nil},
+		{"smallest params", argon2idHash(19, 8, 1, 1, minPasswordSalt, minPasswordKey), nil},
+		{"largest params", argon2idHash(19, maxPasswordMemory, maxPasswordTime, 255, 64, maxPasswordKey), nil},
+		{"missing key", "$argon2id$v=19$m=65536,t=3,p=4$AAAAAAAAAAAAAAAAAAAAAA", ErrUnknownPasswordHash},
+		{"garbled params", "$argon2id$v=19$m=lots,t=3,p=4$AAAAAAAAAAAAAAAAAAAAAA$AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA", ErrUnknownPasswordHash},
+		{"bad salt encoding", "$argon2id$v=19$m=65536,t=3,p=4$!!!$AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA", ErrUnknownPasswordHash},
+		{"no threads", argon2idHash(19, 64*1024, 3, 0, 16, 32), ErrInvalidPasswordParams},
+		{"no passes", argon2idHash(19, 64*1024, 0, 4, 16, 32), ErrInvalidPasswordParams},
+		{"too many passes", argon2idHash(19, 64*1024, maxPasswordTime+1, 4, 16, 32), ErrInvalidPasswordParams},
+		{"too much memory", argon2idHash(19, maxPasswordMemory+1, 3, 4, 16, 32), ErrInvalidPasswordParams},
+		{"too little memory for its threads", argon2idHash(19, 31, 3, 4, 16, 32), ErrInvalidPasswordParams},
+		{"short salt", argon2idHash(19, 64*1024, 3, 4, minPasswordSalt-1, 32), ErrInvalidPasswordParams},
+		{"short key", argon2idHash(19, 64*1024, 3, 4, 16, minPasswordKey-1), ErrInvalidPasswordParams},
+		{"long key", argon2idHash(19, 64*1024, 3, 4, 16, maxPasswordKey+1), ErrInvalidPasswordParams},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, salt, key, err := decodeArgon2id(tt.hash)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if int(p.SaltLen) != len(salt) || int(p.KeyLen) != len(key) {
+				t.Errorf("expected salt and key lengths %d and %d, got %d and %d", p.SaltLen, p.KeyLen, len(salt), len(key))
+			}
+		})
+	}
+}
+
+func TestDecodeArgon2idVersion(t *testing.T) {
+	_, _, _, err := decodeArgon2id(argon2idHash(16, 64*1024, 3, 4, 16, 32))
+	if err == nil || !strings.Contains(err.Error(), "unsupported argon2 version 16") {
+		t.Fatalf("expected an unsupported version error, got %v", err)
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	hash, err := HashPassword("hunter2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	legacy := Hash("hunter2")
+	tests := []struct {
+		name            string
+		password        string
+		hash            []byte
+		wantOk          bool
+		wantNeedsRehash bool
+		wantErr         error
+	}{
+		{"argon2id", "hunter2", hash, true, false, nil},
+		{"argon2id wrong password", "hunter3", hash, false, false, nil},
+		{"legacy", "hunter2", legacy[:], true, true, nil},
+		{"legacy wrong password", "hunter3", legacy[:], false, false, nil},
+		{"out of bounds params", "hunter2", []byte(argon2idHash(19, 64*1024, 3, 0, 16, 32)), false, false, ErrInvalidPasswordParams},
+		{"unknown format", "hunter2", []byte("plaintext"), false, false, ErrUnknownPasswordHash},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ok, needsRehash, err := VerifyPassword(tt.password, tt.hash)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected error %v, got %v", tt.wantErr, err)
+			}
+			if ok != tt.wantOk || needsRehash != tt.wantNeedsRehash {
+				t.Errorf("expected ok %v and needsRehash %v, got %v and %v", tt.wantOk, tt.wantNeedsRehash, ok, needsRehash)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package secrets
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Wraps (encrypts) and unwraps bdc datakeys with a master key that never leaves the provider. Datakeys are only
+// ever stored wrapped.
+type KeyProvider interface {
+	WrapKey(ctx context.Context, key []byte) ([]byte, error)
+	UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error)
+}
+
+const (
+	ProviderKMS   = "kms"
+	ProviderVault = "vault"
+	ProviderLocal = "local"
+)
+
+// How long we give a key provider to wrap or unwrap a key
+const providerTimeout = 30 * time.Second
+
+// Which key provider to use, and how to reach it. Secrets (AWS credentials, the vault token, the keyfile
+// passphrase) only ever come from the environment, so they don't show up in ps.
+type KeyProviderConfig struct {
+	// One of ProviderKMS, ProviderVault or ProviderLocal
+	Provider string
+	// AWS KMS, keyed by $AWS_KMS_KEYID
+	KMSRegion string
+	// Vault transit, authenticated with $VAULT_TOKEN
+	VaultAddr       string
+	VaultMount      string
+	VaultTransitKey string
+	// Local keyfile, unlocked with $BDC_KEYFILE_PASSPHRASE
+	LocalKeyfile string
+}
+
+// Registers the flags every binary picks its key provider with
+func RegisterKeyProviderFlags(fs *flag.FlagSet) *KeyProviderConfig {
+	c := &KeyProviderConfig{}
+	fs.StringVar(&c.Provider, "key-provider", ProviderKMS, "what unwraps bdc datakeys: kms, vault or local")
+	fs.StringVar(&c.KMSRegion, "kms-region", "us-east-1", "aws region of the kms key, for -key-provider kms")
+	fs.StringVar(&c.VaultAddr, "vault-addr", os.Getenv("VAULT_ADDR"), "vault address, for -key-provider vault. Defaults to $VAULT_ADDR")
+	fs.StringVar(&c.VaultMount, "vault-transit-mount", "transit", "path the vault transit engine is mounted at, for -key-provider vault")
+	fs.StringVar(&c.VaultTransitKey, "vault-transit-key", "bdc", "name of the vault transit key, for -key-provider vault")
+	fs.StringVar(&c.LocalKeyfile, "local-keyfile", "", "path to a passphrase protected keyfile, for -key-provider local")
+	return c
+}
+
+func (c *KeyProviderConfig) NewKeyProvider() (KeyProvider, error) {
+	switch c.Provider {
+	case ProviderKMS:
+		return NewKMSProvider(c.KMSRegion)
+	case ProviderVault:
+		return NewVaultTransitProvider(c.VaultAddr, c.VaultMount, c.VaultTransitKey, os.Getenv("VAULT_TOKEN"))
+	case ProviderLocal:
+		return NewLocalKeyfileProvider(c.LocalKeyfile, os.Getenv("BDC_KEYFILE_PASSPHRASE"))
+	default:
+		return nil, fmt.Errorf("unknown key provider %q, expected kms, vault or local", c.Provider)
+	}
+}
@@ -1,59 +1,217 @@
 package secrets
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/md5"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
+	"errors"
+	"fmt"
 	"io"
+	"sort"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// Every versioned ciphertext starts with this, followed by the big endian version of the data key it was
+// encrypted under. Ciphertexts from before we had key versions start straight with their nonce.
+var cipherMagic = []byte{'b', 'd', 'c', 1}
+
+const cipherHeaderLen = 8
+
+// The version data keys from before key versions are given, i.e. a key file with just a key in it. Headerless
+// ciphertexts are decrypted with this key.
+const LegacyKeyVersion uint32 = 0
+
+var (
+	// Returned when a ciphertext was encrypted under a data key we don't have
+	ErrUnknownKeyVersion = errors.New("ciphertext was encrypted under an unknown data key version")
+	ErrNoDataKeys        = errors.New("no data keys loaded")
 )
 
 func Hash(s string) [32]byte {
 	return sha256.Sum256([]byte(s))
 }
 
-// Local bdc encryption using our generated datakey
+// Every data key we can decrypt with. New ciphertexts are always encrypted under the active one.
+type keyring struct {
+	// AES keys derived from each data key, by version
+	keys   map[uint32][]byte
+	active uint32
+	// AES key derived the old way from the legacy data key, for headerless ciphertexts
+	legacy []byte
+}
+
+var ring = &keyring{keys: make(map[uint32][]byte)}
+
+// Replaces the data keys with keys (raw data keys by version). active is the version to encrypt under, and
+// must be one of keys.
+func SetDataKeys(keys map[uint32]string, active uint32) error {
+	r := &keyring{keys: make(map[uint32][]byte), active: active}
+	for version, key := range keys {
+		derived, err := deriveKey(key, version)
+		if err != nil {
+			return err
+		}
+		r.keys[version] = derived
+		if version == LegacyKeyVersion {
+			r.legacy = legacyDeriveKey(key)
+		}
+	}
+	if _, ok := r.keys[active]; !ok {
+		return fmt.Errorf("active data key version %d isn't loaded", active)
+	}
+	ring = r
+	return nil
+}
+
+// The version new ciphertexts are encrypted under
+func ActiveKeyVersion() uint32 {
+	return ring.active
+}
+
+// The versions of every loaded data key, in ascending order
+func KeyVersions() []uint32 {
+	versions := make([]uint32, 0, len(ring.keys))
+	for v := range ring.keys {
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i] < versions[j] })
+	return versions
+}
+
+// Local bdc encryption under the active data key
 func BdcEncrypt(s string) ([]byte, error) {
-	block, err := aes.NewCipher([]byte(createHash(dataKey)))
-	if err != nil {
-		return nil, err
+	r := ring
+	key, ok := r.keys[r.active]
+	if !ok {
+		return nil, ErrNoDataKeys
 	}
-	gcm, err := cipher.NewGCM(block)
+	gcm, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
+	header := make([]byte, cipherHeaderLen)
+	copy(header, cipherMagic)
+	binary.BigEndian.PutUint32(header[len(cipherMagic):], r.active)
 	nonce := make([]byte, gcm.NonceSize())
 	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
 		return nil, err
 	}
-	return gcm.Seal(nonce, nonce, []byte(s), nil), nil
+	// The header is authenticated too, so a ciphertext can't be passed off as being under a different key
+	out := append(header, nonce...)
+	return gcm.Seal(out, nonce, []byte(s), header), nil
 }
 
-// Local bdc decryption using our generated datakey
+// Local bdc decryption, under whichever data key d was encrypted with
 func BdcDecrypt(d []byte) (*string, error) {
-	block, err := aes.NewCipher([]byte(createHash(dataKey)))
+	plaintext, _, err := decrypt(ring, d)
+	if err != nil {
+		return nil, err
+	}
+	res := new(string)
+	*res = string(plaintext)
+	return res, nil
+}
+
+// Returns d's plaintext, and whether it was encrypted under the active data key. A legacy ciphertext whose
+// random nonce happens to start with cipherMagic looks versioned, so one that doesn't open as versioned is
+// tried as legacy too.
+func decrypt(r *keyring, d []byte) ([]byte, bool, error) {
+	version, versioned := CipherKeyVersion(d)
+	if !versioned {
+		if r.legacy == nil {
+			return nil, false, fmt.Errorf("%w %d", ErrUnknownKeyVersion, LegacyKeyVersion)
+		}
+		plaintext, err := open(r.legacy, d, nil)
+		return plaintext, false, err
+	}
+	err := fmt.Errorf("%w %d", ErrUnknownKeyVersion, version)
+	if key, ok := r.keys[version]; ok {
+		var plaintext []byte
+		plaintext, err = open(key, d[cipherHeaderLen:], d[:cipherHeaderLen])
+		if err == nil {
+			return plaintext, version == r.active, nil
+		}
+	}
+	if r.legacy != nil {
+		if plaintext, legacyErr := open(r.legacy, d, nil); legacyErr == nil {
+			return plaintext, false, nil
+		}
+	}
+	return nil, false, err
+}
+
+// Returns the version of the data key d was encrypted under, or false if it's from before key versions
+func CipherKeyVersion(d []byte) (uint32, bool) {
+	if len(d) < cipherHeaderLen || !bytes.HasPrefix(d, cipherMagic) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint32(d[len(cipherMagic):cipherHeaderLen]), true
+}
+
+// Returns whether d is encrypted under anything other than the active data key
+func NeedsReencrypt(d []byte) bool {
+	r := ring
+	version, versioned := CipherKeyVersion(d)
+	if !versioned || version != r.active {
+		return true
+	}
+	// Could still be a legacy ciphertext that only looks like it's under the active key
+	_, current, err := decrypt(r, d)
+	return err == nil && !current
+}
+
+// Decrypts d and encrypts it again under the active data key
+func Reencrypt(d []byte) ([]byte, error) {
+	plaintext, err := BdcDecrypt(d)
 	if err != nil {
 		return nil, err
 	}
-	gcm, err := cipher.NewGCM(block)
+	return BdcEncrypt(*plaintext)
+}
+
+func open(key []byte, d []byte, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
 	if err != nil {
 		return nil, err
 	}
 	nonceSize := gcm.NonceSize()
+	if len(d) < nonceSize {
+		return nil, errors.New("ciphertext too short")
+	}
 	nonce, ciphertext := d[:nonceSize], d[nonceSize:]
-	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
 	if err != nil {
 		return nil, err
 	}
-	res := new(string)
-	*res = string(plaintext)
-	return res, nil
+	return cipher.NewGCM(block)
+}
+
+// Derives the AES-256 key for data key version with HKDF-SHA256. The version goes into the info, so the same
+// data key loaded under two versions still gives two different keys.
+func deriveKey(key string, version uint32) ([]byte, error) {
+	derived := make([]byte, 32)
+	info := []byte(fmt.Sprintf("bdc data key v%d", version))
+	_, err := io.ReadFull(hkdf.New(sha256.New, []byte(key), nil, info), derived)
+	if err != nil {
+		return nil, err
+	}
+	return derived, nil
 }
 
-func createHash(key string) string {
+// How keys were derived before key versions: the hex md5 of the data key, as an AES-256 key. Only used to
+// decrypt headerless ciphertexts, until they've all been rotated.
+func legacyDeriveKey(key string) []byte {
 	hasher := md5.New()
 	hasher.Write([]byte(key))
-	return hex.EncodeToString(hasher.Sum(nil))
+	return []byte(hex.EncodeToString(hasher.Sum(nil)))
 }
@@ -0,0 +1,174 @@
+package secrets
+
+import (
+	"crypto/rand"
+	"errors"
+	"testing"
+)
+
+var testDataKeys = map[uint32]string{
+	LegacyKeyVersion: "legacy data key",
+	1:                "first data key",
+	2:                "second data key",
+}
+
+func setTestDataKeys(t *testing.T, keys map[uint32]string, active uint32) {
+	t.Helper()
+	err := SetDataKeys(keys, active)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Encrypts s the way BdcEncrypt did before key versions, with nonce (random if nil)
+func legacyEncrypt(t *testing.T, key string, s string, nonce []byte) []byte {
+	t.Helper()
+	gcm, err := newGCM(legacyDeriveKey(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nonce == nil {
+		nonce = make([]byte, gcm.NonceSize())
+		if _, err = rand.Read(nonce); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return gcm.Seal(append([]byte(nil), nonce...), nonce, []byte(s), nil)
+}
+
+func encryptUnder(t *testing.T, version uint32, s string) []byte {
+	t.Helper()
+	setTestDataKeys(t, testDataKeys, version)
+	cipher, err := BdcEncrypt(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cipher
+}
+
+func TestBdcDecrypt(t *testing.T) {
+	tests := []struct {
+		name string
+		// Made before the test's keys are loaded, so it can load its own
+		cipher func(t *testing.T) []byte
+		keys   map[uint32]string
+		// Empty when decrypting should fail
+		want            string
+		wantErr         error
+		wantNeedsRotate bool
+	}{
+		{
+			name:   "active key",
+			cipher: func(t *testing.T) []byte { return encryptUnder(t, 2, "secret") },
+			keys:   testDataKeys,
+			want:   "secret",
+		},
+		{
+			name:            "older key",
+			cipher:          func(t *testing.T) []byte { return encryptUnder(t, 1, "secret") },
+			keys:            testDataKeys,
+			want:            "secret",
+			wantNeedsRotate: true,
+		},
+		{
+			name:            "legacy",
+			cipher:          func(t *testing.T) []byte { return legacyEncrypt(t, testDataKeys[LegacyKeyVersion], "secret", nil) },
+			keys:            testDataKeys,
+			want:            "secret",
+			wantNeedsRotate: true,
+		},
+		{
+			name: "legacy whose nonce looks like a header for the active key",
+			cipher: func(t *testing.T) []byte {
+				nonce := append(append([]byte(nil), cipherMagic...), 0, 0, 0, 2, 7, 7, 7, 7)
+				return legacyEncrypt(t, testDataKeys[LegacyKeyVersion], "secret", nonce)
+			},
+			keys:            testDataKeys,
+			want:            "secret",
+			wantNeedsRotate: true,
+		},
+		{
+			name: "legacy whose nonce looks like a header for an unknown key",
+			cipher: func(t *testing.T) []byte {
+				nonce := append(append([]byte(nil), cipherMagic...), 0, 0, 0, 9, 7, 7, 7, 7)
+				return legacyEncrypt(t, testDataKeys[LegacyKeyVersion], "secret", nonce)
+			},
+			keys:            testDataKeys,
+			want:            "secret",
+			wantNeedsRotate: true,
+		},
+		{
+			name:    "legacy without the legacy key",
+			cipher:  func(t *testing.T) []byte { return legacyEncrypt(t, testDataKeys[LegacyKeyVersion], "secret", nil) },
+			keys:    map[uint32]string{2: testDataKeys[2]},
+			wantErr: ErrUnknownKeyVersion,
+		},
+		{
+			name:    "unknown key",
+			cipher:  func(t *testing.T) []byte { return encryptUnder(t, 1, "secret") },
+			keys:    map[uint32]string{LegacyKeyVersion: testDataKeys[LegacyKeyVersion], 2: testDataKeys[2]},
+			wantErr: ErrUnknownKeyVersion,
+		},
+		{
+			name: "header swapped to another key",
+			cipher: func(t *testing.T) []byte {
+				cipher := encryptUnder(t, 1, "secret")
+				cipher[cipherHeaderLen-1] = 2
+				return cipher
+			},
+			keys: testDataKeys,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cipher := tt.cipher(t)
+			setTestDataKeys(t, tt.keys, 2)
+			got, err := BdcDecrypt(cipher)
+			if tt.want == "" {
+				if err == nil {
+					t.Fatalf("expected decrypting to fail, got %q", *got)
+				}
+				if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+					t.Fatalf("expected %v, got %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("expected %q, got %q", tt.want, *got)
+			}
+			if NeedsReencrypt(cipher) != tt.wantNeedsRotate {
+				t.Errorf("expected NeedsReencrypt to be %v", tt.wantNeedsRotate)
+			}
+			if !tt.wantNeedsRotate {
+				return
+			}
+			rotated, err := Reencrypt(cipher)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if version, ok := CipherKeyVersion(rotated); !ok || version != 2 {
+				t.Errorf("expected it re-encrypted under version 2, got %d", version)
+			}
+			if NeedsReencrypt(rotated) {
+				t.Error("expected it not to need re-encrypting again")
+			}
+			got, err = BdcDecrypt(rotated)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *got != tt.want {
+				t.Errorf("expected %q after re-encrypting, got %q", tt.want, *got)
+			}
+		})
+	}
+}
+
+func TestSetDataKeysNeedsActiveKey(t *testing.T) {
+	err := SetDataKeys(map[uint32]string{1: testDataKeys[1]}, 2)
+	if err == nil {
+		t.Fatal("expected an error activating a key that isn't loaded")
+	}
+}
@@ -0,0 +1,114 @@
+package secrets
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// RFC 6238 TOTP with the parameters every authenticator app supports: SHA-1, 6 digits, 30 second steps
+const (
+	totpPeriod    = 30
+	totpDigits    = 6
+	totpModulo    = 1000000
+	totpSecretLen = 20
+	// How many steps either side of now we accept, to allow for clock drift and slow typing
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Returns a random base32 TOTP secret, the form authenticator apps take it in
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, totpSecretLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// Returns the otpauth:// uri authenticator apps enroll from, usually shown as a QR code
+func TOTPProvisioningURI(issuer string, account string, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprintf("%d", totpDigits))
+	v.Set("period", fmt.Sprintf("%d", totpPeriod))
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return fmt.Sprintf("otpauth://totp/%s?%s", label, v.Encode())
+}
+
+// The time step t falls in
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// Returns the code for secret at step
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	// Dynamic truncation, RFC 4226 section 5.3
+	offset := sum[len(sum)-1] & 0xf
+	bin := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, bin%totpModulo), nil
+}
+
+// Checks code against secret around t. Returns the step the code was for, so the caller can refuse it (and
+// any earlier step) next time, otherwise a code could be replayed for as long as it's valid.
+func VerifyTOTP(secret string, code string, t time.Time) (step int64, ok bool, err error) {
+	code = strings.TrimSpace(code)
+	now := TOTPStep(t)
+	for s := now - totpSkew; s <= now+totpSkew; s++ {
+		expected, err := TOTPCode(secret, s)
+		if err != nil {
+			return 0, false, err
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return s, true, nil
+		}
+	}
+	return 0, false, nil
+}
+
+// Recovery codes are 16 base32 characters (80 bits), shown in groups of 4
+const (
+	recoveryCodeLen   = 16
+	recoveryCodeGroup = 4
+)
+
+// Returns a random single use recovery code, i.e. abcd-efgh-ijkl-mnop
+func NewRecoveryCode() (string, error) {
+	b := make([]byte, recoveryCodeLen*5/8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	raw := strings.ToLower(totpEncoding.EncodeToString(b))
+	groups := make([]string, 0)
+	for i := 0; i < len(raw); i += recoveryCodeGroup {
+		groups = append(groups, raw[i:i+recoveryCodeGroup])
+	}
+	return strings.Join(groups, "-"), nil
+}
+
+// What we store a recovery code as. Codes are random enough that a plain hash is as good as a slow one.
+// Ignores case, spaces and dashes, since people retype these by hand.
+func HashRecoveryCode(code string) []byte {
+	normalized := strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	h := Hash(normalized)
+	return h[:]
+}
@@ -0,0 +1,93 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Wraps datakeys with a HashiCorp Vault transit key
+type vaultTransitProvider struct {
+	addr   string
+	mount  string
+	key    string
+	token  string
+	client *http.Client
+}
+
+// Uses transit key key, from the transit engine mounted at mount on the vault at addr
+func NewVaultTransitProvider(addr string, mount string, key string, token string) (KeyProvider, error) {
+	if addr == "" {
+		return nil, errors.New("no vault address, set -vault-addr or $VAULT_ADDR")
+	}
+	if token == "" {
+		return nil, errors.New("$VAULT_TOKEN isn't set")
+	}
+	return &vaultTransitProvider{
+		addr:   strings.TrimRight(addr, "/"),
+		mount:  strings.Trim(mount, "/"),
+		key:    key,
+		token:  token,
+		client: &http.Client{Timeout: providerTimeout},
+	}, nil
+}
+
+// Wrapped keys are vault's own vault:v<n>:... ciphertexts, which also lets vault rotate its transit key
+// without us re-wrapping anything
+func (p *vaultTransitProvider) WrapKey(ctx context.Context, key []byte) ([]byte, error) {
+	var res struct {
+		Data struct {
+			Ciphertext string `json:"ciphertext"`
+		} `json:"data"`
+	}
+	err := p.post(ctx, "encrypt", map[string]string{"plaintext": base64.StdEncoding.EncodeToString(key)}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(res.Data.Ciphertext), nil
+}
+
+func (p *vaultTransitProvider) UnwrapKey(ctx context.Context, wrapped []byte) ([]byte, error) {
+	var res struct {
+		Data struct {
+			Plaintext string `json:"plaintext"`
+		} `json:"data"`
+	}
+	err := p.post(ctx, "decrypt", map[string]string{"ciphertext": string(wrapped)}, &res)
+	if err != nil {
+		return nil, err
+	}
+	return base64.StdEncoding.DecodeString(res.Data.Plaintext)
+}
+
+func (p *vaultTransitProvider) post(ctx context.Context, op string, body interface{}, res interface{}) error {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	url := fmt.Sprintf("%s/v1/%s/%s/%s", p.addr, p.mount, op, p.key)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-Vault-Token", p.token)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var vaultErr struct {
+			Errors []string `json:"errors"`
+		}
+		_ = json.NewDecoder(resp.Body).Decode(&vaultErr)
+		return fmt.Errorf("vault transit %s failed with status %d: %s", op, resp.StatusCode, strings.Join(vaultErr.Errors, "; "))
+	}
+	return json.NewDecoder(resp.Body).Decode(res)
+}
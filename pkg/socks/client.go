@@ -1,12 +1,18 @@
 package socks
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/bluedresscapital/coattails/pkg/wardrobe"
-	"github.com/go-redis/redis/v7"
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
 )
 
@@ -20,88 +26,446 @@ const (
 	// Send pings to peer with this period. Must be less than pongWait.
 	pingPeriod = (pongWait * 9) / 10
 
-	// Maximum message size allowed from peer.
-	maxMessageSize = 512
+	// Largest command we'll handle, bigger ones are answered with an error. Fits a subscribe to maxTopics
+	// topics.
+	maxMessageSize = 16 * 1024
+
+	// Largest message we'll read at all, past this the connection is dropped instead
+	maxFrameSize = 1024 * 1024
+
+	// Most topics a connection can be subscribed to at once
+	maxTopics = 100
+
+	// How many replies and quotes can queue up for the writer before the reader blocks
+	sendBuffer = 16
+
+	// Time allowed to check a portfolio subscription against the db
+	subscribeTimeout = 5 * time.Second
+
+	// Time allowed to read the events a reconnecting client missed
+	replayTimeout = 10 * time.Second
+
+	// Most events we replay to a reconnecting client, past this they're told to resync instead
+	maxReplay = 500
 )
 
+// A user's websocket (or event stream) connection. Events on their channel (or a quote channel they've
+// subscribed to) are written out as Envelopes, and commands from them are validated and acked.
 type Client struct {
 	Uid     string
+	UserId  int
 	Channel string
-	Conn    *websocket.Conn
-	Sub     *redis.PubSub
+	// Only set for websockets, event streams can't send commands
+	Conn *websocket.Conn
+	Sub  wardrobe.Subscription
+	// What envelopes are written out over
+	out transport
+	// How often we make sure an idle connection is still there
+	heartbeat time.Duration
+	store     wardrobe.SharingStore
+	// Cursor of the last event the client saw before reconnecting, if it's replaying what it missed
+	since string
+
+	// Replies to commands, and events from quote subscriptions, for WriteToClient to send
+	send chan Envelope
+	// Closed once either side of the connection shuts down
+	done     chan struct{}
+	doneOnce sync.Once
+
+	mu sync.Mutex
+	// Every topic the client is subscribed to, other than TopicUser
+	topics map[string]bool
+	// Subscriptions to the quote channels of every quotes topic the client is subscribed to
+	quoteSubs map[string]wardrobe.Subscription
 }
 
-type Msg struct {
-	ClientUid string
-	Payload   string
+// Creates a client for conn, subscribed to the user's channel. If since is set, every event after it is
+// replayed before any new ones are sent.
+func NewClient(userId int, conn *websocket.Conn, store wardrobe.SharingStore, since string) *Client {
+	c := newClient(userId, wsTransport{conn: conn}, pingPeriod, store, since)
+	c.Conn = conn
+	return c
 }
 
-// read reads messages from client and writes to redis
-// channel
+func newClient(userId int, out transport, heartbeat time.Duration, store wardrobe.SharingStore, since string) *Client {
+	channel := GetChannelFromUserId(userId)
+	return &Client{
+		Uid:       uuid.New().String(),
+		UserId:    userId,
+		Channel:   channel,
+		Sub:       wardrobe.Sub(channel),
+		out:       out,
+		heartbeat: heartbeat,
+		store:     store,
+		since:     since,
+		send:      make(chan Envelope, sendBuffer),
+		done:      make(chan struct{}),
+		topics:    make(map[string]bool),
+		quoteSubs: make(map[string]wardrobe.Subscription),
+	}
+}
+
+// read reads commands from client, and handles them
 func (c *Client) ReadFromClient() {
 	defer c.shutdown()
-	c.Conn.SetReadLimit(maxMessageSize)
+	c.Conn.SetReadLimit(maxFrameSize)
 	_ = c.Conn.SetReadDeadline(time.Now().Add(pongWait))
 	c.Conn.SetPongHandler(func(string) error { _ = c.Conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
 	for {
-		_, msg, err := c.Conn.ReadMessage()
+		_, r, err := c.Conn.NextReader()
 		if err != nil {
 			return
 		}
-		redisMsg := Msg{
-			ClientUid: c.Uid,
-			Payload:   string(msg),
+		b, err := ioutil.ReadAll(io.LimitReader(r, maxMessageSize+1))
+		if err != nil {
+			return
 		}
-		b, err := json.Marshal(redisMsg)
+		if len(b) > maxMessageSize {
+			// Skip the rest of it, there's no telling what its id was
+			if _, err = io.Copy(ioutil.Discard, r); err != nil {
+				return
+			}
+			if !c.reply(errorReply("", fmt.Errorf("message is over %d bytes", maxMessageSize))) {
+				return
+			}
+			continue
+		}
+		cmd, err := parseCommand(b)
+		if err != nil {
+			id := ""
+			if cmd != nil {
+				id = cmd.Id
+			}
+			if !c.reply(errorReply(id, err)) {
+				return
+			}
+			continue
+		}
+		reply := Envelope{Type: TypeAck, Id: cmd.Id}
+		if err = c.handleCommand(cmd); err != nil {
+			reply = errorReply(cmd.Id, err)
+		}
+		if !c.reply(reply) {
+			return
+		}
+	}
+}
+
+func (c *Client) handleCommand(cmd *Envelope) error {
+	switch cmd.Type {
+	case CmdSubscribe, CmdUnsubscribe:
+		var p subscribePayload
+		if err := json.Unmarshal(cmd.Payload, &p); err != nil || len(p.Topics) == 0 {
+			return errors.New("payload must have a non empty topics list")
+		}
+		for _, topic := range p.Topics {
+			var err error
+			if cmd.Type == CmdSubscribe {
+				ctx, cancel := context.WithTimeout(context.Background(), subscribeTimeout)
+				err = c.Subscribe(ctx, topic)
+				cancel()
+			} else {
+				err = c.unsubscribe(topic)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case CmdBroadcast:
+		if len(cmd.Payload) == 0 {
+			return errors.New("broadcast needs a payload")
+		}
+		msg := Msg{ClientUid: c.Uid, Topic: TopicUser, Type: CmdBroadcast, Payload: cmd.Payload}
+		b, err := json.Marshal(msg)
+		if err != nil {
+			return err
+		}
+		return wardrobe.Publish(c.Channel, b)
+	case CmdPing:
+		return nil
+	}
+	return fmt.Errorf("unknown command %q", cmd.Type)
+}
+
+// Checks that topic is valid and the user can subscribe to it, returning it normalized
+func CheckTopic(ctx context.Context, store wardrobe.SharingStore, userId int, topic string) (string, error) {
+	t, err := checkTopic(ctx, store, userId, topic)
+	if err != nil {
+		return "", err
+	}
+	return t.String(), nil
+}
+
+func checkTopic(ctx context.Context, store wardrobe.SharingStore, userId int, topic string) (*ParsedTopic, error) {
+	t, err := ParseTopic(topic)
+	if err != nil {
+		return nil, err
+	}
+	if t.PortId != 0 {
+		role, err := store.FetchPortfolioRole(ctx, t.PortId, userId)
+		if err != nil {
+			log.Printf("Error fetching role on portfolio %d for user %d: %v", t.PortId, userId, err)
+			return nil, errors.New("error checking portfolio access")
+		}
+		if !role.Includes(wardrobe.RoleViewer) {
+			return nil, fmt.Errorf("no access to portfolio %d", t.PortId)
+		}
+	}
+	return t, nil
+}
+
+func (c *Client) Subscribe(ctx context.Context, topic string) error {
+	t, err := checkTopic(ctx, c.store, c.UserId, topic)
+	if err != nil {
+		return err
+	}
+	topic = t.String()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.topics[topic] {
+		return nil
+	}
+	if len(c.topics) >= maxTopics {
+		return fmt.Errorf("can't subscribe to more than %d topics", maxTopics)
+	}
+	select {
+	case <-c.done:
+		// Shutdown already closed every quote subscription and unwatched every topic, so this one never would be
+		return errors.New("connection is closed")
+	default:
+	}
+	c.topics[topic] = true
+	watch(topic)
+	if t.Ticker != "" {
+		sub := wardrobe.Sub(QuoteChannel(t.Ticker))
+		c.quoteSubs[topic] = sub
+		go c.forward(sub)
+	}
+	return nil
+}
+
+func (c *Client) unsubscribe(topic string) error {
+	t, err := ParseTopic(topic)
+	if err != nil {
+		return err
+	}
+	topic = t.String()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.topics[topic] {
+		delete(c.topics, topic)
+		unwatch(topic)
+	}
+	if sub, ok := c.quoteSubs[topic]; ok {
+		delete(c.quoteSubs, topic)
+		_ = wardrobe.Unsub(sub)
+	}
+	return nil
+}
+
+func (c *Client) subscribed(topic string) bool {
+	if topic == TopicUser {
+		return true
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.topics[topic]
+}
+
+// Passes messages on sub along to the writer, until sub is closed
+func (c *Client) forward(sub wardrobe.Subscription) {
+	for m := range sub.Messages() {
+		msg, err := parseMsg(m)
 		if err != nil {
+			log.Printf("Error in unmarshalling redisMsg: %v", err)
+			continue
+		}
+		select {
+		case c.send <- msg.envelope():
+		case <-c.done:
 			return
 		}
-		_ = wardrobe.Publish(c.Channel, b)
 	}
 }
 
-// write reads from redis channel and writes to client
+// Queues e for the writer, returning false if the connection has shut down
+func (c *Client) reply(e Envelope) bool {
+	select {
+	case c.send <- e:
+		return true
+	case <-c.done:
+		return false
+	}
+}
+
+// write replays whatever the client missed, then reads from redis channel and writes to client
 func (c *Client) WriteToClient() {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(c.heartbeat)
+	defer ticker.Stop()
 	defer c.shutdown()
+	var seq uint64
+	write := func(env Envelope) bool {
+		seq++
+		env.V = ProtocolVersion
+		env.Seq = seq
+		if err := c.out.write(env); err != nil {
+			log.Printf("Error in writing to client: %v", err)
+			return false
+		}
+		return true
+	}
+	// Anything published while we were replaying is already in the replay, so skip it when it comes through
+	// live too
+	var replayed *wardrobe.StreamId
+	if c.since != "" {
+		var ok bool
+		replayed, ok = c.replay(write)
+		if !ok {
+			return
+		}
+	}
 	for {
+		var env Envelope
 		select {
-		case msg := <-c.Sub.Channel():
-			_ = c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
-			var redisMsg Msg
-			err := json.Unmarshal([]byte(msg.Payload), &redisMsg)
+		case m, ok := <-c.Sub.Messages():
+			if !ok {
+				return
+			}
+			parsed, err := parseMsg(m)
 			if err != nil {
 				log.Printf("Error in unmarshalling redisMsg: %v", err)
 				return
 			}
-			if redisMsg.ClientUid == c.Uid {
+			if parsed.ClientUid == c.Uid || !c.subscribed(parsed.Topic) {
 				continue
 			}
-			w, err := c.Conn.NextWriter(websocket.TextMessage)
-			if err != nil {
-				log.Printf("Error in getting next writer: %v", err)
-				return
+			if replayed != nil && parsed.Id != "" {
+				id, err := wardrobe.ParseStreamId(parsed.Id)
+				if err == nil && !id.After(*replayed) {
+					continue
+				}
 			}
-			// Write to client, ignore all errors :D
-			_, _ = w.Write([]byte(redisMsg.Payload))
-			if err := w.Close(); err != nil {
-				log.Printf("Error in writing to client: %v", err)
-				return
+			env = parsed.envelope()
+		case e := <-c.send:
+			// Quotes can still be in flight after an unsubscribe
+			if e.Type != TypeAck && e.Type != TypeError && !c.subscribed(e.Topic) {
+				continue
 			}
+			env = e
 		case <-ticker.C:
-			_ = c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
-			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+			if err := c.out.ping(); err != nil {
 				log.Printf("Error in writing ping message: %v", err)
 				return
 			}
+			continue
+		case <-c.done:
+			return
+		}
+		if !write(env) {
+			return
 		}
 	}
 }
 
+// Writes every event on the user's channel after c.since, or a resync if we no longer have them all. Returns
+// the id of the last event replayed, and false if the connection failed.
+func (c *Client) replay(write func(Envelope) bool) (*wardrobe.StreamId, bool) {
+	last, err := wardrobe.ParseStreamId(c.since)
+	if err != nil {
+		return nil, write(Envelope{Type: TypeResync, Topic: TopicUser})
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), replayTimeout)
+	defer cancel()
+	replay, err := wardrobe.ReadStream(ctx, EventStream(c.Channel), c.since, maxReplay)
+	if err != nil {
+		log.Printf("Error replaying events for channel %s: %v", c.Channel, err)
+		return nil, write(Envelope{Type: TypeResync, Topic: TopicUser})
+	}
+	if replay.Truncated {
+		return last, write(Envelope{Type: TypeResync, Topic: TopicUser})
+	}
+	for _, e := range replay.Events {
+		var msg Msg
+		err = json.Unmarshal(e.Payload, &msg)
+		if err != nil {
+			log.Printf("Error in unmarshalling replayed event %s: %v", e.Id, err)
+			continue
+		}
+		msg.Id = e.Id
+		if id, err := wardrobe.ParseStreamId(e.Id); err == nil {
+			last = id
+		}
+		if !c.subscribed(msg.Topic) {
+			continue
+		}
+		if !write(msg.envelope()) {
+			return nil, false
+		}
+	}
+	return last, true
+}
+
 func (c *Client) shutdown() {
-	// Unsubscribe from redis channel
-	_ = wardrobe.Unsub(c.Sub, c.Channel)
-	// Close client websocket connection
-	_ = c.Conn.Close()
-	log.Printf("Shutting down connection for channel %s", c.Channel)
+	c.doneOnce.Do(func() {
+		close(c.done)
+		// Unsubscribe from redis channels
+		_ = wardrobe.Unsub(c.Sub)
+		c.mu.Lock()
+		for topic, sub := range c.quoteSubs {
+			_ = wardrobe.Unsub(sub)
+			delete(c.quoteSubs, topic)
+		}
+		for topic := range c.topics {
+			unwatch(topic)
+		}
+		c.mu.Unlock()
+		// Close client connection
+		_ = c.out.close()
+		log.Printf("Shutting down connection for channel %s", c.Channel)
+	})
+}
+
+// Writes envelopes out to a connection
+type transport interface {
+	write(e Envelope) error
+	// Keeps an idle connection alive, and finds out if it's gone
+	ping() error
+	close() error
+}
+
+type wsTransport struct {
+	conn *websocket.Conn
+}
+
+func (t wsTransport) write(e Envelope) error {
+	_ = t.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return t.conn.WriteJSON(e)
+}
+
+func (t wsTransport) ping() error {
+	_ = t.conn.SetWriteDeadline(time.Now().Add(writeWait))
+	return t.conn.WriteMessage(websocket.PingMessage, nil)
+}
+
+func (t wsTransport) close() error {
+	return t.conn.Close()
+}
+
+func parseMsg(m wardrobe.Message) (*Msg, error) {
+	var msg Msg
+	err := json.Unmarshal([]byte(m.Payload), &msg)
+	if err != nil {
+		return nil, err
+	}
+	return &msg, nil
+}
+
+func (m *Msg) envelope() Envelope {
+	return Envelope{Type: m.Type, Topic: m.Topic, Cursor: m.Id, Payload: m.Payload}
+}
+
+func errorReply(id string, err error) Envelope {
+	b, _ := json.Marshal(errorPayload{Message: err.Error()})
+	return Envelope{Type: TypeError, Id: id, Payload: b}
 }
@@ -0,0 +1,152 @@
+package socks
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Version of the envelope below. Bumped whenever it changes in a way clients have to know about.
+const ProtocolVersion = 1
+
+// Every message over the websocket, in either direction, is one of these
+type Envelope struct {
+	V    int    `json:"v"`
+	Type string `json:"type"`
+	// What the message is about, see Topic. Events about the user themselves (i.e. LINK_BROKEN) are on TopicUser.
+	Topic string `json:"topic,omitempty"`
+	// Set on every message from the server, counting up from 1 on each connection
+	Seq uint64 `json:"seq,omitempty"`
+	// Set by the client on commands it wants acked, and echoed back on the ack (or error)
+	Id string `json:"id,omitempty"`
+	// Set on events kept in the user's event stream. Reconnecting with ?since=<cursor> replays every event
+	// after it.
+	Cursor  string          `json:"cursor,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// Commands clients can send
+const (
+	CmdSubscribe   = "subscribe"
+	CmdUnsubscribe = "unsubscribe"
+	// Relays the payload to the user's other connections, as a broadcast event on TopicUser
+	CmdBroadcast = "broadcast"
+	CmdPing      = "ping"
+)
+
+// Replies to commands
+const (
+	TypeAck   = "ack"
+	TypeError = "error"
+)
+
+// Sent on TopicUser instead of replaying, when events after the client's cursor are no longer kept (or there
+// are too many of them). The client has to refetch everything it cares about.
+const TypeResync = "resync"
+
+// Clients are always subscribed to this topic, and can't unsubscribe from it
+const TopicUser = "user"
+
+// Kinds of topics clients can subscribe to. Portfolio topics are <kind>:<port_id>, and need at least viewer
+// access to the portfolio. Quote topics are quotes:<ticker>.
+const (
+	TopicPositions  = "positions"
+	TopicOrders     = "orders"
+	TopicTransfers  = "transfers"
+	TopicPortValues = "port_values"
+	TopicQuotes     = "quotes"
+)
+
+var portfolioTopics = map[string]bool{
+	TopicPositions:  true,
+	TopicOrders:     true,
+	TopicTransfers:  true,
+	TopicPortValues: true,
+}
+
+var tickerRegex = regexp.MustCompile(`^[A-Z][A-Z0-9.\-]{0,9}$`)
+
+var ErrInvalidTopic = errors.New("invalid topic")
+
+// Returns the topic for kind about id, i.e. positions:12 or quotes:AAPL
+func Topic(kind string, id interface{}) string {
+	return fmt.Sprintf("%s:%v", kind, id)
+}
+
+// The pub/sub channel events on a quotes topic go out on. Unlike every other topic, quotes are the same for
+// every user, so they're published once instead of on every user's channel.
+func QuoteChannel(ticker string) string {
+	return fmt.Sprintf("quotes_%s", ticker)
+}
+
+// A topic a client asked to subscribe to
+type ParsedTopic struct {
+	Kind string
+	// Set for portfolio topics
+	PortId int
+	// Set for quote topics
+	Ticker string
+}
+
+// Parses and normalizes topic (tickers are upper cased)
+func ParseTopic(topic string) (*ParsedTopic, error) {
+	parts := strings.SplitN(topic, ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("%w %q, expected <kind>:<id>", ErrInvalidTopic, topic)
+	}
+	t := ParsedTopic{Kind: parts[0]}
+	switch {
+	case portfolioTopics[t.Kind]:
+		portId, err := strconv.Atoi(parts[1])
+		if err != nil || portId <= 0 {
+			return nil, fmt.Errorf("%w %q, expected a portfolio id", ErrInvalidTopic, topic)
+		}
+		t.PortId = portId
+	case t.Kind == TopicQuotes:
+		t.Ticker = strings.ToUpper(parts[1])
+		if !tickerRegex.MatchString(t.Ticker) {
+			return nil, fmt.Errorf("%w %q, expected a ticker", ErrInvalidTopic, topic)
+		}
+	default:
+		return nil, fmt.Errorf("%w %q, unknown kind %s", ErrInvalidTopic, topic, t.Kind)
+	}
+	return &t, nil
+}
+
+func (t *ParsedTopic) String() string {
+	if t.Kind == TopicQuotes {
+		return Topic(t.Kind, t.Ticker)
+	}
+	return Topic(t.Kind, t.PortId)
+}
+
+// Payload of subscribe and unsubscribe commands
+type subscribePayload struct {
+	Topics []string `json:"topics"`
+}
+
+// Payload of error replies
+type errorPayload struct {
+	Message string `json:"message"`
+}
+
+// Parses and validates a message from a client
+func parseCommand(b []byte) (*Envelope, error) {
+	var e Envelope
+	err := json.Unmarshal(b, &e)
+	if err != nil {
+		return nil, errors.New("message isn't a json envelope")
+	}
+	if e.V != ProtocolVersion {
+		return &e, fmt.Errorf("unsupported protocol version %d, expected %d", e.V, ProtocolVersion)
+	}
+	switch e.Type {
+	case CmdSubscribe, CmdUnsubscribe, CmdBroadcast, CmdPing:
+	default:
+		return &e, fmt.Errorf("unknown command %q", e.Type)
+	}
+	return &e, nil
+}
@@ -1,40 +1,145 @@
 package socks
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"log"
 
 	"github.com/bluedresscapital/coattails/pkg/wardrobe"
 	"github.com/google/uuid"
 )
 
-type ReduxEvent struct {
-	Type    string `json:"type"`
-	Payload string `json:"payload"`
+// What goes out over pub/sub, for every connection subscribed to the channel to turn into an Envelope
+type Msg struct {
+	// The connection the message came from, so it isn't echoed back to it. Unique for messages from the server.
+	ClientUid string
+	// Id of the event in the user's event stream, which clients can replay from when they reconnect. Empty for
+	// messages that aren't kept (quotes, live position values and broadcasts).
+	Id      string `json:",omitempty"`
+	Topic   string
+	Type    string
+	Payload json.RawMessage
 }
 
-func PublishFromServer(channel string, reduxType string, payload interface{}) error {
-	payloadB, err := json.Marshal(payload)
+// What publishing to everyone who can see a portfolio reads and writes
+type Store interface {
+	wardrobe.SharingStore
+	wardrobe.StreamStore
+}
+
+// Every user has their own channel, which their websocket connections subscribe to. Anything about a
+// portfolio goes out on the channel of everyone who can see it, see PublishToPortfolio.
+func GetChannelFromUserId(userId int) string {
+	return fmt.Sprintf("chanel_user_id_%d", userId)
+}
+
+// Publishes an event on the kind topic of portId (i.e. positions:<portId>) to everyone who can currently see
+// it: its owner, anyone it's shared with, and the owner's households. payloadFor builds each user's payload,
+// since some (i.e. positions) span every portfolio that user can see.
+func PublishToPortfolio(ctx context.Context, store Store, portId int, kind string, eventType string, payloadFor func(userId int) (interface{}, error)) error {
+	userIds, err := store.FetchPortfolioUserIds(ctx, portId)
 	if err != nil {
 		return err
 	}
-	event := ReduxEvent{
-		Type:    reduxType,
-		Payload: string(payloadB),
+	topic := Topic(kind, portId)
+	for _, userId := range userIds {
+		payload, err := payloadFor(userId)
+		if err != nil {
+			return err
+		}
+		err = PublishFromServer(ctx, store, GetChannelFromUserId(userId), topic, eventType, payload)
+		if err != nil {
+			return err
+		}
 	}
-	eventB, err := json.Marshal(event)
+	return nil
+}
+
+// Like PublishToPortfolio, but for events that are superseded by the next one soon enough (i.e. live position
+// values), so aren't kept for replay
+func PublishLiveToPortfolio(ctx context.Context, store Store, portId int, kind string, eventType string, payloadFor func(userId int) (interface{}, error)) error {
+	userIds, err := store.FetchPortfolioUserIds(ctx, portId)
 	if err != nil {
 		return err
 	}
-	msg := Msg{
-		ClientUid: uuid.New().String(),
-		Payload:   string(eventB),
+	topic := Topic(kind, portId)
+	for _, userId := range userIds {
+		payload, err := payloadFor(userId)
+		if err != nil {
+			return err
+		}
+		msg, err := newServerMsg(topic, eventType, payload)
+		if err != nil {
+			return err
+		}
+		err = publish(store, GetChannelFromUserId(userId), *msg)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Publishes an event about the user themselves (i.e. a broken broker link), which every one of their
+// connections gets
+func PublishToUser(ctx context.Context, userId int, eventType string, payload interface{}) error {
+	return PublishFromServer(ctx, wardrobe.Default(), GetChannelFromUserId(userId), TopicUser, eventType, payload)
+}
+
+// Publishes an event to every connection subscribed to ticker's quotes. Quotes are superseded by the next one
+// soon enough, so they aren't kept for replay.
+func PublishQuote(ticker string, eventType string, payload interface{}) error {
+	msg, err := newServerMsg(Topic(TopicQuotes, ticker), eventType, payload)
+	if err != nil {
+		return err
+	}
+	return publish(wardrobe.Default(), QuoteChannel(ticker), *msg)
+}
+
+// Publishes an event on a user's channel, keeping it in their event stream too so clients that were
+// disconnected can replay it
+func PublishFromServer(ctx context.Context, streams wardrobe.StreamStore, channel string, topic string, eventType string, payload interface{}) error {
+	msg, err := newServerMsg(topic, eventType, payload)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return err
 	}
+	msg.Id, err = streams.AppendStream(ctx, EventStream(channel), b)
+	if err != nil {
+		log.Printf("Errored in appending to event stream: %v", err)
+		return err
+	}
+	return publish(streams, channel, *msg)
+}
+
+// The stream events published on channel are kept in
+func EventStream(channel string) string {
+	return fmt.Sprintf("events_%s", channel)
+}
+
+func newServerMsg(topic string, eventType string, payload interface{}) (*Msg, error) {
+	payloadB, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	return &Msg{
+		ClientUid: uuid.New().String(),
+		Topic:     topic,
+		Type:      eventType,
+		Payload:   payloadB,
+	}, nil
+}
+
+func publish(streams wardrobe.StreamStore, channel string, msg Msg) error {
 	msgBytes, err := json.Marshal(msg)
 	if err != nil {
 		return err
 	}
-	err = wardrobe.Publish(channel, msgBytes)
+	err = streams.Publish(channel, msgBytes)
 	if err != nil {
 		log.Printf("Errored in publishing: %v", err)
 		return err
@@ -0,0 +1,114 @@
+package socks
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+)
+
+const (
+	// Comments sent on an idle event stream, often enough that proxies don't time it out
+	sseHeartbeat = 15 * time.Second
+
+	// How long EventSources wait before reconnecting, in ms
+	sseRetry = 3000
+)
+
+// Creates a client that writes events out to w as a server-sent event stream, for whoever can't speak
+// websockets. Event streams are read only, so topics have to be subscribed to up front, and then the stream is
+// served with ServeEventStream.
+func NewEventStreamClient(userId int, w http.ResponseWriter, store wardrobe.SharingStore, since string) (*Client, error) {
+	t := &sseTransport{w: w, rc: http.NewResponseController(w)}
+	err := t.start()
+	if err != nil {
+		return nil, err
+	}
+	return newClient(userId, t, sseHeartbeat, store, since), nil
+}
+
+// Writes events out until ctx is done (the peer hung up, or the server is shutting down) or a write fails.
+// Has to run on the request's goroutine, since that's the only one that can write the response.
+func (c *Client) ServeEventStream(ctx context.Context) {
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.shutdown()
+		case <-stop:
+		}
+	}()
+	c.WriteToClient()
+}
+
+// Writes each envelope as an event of its type, with the envelope as its data, and its cursor (if it has one)
+// as its id so EventSources send it back as Last-Event-ID when they reconnect
+type sseTransport struct {
+	w  http.ResponseWriter
+	rc *http.ResponseController
+}
+
+// Writes out the response headers. The body is the stream itself, which lasts until the request ends.
+func (t *sseTransport) start() error {
+	h := t.w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// Stops nginx from buffering events
+	h.Set("X-Accel-Buffering", "no")
+	t.w.WriteHeader(http.StatusOK)
+	return t.send(func() error {
+		_, err := fmt.Fprintf(t.w, "retry: %d\n\n", sseRetry)
+		return err
+	})
+}
+
+func (t *sseTransport) write(e Envelope) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return t.send(func() error {
+		if e.Cursor != "" {
+			_, err := fmt.Fprintf(t.w, "id: %s\n", e.Cursor)
+			if err != nil {
+				return err
+			}
+		}
+		_, err := fmt.Fprintf(t.w, "event: %s\ndata: %s\n\n", e.Type, b)
+		return err
+	})
+}
+
+func (t *sseTransport) ping() error {
+	return t.send(func() error {
+		_, err := fmt.Fprint(t.w, ": ping\n\n")
+		return err
+	})
+}
+
+// Runs write and flushes it under writeWait. The stream outlives the server's write timeout, so between
+// writes there's no deadline at all (an expired one resets http/2 streams even when idle).
+func (t *sseTransport) send(write func() error) error {
+	err := t.rc.SetWriteDeadline(time.Now().Add(writeWait))
+	if err != nil {
+		return err
+	}
+	err = write()
+	if err != nil {
+		return err
+	}
+	err = t.rc.Flush()
+	if err != nil {
+		return err
+	}
+	return t.rc.SetWriteDeadline(time.Time{})
+}
+
+// The stream ends when ServeEventStream returns and the handler with it
+func (t *sseTransport) close() error {
+	return nil
+}
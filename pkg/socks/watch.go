@@ -0,0 +1,85 @@
+package socks
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+)
+
+// Topics that something has to produce events for while anyone's subscribed, rather than events just happening
+// to them. The tickertape streams live prices for these.
+var watchedKinds = map[string]bool{
+	TopicQuotes:    true,
+	TopicPositions: true,
+}
+
+// Time allowed to mark topics as watched
+const watchTimeout = 5 * time.Second
+
+// How many connections on this process are subscribed to each watched topic. Topics are marked as watched
+// when the first connection subscribes, and kept watched until the last one leaves.
+var watchers = struct {
+	mu     sync.Mutex
+	counts map[string]int
+	once   sync.Once
+}{counts: make(map[string]int)}
+
+// topic has to already be normalized
+func watch(topic string) {
+	if !watched(topic) {
+		return
+	}
+	watchers.once.Do(func() { go refreshWatched() })
+	watchers.mu.Lock()
+	watchers.counts[topic]++
+	first := watchers.counts[topic] == 1
+	watchers.mu.Unlock()
+	if first {
+		markWatched([]string{topic})
+	}
+}
+
+// Once the last connection leaves, the topic is simply no longer refreshed, and expires
+func unwatch(topic string) {
+	if !watched(topic) {
+		return
+	}
+	watchers.mu.Lock()
+	defer watchers.mu.Unlock()
+	watchers.counts[topic]--
+	if watchers.counts[topic] <= 0 {
+		delete(watchers.counts, topic)
+	}
+}
+
+func watched(topic string) bool {
+	t, err := ParseTopic(topic)
+	return err == nil && watchedKinds[t.Kind]
+}
+
+// Keeps every topic watched on this process from expiring
+func refreshWatched() {
+	ticker := time.NewTicker(wardrobe.WatchedTopicTtl / 3)
+	defer ticker.Stop()
+	for range ticker.C {
+		watchers.mu.Lock()
+		topics := make([]string, 0, len(watchers.counts))
+		for topic := range watchers.counts {
+			topics = append(topics, topic)
+		}
+		watchers.mu.Unlock()
+		markWatched(topics)
+	}
+}
+
+func markWatched(topics []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), watchTimeout)
+	defer cancel()
+	err := wardrobe.WatchTopics(ctx, topics)
+	if err != nil {
+		log.Printf("Error marking topics %v as watched: %v", topics, err)
+	}
+}
@@ -1,6 +1,7 @@
 package stockings
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"strings"
@@ -24,8 +25,12 @@ func getHref(t html.Token) (ok bool, href string) {
 	return
 }
 
-func ScrapeCollections(ticker string) ([]string, error) {
-	resp, err := http.Get(fmt.Sprint(urlFmt, ticker))
+func ScrapeCollections(ctx context.Context, ticker string) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprint(urlFmt, ticker), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
 	if err != nil {
 		return nil, err
 	}
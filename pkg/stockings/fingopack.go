@@ -1,11 +1,13 @@
 package stockings
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
 
 	"github.com/bluedresscapital/coattails/pkg/util"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
 
 	"github.com/piquette/finance-go"
 	"github.com/piquette/finance-go/chart"
@@ -19,15 +21,19 @@ type FingoPack struct {
 }
 
 var _ StockAPI = (*FingoPack)(nil)
+var _ OptionAPI = (*FingoPack)(nil)
 
 type piqHistoricalStocks []finance.ChartBar
 
-func (piq FingoPack) GetCurrentPrice(ticker string) (*Stock, error) {
-
-	quote, err := quote.Get(ticker)
-	if err != nil {
-		return nil, err
+func (piq FingoPack) GetCurrentPrice(ctx context.Context, ticker string) (*Stock, error) {
+	iter := quote.ListP(&quote.Params{Params: finance.Params{Context: &ctx}, Symbols: []string{ticker}})
+	if !iter.Next() {
+		if iter.Err() != nil {
+			return nil, iter.Err()
+		}
+		return nil, fmt.Errorf("no quote found for %s", ticker)
 	}
+	quote := iter.Quote()
 
 	symbol := quote.Symbol
 	name := quote.ShortName
@@ -39,9 +45,9 @@ func (piq FingoPack) GetCurrentPrice(ticker string) (*Stock, error) {
 
 }
 
-func (piq FingoPack) GetHistoricalPrice(ticker string, date time.Time) (*HistoricalStock, error) {
+func (piq FingoPack) GetHistoricalPrice(ctx context.Context, ticker string, date time.Time) (*HistoricalStock, error) {
 	// Assume GetHistoricalRange (ticker, start, end) will return prices from [start, end] (inclusive)
-	historicalQuotes, err := piq.GetHistoricalRange(ticker, date, date)
+	historicalQuotes, err := piq.GetHistoricalRange(ctx, ticker, date, date)
 	if err != nil {
 		return nil, err
 	}
@@ -52,18 +58,23 @@ func (piq FingoPack) GetHistoricalPrice(ticker string, date time.Time) (*Histori
 	return &(*historicalQuotes)[len(*historicalQuotes)-1], nil
 }
 
-func (piq FingoPack) GetHistoricalRange(ticker string, start time.Time, end time.Time) (*HistoricalStocks, error) {
+func (piq FingoPack) GetHistoricalRange(ctx context.Context, ticker string, start time.Time, end time.Time) (*HistoricalStocks, error) {
 	if end.Before(start) {
 		return nil, fmt.Errorf("invalid date range. start (%s) is after end (%s)", start, end)
 	}
-	historicalStocks, err := getHistoricalStocks(ticker, start, end)
+	historicalStocks, err := getHistoricalStocks(ctx, ticker, start, end)
 	if err != nil {
 		return nil, err
 	}
 	return historicalStocks, nil
 }
 
-func getHistoricalStocks(ticker string, start time.Time, end time.Time) (*HistoricalStocks, error) {
+// Yahoo quotes option contracts by their OCC symbol, so we can reuse the same chart api we use for stocks
+func (piq FingoPack) GetOptionHistoricalRange(ctx context.Context, contract wardrobe.Option, start time.Time, end time.Time) (*HistoricalStocks, error) {
+	return piq.GetHistoricalRange(ctx, OCCSymbol(contract), start, end)
+}
+
+func getHistoricalStocks(ctx context.Context, ticker string, start time.Time, end time.Time) (*HistoricalStocks, error) {
 	start = util.GetTimelessDate(start)
 	end = util.GetTimelessDate(end)
 	// Just subtract 5 days (for now) to try and guarantee we can get some valid price for our date range
@@ -73,6 +84,7 @@ func getHistoricalStocks(ticker string, start time.Time, end time.Time) (*Histor
 	endRange := end.AddDate(0, 0, 1)
 	log.Printf("Fingo fetching historical stocks for %s from [%s, %s)", ticker, startRange, endRange)
 	params := &chart.Params{
+		Params:   finance.Params{Context: &ctx},
 		Symbol:   ticker,
 		Interval: datetime.OneDay,
 		Start:    datetime.New(&startRange),
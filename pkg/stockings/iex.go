@@ -1,6 +1,7 @@
 package stockings
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -46,9 +47,9 @@ const (
 
 //example for ralles, he should refactor this to better handle error checking etc
 //since this is a large struct, should we perhaps return *IexStock?
-func (iex IexApi) GetCurrentPrice(ticker string) (*Stock, error) {
+func (iex IexApi) GetCurrentPrice(ctx context.Context, ticker string) (*Stock, error) {
 	url := fmt.Sprintf(iexCurrentPriceUrl, ticker, getKey())
-	resp, err := http.Get(url)
+	resp, err := iexGet(ctx, url)
 	if err != nil {
 		return nil, err
 	}
@@ -66,10 +67,10 @@ func (iex IexApi) GetCurrentPrice(ticker string) (*Stock, error) {
 }
 
 //function that returns HistoricalStock at a certain date
-func (iex IexApi) GetHistoricalPrice(ticker string, date time.Time) (*HistoricalStock, error) {
+func (iex IexApi) GetHistoricalPrice(ctx context.Context, ticker string, date time.Time) (*HistoricalStock, error) {
 	parsedDate := date.Format(DateLayout)
 	url := fmt.Sprintf(iexHistoricalDateUrl, ticker, parsedDate, getKey())
-	resp, err := http.Get(url)
+	resp, err := iexGet(ctx, url)
 	if err != nil {
 		return nil, err
 	}
@@ -90,7 +91,7 @@ func (iex IexApi) GetHistoricalPrice(ticker string, date time.Time) (*Historical
 
 //function that returns a pointer to a slice of IexHistoricalStock's for a date range
 //do we need to return *[]*IexHistoricalStock?
-func (iex IexApi) GetHistoricalRange(ticker string, start time.Time, end time.Time) (*HistoricalStocks, error) {
+func (iex IexApi) GetHistoricalRange(ctx context.Context, ticker string, start time.Time, end time.Time) (*HistoricalStocks, error) {
 	parsedStart := start.Format(DateLayout)
 	parsedEnd := end.Format(DateLayout)
 	rangeQuery, err := getRange(parsedStart)
@@ -98,7 +99,7 @@ func (iex IexApi) GetHistoricalRange(ticker string, start time.Time, end time.Ti
 		return nil, err
 	}
 	url := fmt.Sprintf(iexHistoricalDateRangeUrl, ticker, *rangeQuery, getKey())
-	resp, err := http.Get(url)
+	resp, err := iexGet(ctx, url)
 	if err != nil {
 		return nil, err
 	}
@@ -125,6 +126,14 @@ func (iex IexApi) GetHistoricalRange(ticker string, start time.Time, end time.Ti
 	return convertToHistoricalRange(historical), nil
 }
 
+func iexGet(ctx context.Context, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return http.DefaultClient.Do(req)
+}
+
 //taken from https://github.com/addisonlynch/iexfinance/blob/master/iexfinance/stocks/historical.py
 //some leap year stuff is fucked up for them so will have to rewrite this later probably
 func getRange(date string) (*string, error) {
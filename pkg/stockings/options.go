@@ -0,0 +1,130 @@
+package stockings
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/util"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/shopspring/decimal"
+)
+
+// OptionAPI is implemented by stock apis that are also able to quote option contracts. Prices returned
+// are per share, same as how contracts are quoted - callers are responsible for applying the multiplier.
+type OptionAPI interface {
+	GetOptionHistoricalRange(ctx context.Context, contract wardrobe.Option, start time.Time, end time.Time) (*HistoricalStocks, error)
+}
+
+// Returns the OCC symbol of the contract, i.e. AAPL210115C00300000 for the AAPL $300 call expiring 2021-01-15
+func OCCSymbol(contract wardrobe.Option) string {
+	putCall := "C"
+	if contract.PutCall == wardrobe.Put {
+		putCall = "P"
+	}
+	strike := contract.Strike.Mul(decimal.NewFromInt(1000)).IntPart()
+	return fmt.Sprintf("%s%s%s%08d", strings.ToUpper(contract.Underlying), contract.Expiry.Format("060102"), putCall, strike)
+}
+
+// Returns what the contract would be worth (per share) if it were exercised with the underlying at
+// underlyingPrice
+func IntrinsicValue(contract wardrobe.Option, underlyingPrice decimal.Decimal) decimal.Decimal {
+	var value decimal.Decimal
+	if contract.PutCall == wardrobe.Put {
+		value = contract.Strike.Sub(underlyingPrice)
+	} else {
+		value = underlyingPrice.Sub(contract.Strike)
+	}
+	if value.IsNegative() {
+		return decimal.Zero
+	}
+	return value
+}
+
+// Returns the per share price of contract on date. Same as GetHistoricalPrice, but for option contracts
+func GetOptionPrice(ctx context.Context, quotes wardrobe.QuoteStore, api StockAPI, contract wardrobe.Option, date time.Time) (*decimal.Decimal, error) {
+	return GetHistoricalPrice(ctx, quotes, optionQuotes{quotes: quotes, api: api, contract: contract}, contract.Ticker, date)
+}
+
+// Same as GetHistoricalRange, but for option contracts. Prices after the contract expires are zero.
+func GetOptionHistoricalRange(ctx context.Context, quotes wardrobe.QuoteStore, api StockAPI, contract wardrobe.Option, start time.Time, end time.Time) (*HistoricalStocks, error) {
+	return GetHistoricalRange(ctx, quotes, optionQuotes{quotes: quotes, api: api, contract: contract}, contract.Ticker, start, end)
+}
+
+// optionQuotes adapts an option contract to the StockAPI interface, so option prices get cached in
+// stock_quotes under the contract's ticker just like every other stock. If the underlying api can't quote
+// options (or doesn't have any data for the contract), we fall back to the contract's intrinsic value.
+type optionQuotes struct {
+	quotes   wardrobe.QuoteStore
+	api      StockAPI
+	contract wardrobe.Option
+}
+
+var _ StockAPI = (*optionQuotes)(nil)
+
+func (oq optionQuotes) GetCurrentPrice(ctx context.Context, ticker string) (*Stock, error) {
+	price, err := GetOptionPrice(ctx, oq.quotes, oq.api, oq.contract, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &Stock{Symbol: ticker, Name: OCCSymbol(oq.contract), LatestPrice: *price}, nil
+}
+
+func (oq optionQuotes) GetHistoricalPrice(ctx context.Context, ticker string, date time.Time) (*HistoricalStock, error) {
+	hist, err := oq.GetHistoricalRange(ctx, ticker, date, date)
+	if err != nil {
+		return nil, err
+	}
+	if len(*hist) == 0 {
+		return nil, fmt.Errorf("couldn't find a valid price for %s on %s", ticker, date)
+	}
+	return &(*hist)[len(*hist)-1], nil
+}
+
+func (oq optionQuotes) GetHistoricalRange(ctx context.Context, ticker string, start time.Time, end time.Time) (*HistoricalStocks, error) {
+	start = util.GetTimelessDate(start)
+	end = util.GetTimelessDate(end)
+	expiry := util.GetTimelessDate(oq.contract.Expiry)
+	days := int(end.Sub(start).Hours()/24) + 1
+	if optionAPI, ok := oq.api.(OptionAPI); ok && !start.After(expiry) {
+		quoteEnd := end
+		if quoteEnd.After(expiry) {
+			quoteEnd = expiry
+		}
+		quotes, err := optionAPI.GetOptionHistoricalRange(ctx, oq.contract, start, quoteEnd)
+		if err == nil {
+			return padExpiredDays(quotes, end), nil
+		}
+		log.Printf("Unable to quote %s, falling back to intrinsic value: %v", ticker, err)
+	}
+	underlying, err := GetHistoricalRange(ctx, oq.quotes, oq.api, oq.contract.Underlying, start, end)
+	if err != nil {
+		return nil, err
+	}
+	if len(*underlying) != days {
+		return nil, fmt.Errorf("expected %d %s prices between %s and %s, got %d", days, oq.contract.Underlying, start, end, len(*underlying))
+	}
+	ret := new(HistoricalStocks)
+	for _, u := range *underlying {
+		price := IntrinsicValue(oq.contract, u.Price)
+		if u.Date.After(expiry) {
+			price = decimal.Zero
+		}
+		*ret = append(*ret, HistoricalStock{Date: u.Date, Price: price})
+	}
+	return ret, nil
+}
+
+// Contracts stop trading once they expire, so we just fill in the rest of the range with zeros
+func padExpiredDays(quotes *HistoricalStocks, end time.Time) *HistoricalStocks {
+	if len(*quotes) == 0 {
+		return quotes
+	}
+	last := (*quotes)[len(*quotes)-1].Date
+	for d := last.AddDate(0, 0, 1); !d.After(end); d = d.AddDate(0, 0, 1) {
+		*quotes = append(*quotes, HistoricalStock{Date: d, Price: decimal.Zero})
+	}
+	return quotes
+}
@@ -1,6 +1,7 @@
 package stockings
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"time"
@@ -18,9 +19,9 @@ import (
 */
 
 type StockAPI interface {
-	GetCurrentPrice(ticker string) (*Stock, error)
-	GetHistoricalPrice(ticker string, date time.Time) (*HistoricalStock, error)
-	GetHistoricalRange(ticker string, start time.Time, end time.Time) (*HistoricalStocks, error)
+	GetCurrentPrice(ctx context.Context, ticker string) (*Stock, error)
+	GetHistoricalPrice(ctx context.Context, ticker string, date time.Time) (*HistoricalStock, error)
+	GetHistoricalRange(ctx context.Context, ticker string, start time.Time, end time.Time) (*HistoricalStocks, error)
 }
 
 type Stock struct {
@@ -38,9 +39,9 @@ type HistoricalStock struct {
 
 type HistoricalStocks []HistoricalStock
 
-func GetHistoricalPrice(api StockAPI, ticker string, date time.Time) (*decimal.Decimal, error) {
+func GetHistoricalPrice(ctx context.Context, quotes wardrobe.QuoteStore, api StockAPI, ticker string, date time.Time) (*decimal.Decimal, error) {
 	date = util.GetTimelessDate(date)
-	hist, err := GetHistoricalRange(api, ticker, date, date)
+	hist, err := GetHistoricalRange(ctx, quotes, api, ticker, date, date)
 	if err != nil {
 		return nil, err
 	}
@@ -51,12 +52,13 @@ func GetHistoricalPrice(api StockAPI, ticker string, date time.Time) (*decimal.D
 	return &price, nil
 }
 
-func GetCurrentPrice(api StockAPI, ticker string) (*decimal.Decimal, error) {
-	return GetHistoricalPrice(api, ticker, util.GetTimelessDate(time.Now()))
+func GetCurrentPrice(ctx context.Context, quotes wardrobe.QuoteStore, api StockAPI, ticker string) (*decimal.Decimal, error) {
+	return GetHistoricalPrice(ctx, quotes, api, ticker, util.GetTimelessDate(time.Now()))
 }
 
-// GetHistoricalRange will return prices for *EVERY DAY* from start to end
-func GetHistoricalRange(api StockAPI, ticker string, start time.Time, end time.Time) (*HistoricalStocks, error) {
+// GetHistoricalRange will return prices for *EVERY DAY* from start to end. Prices are cached in quotes, so we only
+// hit the api for ranges we haven't seen before
+func GetHistoricalRange(ctx context.Context, quotes wardrobe.QuoteStore, api StockAPI, ticker string, start time.Time, end time.Time) (*HistoricalStocks, error) {
 	if start.After(end) {
 		return nil, fmt.Errorf("start date (%s) is after end (%s)", start, end)
 	}
@@ -67,13 +69,13 @@ func GetHistoricalRange(api StockAPI, ticker string, start time.Time, end time.T
 	start = util.GetTimelessDate(start)
 	end = util.GetTimelessDate(end)
 	days := int(end.Sub(start).Hours()/24) + 1 // Add one to include end date
-	count, err := wardrobe.FetchStockQuoteCount(ticker, start, end)
+	count, err := quotes.FetchStockQuoteCount(ctx, ticker, start, end)
 	if err != nil {
 		return nil, err
 	}
 	if days == *count {
 		log.Printf("Fetching %s quotes from db", ticker)
-		sq, err := wardrobe.FetchStockQuotes(ticker, start, end)
+		sq, err := quotes.FetchStockQuotes(ctx, ticker, start, end)
 		if err != nil {
 			return nil, err
 		}
@@ -87,7 +89,7 @@ func GetHistoricalRange(api StockAPI, ticker string, start time.Time, end time.T
 		return ret, nil
 	}
 	log.Printf("We only have %d/%d stock quotes, fetching %s quotes from api...", *count, days, ticker)
-	stocksP, err := api.GetHistoricalRange(ticker, start, end)
+	stocksP, err := api.GetHistoricalRange(ctx, ticker, start, end)
 	if err != nil {
 		return nil, fmt.Errorf("errored out from stock api's get historical range: %v", err)
 	}
@@ -95,16 +97,16 @@ func GetHistoricalRange(api StockAPI, ticker string, start time.Time, end time.T
 	if len(*stocksP) != days {
 		return nil, fmt.Errorf("api.GetHistoricalRange should've returned %d prices between %s to %s, only got %d", days, start, end, len(*stocksP))
 	}
-	quotes := make([]wardrobe.StockQuote, 0)
+	newQuotes := make([]wardrobe.StockQuote, 0)
 	for _, s := range *stocksP {
-		quotes = append(quotes, wardrobe.StockQuote{
+		newQuotes = append(newQuotes, wardrobe.StockQuote{
 			Stock: ticker,
 			Price: s.Price,
 			Date:  s.Date,
 		})
 	}
 	log.Printf("Bulk inserting stock quotes...")
-	err = wardrobe.BatchUpsertStockQuotes(quotes)
+	err = quotes.BatchUpsertStockQuotes(ctx, newQuotes)
 	if err != nil {
 		return nil, err
 	}
@@ -1,12 +1,12 @@
 package tda
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net/http"
 	url2 "net/url"
+	"time"
 
 	"github.com/bluedresscapital/coattails/pkg/util"
 	"github.com/shopspring/decimal"
@@ -14,7 +14,21 @@ import (
 
 //func FetchRefreshToken()
 
-const ClientId = "GBCZDGRJAOIJHF0IETOA76NFAKZ0OGQX"
+const (
+	ClientId       = "GBCZDGRJAOIJHF0IETOA76NFAKZ0OGQX"
+	DefaultBaseURL = "https://api.tdameritrade.com"
+)
+
+var client = util.NewClient(util.ClientConfig{BaseURL: DefaultBaseURL, Retries: util.DefaultRetries})
+
+// Points every TD request at cfg.BaseURL (or the real api if it's empty) using cfg's timeouts and retries.
+// Must be called before any requests are made.
+func Configure(cfg util.ClientConfig) {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = DefaultBaseURL
+	}
+	client = util.NewClient(cfg)
+}
 
 type AuthResponse struct {
 	AccessToken  string `json:"access_token"`
@@ -33,39 +47,27 @@ type AuthResponse struct {
 // 3. Use access token.
 // Note - if we ever mishandle the refresh token (i.e. delete or lose it), we would need to reauth TD
 // Ideally if done correctly, the client should never realize that we're constantly swapping these refresh tokens.
-func FetchAccessToken(refreshToken string) (*AuthResponse, error) {
+func FetchAccessToken(ctx context.Context, refreshToken string) (*AuthResponse, error) {
 	escapedToken := url2.QueryEscape(refreshToken)
-	url := "https://api.tdameritrade.com/v1/oauth2/token"
 	data := fmt.Sprintf(
 		"grant_type=refresh_token&refresh_token=%s&access_type=offline&code=&client_id=%s%%40AMER.OAUTHAP&redirect_uri=http%%3A%%2F%%2Flocalhost",
 		escapedToken,
 		ClientId)
-	resp, err := http.Post(url, "application/x-www-form-urlencoded", bytes.NewBuffer([]byte(data)))
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-	var auth AuthResponse
-	err = json.Unmarshal(body, &auth)
-	if err != nil {
-		return nil, err
-	}
-	return &auth, nil
+	return fetchAuthResponse(ctx, data)
 }
 
 // Given auth code,
-func FetchRefreshTokenUsingAuthCode(code string, clientId string) (*AuthResponse, error) {
+func FetchRefreshTokenUsingAuthCode(ctx context.Context, code string, clientId string) (*AuthResponse, error) {
 	encodedCode := url2.QueryEscape(code)
 	data := fmt.Sprintf(
 		"grant_type=authorization_code&refresh_token=&access_type=offline&code=%s&client_id=%s%%40AMER.OAUTHAP&redirect_uri=http%%3A%%2F%%2Flocalhost",
 		encodedCode,
 		clientId)
-	url := "https://api.tdameritrade.com/v1/oauth2/token"
-	resp, err := http.Post(url, "application/x-www-form-urlencoded", bytes.NewBuffer([]byte(data)))
+	return fetchAuthResponse(ctx, data)
+}
+
+func fetchAuthResponse(ctx context.Context, data string) (*AuthResponse, error) {
+	resp, err := client.Post(ctx, "/v1/oauth2/token", "application/x-www-form-urlencoded", []byte(data), nil)
 	if err != nil {
 		return nil, err
 	}
@@ -98,14 +100,19 @@ type TDTransactionResponse struct {
 }
 
 type TDTransactionItem struct {
-	Amount      decimal.Decimal             `json:"amount"`
-	Price       decimal.Decimal             `json:"price"`
-	Instrument  TDTransactionItemInstrument `json:"instrument"`
-	Instruction string                      `json:"instruction"`
+	Amount         decimal.Decimal             `json:"amount"`
+	Price          decimal.Decimal             `json:"price"`
+	Instrument     TDTransactionItemInstrument `json:"instrument"`
+	Instruction    string                      `json:"instruction"`
+	PositionEffect string                      `json:"positionEffect"`
 }
 
 type TDTransactionItemInstrument struct {
-	Symbol string `json:"symbol"`
+	Symbol               string `json:"symbol"`
+	AssetType            string `json:"assetType"`
+	UnderlyingSymbol     string `json:"underlyingSymbol"`
+	OptionExpirationDate string `json:"optionExpirationDate"`
+	PutCall              string `json:"putCall"`
 }
 
 type TDTransfer struct {
@@ -116,12 +123,16 @@ type TDTransfer struct {
 
 type TDTransfers []TDTransfer
 
-func ScrapeTransactions(authTok string, accountId string) (TDTransactions, error) {
-	url := fmt.Sprintf("https://api.tdameritrade.com/v1/accounts/%s/transactions", accountId)
-	resp, err := util.MakeGetRequest(authTok, url)
+// Fetches every transaction between start and end (inclusive). TD rejects ranges longer than a year, see
+// ScrapeTransactionsSince for fetching longer ones
+func ScrapeTransactions(ctx context.Context, authTok string, accountId string, start time.Time, end time.Time) (TDTransactions, error) {
+	path := fmt.Sprintf("/v1/accounts/%s/transactions?startDate=%s&endDate=%s",
+		accountId, start.Format("2006-01-02"), end.Format("2006-01-02"))
+	resp, err := client.Get(ctx, authTok, path)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	body, _ := ioutil.ReadAll(resp.Body)
 	var orders TDTransactions
 	err = json.Unmarshal(body, &orders)
@@ -0,0 +1,91 @@
+package tda
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/util"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/shopspring/decimal"
+)
+
+// TD reports contracts that leave the account without a trade as RECEIVE_AND_DELIVER transactions,
+// and the description is the only thing telling us why they left
+var optionRemovals = map[string]wardrobe.OptionEvent{
+	"REMOVAL OF OPTION DUE TO EXPIRATION": wardrobe.OptionExpiration,
+	"REMOVAL OF OPTION DUE TO ASSIGNMENT": wardrobe.OptionAssignment,
+	"REMOVAL OF OPTION DUE TO EXERCISE":   wardrobe.OptionExercise,
+}
+
+// Parses an option instrument. TD doesn't give us the strike directly, so we pull it out of the symbol,
+// which looks like AAPL_011521C300 (underlying_MMDDYY{C,P}strike)
+func parseOption(inst TDTransactionItemInstrument) (*wardrobe.Option, error) {
+	parts := strings.Split(inst.Symbol, "_")
+	if len(parts) != 2 || len(parts[1]) < 8 {
+		return nil, fmt.Errorf("invalid td option symbol %s", inst.Symbol)
+	}
+	strike, err := decimal.NewFromString(parts[1][7:])
+	if err != nil {
+		return nil, fmt.Errorf("invalid strike in td option symbol %s: %v", inst.Symbol, err)
+	}
+	expiry, err := time.Parse("2006-01-02T15:04:05+0000", inst.OptionExpirationDate)
+	if err != nil {
+		// Fall back to the date embedded in the symbol
+		expiry, err = time.Parse("010206", parts[1][:6])
+		if err != nil {
+			return nil, fmt.Errorf("invalid expiry in td option symbol %s: %v", inst.Symbol, err)
+		}
+	}
+	putCall := inst.PutCall
+	if putCall == "" {
+		putCall = wardrobe.Call
+		if parts[1][6] == 'P' {
+			putCall = wardrobe.Put
+		}
+	}
+	underlying := inst.UnderlyingSymbol
+	if underlying == "" {
+		underlying = parts[0]
+	}
+	return &wardrobe.Option{
+		Ticker:     inst.Symbol,
+		Underlying: underlying,
+		Strike:     strike,
+		Expiry:     util.GetTimelessDate(expiry),
+		PutCall:    putCall,
+		Multiplier: wardrobe.DefaultMultiplier,
+	}, nil
+}
+
+// TD doesn't tell us whether an expiration/assignment closed a long or a short, so we close out whichever
+// side the account is holding. DESTRUCTIVELY sets IsBuy on the removal orders.
+func closeOutOptionRemovals(orders []wardrobe.Order, existing []wardrobe.Order) {
+	seen := make(map[string]bool)
+	held := make(map[string]decimal.Decimal)
+	addOrder := func(o wardrobe.Order) {
+		if o.OptionEvent.ClosesAtZero() {
+			return
+		}
+		if o.IsBuy {
+			held[o.Stock] = held[o.Stock].Add(o.Quantity)
+		} else {
+			held[o.Stock] = held[o.Stock].Sub(o.Quantity)
+		}
+	}
+	for _, o := range orders {
+		seen[o.Uid] = true
+		addOrder(o)
+	}
+	for _, o := range existing {
+		if !seen[o.Uid] {
+			addOrder(o)
+		}
+	}
+	for i, o := range orders {
+		if o.OptionEvent.ClosesAtZero() {
+			// Selling closes a long position, buying closes a short one
+			orders[i].IsBuy = held[o.Stock].IsNegative()
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package tda
+
+import (
+	"context"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/util"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+)
+
+var (
+	// TD caps each transactions request at a year
+	TransactionWindow = 365 * 24 * time.Hour
+	// TD doesn't tell us when the account was opened, so when backfilling we keep walking back until we see
+	// this many empty windows in a row
+	MaxEmptyWindows = 2
+	// Transactions can show up a few days after their transaction date (i.e. settlement, corrections), so
+	// incremental syncs re-fetch this far behind the cursor. Already saved transactions are ignored on insert.
+	SyncOverlap = 7 * 24 * time.Hour
+)
+
+// Fetches every transaction since `since`, or the account's entire history if since is nil, one window at a
+// time. Transactions on window boundaries are only returned once.
+func ScrapeTransactionsSince(ctx context.Context, authTok string, accountId string, since *time.Time) (TDTransactions, error) {
+	end := util.GetTimelessDate(time.Now())
+	var stop time.Time
+	if since != nil {
+		stop = util.GetTimelessDate(since.Add(-SyncOverlap))
+	}
+	seen := make(map[int]bool)
+	var trans TDTransactions
+	emptyWindows := 0
+	for {
+		start := end.Add(-TransactionWindow)
+		if since != nil && start.Before(stop) {
+			start = stop
+		}
+		window, err := ScrapeTransactions(ctx, authTok, accountId, start, end)
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range window {
+			if !seen[t.TransactionId] {
+				seen[t.TransactionId] = true
+				trans = append(trans, t)
+			}
+		}
+		if len(window) == 0 {
+			emptyWindows++
+		} else {
+			emptyWindows = 0
+		}
+		if since != nil && !start.After(stop) {
+			break
+		}
+		if since == nil && emptyWindows >= MaxEmptyWindows {
+			break
+		}
+		end = start.AddDate(0, 0, -1)
+	}
+	return trans, nil
+}
+
+func (api API) scrapeTransactions(ctx context.Context, kind wardrobe.SyncKind) (TDTransactions, *wardrobe.TDAccount, error) {
+	accessTok, tdAccount, err := api.getAccessToken(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	cursor, err := wardrobe.FetchSyncCursor(ctx, wardrobe.TDA, api.AccountId, kind)
+	if err != nil {
+		return nil, nil, err
+	}
+	trans, err := ScrapeTransactionsSince(ctx, *accessTok, tdAccount.AccountNum, cursor)
+	if err != nil {
+		return nil, nil, err
+	}
+	return trans, tdAccount, nil
+}
+
+// Called by orders.ReloadOrders once the orders we returned are saved, so the next sync only fetches
+// what's new
+func (api API) CommitOrderSync(ctx context.Context) error {
+	return wardrobe.UpsertSyncCursor(ctx, wardrobe.TDA, api.AccountId, wardrobe.OrderSync, util.GetTimelessDate(time.Now()))
+}
+
+// Same as CommitOrderSync, but for transfers
+func (api API) CommitTransferSync(ctx context.Context) error {
+	return wardrobe.UpsertSyncCursor(ctx, wardrobe.TDA, api.AccountId, wardrobe.TransferSync, util.GetTimelessDate(time.Now()))
+}
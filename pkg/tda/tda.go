@@ -1,11 +1,14 @@
 package tda
 
 import (
+	"context"
 	"strconv"
 	"time"
 
+	"github.com/bluedresscapital/coattails/pkg/links"
 	"github.com/bluedresscapital/coattails/pkg/orders"
 	"github.com/bluedresscapital/coattails/pkg/transfers"
+	"github.com/bluedresscapital/coattails/pkg/util"
 	"github.com/bluedresscapital/coattails/pkg/wardrobe"
 	"github.com/shopspring/decimal"
 )
@@ -14,39 +17,59 @@ type API struct {
 	AccountId int
 }
 
-var _ orders.OrderAPI = (*API)(nil)
-var _ transfers.TransferAPI = (*API)(nil)
+var _ orders.SyncedOrderAPI = (*API)(nil)
+var _ transfers.SyncedTransferAPI = (*API)(nil)
 
-func (api API) GetOrders() ([]wardrobe.Order, error) {
-	accessTok, tdAccount, err := api.getAccessToken()
+func (api API) GetOrders(ctx context.Context) ([]wardrobe.Order, error) {
+	trans, _, err := api.scrapeTransactions(ctx, wardrobe.OrderSync)
 	if err != nil {
 		return nil, err
 	}
-	trans, err := ScrapeTransactions(*accessTok, tdAccount.AccountNum)
-	if err != nil {
-		return nil, err
-	}
-	port, err := wardrobe.FetchPortfolioByTDAccountId(api.AccountId)
+	port, err := wardrobe.FetchPortfolioByTDAccountId(ctx, api.AccountId)
 	if err != nil {
 		return nil, err
 	}
 	var orders []wardrobe.Order
+	hasRemovals := false
 	for _, t := range trans {
 		var isBuy bool
 		var price decimal.Decimal
+		var event wardrobe.OptionEvent
+		removal, isRemoval := optionRemovals[t.Description]
 		if t.Type == "RECEIVE_AND_DELIVER" && t.Description == "TRANSFER OF SECURITY OR OPTION IN" {
 			// This is for assets that were transferred into the account, so they don't have a starting price
 			// For now, we just set their cost basis as whatever the price of the stock was that day, but if
 			// we wanted to be more pedantic we could allow the user to manually edit it
 			isBuy = true
 			price = decimal.Zero
+		} else if t.Type == "RECEIVE_AND_DELIVER" && isRemoval {
+			// Contracts leaving the account without a trade - we figure out which side we're closing below
+			event = removal
+			price = decimal.Zero
+			hasRemovals = true
 		} else if t.Type == "TRADE" {
 			isBuy = t.TransactionItem.Instruction == "BUY"
 			price = t.TransactionItem.Price
+			if t.TransactionItem.Instrument.AssetType == "OPTION" {
+				event = wardrobe.OptionOpen
+				if t.TransactionItem.PositionEffect == "CLOSING" {
+					event = wardrobe.OptionClose
+				}
+			}
 		} else {
 			// Don't even add the order if it isn't one of the categories listed above
 			continue
 		}
+		if t.TransactionItem.Instrument.AssetType == "OPTION" {
+			opt, err := parseOption(t.TransactionItem.Instrument)
+			if err != nil {
+				return nil, err
+			}
+			err = wardrobe.UpsertOption(ctx, *opt)
+			if err != nil {
+				return nil, err
+			}
+		}
 		date, err := time.Parse("2006-01-02T15:04:05+0000", t.TransactionDate)
 		if err != nil {
 			return nil, err
@@ -55,27 +78,31 @@ func (api API) GetOrders() ([]wardrobe.Order, error) {
 			Uid:           strconv.Itoa(t.TransactionId),
 			PortId:        port.Id,
 			Stock:         t.TransactionItem.Instrument.Symbol,
-			Quantity:      t.TransactionItem.Amount,
+			Quantity:      t.TransactionItem.Amount.Abs(),
 			Value:         price,
 			IsBuy:         isBuy,
 			ManuallyAdded: false,
 			Date:          date,
+			OptionEvent:   event,
 		}
 		orders = append(orders, order)
 	}
+	if hasRemovals {
+		existing, err := wardrobe.FetchOrdersByPortfolioId(ctx, port.Id)
+		if err != nil {
+			return nil, err
+		}
+		closeOutOptionRemovals(orders, existing)
+	}
 	return orders, nil
 }
 
-func (api API) GetTransfers() ([]wardrobe.Transfer, error) {
-	accessTok, tdAccount, err := api.getAccessToken()
-	if err != nil {
-		return nil, err
-	}
-	trans, err := ScrapeTransactions(*accessTok, tdAccount.AccountNum)
+func (api API) GetTransfers(ctx context.Context) ([]wardrobe.Transfer, error) {
+	trans, _, err := api.scrapeTransactions(ctx, wardrobe.TransferSync)
 	if err != nil {
 		return nil, err
 	}
-	port, err := wardrobe.FetchPortfolioByTDAccountId(api.AccountId)
+	port, err := wardrobe.FetchPortfolioByTDAccountId(ctx, api.AccountId)
 	if err != nil {
 		return nil, err
 	}
@@ -101,18 +128,25 @@ func (api API) GetTransfers() ([]wardrobe.Transfer, error) {
 	return transfers, nil
 }
 
-func (api API) getAccessToken() (*string, *wardrobe.TDAccount, error) {
-	tdAccount, err := wardrobe.FetchTDAccount(api.AccountId)
+func (api API) getAccessToken(ctx context.Context) (*string, *wardrobe.TDAccount, error) {
+	tdAccount, err := wardrobe.FetchTDAccount(ctx, api.AccountId)
 	if err != nil {
 		return nil, nil, err
 	}
-	auth, err := FetchAccessToken(tdAccount.RefreshToken)
+	// TD rotates the refresh token as soon as it hands us an access token, so once we've asked for one we
+	// finish saving the new refresh token even if we're cancelled. The broker client's timeout still applies.
+	tokCtx := util.Detach(ctx)
+	auth, err := FetchAccessToken(tokCtx, tdAccount.RefreshToken)
 	if err != nil {
+		links.Broken(tokCtx, wardrobe.TDA, api.AccountId, tdAccount.UserId, err)
 		return nil, nil, err
 	}
-	err = wardrobe.UpdateRefreshToken(api.AccountId, auth.RefreshToken)
+	err = wardrobe.UpdateRefreshToken(tokCtx, api.AccountId, auth.RefreshToken)
 	if err != nil {
+		// TD already rotated the refresh token, so if we fail to save the new one the link is gone
+		links.Broken(tokCtx, wardrobe.TDA, api.AccountId, tdAccount.UserId, err)
 		return nil, nil, err
 	}
+	links.Healthy(tokCtx, wardrobe.TDA, api.AccountId)
 	return &auth.AccessToken, tdAccount, nil
 }
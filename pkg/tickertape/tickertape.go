@@ -0,0 +1,201 @@
+package tickertape
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/socks"
+	"github.com/bluedresscapital/coattails/pkg/stockings"
+	"github.com/bluedresscapital/coattails/pkg/util"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/shopspring/decimal"
+)
+
+// Events we push
+const (
+	// On quotes:<ticker>, with a Quote
+	TypeQuote = "QUOTE"
+	// On positions:<port_id>, with LivePositions
+	TypeLivePositions = "LIVE_POSITIONS"
+)
+
+// How many prices we fetch at once
+const maxFetches = 8
+
+// Everything the tape reads
+type Store interface {
+	wardrobe.PositionStore
+	socks.Store
+}
+
+// Polls the price of every ticker a connected client is watching (either directly on quotes:<ticker>, or as a
+// position in a portfolio they're watching on positions:<port_id>), and pushes them out while the market's
+// open. Each ticker is only fetched once per tick, no matter how many clients are watching it.
+//
+// Watched topics are shared across every coattails process, so only one of them needs to run a tape.
+type Tape struct {
+	api      stockings.StockAPI
+	store    Store
+	interval time.Duration
+	// Whether prices should be fetched right now, swappable so we can run outside market hours
+	marketOpen func() bool
+}
+
+type Quote struct {
+	Ticker string          `json:"ticker"`
+	Price  decimal.Decimal `json:"price"`
+	At     time.Time       `json:"at"`
+}
+
+// A portfolio's positions, with stocks revalued at their latest price. Options and cash keep the value they
+// had as of the last reload.
+type LivePositions struct {
+	PortId    int                 `json:"port_id"`
+	Positions []wardrobe.Position `json:"positions"`
+	Value     decimal.Decimal     `json:"value"`
+	At        time.Time           `json:"at"`
+}
+
+func New(api stockings.StockAPI, store Store, interval time.Duration) *Tape {
+	return &Tape{
+		api:      api,
+		store:    store,
+		interval: interval,
+		marketOpen: func() bool {
+			return util.IsMarketOpen(util.GetESTNow())
+		},
+	}
+}
+
+// Ignores market hours, pushing prices whenever anyone's watching
+func (t *Tape) AlwaysOpen() *Tape {
+	t.marketOpen = func() bool { return true }
+	return t
+}
+
+// Pushes prices every interval until ctx is done
+func (t *Tape) Run(ctx context.Context) {
+	log.Printf("Streaming quotes every %s", t.interval)
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !t.marketOpen() {
+				continue
+			}
+			tickCtx, cancel := context.WithTimeout(ctx, t.interval)
+			err := t.Tick(tickCtx)
+			cancel()
+			if err != nil {
+				log.Printf("Error streaming quotes: %v", err)
+			}
+		}
+	}
+}
+
+// Fetches and pushes the price of everything that's currently watched
+func (t *Tape) Tick(ctx context.Context) error {
+	topics, err := wardrobe.FetchWatchedTopics(ctx)
+	if err != nil {
+		return err
+	}
+	quoted := make(map[string]bool)
+	ports := make(map[int][]wardrobe.Position)
+	tickers := make(map[string]bool)
+	for _, topic := range topics {
+		parsed, err := socks.ParseTopic(topic)
+		if err != nil {
+			continue
+		}
+		switch parsed.Kind {
+		case socks.TopicQuotes:
+			quoted[parsed.Ticker] = true
+			tickers[parsed.Ticker] = true
+		case socks.TopicPositions:
+			positions, err := t.store.FetchPortfolioPositions(ctx, parsed.PortId)
+			if err != nil {
+				log.Printf("Error fetching positions of port %d: %v", parsed.PortId, err)
+				continue
+			}
+			ports[parsed.PortId] = positions
+			for _, p := range positions {
+				if live(p) {
+					tickers[p.Stock] = true
+				}
+			}
+		}
+	}
+	if len(tickers) == 0 {
+		return nil
+	}
+	prices := t.fetchPrices(ctx, tickers)
+	now := time.Now()
+	for ticker := range quoted {
+		price, ok := prices[ticker]
+		if !ok {
+			continue
+		}
+		err = socks.PublishQuote(ticker, TypeQuote, Quote{Ticker: ticker, Price: price, At: now})
+		if err != nil {
+			log.Printf("Error publishing quote for %s: %v", ticker, err)
+		}
+	}
+	for portId, positions := range ports {
+		update := revalue(portId, positions, prices, now)
+		err = socks.PublishLiveToPortfolio(ctx, t.store, portId, socks.TopicPositions, TypeLivePositions, func(int) (interface{}, error) {
+			return update, nil
+		})
+		if err != nil {
+			log.Printf("Error publishing live positions of port %d: %v", portId, err)
+		}
+	}
+	return ctx.Err()
+}
+
+// Positions whose value moves with their stock's price
+func live(p wardrobe.Position) bool {
+	return p.Option == nil && p.Stock != "_CASH" && !p.Quantity.IsZero()
+}
+
+// Fetches the current price of every ticker, leaving out any we couldn't get
+func (t *Tape) fetchPrices(ctx context.Context, tickers map[string]bool) map[string]decimal.Decimal {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	prices := make(map[string]decimal.Decimal, len(tickers))
+	sem := make(chan struct{}, maxFetches)
+	for ticker := range tickers {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(ticker string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			stock, err := t.api.GetCurrentPrice(ctx, ticker)
+			if err != nil {
+				log.Printf("Error fetching current price of %s: %v", ticker, err)
+				return
+			}
+			mu.Lock()
+			prices[ticker] = stock.LatestPrice
+			mu.Unlock()
+		}(ticker)
+	}
+	wg.Wait()
+	return prices
+}
+
+func revalue(portId int, positions []wardrobe.Position, prices map[string]decimal.Decimal, now time.Time) LivePositions {
+	update := LivePositions{PortId: portId, Positions: make([]wardrobe.Position, 0, len(positions)), Value: decimal.Zero, At: now}
+	for _, p := range positions {
+		if price, ok := prices[p.Stock]; ok && live(p) {
+			p.Value = p.Quantity.Mul(price)
+		}
+		update.Positions = append(update.Positions, p)
+		update.Value = update.Value.Add(p.Value)
+	}
+	return update
+}
@@ -1,30 +1,49 @@
 package transfers
 
 import (
+	"context"
 	"github.com/bluedresscapital/coattails/pkg/wardrobe"
 )
 
 type TransferAPI interface {
-	GetTransfers() ([]wardrobe.Transfer, error)
+	GetTransfers(ctx context.Context) ([]wardrobe.Transfer, error)
+}
+
+// Same as orders.SyncedOrderAPI, but for transfers
+type SyncedTransferAPI interface {
+	TransferAPI
+	CommitTransferSync(ctx context.Context) error
 }
 
 // Reloads transfers from TransferAPI - If there are changes, it will also
 // return whether it should be updated
-func ReloadTransfers(transfer TransferAPI) (bool, error) {
-	transfers, err := transfer.GetTransfers()
+func ReloadTransfers(ctx context.Context, store wardrobe.TransferStore, transfer TransferAPI) (bool, error) {
+	transfers, err := transfer.GetTransfers(ctx)
 	if err != nil {
 		return false, err
 	}
 	if transfers == nil || len(transfers) == 0 {
-		return false, nil
+		return false, commitSync(ctx, transfer)
 	}
 	var portId int
 	for _, t := range transfers {
 		portId = t.PortId
-		err = wardrobe.InsertIgnoreTransfer(t)
+		err = store.InsertIgnoreTransfer(ctx, t)
 		if err != nil {
 			return false, err
 		}
 	}
-	return wardrobe.HasUncommittedTransfers(portId)
+	err = commitSync(ctx, transfer)
+	if err != nil {
+		return false, err
+	}
+	return store.HasUncommittedTransfers(ctx, portId)
+}
+
+func commitSync(ctx context.Context, transfer TransferAPI) error {
+	synced, ok := transfer.(SyncedTransferAPI)
+	if !ok {
+		return nil
+	}
+	return synced.CommitTransferSync(ctx)
 }
@@ -1,17 +1,134 @@
 package util
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io/ioutil"
 	"net/http"
+	"net/url"
+	"strings"
+	"time"
 )
 
-func MakeGetRequest(bearerTok string, url string) (*http.Response, error) {
-	var bearer = fmt.Sprintf("Bearer %s", bearerTok)
-	req, err := http.NewRequest("GET", url, nil)
+const (
+	DefaultTimeout   = 30 * time.Second
+	DefaultRetries   = 2
+	DefaultRetryWait = 500 * time.Millisecond
+)
+
+type ClientConfig struct {
+	// Every request path is resolved against BaseURL, so pointing it at a local stand-in redirects all traffic
+	BaseURL string
+	Timeout time.Duration
+	// How many times we retry GETs that fail with a network error, 429 or 5xx
+	Retries   int
+	RetryWait time.Duration
+	// Optional, i.e. a mannequin transport replaying recorded fixtures
+	Transport http.RoundTripper
+}
+
+// Client talks to a single broker host. Brokers hand us absolute urls (pagination, instruments) that point
+// at their real host, so those get re-rooted onto BaseURL too.
+type Client struct {
+	baseURL   string
+	http      *http.Client
+	retries   int
+	retryWait time.Duration
+}
+
+func NewClient(cfg ClientConfig) *Client {
+	if cfg.Timeout == 0 {
+		cfg.Timeout = DefaultTimeout
+	}
+	if cfg.RetryWait == 0 {
+		cfg.RetryWait = DefaultRetryWait
+	}
+	return &Client{
+		baseURL:   strings.TrimSuffix(cfg.BaseURL, "/"),
+		http:      &http.Client{Timeout: cfg.Timeout, Transport: cfg.Transport},
+		retries:   cfg.Retries,
+		retryWait: cfg.RetryWait,
+	}
+}
+
+// Returns the url we actually request for path, which is either relative to the base url or an absolute
+// url handed back by the broker
+func (c *Client) URL(path string) (string, error) {
+	if !strings.HasPrefix(path, "http://") && !strings.HasPrefix(path, "https://") {
+		return c.baseURL + path, nil
+	}
+	u, err := url.Parse(path)
+	if err != nil {
+		return "", err
+	}
+	ret := c.baseURL + u.Path
+	if u.RawQuery != "" {
+		ret = fmt.Sprintf("%s?%s", ret, u.RawQuery)
+	}
+	return ret, nil
+}
+
+func (c *Client) Get(ctx context.Context, bearerTok string, path string) (*http.Response, error) {
+	headers := make(map[string]string)
+	if bearerTok != "" {
+		headers["Authorization"] = fmt.Sprintf("Bearer %s", bearerTok)
+	}
+	return c.Do(ctx, "GET", path, nil, headers)
+}
+
+func (c *Client) Post(ctx context.Context, path string, contentType string, body []byte, headers map[string]string) (*http.Response, error) {
+	all := map[string]string{"Content-Type": contentType}
+	for k, v := range headers {
+		all[k] = v
+	}
+	return c.Do(ctx, "POST", path, body, all)
+}
+
+// Sends the request, retrying GETs that fail for transient reasons. We never retry POSTs, since the broker
+// may have already rotated the tokens we sent. Gives up as soon as ctx is done, even mid backoff.
+func (c *Client) Do(ctx context.Context, method string, path string, body []byte, headers map[string]string) (*http.Response, error) {
+	u, err := c.URL(path)
 	if err != nil {
 		return nil, err
 	}
-	req.Header.Add("Authorization", bearer)
-	client := &http.Client{}
-	return client.Do(req)
+	attempts := 1
+	if method == "GET" {
+		attempts += c.retries
+	}
+	var resp *http.Response
+	for i := 0; i < attempts; i++ {
+		if i > 0 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(c.retryWait * time.Duration(1<<uint(i-1))):
+			}
+		}
+		req, err := http.NewRequestWithContext(ctx, method, u, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range headers {
+			req.Header.Set(k, v)
+		}
+		resp, err = c.http.Do(req)
+		if err != nil {
+			if i == attempts-1 || ctx.Err() != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !isRetryable(resp.StatusCode) || i == attempts-1 {
+			return resp, nil
+		}
+		// Drain the body so the connection can be reused
+		ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+	}
+	return resp, nil
+}
+
+func isRetryable(status int) bool {
+	return status == http.StatusTooManyRequests || status >= 500
 }
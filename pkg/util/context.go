@@ -0,0 +1,21 @@
+package util
+
+import (
+	"context"
+	"time"
+)
+
+type detached struct {
+	parent context.Context
+}
+
+func (d detached) Deadline() (time.Time, bool)       { return time.Time{}, false }
+func (d detached) Done() <-chan struct{}             { return nil }
+func (d detached) Err() error                        { return nil }
+func (d detached) Value(key interface{}) interface{} { return d.parent.Value(key) }
+
+// Returns a context with ctx's values that's never cancelled. Meant for writes that must finish once we've
+// started them, i.e. saving a refresh token the broker has already rotated.
+func Detach(ctx context.Context) context.Context {
+	return detached{parent: ctx}
+}
@@ -0,0 +1,129 @@
+package util
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+// Lets NewPublicClient and CheckPublicURL reach private addresses, i.e. a webhook receiver on localhost while
+// developing. Never set in production.
+var AllowPrivateAddresses = false
+
+// Returned when dialing an address NewPublicClient won't connect to
+var ErrPrivateAddress = errors.New("address isn't public")
+
+// Loopback, private, link local (i.e. cloud metadata), shared, multicast and reserved ranges. IPv4 mapped IPv6
+// addresses are matched against the IPv4 ranges.
+var privateNets = parseCIDRs(
+	"0.0.0.0/8",
+	"10.0.0.0/8",
+	"100.64.0.0/10",
+	"127.0.0.0/8",
+	"169.254.0.0/16",
+	"172.16.0.0/12",
+	"192.0.0.0/24",
+	"192.168.0.0/16",
+	"198.18.0.0/15",
+	"224.0.0.0/4",
+	"240.0.0.0/4",
+	"::/128",
+	"::1/128",
+	"64:ff9b::/96",
+	"fc00::/7",
+	"fe80::/10",
+	"ff00::/8",
+)
+
+func parseCIDRs(cidrs ...string) []*net.IPNet {
+	nets := make([]*net.IPNet, 0)
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func isPublicIP(ip net.IP) bool {
+	for _, n := range privateNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns a client for posting to urls users give us (webhooks), which only connects to public addresses. The
+// check happens when dialing, after the host's resolved, so a host can't pass CheckPublicURL and then resolve
+// somewhere else. Redirects aren't followed, the 3xx is returned as is.
+func NewPublicClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   refusePrivate,
+	}
+	return &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
+
+func refusePrivate(network string, address string, c syscall.RawConn) error {
+	if AllowPrivateAddresses {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isPublicIP(ip) {
+		return ErrPrivateAddress
+	}
+	return nil
+}
+
+// Checks rawURL is http or https, and that its host is (or resolves to) only public addresses. Errors read as
+// what's wrong with the url, i.e. "must be http or https".
+func CheckPublicURL(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Hostname() == "" {
+		return errors.New("must be http or https")
+	}
+	if AllowPrivateAddresses {
+		return nil
+	}
+	host := u.Hostname()
+	if ip := net.ParseIP(host); ip != nil {
+		if !isPublicIP(ip) {
+			return fmt.Errorf("must be on a public host, not %s", host)
+		}
+		return nil
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil || len(addrs) == 0 {
+		return fmt.Errorf("host %s doesn't resolve", host)
+	}
+	for _, a := range addrs {
+		if !isPublicIP(a.IP) {
+			return fmt.Errorf("must be on a public host, %s resolves to %s", host, a.IP)
+		}
+	}
+	return nil
+}
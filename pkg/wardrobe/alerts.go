@@ -0,0 +1,175 @@
+package wardrobe
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Returned when an alert rule doesn't exist (or isn't the user's)
+var ErrNoAlertRule = errors.New("no alert rule found")
+
+// A rule the user wants to be notified about whenever it matches, at most once per Cooldown. See
+// alerts.Evaluate* for what each kind matches.
+type AlertRule struct {
+	Id     int    `json:"id"`
+	UserId int    `json:"user_id"`
+	Kind   string `json:"kind"`
+	// Set for price rules
+	Ticker string `json:"ticker,omitempty"`
+	// Set for portfolio rules
+	PortId    int             `json:"port_id,omitempty"`
+	Direction string          `json:"direction"`
+	Threshold decimal.Decimal `json:"threshold"`
+	// Where the alert is delivered besides the user's websockets
+	Channels        []string   `json:"channels"`
+	Email           string     `json:"email,omitempty"`
+	WebhookURL      string     `json:"webhook_url,omitempty"`
+	Cooldown        int        `json:"cooldown_secs"`
+	CreatedAt       time.Time  `json:"created_at"`
+	LastTriggeredAt *time.Time `json:"last_triggered_at"`
+}
+
+func (s *SQL) CreateAlertRule(ctx context.Context, a AlertRule) (int, error) {
+	var id int
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO alert_rules
+			(user_id, kind, ticker, port_id, direction, threshold, channels, email, webhook_url, cooldown_secs, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
+		RETURNING id`,
+		a.UserId, a.Kind, nullableString(a.Ticker), nullableInt(a.PortId), a.Direction, a.Threshold,
+		strings.Join(a.Channels, " "), a.Email, a.WebhookURL, a.Cooldown, a.CreatedAt).Scan(&id)
+	return id, err
+}
+
+const alertRuleColumns = `
+	id, user_id, kind, ticker, port_id, direction, threshold, channels, email, webhook_url, cooldown_secs,
+	created_at, last_triggered_at`
+
+// Newest first
+func (s *SQL) FetchAlertRulesByUserId(ctx context.Context, userId int) ([]AlertRule, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+alertRuleColumns+`
+		FROM alert_rules
+		WHERE user_id=$1
+		ORDER BY id DESC`, userId)
+	if err != nil {
+		return nil, err
+	}
+	return scanAlertRules(rows)
+}
+
+// Every rule of kind, oldest first
+func (s *SQL) FetchAlertRulesByKind(ctx context.Context, kind string) ([]AlertRule, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+alertRuleColumns+`
+		FROM alert_rules
+		WHERE kind=$1
+		ORDER BY id`, kind)
+	if err != nil {
+		return nil, err
+	}
+	return scanAlertRules(rows)
+}
+
+// Every rule on portId, oldest first
+func (s *SQL) FetchAlertRulesByPortId(ctx context.Context, portId int) ([]AlertRule, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+alertRuleColumns+`
+		FROM alert_rules
+		WHERE port_id=$1
+		ORDER BY id`, portId)
+	if err != nil {
+		return nil, err
+	}
+	return scanAlertRules(rows)
+}
+
+// Every ticker a price rule watches, so their prices get reloaded even if nobody holds them
+func (s *SQL) FetchAlertTickers(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT DISTINCT ticker FROM alert_rules WHERE ticker IS NOT NULL`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tickers := make([]string, 0)
+	for rows.Next() {
+		var ticker string
+		err = rows.Scan(&ticker)
+		if err != nil {
+			return nil, err
+		}
+		tickers = append(tickers, ticker)
+	}
+	return tickers, rows.Err()
+}
+
+// Marks the rule as triggered at now, unless it already was within its cooldown. Returns whether it was, so
+// only one of any concurrent evaluations of a rule ever delivers it.
+func (s *SQL) ClaimAlertRule(ctx context.Context, a AlertRule, now time.Time) (bool, error) {
+	cutoff := now.Add(-time.Duration(a.Cooldown) * time.Second)
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE alert_rules SET last_triggered_at=$2
+		WHERE id=$1 AND (last_triggered_at IS NULL OR last_triggered_at <= $3)`, a.Id, now, cutoff)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// Deletes the user's rule. Returns ErrNoAlertRule if they don't have one with id.
+func (s *SQL) DeleteAlertRule(ctx context.Context, userId int, id int) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM alert_rules WHERE id=$1 AND user_id=$2`, id, userId)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoAlertRule
+	}
+	return nil
+}
+
+func scanAlertRules(rows *sql.Rows) ([]AlertRule, error) {
+	defer rows.Close()
+	rules := make([]AlertRule, 0)
+	for rows.Next() {
+		var a AlertRule
+		var ticker sql.NullString
+		var portId sql.NullInt64
+		var channels string
+		var lastTriggeredAt sql.NullTime
+		err := rows.Scan(&a.Id, &a.UserId, &a.Kind, &ticker, &portId, &a.Direction, &a.Threshold, &channels,
+			&a.Email, &a.WebhookURL, &a.Cooldown, &a.CreatedAt, &lastTriggeredAt)
+		if err != nil {
+			return nil, err
+		}
+		a.Ticker = ticker.String
+		a.PortId = int(portId.Int64)
+		a.Channels = strings.Fields(channels)
+		if lastTriggeredAt.Valid {
+			a.LastTriggeredAt = &lastTriggeredAt.Time
+		}
+		rules = append(rules, a)
+	}
+	return rules, rows.Err()
+}
+
+func nullableString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func nullableInt(i int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(i), Valid: i != 0}
+}
@@ -0,0 +1,123 @@
+package wardrobe
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+)
+
+// Returned when an api token doesn't exist (or has been revoked)
+var ErrNoAPIToken = errors.New("no api token found")
+
+// How often an api token's last used time is saved. Saving it on every request would mean a write per
+// request for nothing.
+const apiTokenTouchInterval = time.Minute
+
+// A personal api token. The token itself is never stored, only its hash.
+type APIToken struct {
+	Id         int        `json:"id"`
+	UserId     int        `json:"user_id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+}
+
+func (s *SQL) CreateAPIToken(ctx context.Context, t APIToken, tokenHash []byte) (int, error) {
+	var id int
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO api_tokens (user_id, name, token_hash, scopes, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING id`,
+		t.UserId, t.Name, tokenHash, strings.Join(t.Scopes, " "), t.CreatedAt, nullableTime(t.ExpiresAt)).Scan(&id)
+	return id, err
+}
+
+// Returns the token with hash, whether or not it's expired
+func (s *SQL) FetchAPITokenByHash(ctx context.Context, tokenHash []byte) (*APIToken, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, name, scopes, created_at, expires_at, last_used_at
+		FROM api_tokens
+		WHERE token_hash=$1`, tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := scanAPITokens(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, ErrNoAPIToken
+	}
+	return &tokens[0], nil
+}
+
+// Newest first
+func (s *SQL) FetchAPITokensByUserId(ctx context.Context, userId int) ([]APIToken, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, name, scopes, created_at, expires_at, last_used_at
+		FROM api_tokens
+		WHERE user_id=$1
+		ORDER BY id DESC`, userId)
+	if err != nil {
+		return nil, err
+	}
+	return scanAPITokens(rows)
+}
+
+// Records that the token was used at usedAt, unless it already was recently
+func (s *SQL) TouchAPIToken(ctx context.Context, id int, usedAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE api_tokens SET last_used_at=$2
+		WHERE id=$1 AND (last_used_at IS NULL OR last_used_at < $3)`, id, usedAt, usedAt.Add(-apiTokenTouchInterval))
+	return err
+}
+
+// Revokes the user's token. Returns ErrNoAPIToken if they don't have one with id.
+func (s *SQL) DeleteAPIToken(ctx context.Context, userId int, id int) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM api_tokens WHERE id=$1 AND user_id=$2`, id, userId)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoAPIToken
+	}
+	return nil
+}
+
+func scanAPITokens(rows *sql.Rows) ([]APIToken, error) {
+	defer rows.Close()
+	tokens := make([]APIToken, 0)
+	for rows.Next() {
+		var t APIToken
+		var scopes string
+		var expiresAt, lastUsedAt sql.NullTime
+		err := rows.Scan(&t.Id, &t.UserId, &t.Name, &scopes, &t.CreatedAt, &expiresAt, &lastUsedAt)
+		if err != nil {
+			return nil, err
+		}
+		t.Scopes = strings.Fields(scopes)
+		if expiresAt.Valid {
+			t.ExpiresAt = &expiresAt.Time
+		}
+		if lastUsedAt.Valid {
+			t.LastUsedAt = &lastUsedAt.Time
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+func nullableTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: *t, Valid: true}
+}
@@ -0,0 +1,198 @@
+package wardrobe
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	// Returned when restoring an order or transfer that isn't deleted
+	ErrNotDeleted = errors.New("not deleted")
+	// Returned when upserting an order or transfer that's deleted, which has to be restored first
+	ErrDeleted = errors.New("deleted, restore it first")
+	// Returned when upserting an order or transfer whose uid is already taken in another portfolio
+	ErrOtherPortfolio = errors.New("uid belongs to another portfolio")
+)
+
+// Where a change came from
+type AuditSource string
+
+const (
+	SourceManual AuditSource = "manual"
+	SourceTDA    AuditSource = "tda"
+	SourceRH     AuditSource = "rh"
+	SourceImport AuditSource = "import"
+	// Made with a personal api token rather than a logged in session
+	SourceAPI AuditSource = "api"
+	// Anything that didn't say, i.e. background jobs
+	SourceSystem AuditSource = "system"
+)
+
+type AuditEntity string
+
+const (
+	AuditOrder     AuditEntity = "order"
+	AuditTransfer  AuditEntity = "transfer"
+	AuditPortfolio AuditEntity = "portfolio"
+	// Someone other than the owner being given (or losing) access to a portfolio. The entity id is their user id.
+	AuditMember AuditEntity = "portfolio_member"
+)
+
+type AuditAction string
+
+const (
+	AuditCreate  AuditAction = "create"
+	AuditUpdate  AuditAction = "update"
+	AuditDelete  AuditAction = "delete"
+	AuditRestore AuditAction = "restore"
+)
+
+// One change to an order, transfer or portfolio. Before is null for creates, After is null for deletes.
+// PortId is null once the portfolio's been deleted.
+type AuditEntry struct {
+	Id        int             `json:"id"`
+	UserId    *int            `json:"user_id"`
+	PortId    *int            `json:"port_id"`
+	Entity    AuditEntity     `json:"entity"`
+	EntityId  string          `json:"entity_id"`
+	Action    AuditAction     `json:"action"`
+	Source    AuditSource     `json:"source"`
+	Before    json.RawMessage `json:"before"`
+	After     json.RawMessage `json:"after"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type AuditQuery struct {
+	// Only entries for portfolios this user can see, along with the ones they made to portfolios that have since
+	// been deleted
+	UserId int
+	// Optional, 0 means every one of the user's portfolios
+	PortId int
+	// Optional, only entries older than this id, for paging
+	BeforeId int
+	Limit    int
+}
+
+type AuditStore interface {
+	FetchAuditLog(ctx context.Context, q AuditQuery) ([]AuditEntry, error)
+}
+
+type actorKey struct{}
+
+type actor struct {
+	userId *int
+	source AuditSource
+}
+
+// Attributes every change made with ctx to userId
+func WithActor(ctx context.Context, userId int, source AuditSource) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor{userId: &userId, source: source})
+}
+
+// Overrides where changes made with ctx come from, keeping the user (if any) they're attributed to. Used when a
+// user kicks off a broker reload, so the orders it writes show up as coming from the broker.
+func WithSource(ctx context.Context, source AuditSource) context.Context {
+	a := actorFrom(ctx)
+	a.source = source
+	return context.WithValue(ctx, actorKey{}, a)
+}
+
+func actorFrom(ctx context.Context) actor {
+	if a, ok := ctx.Value(actorKey{}).(actor); ok {
+		return a
+	}
+	return actor{source: SourceSystem}
+}
+
+// Builds the audit entry for a change made with ctx. before and after are marshalled as is, so a nil pointer
+// is stored as null.
+func newAuditEntry(ctx context.Context, entity AuditEntity, entityId string, portId int, action AuditAction, before interface{}, after interface{}) (*AuditEntry, error) {
+	beforeJson, err := json.Marshal(before)
+	if err != nil {
+		return nil, err
+	}
+	afterJson, err := json.Marshal(after)
+	if err != nil {
+		return nil, err
+	}
+	a := actorFrom(ctx)
+	return &AuditEntry{
+		UserId:    a.userId,
+		PortId:    &portId,
+		Entity:    entity,
+		EntityId:  entityId,
+		Action:    action,
+		Source:    a.source,
+		Before:    beforeJson,
+		After:     afterJson,
+		CreatedAt: time.Now(),
+	}, nil
+}
+
+// Appends to the audit log. Should be called with the same transaction as the change it records.
+func appendAudit(ctx context.Context, q dbtx, entity AuditEntity, entityId string, portId int, action AuditAction, before interface{}, after interface{}) error {
+	e, err := newAuditEntry(ctx, entity, entityId, portId, action, before, after)
+	if err != nil {
+		return err
+	}
+	_, err = q.ExecContext(ctx, `
+		INSERT INTO audit_log (user_id, port_id, entity, entity_id, action, source, before, after, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		e.UserId, e.PortId, e.Entity, e.EntityId, e.Action, e.Source, jsonColumn(e.Before), jsonColumn(e.After), e.CreatedAt)
+	return err
+}
+
+// Stores a json null as a sql NULL
+func jsonColumn(j json.RawMessage) sql.NullString {
+	return sql.NullString{String: string(j), Valid: string(j) != "null"}
+}
+
+// Newest first
+func (s *SQL) FetchAuditLog(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	if q.Limit <= 0 {
+		return nil, fmt.Errorf("invalid audit log limit %d", q.Limit)
+	}
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT a.id, a.user_id, a.port_id, a.entity, a.entity_id, a.action, a.source, a.before, a.after, a.created_at
+		FROM audit_log a
+		WHERE (a.port_id IN (%s) OR (a.port_id IS NULL AND a.user_id=$1)) AND ($2=0 OR a.port_id=$2) AND ($3=0 OR a.id<$3)
+		ORDER BY a.id DESC
+		LIMIT $4`, accessiblePortIdsQuery), q.UserId, q.PortId, q.BeforeId, q.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	entries := make([]AuditEntry, 0)
+	for rows.Next() {
+		var e AuditEntry
+		var userId, portId sql.NullInt64
+		var before, after []byte
+		err = rows.Scan(&e.Id, &userId, &portId, &e.Entity, &e.EntityId, &e.Action, &e.Source, &before, &after, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if userId.Valid {
+			id := int(userId.Int64)
+			e.UserId = &id
+		}
+		if portId.Valid {
+			id := int(portId.Int64)
+			e.PortId = &id
+		}
+		e.Before = nullableJson(before)
+		e.After = nullableJson(after)
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+func nullableJson(b []byte) json.RawMessage {
+	if b == nil {
+		return json.RawMessage("null")
+	}
+	return b
+}
@@ -1,45 +1,55 @@
 package wardrobe
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
-)
 
-var (
-	SessionTokenTtl = time.Duration(24*7) * time.Hour
+	"github.com/go-redis/redis/v7"
 )
 
-type AuthToken struct {
-	UserId    int
-	Token     string
-	TtlSec    int
-	CreatedAt time.Time
-}
+// Returned by FetchUserPassword when there's no user with the username
+var ErrNoUser = errors.New("no such user")
 
-// Finds user row and returns its id
-func FetchUser(username string, password [32]byte) (*int, error) {
-	rows, err := db.Query("SELECT id FROM users WHERE username=$1 and password=$2", username, password[:])
-	if err != nil {
-		return nil, err
+// Returns the user's id and stored password hash (see secrets.VerifyPassword). Matching the password is
+// left to the caller, since a salted hash can't be looked up by.
+func FetchUserPassword(ctx context.Context, username string) (*int, []byte, error) {
+	id := new(int)
+	var hash []byte
+	err := db.QueryRowContext(ctx, "SELECT id, password FROM users WHERE username=$1", username).Scan(id, &hash)
+	if err == sql.ErrNoRows {
+		return nil, nil, ErrNoUser
 	}
-	defer rows.Close()
-	if !rows.Next() {
-		return nil, fmt.Errorf("invalid credentials for username %s", username)
+	if err != nil {
+		return nil, nil, err
 	}
+	return id, hash, nil
+}
+
+// Returns ErrNoUser if there's no user with the username
+func FetchUserIdByUsername(ctx context.Context, username string) (*int, error) {
 	id := new(int)
-	err = rows.Scan(id)
+	err := db.QueryRowContext(ctx, "SELECT id FROM users WHERE username=$1", username).Scan(id)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoUser
+	}
 	if err != nil {
 		return nil, err
 	}
-	if rows.Next() {
-		return nil, fmt.Errorf("multiple users returned with username %s", username)
-	}
 	return id, nil
 }
 
-func FetchUserById(id int) (*string, error) {
-	rows, err := db.Query("SELECT username FROM users WHERE id=$1", id)
+// Replaces the user's stored password hash, i.e. when rehashing a legacy one on login
+func UpdateUserPassword(ctx context.Context, userId int, hash []byte) error {
+	_, err := db.ExecContext(ctx, "UPDATE users SET password=$1 WHERE id=$2", hash, userId)
+	return err
+}
+
+func FetchUserById(ctx context.Context, id int) (*string, error) {
+	rows, err := db.QueryContext(ctx, "SELECT username FROM users WHERE id=$1", id)
 	if err != nil {
 		return nil, err
 	}
@@ -58,55 +68,151 @@ func FetchUserById(id int) (*string, error) {
 	return username, nil
 }
 
-func CreateUser(username string, password [32]byte) error {
-	_, err := db.Exec("INSERT INTO users (username, password) VALUES ($1, $2)", username, password[:])
+// Creates the user with an already hashed password, from secrets.HashPassword
+func CreateUser(ctx context.Context, username string, hash []byte) error {
+	_, err := db.ExecContext(ctx, "INSERT INTO users (username, password) VALUES ($1, $2)", username, hash)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-// TODO DEPRECATE THIS ONCE U GET TO SESSION STUFF
-func FetchAuthToken(sessionToken string) (*string, error) {
-	res, err := cache.Get(sessionToken).Result()
+func redisSessionKey(id string) string {
+	return "session:" + id
+}
+
+func redisUserSessionsKey(userId int) string {
+	return fmt.Sprintf("user_sessions:%d", userId)
+}
+
+func (r *Redis) CreateSession(ctx context.Context, s Session) error {
+	c := r.client.WithContext(ctx)
+	_, err := c.TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.HSet(redisSessionKey(s.Id), redisSessionFields(s))
+		pipe.ExpireAt(redisSessionKey(s.Id), s.ExpiresAt)
+		// Lets us find the user's sessions. Ids whose session has expired are pruned as we come across them.
+		pipe.SAdd(redisUserSessionsKey(s.UserId), s.Id)
+		pipe.Expire(redisUserSessionsKey(s.UserId), SessionMaxAge)
+		return nil
+	})
+	return err
+}
+
+func (r *Redis) FetchSession(ctx context.Context, id string) (*Session, error) {
+	fields, err := r.client.WithContext(ctx).HGetAll(redisSessionKey(id)).Result()
 	if err != nil {
 		return nil, err
 	}
-	tok := new(string)
-	// Looks like this is the "correct" way to convert interface to string:
-	// https://yourbasic.org/golang/interface-to-string/
-	*tok = fmt.Sprintf("%s", res)
-	return tok, nil
+	if len(fields) == 0 {
+		return nil, ErrNoSession
+	}
+	return parseRedisSession(id, fields)
 }
 
-// Given cookie, verifies it by fetching in cache
-func VerifyCookie(cookie string) (*int, error) {
-	// Assume we map cookie to userId
-	userId, err := cache.Get(cookie).Result()
+// Only updates the session if it still exists, so a request that was in flight when the session was revoked
+// can't bring it back
+var touchSessionScript = redis.NewScript(`
+if redis.call("EXISTS", KEYS[1]) == 0 then
+	return 0
+end
+redis.call("HSET", KEYS[1], "last_seen_at", ARGV[1], "expires_at", ARGV[2], "ip", ARGV[3], "user_agent", ARGV[4])
+redis.call("PEXPIREAT", KEYS[1], ARGV[5])
+return 1
+`)
+
+func (r *Redis) TouchSession(ctx context.Context, s Session) error {
+	return touchSessionScript.Run(r.client.WithContext(ctx), []string{redisSessionKey(s.Id)},
+		formatRedisTime(s.LastSeenAt), formatRedisTime(s.ExpiresAt), s.IP, s.UserAgent,
+		s.ExpiresAt.UnixNano()/int64(time.Millisecond)).Err()
+}
+
+func (r *Redis) FetchSessionsByUserId(ctx context.Context, userId int) ([]Session, error) {
+	ids, err := r.client.WithContext(ctx).SMembers(redisUserSessionsKey(userId)).Result()
 	if err != nil {
 		return nil, err
 	}
-	var i = new(int)
-	*i, err = strconv.Atoi(userId)
-	if err != nil {
-		return nil, err
+	ret := make([]Session, 0)
+	for _, id := range ids {
+		s, err := r.FetchSession(ctx, id)
+		if errors.Is(err, ErrNoSession) {
+			_ = r.client.WithContext(ctx).SRem(redisUserSessionsKey(userId), id).Err()
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, *s)
 	}
-	return i, nil
+	sortSessions(ret)
+	return ret, nil
 }
 
-// Sets an expiring auth token into cache
-func SetExpiringAuthToken(token string, userId *int) error {
-	err := cache.SetNX(token, *userId, SessionTokenTtl).Err()
+func (r *Redis) DeleteSession(ctx context.Context, userId int, id string) error {
+	s, err := r.FetchSession(ctx, id)
+	if errors.Is(err, ErrNoSession) {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
-	return nil
+	// Never lets a user revoke someone else's session
+	if s.UserId != userId {
+		return nil
+	}
+	_, err = r.client.WithContext(ctx).TxPipelined(func(pipe redis.Pipeliner) error {
+		pipe.Del(redisSessionKey(id))
+		pipe.SRem(redisUserSessionsKey(userId), id)
+		return nil
+	})
+	return err
 }
 
-func ClearAuthToken(sessionToken string) error {
-	err := cache.Del(sessionToken).Err()
+func (r *Redis) DeleteSessionsByUserId(ctx context.Context, userId int, keepId string) error {
+	ids, err := r.client.WithContext(ctx).SMembers(redisUserSessionsKey(userId)).Result()
 	if err != nil {
 		return err
 	}
-	return nil
+	_, err = r.client.WithContext(ctx).TxPipelined(func(pipe redis.Pipeliner) error {
+		for _, id := range ids {
+			if id == keepId {
+				continue
+			}
+			pipe.Del(redisSessionKey(id))
+			pipe.SRem(redisUserSessionsKey(userId), id)
+		}
+		return nil
+	})
+	return err
+}
+
+func redisSessionFields(s Session) map[string]interface{} {
+	return map[string]interface{}{
+		"user_id":      s.UserId,
+		"created_at":   formatRedisTime(s.CreatedAt),
+		"last_seen_at": formatRedisTime(s.LastSeenAt),
+		"expires_at":   formatRedisTime(s.ExpiresAt),
+		"ip":           s.IP,
+		"user_agent":   s.UserAgent,
+		"two_factor":   strconv.FormatBool(s.TwoFactor),
+	}
+}
+
+func parseRedisSession(id string, fields map[string]string) (*Session, error) {
+	s := Session{Id: id, IP: fields["ip"], UserAgent: fields["user_agent"], TwoFactor: fields["two_factor"] == "true"}
+	var err error
+	s.UserId, err = strconv.Atoi(fields["user_id"])
+	if err != nil {
+		return nil, fmt.Errorf("invalid session %s: %v", id, err)
+	}
+	for field, t := range map[string]*time.Time{"created_at": &s.CreatedAt, "last_seen_at": &s.LastSeenAt, "expires_at": &s.ExpiresAt} {
+		*t, err = time.Parse(time.RFC3339Nano, fields[field])
+		if err != nil {
+			return nil, fmt.Errorf("invalid session %s: %v", id, err)
+		}
+	}
+	return &s, nil
+}
+
+func formatRedisTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
 }
@@ -1,16 +1,44 @@
 package wardrobe
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"time"
 
 	"github.com/go-redis/redis/v7"
 )
 
 var (
-	cache *redis.Client
+	cache  *redis.Client
+	pubsub pubSub
+	kv     keyValues
 )
 
+// Returned when a cached key doesn't exist (or has expired)
+var ErrCacheMiss = errors.New("cache miss")
+
+// How many messages a subscriber can fall behind by before the in process pub/sub starts dropping them
+const localSubBuffer = 100
+
+type Message struct {
+	Channel string
+	Payload string
+}
+
+// A subscription to a pub/sub channel, from Sub. Messages is closed once the subscription is.
+type Subscription interface {
+	Messages() <-chan Message
+	Close() error
+}
+
+type pubSub interface {
+	publish(channel string, message []byte) error
+	subscribe(channel string) Subscription
+}
+
 func InitCache(host string) {
 	// Initialize the redis connection to a redis instance
 	cache = redis.NewClient(&redis.Options{
@@ -25,16 +53,229 @@ func InitCache(host string) {
 	if err != nil {
 		log.Fatal("Error in connecting to redis server")
 	}
+	sessions = NewRedis(cache)
+	pubsub = redisPubSub{client: cache}
+	kv = redisKeyValues{client: cache}
+	streams = redisStreams{client: cache}
+	watched = redisWatchedTopics{client: cache}
+}
+
+// Keeps sessions, pub/sub and event streams in process instead of in redis, so a single coattails binary can
+// run without one. Only works if there's exactly one process serving users, since nothing is shared between
+// processes.
+func InitLocalCache() {
+	sessions = NewLocalSessions()
+	pubsub = &localPubSub{subs: make(map[string]map[*localSub]bool)}
+	kv = &localKeyValues{values: make(map[string]localValue)}
+	streams = newLocalStreams()
+	watched = &localWatchedTopics{topics: make(map[string]time.Time)}
+}
+
+// Cached values, i.e. instrument lookups and pending robinhood links
+type keyValues interface {
+	// Returns ErrCacheMiss if key doesn't exist
+	get(ctx context.Context, key string) ([]byte, error)
+	// A ttl of 0 keeps the value forever
+	set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	exists(ctx context.Context, key string) (bool, error)
+	del(ctx context.Context, keys ...string) error
+}
+
+type redisKeyValues struct {
+	client *redis.Client
+}
+
+func (r redisKeyValues) get(ctx context.Context, key string) ([]byte, error) {
+	b, err := r.client.WithContext(ctx).Get(key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrCacheMiss
+	}
+	return b, err
+}
+
+func (r redisKeyValues) set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return r.client.WithContext(ctx).Set(key, value, ttl).Err()
+}
+
+func (r redisKeyValues) exists(ctx context.Context, key string) (bool, error) {
+	n, err := r.client.WithContext(ctx).Exists(key).Result()
+	return n > 0, err
+}
+
+func (r redisKeyValues) del(ctx context.Context, keys ...string) error {
+	return r.client.WithContext(ctx).Del(keys...).Err()
+}
+
+type localKeyValues struct {
+	mu     sync.Mutex
+	values map[string]localValue
+}
+
+type localValue struct {
+	value []byte
+	// Zero if it never expires
+	expiresAt time.Time
+}
+
+// Callers must hold mu
+func (l *localKeyValues) lookup(key string) ([]byte, bool) {
+	v, found := l.values[key]
+	if !found {
+		return nil, false
+	}
+	if !v.expiresAt.IsZero() && time.Now().After(v.expiresAt) {
+		delete(l.values, key)
+		return nil, false
+	}
+	return v.value, true
+}
+
+func (l *localKeyValues) get(ctx context.Context, key string) ([]byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	value, found := l.lookup(key)
+	if !found {
+		return nil, ErrCacheMiss
+	}
+	return value, nil
+}
+
+func (l *localKeyValues) set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	v := localValue{value: value}
+	if ttl > 0 {
+		v.expiresAt = time.Now().Add(ttl)
+	}
+	l.values[key] = v
+	return nil
+}
+
+func (l *localKeyValues) exists(ctx context.Context, key string) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, found := l.lookup(key)
+	return found, nil
+}
+
+func (l *localKeyValues) del(ctx context.Context, keys ...string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, key := range keys {
+		delete(l.values, key)
+	}
+	return nil
 }
 
 func Publish(channel string, message []byte) error {
-	return cache.Publish(channel, message).Err()
+	return pubsub.publish(channel, message)
+}
+
+func (l *Live) Publish(channel string, message []byte) error {
+	return Publish(channel, message)
+}
+
+func Sub(channel string) Subscription {
+	return pubsub.subscribe(channel)
+}
+
+func Unsub(sub Subscription) error {
+	return sub.Close()
+}
+
+type redisPubSub struct {
+	client *redis.Client
+}
+
+func (r redisPubSub) publish(channel string, message []byte) error {
+	return r.client.Publish(channel, message).Err()
+}
+
+func (r redisPubSub) subscribe(channel string) Subscription {
+	sub := &redisSub{sub: r.client.Subscribe(channel), messages: make(chan Message), closed: make(chan struct{})}
+	go func() {
+		defer close(sub.messages)
+		// Ends once sub is closed
+		for msg := range sub.sub.Channel() {
+			select {
+			case sub.messages <- Message{Channel: msg.Channel, Payload: msg.Payload}:
+			case <-sub.closed:
+				return
+			}
+		}
+	}()
+	return sub
+}
+
+type redisSub struct {
+	sub       *redis.PubSub
+	messages  chan Message
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+func (r *redisSub) Messages() <-chan Message {
+	return r.messages
+}
+
+func (r *redisSub) Close() error {
+	var err error
+	r.closeOnce.Do(func() {
+		close(r.closed)
+		err = r.sub.Close()
+	})
+	return err
+}
+
+type localPubSub struct {
+	mu   sync.Mutex
+	subs map[string]map[*localSub]bool
+}
+
+func (l *localPubSub) publish(channel string, message []byte) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for sub := range l.subs[channel] {
+		select {
+		case sub.messages <- Message{Channel: channel, Payload: string(message)}:
+		default:
+			log.Printf("Dropping message on channel %s, subscriber is too far behind", channel)
+		}
+	}
+	return nil
+}
+
+func (l *localPubSub) subscribe(channel string) Subscription {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	sub := &localSub{pubsub: l, channel: channel, messages: make(chan Message, localSubBuffer)}
+	if l.subs[channel] == nil {
+		l.subs[channel] = make(map[*localSub]bool)
+	}
+	l.subs[channel][sub] = true
+	return sub
 }
 
-func Sub(channel string) *redis.PubSub {
-	return cache.Subscribe(channel)
+type localSub struct {
+	pubsub   *localPubSub
+	channel  string
+	messages chan Message
 }
 
-func Unsub(sub *redis.PubSub, channel string) error {
-	return sub.Unsubscribe(channel)
+func (l *localSub) Messages() <-chan Message {
+	return l.messages
+}
+
+func (l *localSub) Close() error {
+	l.pubsub.mu.Lock()
+	defer l.pubsub.mu.Unlock()
+	if !l.pubsub.subs[l.channel][l] {
+		return nil
+	}
+	delete(l.pubsub.subs[l.channel], l)
+	if len(l.pubsub.subs[l.channel]) == 0 {
+		delete(l.pubsub.subs, l.channel)
+	}
+	close(l.messages)
+	return nil
 }
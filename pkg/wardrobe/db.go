@@ -9,10 +9,17 @@ import (
 )
 
 var db *sql.DB
-var ctx context.Context
-var conn *sql.Conn
 
+// Connects to the db, and panics if its schema is behind what this binary expects
 func InitDB(psqlInfo string) {
+	ConnectDB(psqlInfo)
+	if err := checkSchemaVersion(context.Background()); err != nil {
+		log.Panic(err)
+	}
+}
+
+// Same as InitDB, but skips the schema check. Only meant for running migrations.
+func ConnectDB(psqlInfo string) {
 	var err error
 	db, err = sql.Open("postgres", psqlInfo)
 	if err != nil {
@@ -22,6 +29,7 @@ func InitDB(psqlInfo string) {
 	if err = db.Ping(); err != nil {
 		log.Panic(err)
 	}
+	dialect = DialectPostgres
 }
 
 func CloseDB() error {
@@ -0,0 +1,455 @@
+package wardrobe
+
+import (
+	"context"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Package level versions of the store methods, all backed by the Default store. Business packages should take
+// a store instead of using these.
+
+func FetchOrdersByUserId(ctx context.Context, userId int) ([]Order, error) {
+	return Default().FetchOrdersByUserId(ctx, userId)
+}
+
+func FetchOrdersByPortfolioId(ctx context.Context, portId int) ([]Order, error) {
+	return Default().FetchOrdersByPortfolioId(ctx, portId)
+}
+
+func FetchZeroPriceOrdersByPortfolioId(ctx context.Context, portId int) ([]Order, error) {
+	return Default().FetchZeroPriceOrdersByPortfolioId(ctx, portId)
+}
+
+func InsertIgnoreOrder(ctx context.Context, o Order) error {
+	return Default().InsertIgnoreOrder(ctx, o)
+}
+
+func UpsertOrder(ctx context.Context, o Order) error {
+	return Default().UpsertOrder(ctx, o)
+}
+
+func DeleteOrder(ctx context.Context, uid string, portId int) error {
+	return Default().DeleteOrder(ctx, uid, portId)
+}
+
+func RestoreOrder(ctx context.Context, uid string, portId int) error {
+	return Default().RestoreOrder(ctx, uid, portId)
+}
+
+func SetOrdersCommitted(ctx context.Context, portId int) error {
+	return Default().SetOrdersCommitted(ctx, portId)
+}
+
+func HasUncommittedOrders(ctx context.Context, portId int) (bool, error) {
+	return Default().HasUncommittedOrders(ctx, portId)
+}
+
+func InsertIgnoreTransfer(ctx context.Context, t Transfer) error {
+	return Default().InsertIgnoreTransfer(ctx, t)
+}
+
+func UpsertTransfer(ctx context.Context, t Transfer) error {
+	return Default().UpsertTransfer(ctx, t)
+}
+
+func FetchTransfersbyUserId(ctx context.Context, userId int) ([]Transfer, error) {
+	return Default().FetchTransfersbyUserId(ctx, userId)
+}
+
+func FetchTransfersByPortfolioId(ctx context.Context, portId int) ([]Transfer, error) {
+	return Default().FetchTransfersByPortfolioId(ctx, portId)
+}
+
+func DeleteTransfer(ctx context.Context, uid string, portId int) error {
+	return Default().DeleteTransfer(ctx, uid, portId)
+}
+
+func RestoreTransfer(ctx context.Context, uid string, portId int) error {
+	return Default().RestoreTransfer(ctx, uid, portId)
+}
+
+func SetTransfersCommitted(ctx context.Context, portId int) error {
+	return Default().SetTransfersCommitted(ctx, portId)
+}
+
+func HasUncommittedTransfers(ctx context.Context, portId int) (bool, error) {
+	return Default().HasUncommittedTransfers(ctx, portId)
+}
+
+func UpsertOption(ctx context.Context, o Option) error {
+	return Default().UpsertOption(ctx, o)
+}
+
+func FetchOption(ctx context.Context, ticker string) (*Option, error) {
+	return Default().FetchOption(ctx, ticker)
+}
+
+func FetchOptionsByPortfolioId(ctx context.Context, portId int) (map[string]Option, error) {
+	return Default().FetchOptionsByPortfolioId(ctx, portId)
+}
+
+func FetchPositions(ctx context.Context, userId int) ([]Position, error) {
+	return Default().FetchPositions(ctx, userId)
+}
+
+func FetchPortfolioPositions(ctx context.Context, portId int) ([]Position, error) {
+	return Default().FetchPortfolioPositions(ctx, portId)
+}
+
+func InsertPosition(ctx context.Context, p Position) error {
+	return Default().InsertPosition(ctx, p)
+}
+
+func DeletePositions(ctx context.Context, portId int) error {
+	return Default().DeletePositions(ctx, portId)
+}
+
+func FetchNonZeroQuantityPositions(ctx context.Context) ([]string, error) {
+	return Default().FetchNonZeroQuantityPositions(ctx)
+}
+
+func CreatePortfolio(ctx context.Context, userId int, name string, portType string) error {
+	return Default().CreatePortfolio(ctx, userId, name, portType)
+}
+
+func FetchPortfolioById(ctx context.Context, id int) (*Portfolio, error) {
+	return Default().FetchPortfolioById(ctx, id)
+}
+
+func FetchPortfolioByTDAccountId(ctx context.Context, tdAccountId int) (*Portfolio, error) {
+	return Default().FetchPortfolioByTDAccountId(ctx, tdAccountId)
+}
+
+func FetchPortfolioByRHAccountId(ctx context.Context, rhAccountId int) (*Portfolio, error) {
+	return Default().FetchPortfolioByRHAccountId(ctx, rhAccountId)
+}
+
+func FetchPortfoliosByUserId(ctx context.Context, userId int) ([]Portfolio, error) {
+	return Default().FetchPortfoliosByUserId(ctx, userId)
+}
+
+func BulkUpsertPortfolioValuesByPortId(ctx context.Context, pvs []PortValue, portId int) error {
+	return Default().BulkUpsertPortfolioValuesByPortId(ctx, pvs, portId)
+}
+
+func UpsertPortfolioValue(ctx context.Context, pv PortValue) error {
+	return Default().UpsertPortfolioValue(ctx, pv)
+}
+
+func FetchPortfolioValuesByPortId(ctx context.Context, portId int) ([]PortValue, error) {
+	return Default().FetchPortfolioValuesByPortId(ctx, portId)
+}
+
+func FetchPortfolioValueOnDay(ctx context.Context, portId int, date time.Time) (*PortValue, error) {
+	return Default().FetchPortfolioValueOnDay(ctx, portId, date)
+}
+
+func FetchAllPortfolioIds(ctx context.Context) ([]int, error) {
+	return Default().FetchAllPortfolioIds(ctx)
+}
+
+func InsertDailyPortValue(ctx context.Context, dpv DailyPortVal) error {
+	return Default().InsertDailyPortValue(ctx, dpv)
+}
+
+func FetchDailyPortValuesByPortfolioId(ctx context.Context, portId int) ([]DailyPortVal, error) {
+	return Default().FetchDailyPortValuesByPortfolioId(ctx, portId)
+}
+
+func DeletePrevDailyPortValues(ctx context.Context) error {
+	return Default().DeletePrevDailyPortValues(ctx)
+}
+
+func FetchPortfolioRole(ctx context.Context, portId int, userId int) (PortfolioRole, error) {
+	return Default().FetchPortfolioRole(ctx, portId, userId)
+}
+
+func FetchPortfolioUserIds(ctx context.Context, portId int) ([]int, error) {
+	return Default().FetchPortfolioUserIds(ctx, portId)
+}
+
+func FetchPortfolioMembers(ctx context.Context, portId int) ([]PortfolioMember, error) {
+	return Default().FetchPortfolioMembers(ctx, portId)
+}
+
+func UpsertPortfolioMember(ctx context.Context, m PortfolioMember) error {
+	return Default().UpsertPortfolioMember(ctx, m)
+}
+
+func DeletePortfolioMember(ctx context.Context, portId int, userId int) error {
+	return Default().DeletePortfolioMember(ctx, portId, userId)
+}
+
+func CreateHousehold(ctx context.Context, name string, ownerId int) (int, error) {
+	return Default().CreateHousehold(ctx, name, ownerId)
+}
+
+func FetchHouseholdsByUserId(ctx context.Context, userId int) ([]Household, error) {
+	return Default().FetchHouseholdsByUserId(ctx, userId)
+}
+
+func FetchHouseholdUser(ctx context.Context, householdId int, userId int) (*HouseholdUser, error) {
+	return Default().FetchHouseholdUser(ctx, householdId, userId)
+}
+
+func InviteHouseholdMember(ctx context.Context, householdId int, userId int) error {
+	return Default().InviteHouseholdMember(ctx, householdId, userId)
+}
+
+func AcceptHouseholdInvite(ctx context.Context, householdId int, userId int) error {
+	return Default().AcceptHouseholdInvite(ctx, householdId, userId)
+}
+
+func DeleteHouseholdMember(ctx context.Context, householdId int, userId int) error {
+	return Default().DeleteHouseholdMember(ctx, householdId, userId)
+}
+
+func DeleteHousehold(ctx context.Context, householdId int) error {
+	return Default().DeleteHousehold(ctx, householdId)
+}
+
+func UpsertStockQuotePrice(ctx context.Context, ticker string, date time.Time, price decimal.Decimal) error {
+	return Default().UpsertStockQuotePrice(ctx, ticker, date, price)
+}
+
+func BatchUpsertStockQuotes(ctx context.Context, quotes []StockQuote) error {
+	return Default().BatchUpsertStockQuotes(ctx, quotes)
+}
+
+func FetchStockQuoteCount(ctx context.Context, ticker string, start time.Time, end time.Time) (*int, error) {
+	return Default().FetchStockQuoteCount(ctx, ticker, start, end)
+}
+
+func FetchStockQuotes(ctx context.Context, ticker string, start time.Time, end time.Time) ([]StockQuote, error) {
+	return Default().FetchStockQuotes(ctx, ticker, start, end)
+}
+
+func UpsertStock(ctx context.Context, ticker string) error {
+	return defaultSQL().UpsertStock(ctx, ticker)
+}
+
+func FetchStockIdFromTicker(ctx context.Context, ticker string) (*int, error) {
+	return defaultSQL().FetchStockIdFromTicker(ctx, ticker)
+}
+
+func CreateSession(ctx context.Context, s Session) error {
+	return Default().CreateSession(ctx, s)
+}
+
+func FetchSession(ctx context.Context, id string) (*Session, error) {
+	return Default().FetchSession(ctx, id)
+}
+
+func TouchSession(ctx context.Context, s Session) error {
+	return Default().TouchSession(ctx, s)
+}
+
+func FetchSessionsByUserId(ctx context.Context, userId int) ([]Session, error) {
+	return Default().FetchSessionsByUserId(ctx, userId)
+}
+
+func DeleteSession(ctx context.Context, userId int, id string) error {
+	return Default().DeleteSession(ctx, userId, id)
+}
+
+func DeleteSessionsByUserId(ctx context.Context, userId int, keepId string) error {
+	return Default().DeleteSessionsByUserId(ctx, userId, keepId)
+}
+
+func FetchAuditLog(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	return Default().FetchAuditLog(ctx, q)
+}
+
+func WithTx(ctx context.Context, fn func(tx Store) error) error {
+	return Default().WithTx(ctx, fn)
+}
+
+func FetchWebhookEndpointsByUserId(ctx context.Context, userId int) ([]WebhookEndpoint, error) {
+	return Default().FetchWebhookEndpointsByUserId(ctx, userId)
+}
+
+func InsertWebhookDelivery(ctx context.Context, d WebhookDelivery) (int, error) {
+	return Default().InsertWebhookDelivery(ctx, d)
+}
+
+func CreateWebhookEndpoint(ctx context.Context, e WebhookEndpoint) (int, error) {
+	return Default().CreateWebhookEndpoint(ctx, e)
+}
+
+func DeleteWebhookEndpoint(ctx context.Context, userId int, id int) error {
+	return Default().DeleteWebhookEndpoint(ctx, userId, id)
+}
+
+func FetchWebhookDeliveries(ctx context.Context, userId int, endpointId int, limit int) ([]WebhookDelivery, error) {
+	return Default().FetchWebhookDeliveries(ctx, userId, endpointId, limit)
+}
+
+func FetchWebhookDelivery(ctx context.Context, userId int, id int) (*WebhookDelivery, error) {
+	return Default().FetchWebhookDelivery(ctx, userId, id)
+}
+
+func FetchDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]DueWebhookDelivery, error) {
+	return Default().FetchDueWebhookDeliveries(ctx, now, limit)
+}
+
+func ClaimWebhookDelivery(ctx context.Context, id int, now time.Time, leaseUntil time.Time) (bool, error) {
+	return Default().ClaimWebhookDelivery(ctx, id, now, leaseUntil)
+}
+
+func RecordWebhookAttempt(ctx context.Context, d WebhookDelivery) error {
+	return Default().RecordWebhookAttempt(ctx, d)
+}
+
+func DeleteWebhookDeliveriesBefore(ctx context.Context, cutoff time.Time) error {
+	return Default().DeleteWebhookDeliveriesBefore(ctx, cutoff)
+}
+
+func FetchUserTOTP(ctx context.Context, userId int) (*UserTOTP, error) {
+	return Default().FetchUserTOTP(ctx, userId)
+}
+
+func HasTOTPEnabled(ctx context.Context, userId int) (bool, error) {
+	return Default().HasTOTPEnabled(ctx, userId)
+}
+
+func UpsertPendingTOTP(ctx context.Context, userId int, secret string) error {
+	return Default().UpsertPendingTOTP(ctx, userId, secret)
+}
+
+func EnableTOTP(ctx context.Context, userId int, step int64, recoveryCodeHashes [][]byte) error {
+	return Default().EnableTOTP(ctx, userId, step, recoveryCodeHashes)
+}
+
+func UseTOTPStep(ctx context.Context, userId int, step int64) (bool, error) {
+	return Default().UseTOTPStep(ctx, userId, step)
+}
+
+func StartTOTPAttempt(ctx context.Context, userId int, now time.Time, windowStart time.Time, maxAttempts int, lockUntil time.Time) (bool, error) {
+	return Default().StartTOTPAttempt(ctx, userId, now, windowStart, maxAttempts, lockUntil)
+}
+
+func ResetTOTPAttempts(ctx context.Context, userId int) error {
+	return Default().ResetTOTPAttempts(ctx, userId)
+}
+
+func DeleteTOTP(ctx context.Context, userId int) error {
+	return Default().DeleteTOTP(ctx, userId)
+}
+
+func ReplaceRecoveryCodes(ctx context.Context, userId int, codeHashes [][]byte) error {
+	return Default().ReplaceRecoveryCodes(ctx, userId, codeHashes)
+}
+
+func UseRecoveryCode(ctx context.Context, userId int, codeHash []byte) (bool, error) {
+	return Default().UseRecoveryCode(ctx, userId, codeHash)
+}
+
+func CountUnusedRecoveryCodes(ctx context.Context, userId int) (int, error) {
+	return Default().CountUnusedRecoveryCodes(ctx, userId)
+}
+
+func CreateAPIToken(ctx context.Context, t APIToken, tokenHash []byte) (int, error) {
+	return Default().CreateAPIToken(ctx, t, tokenHash)
+}
+
+func FetchAPITokenByHash(ctx context.Context, tokenHash []byte) (*APIToken, error) {
+	return Default().FetchAPITokenByHash(ctx, tokenHash)
+}
+
+func FetchAPITokensByUserId(ctx context.Context, userId int) ([]APIToken, error) {
+	return Default().FetchAPITokensByUserId(ctx, userId)
+}
+
+func TouchAPIToken(ctx context.Context, id int, usedAt time.Time) error {
+	return Default().TouchAPIToken(ctx, id, usedAt)
+}
+
+func DeleteAPIToken(ctx context.Context, userId int, id int) error {
+	return Default().DeleteAPIToken(ctx, userId, id)
+}
+
+func MarkLinkHealthy(ctx context.Context, broker Broker, accountId int) error {
+	return Default().MarkLinkHealthy(ctx, broker, accountId)
+}
+
+func MarkLinkBroken(ctx context.Context, broker Broker, accountId int, linkErr error) (bool, error) {
+	return Default().MarkLinkBroken(ctx, broker, accountId, linkErr)
+}
+
+func RotateDataKeys(ctx context.Context, dryRun bool) ([]RotatedColumn, error) {
+	return Default().RotateDataKeys(ctx, dryRun)
+}
+
+func UpsertEmailCode(ctx context.Context, userId int, email string, codeHash []byte, expiresAt time.Time) error {
+	return Default().UpsertEmailCode(ctx, userId, email, codeHash, expiresAt)
+}
+
+func VerifyEmail(ctx context.Context, userId int, email string, codeHash []byte, now time.Time) (bool, error) {
+	return Default().VerifyEmail(ctx, userId, email, codeHash, now)
+}
+
+func FetchUserEmails(ctx context.Context, userId int) ([]UserEmail, error) {
+	return Default().FetchUserEmails(ctx, userId)
+}
+
+func IsEmailVerified(ctx context.Context, userId int, email string) (bool, error) {
+	return Default().IsEmailVerified(ctx, userId, email)
+}
+
+func DeleteUserEmail(ctx context.Context, userId int, email string) error {
+	return Default().DeleteUserEmail(ctx, userId, email)
+}
+
+func CreateAlertRule(ctx context.Context, a AlertRule) (int, error) {
+	return Default().CreateAlertRule(ctx, a)
+}
+
+func FetchAlertRulesByUserId(ctx context.Context, userId int) ([]AlertRule, error) {
+	return Default().FetchAlertRulesByUserId(ctx, userId)
+}
+
+func FetchAlertRulesByKind(ctx context.Context, kind string) ([]AlertRule, error) {
+	return Default().FetchAlertRulesByKind(ctx, kind)
+}
+
+func FetchAlertRulesByPortId(ctx context.Context, portId int) ([]AlertRule, error) {
+	return Default().FetchAlertRulesByPortId(ctx, portId)
+}
+
+func FetchAlertTickers(ctx context.Context) ([]string, error) {
+	return Default().FetchAlertTickers(ctx)
+}
+
+func ClaimAlertRule(ctx context.Context, a AlertRule, now time.Time) (bool, error) {
+	return Default().ClaimAlertRule(ctx, a, now)
+}
+
+func DeleteAlertRule(ctx context.Context, userId int, id int) error {
+	return Default().DeleteAlertRule(ctx, userId, id)
+}
+
+func UpsertDigestSettings(ctx context.Context, s DigestSettings) error {
+	return Default().UpsertDigestSettings(ctx, s)
+}
+
+func FetchDigestSettings(ctx context.Context, userId int) (*DigestSettings, error) {
+	return Default().FetchDigestSettings(ctx, userId)
+}
+
+func FetchAllDigestSettings(ctx context.Context) ([]DigestSettings, error) {
+	return Default().FetchAllDigestSettings(ctx)
+}
+
+func DeleteDigestSettings(ctx context.Context, userId int) error {
+	return Default().DeleteDigestSettings(ctx, userId)
+}
+
+func ClaimDigest(ctx context.Context, userId int, slot time.Time, now time.Time) (bool, error) {
+	return Default().ClaimDigest(ctx, userId, slot, now)
+}
+
+func ReleaseDigest(ctx context.Context, userId int, claimedAt time.Time, previous *time.Time) error {
+	return Default().ReleaseDigest(ctx, userId, claimedAt, previous)
+}
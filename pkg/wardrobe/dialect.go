@@ -0,0 +1,77 @@
+package wardrobe
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/util"
+	"github.com/lib/pq"
+)
+
+// Which sql database we're talking to. Queries are written for postgres and stick to the subset of it that
+// sqlite also understands, the few that can't branch on the dialect.
+type Dialect string
+
+const (
+	DialectPostgres Dialect = "postgres"
+	DialectSQLite   Dialect = "sqlite"
+)
+
+// The dialect of db, set by whichever of ConnectDB and ConnectSQLite set it up
+var dialect = DialectPostgres
+
+// Locks the rows a SELECT returns (of table alias, if given) until the transaction ends. sqlite doesn't
+// have row locks, and doesn't need them since it only ever runs one write transaction at a time.
+func (d Dialect) forUpdate(alias string) string {
+	if d == DialectSQLite {
+		return ""
+	}
+	if alias == "" {
+		return "FOR UPDATE"
+	}
+	return "FOR UPDATE OF " + alias
+}
+
+// Inserts rows into table. Postgres COPYs them in, sqlite (which has no COPY) inserts them one by one with a
+// prepared statement.
+func bulkInsert(ctx context.Context, q dbtx, d Dialect, table string, columns []string, rows [][]interface{}) (err error) {
+	var query string
+	if d == DialectSQLite {
+		placeholders := make([]string, len(columns))
+		for i := range columns {
+			placeholders[i] = fmt.Sprintf("$%d", i+1)
+		}
+		query = fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), strings.Join(placeholders, ", "))
+	} else {
+		query = pq.CopyIn(table, columns...)
+	}
+	stmt, err := q.PrepareContext(ctx, query)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		closeErr := stmt.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
+	for _, row := range rows {
+		_, err = stmt.ExecContext(ctx, row...)
+		if err != nil {
+			return err
+		}
+	}
+	if d == DialectPostgres {
+		// Flushes the COPY
+		_, err = stmt.ExecContext(ctx)
+	}
+	return err
+}
+
+// What we bind a time to a DATE column as. Postgres reads it as the date in the time's own zone and drops the
+// rest, sqlite would keep all of it, so we drop it ourselves for both.
+func sqlDate(t time.Time) time.Time {
+	return util.GetTimelessDate(t)
+}
@@ -0,0 +1,132 @@
+package wardrobe
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Returned when the user hasn't opted into digests
+var ErrNoDigestSettings = errors.New("no digest settings found")
+
+// How (and how often) a user wants their digest. See digest.Due for when one goes out.
+type DigestSettings struct {
+	UserId    int    `json:"user_id"`
+	Email     string `json:"email"`
+	Frequency string `json:"frequency"`
+	// Hour of the day (EST) it goes out
+	SendHour int `json:"send_hour"`
+	// Only used by weekly digests, 0 is sunday
+	SendWeekday int        `json:"send_weekday"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastSentAt  *time.Time `json:"last_sent_at"`
+}
+
+// Opts the user in, or changes how they get their digest. When the last one was sent is kept.
+func (s *SQL) UpsertDigestSettings(ctx context.Context, settings DigestSettings) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO digest_settings (user_id, email, frequency, send_hour, send_weekday, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (user_id) DO UPDATE
+		SET email=excluded.email, frequency=excluded.frequency, send_hour=excluded.send_hour,
+			send_weekday=excluded.send_weekday`,
+		settings.UserId, settings.Email, settings.Frequency, settings.SendHour, settings.SendWeekday, settings.CreatedAt)
+	return err
+}
+
+const digestSettingsColumns = `user_id, email, frequency, send_hour, send_weekday, created_at, last_sent_at`
+
+// Returns ErrNoDigestSettings if the user hasn't opted in
+func (s *SQL) FetchDigestSettings(ctx context.Context, userId int) (*DigestSettings, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+digestSettingsColumns+`
+		FROM digest_settings
+		WHERE user_id=$1`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrNoDigestSettings
+	}
+	return scanDigestSettings(rows)
+}
+
+// Everyone who's opted in
+func (s *SQL) FetchAllDigestSettings(ctx context.Context) ([]DigestSettings, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+digestSettingsColumns+`
+		FROM digest_settings
+		ORDER BY user_id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	settings := make([]DigestSettings, 0)
+	for rows.Next() {
+		s, err := scanDigestSettings(rows)
+		if err != nil {
+			return nil, err
+		}
+		settings = append(settings, *s)
+	}
+	return settings, rows.Err()
+}
+
+// Opts the user out. Returns ErrNoDigestSettings if they weren't opted in.
+func (s *SQL) DeleteDigestSettings(ctx context.Context, userId int) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM digest_settings WHERE user_id=$1`, userId)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoDigestSettings
+	}
+	return nil
+}
+
+// Marks the user's digest sent at now, unless one already went out at or after slot (the time it was scheduled
+// for). Returns whether we're the ones sending it, so overlapping runs don't both.
+func (s *SQL) ClaimDigest(ctx context.Context, userId int, slot time.Time, now time.Time) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE digest_settings SET last_sent_at=$2
+		WHERE user_id=$1 AND (last_sent_at IS NULL OR last_sent_at < $3)`, userId, now, slot)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// Undoes ClaimDigest when the digest couldn't be sent, putting last_sent_at back to previous so the next run
+// tries again. Does nothing if another run has claimed it since.
+func (s *SQL) ReleaseDigest(ctx context.Context, userId int, claimedAt time.Time, previous *time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE digest_settings SET last_sent_at=$3
+		WHERE user_id=$1 AND last_sent_at=$2`, userId, claimedAt, nullableTime(previous))
+	return err
+}
+
+func scanDigestSettings(rows *sql.Rows) (*DigestSettings, error) {
+	var s DigestSettings
+	var lastSentAt sql.NullTime
+	err := rows.Scan(&s.UserId, &s.Email, &s.Frequency, &s.SendHour, &s.SendWeekday, &s.CreatedAt, &lastSentAt)
+	if err != nil {
+		return nil, err
+	}
+	if lastSentAt.Valid {
+		s.LastSentAt = &lastSentAt.Time
+	}
+	return &s, nil
+}
@@ -0,0 +1,97 @@
+package wardrobe
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Returned when the user hasn't added an email
+var ErrNoUserEmail = errors.New("no email found")
+
+// An address the user wants email at. Nothing but its verification code goes to it until VerifiedAt is set.
+type UserEmail struct {
+	UserId     int        `json:"user_id"`
+	Email      string     `json:"email"`
+	VerifiedAt *time.Time `json:"verified_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// Adds email to the user's addresses if it isn't already, with a new code to verify it with. An address
+// that's already verified stays verified.
+func (s *SQL) UpsertEmailCode(ctx context.Context, userId int, email string, codeHash []byte, expiresAt time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO user_emails (user_id, email, code_hash, code_expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, email) DO UPDATE
+		SET code_hash=excluded.code_hash, code_expires_at=excluded.code_expires_at`,
+		userId, email, codeHash, expiresAt, time.Now())
+	return err
+}
+
+// Marks the user's email verified if codeHash is its unexpired code, which can't be used again. Returns false
+// if it isn't.
+func (s *SQL) VerifyEmail(ctx context.Context, userId int, email string, codeHash []byte, now time.Time) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE user_emails SET verified_at=$4, code_hash=NULL, code_expires_at=NULL
+		WHERE user_id=$1 AND email=$2 AND code_hash=$3 AND code_expires_at > $4`,
+		userId, email, codeHash, now)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Oldest first
+func (s *SQL) FetchUserEmails(ctx context.Context, userId int) ([]UserEmail, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT user_id, email, verified_at, created_at
+		FROM user_emails
+		WHERE user_id=$1
+		ORDER BY created_at, email`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	emails := make([]UserEmail, 0)
+	for rows.Next() {
+		var e UserEmail
+		var verifiedAt sql.NullTime
+		err = rows.Scan(&e.UserId, &e.Email, &verifiedAt, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		if verifiedAt.Valid {
+			e.VerifiedAt = &verifiedAt.Time
+		}
+		emails = append(emails, e)
+	}
+	return emails, rows.Err()
+}
+
+// Returns whether the user has verified they get email at email
+func (s *SQL) IsEmailVerified(ctx context.Context, userId int, email string) (bool, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `
+		SELECT COUNT(*) FROM user_emails
+		WHERE user_id=$1 AND email=$2 AND verified_at IS NOT NULL`, userId, email).Scan(&n)
+	return n > 0, err
+}
+
+// Returns ErrNoUserEmail if the user doesn't have email
+func (s *SQL) DeleteUserEmail(ctx context.Context, userId int, email string) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM user_emails WHERE user_id=$1 AND email=$2`, userId, email)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoUserEmail
+	}
+	return nil
+}
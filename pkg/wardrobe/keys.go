@@ -0,0 +1,109 @@
+package wardrobe
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/bluedresscapital/coattails/pkg/secrets"
+)
+
+// A column of secrets.BdcEncrypt ciphertexts, keyed by idColumn
+type cipherColumn struct {
+	table    string
+	idColumn string
+	column   string
+}
+
+// Every column we store ciphertexts in. Anything added here gets re-encrypted by RotateDataKeys.
+var cipherColumns = []cipherColumn{
+	{"rh_accounts", "id", "username_cipher"},
+	{"rh_accounts", "id", "password_cipher"},
+	{"rh_accounts", "id", "device_token_cipher"},
+	{"rh_accounts", "id", "refresh_token_cipher"},
+	{"tda_accounts", "id", "account_num_cipher"},
+	{"tda_accounts", "id", "refresh_token_cipher"},
+	{"user_totp", "user_id", "secret_cipher"},
+	{"webhook_endpoints", "id", "secret_cipher"},
+}
+
+// How many of a column's ciphertexts RotateDataKeys re-encrypted
+type RotatedColumn struct {
+	Table  string
+	Column string
+	// Already under the active data key
+	Current int
+	Rotated int
+	// Changed out from under us while we re-encrypted them, so whatever replaced them is already under the
+	// active data key
+	Skipped int
+}
+
+// Re-encrypts every stored ciphertext under the active data key, so older data keys can be retired once it's
+// done. Each ciphertext is only replaced if it hasn't changed since we read it, so it's safe to run while
+// coattails is serving requests. When dryRun is set, only counts what would be re-encrypted.
+func (s *SQL) RotateDataKeys(ctx context.Context, dryRun bool) ([]RotatedColumn, error) {
+	res := make([]RotatedColumn, 0, len(cipherColumns))
+	for _, c := range cipherColumns {
+		rotated, err := s.rotateCipherColumn(ctx, c, dryRun)
+		if err != nil {
+			return nil, fmt.Errorf("error rotating %s.%s: %v", c.table, c.column, err)
+		}
+		res = append(res, *rotated)
+	}
+	return res, nil
+}
+
+func (s *SQL) rotateCipherColumn(ctx context.Context, c cipherColumn, dryRun bool) (*RotatedColumn, error) {
+	res := RotatedColumn{Table: c.table, Column: c.column}
+	// Read everything up front, sqlite can't update while we're still iterating over rows
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`SELECT %s, %s FROM %s`, c.idColumn, c.column, c.table))
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]int, 0)
+	ciphers := make([][]byte, 0)
+	for rows.Next() {
+		var id int
+		var cipher []byte
+		err = rows.Scan(&id, &cipher)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		ciphers = append(ciphers, cipher)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, cipher := range ciphers {
+		if !secrets.NeedsReencrypt(cipher) {
+			res.Current++
+			continue
+		}
+		newCipher, err := secrets.Reencrypt(cipher)
+		if err != nil {
+			return nil, fmt.Errorf("error re-encrypting %s %d: %v", c.idColumn, ids[i], err)
+		}
+		if dryRun {
+			res.Rotated++
+			continue
+		}
+		updated, err := s.db.ExecContext(ctx, fmt.Sprintf(`UPDATE %s SET %s=$1 WHERE %s=$2 AND %s=$3`,
+			c.table, c.column, c.idColumn, c.column), newCipher, ids[i], cipher)
+		if err != nil {
+			return nil, err
+		}
+		n, err := updated.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if n == 0 {
+			res.Skipped++
+		} else {
+			res.Rotated++
+		}
+	}
+	return &res, nil
+}
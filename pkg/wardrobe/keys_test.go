@@ -0,0 +1,106 @@
+package wardrobe
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/bluedresscapital/coattails/pkg/secrets"
+)
+
+// Points the package level db at a fresh, fully migrated sqlite db
+func newTestSQLite(t *testing.T) *SQL {
+	t.Helper()
+	ConnectSQLite(filepath.Join(t.TempDir(), "wardrobe.db"))
+	t.Cleanup(func() { _ = CloseDB() })
+	ctx := context.Background()
+	latest, err := LatestSchemaVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = MigrateUp(ctx, latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return defaultSQL()
+}
+
+func setTestDataKeys(t *testing.T, active uint32) {
+	t.Helper()
+	err := secrets.SetDataKeys(map[uint32]string{1: "first data key", 2: "second data key"}, active)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRotateDataKeys(t *testing.T) {
+	ctx := context.Background()
+	store := newTestSQLite(t)
+	setTestDataKeys(t, 1)
+	for i, username := range []string{"alice", "bob"} {
+		err := CreateUser(ctx, username, []byte("hash"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = store.UpsertPendingTOTP(ctx, i+1, "secret of "+username)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	setTestDataKeys(t, 2)
+	// bob's re-enrolled since, under the new key
+	err := store.UpsertPendingTOTP(ctx, 2, "new secret of bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		dryRun      bool
+		wantRotated int
+		wantCurrent int
+	}{
+		{"dry run", true, 1, 1},
+		{"rotating", false, 1, 1},
+		{"already rotated", false, 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rotated, err := store.RotateDataKeys(ctx, tt.dryRun)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, c := range rotated {
+				if c.Table != "user_totp" {
+					if c.Rotated+c.Current+c.Skipped != 0 {
+						t.Errorf("expected nothing in %s.%s, got %+v", c.Table, c.Column, c)
+					}
+					continue
+				}
+				found = true
+				if c.Rotated != tt.wantRotated || c.Current != tt.wantCurrent || c.Skipped != 0 {
+					t.Errorf("expected %d rotated and %d current, got %+v", tt.wantRotated, tt.wantCurrent, c)
+				}
+			}
+			if !found {
+				t.Fatal("expected user_totp.secret_cipher to be rotated")
+			}
+		})
+	}
+
+	// Everything's readable under the new key alone
+	err = secrets.SetDataKeys(map[uint32]string{2: "second data key"}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for userId, want := range map[int]string{1: "secret of alice", 2: "new secret of bob"} {
+		totp, err := store.FetchUserTOTP(ctx, userId)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if totp.Secret != want {
+			t.Errorf("expected user %d's secret to be %q, got %q", userId, want, totp.Secret)
+		}
+	}
+}
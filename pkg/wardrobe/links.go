@@ -0,0 +1,113 @@
+package wardrobe
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+type Broker string
+
+const (
+	TDA       Broker = "tda"
+	Robinhood Broker = "rh"
+)
+
+type LinkStatus string
+
+const (
+	LinkHealthy LinkStatus = "healthy"
+	// The link still works, but we haven't managed to rotate its refresh token in a while, so it's about to
+	// stop working
+	LinkExpiring LinkStatus = "expiring"
+	// The user needs to re-link the account
+	LinkBroken LinkStatus = "broken"
+)
+
+var (
+	// How long a refresh token stays valid after we last rotated it. TD documents 90 days, robinhood doesn't
+	// document theirs, so we assume the worst
+	refreshTokenLifetimes = map[Broker]time.Duration{
+		TDA:       90 * 24 * time.Hour,
+		Robinhood: 30 * 24 * time.Hour,
+	}
+	// How long before a refresh token dies we start warning the user
+	LinkExpiringWindow = 7 * 24 * time.Hour
+)
+
+type LinkHealth struct {
+	Status        LinkStatus `json:"status"`
+	LastSuccessAt *time.Time `json:"last_success_at"`
+	LastErrorAt   *time.Time `json:"last_error_at"`
+	LastError     string     `json:"last_error"`
+}
+
+func brokerTable(broker Broker) (string, error) {
+	switch broker {
+	case TDA:
+		return "tda_accounts", nil
+	case Robinhood:
+		return "rh_accounts", nil
+	default:
+		return "", fmt.Errorf("unsupported broker %s", broker)
+	}
+}
+
+// Records that we successfully used (and rotated) the account's tokens
+func (s *SQL) MarkLinkHealthy(ctx context.Context, broker Broker, accountId int) error {
+	table, err := brokerTable(broker)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s SET link_status=$1, last_success_at=$2 WHERE id=$3`, table),
+		LinkHealthy, time.Now(), accountId)
+	return err
+}
+
+// Records that the account's tokens stopped working. Returns true if the link was healthy up until now,
+// so callers only notify the user once per breakage.
+func (s *SQL) MarkLinkBroken(ctx context.Context, broker Broker, accountId int, linkErr error) (bool, error) {
+	table, err := brokerTable(broker)
+	if err != nil {
+		return false, err
+	}
+	_, err = s.db.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s SET last_error=$1, last_error_at=$2 WHERE id=$3`, table),
+		linkErr.Error(), time.Now(), accountId)
+	if err != nil {
+		return false, err
+	}
+	res, err := s.db.ExecContext(ctx, fmt.Sprintf(`
+		UPDATE %s SET link_status=$1 WHERE id=$2 AND link_status<>$1`, table),
+		LinkBroken, accountId)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// Builds link health from the link columns every broker account table has, figuring out whether a healthy
+// link is about to expire
+func newLinkHealth(broker Broker, status string, lastSuccessAt sql.NullTime, lastErrorAt sql.NullTime, lastError sql.NullString) LinkHealth {
+	health := LinkHealth{
+		Status:    LinkStatus(status),
+		LastError: lastError.String,
+	}
+	if lastSuccessAt.Valid {
+		health.LastSuccessAt = &lastSuccessAt.Time
+		expiresAt := lastSuccessAt.Time.Add(refreshTokenLifetimes[broker])
+		if health.Status == LinkHealthy && time.Now().Add(LinkExpiringWindow).After(expiresAt) {
+			health.Status = LinkExpiring
+		}
+	}
+	if lastErrorAt.Valid {
+		health.LastErrorAt = &lastErrorAt.Time
+	}
+	return health
+}
@@ -0,0 +1,1583 @@
+package wardrobe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/util"
+	"github.com/shopspring/decimal"
+)
+
+// Memory is an in-memory Store, so the reload pipeline can run without postgres or redis (i.e. in tests).
+// It mirrors the sql implementation's behavior, including which lookups error when nothing is found.
+type Memory struct {
+	mu                    sync.Mutex
+	txMu                  sync.Mutex
+	nextPortId            int
+	portfolios            map[int]Portfolio
+	orders                map[string]memOrder
+	transfers             map[string]memTransfer
+	options               map[string]Option
+	positions             []Position
+	portValues            map[int]map[time.Time]PortValue
+	dailyPortValues       []DailyPortVal
+	quotes                map[string]map[time.Time]decimal.Decimal
+	audit                 []AuditEntry
+	portMembers           []PortfolioMember
+	nextHouseholdId       int
+	households            []Household
+	householdUsers        []HouseholdUser
+	nextWebhookEndpointId int
+	// Keyed by user id
+	webhookEndpoints      map[int][]WebhookEndpoint
+	nextWebhookDeliveryId int
+	webhookDeliveries     []WebhookDelivery
+	// Keyed by user id
+	totp            map[int]memTOTP
+	recoveryCodes   map[int][]memRecoveryCode
+	nextAPITokenId  int
+	apiTokens       []memAPIToken
+	links           map[memLinkKey]LinkHealth
+	userEmails      []memUserEmail
+	nextAlertRuleId int
+	alertRules      []AlertRule
+	// Keyed by user id
+	digestSettings map[int]DigestSettings
+	streams        *localStreams
+	pubsub         *localPubSub
+	*LocalSessions
+}
+
+type memOrder struct {
+	Order
+	committed bool
+	deleted   bool
+}
+
+type memTransfer struct {
+	Transfer
+	committed bool
+	deleted   bool
+}
+
+type memTOTP struct {
+	UserTOTP
+	attempts      int
+	attemptsSince *time.Time
+	lockedUntil   *time.Time
+}
+
+type memRecoveryCode struct {
+	hash []byte
+	used bool
+}
+
+type memAPIToken struct {
+	APIToken
+	hash []byte
+}
+
+type memUserEmail struct {
+	UserEmail
+	codeHash      []byte
+	codeExpiresAt time.Time
+}
+
+type memLinkKey struct {
+	broker    Broker
+	accountId int
+}
+
+var _ Store = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{
+		nextPortId:            1,
+		portfolios:            make(map[int]Portfolio),
+		orders:                make(map[string]memOrder),
+		transfers:             make(map[string]memTransfer),
+		options:               make(map[string]Option),
+		portValues:            make(map[int]map[time.Time]PortValue),
+		quotes:                make(map[string]map[time.Time]decimal.Decimal),
+		nextHouseholdId:       1,
+		nextWebhookEndpointId: 1,
+		webhookEndpoints:      make(map[int][]WebhookEndpoint),
+		nextWebhookDeliveryId: 1,
+		totp:                  make(map[int]memTOTP),
+		recoveryCodes:         make(map[int][]memRecoveryCode),
+		nextAPITokenId:        1,
+		links:                 make(map[memLinkKey]LinkHealth),
+		nextAlertRuleId:       1,
+		digestSettings:        make(map[int]DigestSettings),
+		streams:               newLocalStreams(),
+		pubsub:                &localPubSub{subs: make(map[string]map[*localSub]bool)},
+		LocalSessions:         NewLocalSessions(),
+	}
+}
+
+// Adds port as is (keeping its id), since broker portfolios are created alongside their broker accounts,
+// which Memory doesn't store
+func (m *Memory) AddPortfolio(port Portfolio) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.portfolios[port.Id] = port
+	if port.Id >= m.nextPortId {
+		m.nextPortId = port.Id + 1
+	}
+}
+
+// Every portfolio the user can see. Callers must hold mu.
+func (m *Memory) userPortIds(userId int) map[int]bool {
+	ids := make(map[int]bool)
+	for id := range m.portfolios {
+		if m.portfolioRole(id, userId) != RoleNone {
+			ids[id] = true
+		}
+	}
+	return ids
+}
+
+func (m *Memory) filterOrders(keep func(o memOrder) bool) []Order {
+	ret := make([]Order, 0)
+	for _, o := range m.orders {
+		if !o.deleted && keep(o) {
+			ret = append(ret, o.Order)
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Date.Before(ret[j].Date)
+	})
+	return ret
+}
+
+func (m *Memory) FetchOrdersByUserId(ctx context.Context, userId int) ([]Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	portIds := m.userPortIds(userId)
+	return m.filterOrders(func(o memOrder) bool { return portIds[o.PortId] }), nil
+}
+
+func (m *Memory) FetchOrdersByPortfolioId(ctx context.Context, portId int) ([]Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.filterOrders(func(o memOrder) bool { return o.PortId == portId }), nil
+}
+
+func (m *Memory) FetchZeroPriceOrdersByPortfolioId(ctx context.Context, portId int) ([]Order, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.filterOrders(func(o memOrder) bool { return o.PortId == portId && o.Value.IsZero() }), nil
+}
+
+func (m *Memory) InsertIgnoreOrder(ctx context.Context, o Order) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.orders[o.Uid]; found {
+		return nil
+	}
+	m.orders[o.Uid] = memOrder{Order: o}
+	return m.appendAudit(ctx, AuditOrder, o.Uid, o.PortId, AuditCreate, nil, o)
+}
+
+func (m *Memory) UpsertOrder(ctx context.Context, o Order) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	before, found := m.orders[o.Uid]
+	if found && before.PortId != o.PortId {
+		return ErrOtherPortfolio
+	}
+	if before.deleted {
+		return ErrDeleted
+	}
+	m.orders[o.Uid] = memOrder{Order: o}
+	if !found {
+		return m.appendAudit(ctx, AuditOrder, o.Uid, o.PortId, AuditCreate, nil, o)
+	}
+	return m.appendAudit(ctx, AuditOrder, o.Uid, o.PortId, AuditUpdate, before.Order, o)
+}
+
+func (m *Memory) DeleteOrder(ctx context.Context, uid string, portId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, found := m.orders[uid]
+	if !found || o.PortId != portId || o.deleted {
+		return nil
+	}
+	m.orders[uid] = memOrder{Order: o.Order, deleted: true}
+	return m.appendAudit(ctx, AuditOrder, uid, portId, AuditDelete, o.Order, nil)
+}
+
+func (m *Memory) RestoreOrder(ctx context.Context, uid string, portId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, found := m.orders[uid]
+	if !found || o.PortId != portId || !o.deleted {
+		return fmt.Errorf("order %s in portfolio %d: %w", uid, portId, ErrNotDeleted)
+	}
+	m.orders[uid] = memOrder{Order: o.Order}
+	return m.appendAudit(ctx, AuditOrder, uid, portId, AuditRestore, nil, o.Order)
+}
+
+func (m *Memory) SetOrdersCommitted(ctx context.Context, portId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for uid, o := range m.orders {
+		if o.PortId == portId {
+			o.committed = true
+			m.orders[uid] = o
+		}
+	}
+	return nil
+}
+
+func (m *Memory) HasUncommittedOrders(ctx context.Context, portId int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, o := range m.orders {
+		if o.PortId == portId && !o.committed {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) filterTransfers(keep func(t memTransfer) bool) []Transfer {
+	ret := make([]Transfer, 0)
+	for _, t := range m.transfers {
+		if !t.deleted && keep(t) {
+			ret = append(ret, t.Transfer)
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Date.Before(ret[j].Date)
+	})
+	return ret
+}
+
+func (m *Memory) FetchTransfersbyUserId(ctx context.Context, userId int) ([]Transfer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	portIds := m.userPortIds(userId)
+	return m.filterTransfers(func(t memTransfer) bool { return portIds[t.PortId] }), nil
+}
+
+func (m *Memory) FetchTransfersByPortfolioId(ctx context.Context, portId int) ([]Transfer, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.filterTransfers(func(t memTransfer) bool { return t.PortId == portId }), nil
+}
+
+func (m *Memory) InsertIgnoreTransfer(ctx context.Context, t Transfer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, found := m.transfers[t.Uid]; found {
+		return nil
+	}
+	m.transfers[t.Uid] = memTransfer{Transfer: t}
+	return m.appendAudit(ctx, AuditTransfer, t.Uid, t.PortId, AuditCreate, nil, t)
+}
+
+func (m *Memory) UpsertTransfer(ctx context.Context, t Transfer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	before, found := m.transfers[t.Uid]
+	if found && before.PortId != t.PortId {
+		return ErrOtherPortfolio
+	}
+	if before.deleted {
+		return ErrDeleted
+	}
+	m.transfers[t.Uid] = memTransfer{Transfer: t}
+	if !found {
+		return m.appendAudit(ctx, AuditTransfer, t.Uid, t.PortId, AuditCreate, nil, t)
+	}
+	return m.appendAudit(ctx, AuditTransfer, t.Uid, t.PortId, AuditUpdate, before.Transfer, t)
+}
+
+func (m *Memory) DeleteTransfer(ctx context.Context, uid string, portId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, found := m.transfers[uid]
+	if !found || t.PortId != portId || t.deleted {
+		return nil
+	}
+	m.transfers[uid] = memTransfer{Transfer: t.Transfer, deleted: true}
+	return m.appendAudit(ctx, AuditTransfer, uid, portId, AuditDelete, t.Transfer, nil)
+}
+
+func (m *Memory) RestoreTransfer(ctx context.Context, uid string, portId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, found := m.transfers[uid]
+	if !found || t.PortId != portId || !t.deleted {
+		return fmt.Errorf("transfer %s in portfolio %d: %w", uid, portId, ErrNotDeleted)
+	}
+	m.transfers[uid] = memTransfer{Transfer: t.Transfer}
+	return m.appendAudit(ctx, AuditTransfer, uid, portId, AuditRestore, nil, t.Transfer)
+}
+
+func (m *Memory) SetTransfersCommitted(ctx context.Context, portId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for uid, t := range m.transfers {
+		if t.PortId == portId {
+			t.committed = true
+			m.transfers[uid] = t
+		}
+	}
+	return nil
+}
+
+func (m *Memory) HasUncommittedTransfers(ctx context.Context, portId int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.transfers {
+		if t.PortId == portId && !t.committed {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) UpsertOption(ctx context.Context, o Option) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.options[o.Ticker] = o
+	return nil
+}
+
+func (m *Memory) FetchOption(ctx context.Context, ticker string) (*Option, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	o, found := m.options[ticker]
+	if !found {
+		return nil, nil
+	}
+	return &o, nil
+}
+
+func (m *Memory) FetchOptionsByPortfolioId(ctx context.Context, portId int) (map[string]Option, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	options := make(map[string]Option)
+	for _, o := range m.orders {
+		if opt, found := m.options[o.Stock]; found && o.PortId == portId && !o.deleted {
+			options[opt.Ticker] = opt
+		}
+	}
+	return options, nil
+}
+
+func (m *Memory) withOption(p Position) Position {
+	if opt, found := m.options[p.Stock]; found {
+		p.Option = &opt
+	}
+	return p
+}
+
+func (m *Memory) FetchPositions(ctx context.Context, userId int) ([]Position, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	portIds := m.userPortIds(userId)
+	ret := make([]Position, 0)
+	for _, p := range m.positions {
+		if portIds[p.PortId] {
+			ret = append(ret, m.withOption(p))
+		}
+	}
+	return ret, nil
+}
+
+func (m *Memory) FetchPortfolioPositions(ctx context.Context, portId int) ([]Position, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ret := make([]Position, 0)
+	for _, p := range m.positions {
+		if p.PortId == portId {
+			ret = append(ret, m.withOption(p))
+		}
+	}
+	return ret, nil
+}
+
+func (m *Memory) InsertPosition(ctx context.Context, p Position) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p.Option = nil
+	m.positions = append(m.positions, p)
+	return nil
+}
+
+func (m *Memory) DeletePositions(ctx context.Context, portId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	kept := make([]Position, 0)
+	for _, p := range m.positions {
+		if p.PortId != portId {
+			kept = append(kept, p)
+		}
+	}
+	m.positions = kept
+	return nil
+}
+
+func (m *Memory) FetchNonZeroQuantityPositions(ctx context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tickers := make([]string, 0)
+	for _, p := range m.positions {
+		if _, isOption := m.options[p.Stock]; !isOption && !p.Quantity.IsZero() {
+			tickers = append(tickers, p.Stock)
+		}
+	}
+	return tickers, nil
+}
+
+func (m *Memory) CreatePortfolio(ctx context.Context, userId int, name string, portType string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	port := Portfolio{Id: m.nextPortId, Name: name, Type: portType, UserId: userId}
+	m.portfolios[port.Id] = port
+	m.nextPortId++
+	return m.appendAudit(ctx, AuditPortfolio, strconv.Itoa(port.Id), port.Id, AuditCreate, nil, port)
+}
+
+func (m *Memory) FetchPortfolioById(ctx context.Context, id int) (*Portfolio, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	port, found := m.portfolios[id]
+	if !found {
+		return nil, fmt.Errorf("no portfolio with id %d found", id)
+	}
+	return &port, nil
+}
+
+func (m *Memory) fetchPortfolio(matches func(p Portfolio) bool) *Portfolio {
+	for _, p := range m.portfolios {
+		if matches(p) {
+			return &p
+		}
+	}
+	return nil
+}
+
+func (m *Memory) FetchPortfolioByTDAccountId(ctx context.Context, tdAccountId int) (*Portfolio, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	port := m.fetchPortfolio(func(p Portfolio) bool { return p.TDAccountId == tdAccountId })
+	if port == nil {
+		return nil, fmt.Errorf("no portfolio with tda_account_id %d found", tdAccountId)
+	}
+	return port, nil
+}
+
+func (m *Memory) FetchPortfolioByRHAccountId(ctx context.Context, rhAccountId int) (*Portfolio, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	port := m.fetchPortfolio(func(p Portfolio) bool { return p.RHAccountId == rhAccountId })
+	if port == nil {
+		return nil, fmt.Errorf("no portfolio with rh_account_id %d found", rhAccountId)
+	}
+	return port, nil
+}
+
+func (m *Memory) FetchPortfoliosByUserId(ctx context.Context, userId int) ([]Portfolio, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ports := make([]Portfolio, 0)
+	for _, p := range m.portfolios {
+		if p.Role = m.portfolioRole(p.Id, userId); p.Role != RoleNone {
+			ports = append(ports, p)
+		}
+	}
+	sort.Slice(ports, func(i, j int) bool {
+		return ports[i].Id < ports[j].Id
+	})
+	return ports, nil
+}
+
+func (m *Memory) FetchAllPortfolioIds(ctx context.Context) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ids := make([]int, 0)
+	for id := range m.portfolios {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (m *Memory) BulkUpsertPortfolioValuesByPortId(ctx context.Context, pvs []PortValue, portId int) error {
+	if len(pvs) == 0 {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	start := pvs[0].Date
+	end := pvs[len(pvs)-1].Date
+	values, found := m.portValues[portId]
+	if !found {
+		values = make(map[time.Time]PortValue)
+		m.portValues[portId] = values
+	}
+	for date := range values {
+		if !date.Before(start) && !date.After(end) {
+			delete(values, date)
+		}
+	}
+	for _, pv := range pvs {
+		values[pv.Date] = pv
+	}
+	return nil
+}
+
+func (m *Memory) UpsertPortfolioValue(ctx context.Context, pv PortValue) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	values, found := m.portValues[pv.PortId]
+	if !found {
+		values = make(map[time.Time]PortValue)
+		m.portValues[pv.PortId] = values
+	}
+	values[pv.Date] = pv
+	return nil
+}
+
+func (m *Memory) FetchPortfolioValuesByPortId(ctx context.Context, portId int) ([]PortValue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	pvs := make([]PortValue, 0)
+	for _, pv := range m.portValues[portId] {
+		pvs = append(pvs, pv)
+	}
+	sort.Slice(pvs, func(i, j int) bool {
+		return pvs[i].Date.Before(pvs[j].Date)
+	})
+	return pvs, nil
+}
+
+func (m *Memory) FetchPortfolioValueOnDay(ctx context.Context, portId int, date time.Time) (*PortValue, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for d, pv := range m.portValues[portId] {
+		if d.Equal(date) {
+			return &pv, nil
+		}
+	}
+	return nil, fmt.Errorf("no portfolio value found for port %d on %s", portId, date)
+}
+
+func (m *Memory) InsertDailyPortValue(ctx context.Context, dpv DailyPortVal) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dailyPortValues = append(m.dailyPortValues, dpv)
+	return nil
+}
+
+func (m *Memory) FetchDailyPortValuesByPortfolioId(ctx context.Context, portId int) ([]DailyPortVal, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ret := make([]DailyPortVal, 0)
+	for _, dpv := range m.dailyPortValues {
+		if dpv.PortId == portId {
+			ret = append(ret, dpv)
+		}
+	}
+	sort.SliceStable(ret, func(i, j int) bool {
+		return ret[i].Date.Before(ret[j].Date)
+	})
+	return ret, nil
+}
+
+func (m *Memory) DeletePrevDailyPortValues(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := util.GetTimelessDate(time.Now())
+	kept := make([]DailyPortVal, 0)
+	for _, dpv := range m.dailyPortValues {
+		if !dpv.Date.Before(now) {
+			kept = append(kept, dpv)
+		}
+	}
+	m.dailyPortValues = kept
+	return nil
+}
+
+func (m *Memory) UpsertStockQuotePrice(ctx context.Context, ticker string, date time.Time, price decimal.Decimal) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.upsertQuote(ticker, date, price)
+	return nil
+}
+
+func (m *Memory) upsertQuote(ticker string, date time.Time, price decimal.Decimal) {
+	quotes, found := m.quotes[ticker]
+	if !found {
+		quotes = make(map[time.Time]decimal.Decimal)
+		m.quotes[ticker] = quotes
+	}
+	quotes[util.GetTimelessDate(date)] = price
+}
+
+func (m *Memory) BatchUpsertStockQuotes(ctx context.Context, quotes []StockQuote) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, q := range quotes {
+		m.upsertQuote(q.Stock, q.Date, q.Price)
+	}
+	return nil
+}
+
+func (m *Memory) FetchStockQuoteCount(ctx context.Context, ticker string, start time.Time, end time.Time) (*int, error) {
+	quotes, err := m.FetchStockQuotes(ctx, ticker, start, end)
+	if err != nil {
+		return nil, err
+	}
+	count := len(quotes)
+	return &count, nil
+}
+
+func (m *Memory) FetchStockQuotes(ctx context.Context, ticker string, start time.Time, end time.Time) ([]StockQuote, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ret := make([]StockQuote, 0)
+	for date, price := range m.quotes[ticker] {
+		if !date.Before(start) && !date.After(end) {
+			ret = append(ret, StockQuote{Stock: ticker, Price: price, Date: date})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Date.Before(ret[j].Date)
+	})
+	return ret, nil
+}
+
+// Callers must hold mu
+func (m *Memory) appendAudit(ctx context.Context, entity AuditEntity, entityId string, portId int, action AuditAction, before interface{}, after interface{}) error {
+	e, err := newAuditEntry(ctx, entity, entityId, portId, action, before, after)
+	if err != nil {
+		return err
+	}
+	e.Id = len(m.audit) + 1
+	m.audit = append(m.audit, *e)
+	return nil
+}
+
+func (m *Memory) FetchAuditLog(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	if q.Limit <= 0 {
+		return nil, fmt.Errorf("invalid audit log limit %d", q.Limit)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	portIds := m.userPortIds(q.UserId)
+	entries := make([]AuditEntry, 0)
+	for i := len(m.audit) - 1; i >= 0 && len(entries) < q.Limit; i-- {
+		e := m.audit[i]
+		var visible bool
+		if e.PortId == nil {
+			visible = e.UserId != nil && *e.UserId == q.UserId
+		} else {
+			visible = portIds[*e.PortId]
+		}
+		if !visible || (q.PortId != 0 && (e.PortId == nil || *e.PortId != q.PortId)) || (q.BeforeId != 0 && e.Id >= q.BeforeId) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
+
+// Callers must hold mu
+func (m *Memory) portfolioRole(portId int, userId int) PortfolioRole {
+	port, found := m.portfolios[portId]
+	if !found {
+		return RoleNone
+	}
+	if port.UserId == userId {
+		return RoleOwner
+	}
+	role := RoleNone
+	for _, member := range m.portMembers {
+		if member.PortId == portId && member.UserId == userId {
+			role = member.Role
+		}
+	}
+	if role == RoleNone && m.shareHousehold(port.UserId, userId) {
+		role = RoleViewer
+	}
+	return role
+}
+
+// Whether both users have accepted their invites to some household. Callers must hold mu.
+func (m *Memory) shareHousehold(a int, b int) bool {
+	accepted := make(map[int]map[int]bool)
+	for _, hu := range m.householdUsers {
+		if hu.AcceptedAt == nil {
+			continue
+		}
+		if accepted[hu.HouseholdId] == nil {
+			accepted[hu.HouseholdId] = make(map[int]bool)
+		}
+		accepted[hu.HouseholdId][hu.UserId] = true
+	}
+	for _, users := range accepted {
+		if users[a] && users[b] {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Memory) FetchPortfolioRole(ctx context.Context, portId int, userId int) (PortfolioRole, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.portfolioRole(portId, userId), nil
+}
+
+func (m *Memory) FetchPortfolioUserIds(ctx context.Context, portId int) ([]int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	candidates := make(map[int]bool)
+	for _, p := range m.portfolios {
+		candidates[p.UserId] = true
+	}
+	for _, member := range m.portMembers {
+		candidates[member.UserId] = true
+	}
+	for _, hu := range m.householdUsers {
+		candidates[hu.UserId] = true
+	}
+	ids := make([]int, 0)
+	for userId := range candidates {
+		if m.portfolioRole(portId, userId) != RoleNone {
+			ids = append(ids, userId)
+		}
+	}
+	sort.Ints(ids)
+	return ids, nil
+}
+
+func (m *Memory) FetchPortfolioMembers(ctx context.Context, portId int) ([]PortfolioMember, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	members := make([]PortfolioMember, 0)
+	for _, member := range m.portMembers {
+		if member.PortId == portId {
+			members = append(members, member)
+		}
+	}
+	return members, nil
+}
+
+func (m *Memory) UpsertPortfolioMember(ctx context.Context, member PortfolioMember) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	port, found := m.portfolios[member.PortId]
+	if !found {
+		return fmt.Errorf("no portfolio with id %d found", member.PortId)
+	}
+	if port.UserId == member.UserId {
+		return ErrAlreadyMember
+	}
+	entityId := strconv.Itoa(member.UserId)
+	for i, before := range m.portMembers {
+		if before.PortId == member.PortId && before.UserId == member.UserId {
+			member.CreatedAt = before.CreatedAt
+			m.portMembers[i] = member
+			return m.appendAudit(ctx, AuditMember, entityId, member.PortId, AuditUpdate, before, member)
+		}
+	}
+	m.portMembers = append(m.portMembers, member)
+	return m.appendAudit(ctx, AuditMember, entityId, member.PortId, AuditCreate, nil, member)
+}
+
+func (m *Memory) DeletePortfolioMember(ctx context.Context, portId int, userId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, before := range m.portMembers {
+		if before.PortId == portId && before.UserId == userId {
+			m.portMembers = append(m.portMembers[:i:i], m.portMembers[i+1:]...)
+			return m.appendAudit(ctx, AuditMember, strconv.Itoa(userId), portId, AuditDelete, before, nil)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) CreateHousehold(ctx context.Context, name string, ownerId int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	h := Household{Id: m.nextHouseholdId, Name: name, CreatedAt: now}
+	m.nextHouseholdId++
+	m.households = append(m.households, h)
+	m.householdUsers = append(m.householdUsers, HouseholdUser{
+		HouseholdId: h.Id,
+		UserId:      ownerId,
+		Role:        HouseholdOwner,
+		CreatedAt:   now,
+		AcceptedAt:  &now,
+	})
+	return h.Id, nil
+}
+
+func (m *Memory) FetchHouseholdsByUserId(ctx context.Context, userId int) ([]Household, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ret := make([]Household, 0)
+	for _, h := range m.households {
+		h.Members = make([]HouseholdUser, 0)
+		in := false
+		for _, hu := range m.householdUsers {
+			if hu.HouseholdId == h.Id {
+				h.Members = append(h.Members, hu)
+				in = in || hu.UserId == userId
+			}
+		}
+		if in {
+			ret = append(ret, h)
+		}
+	}
+	return ret, nil
+}
+
+func (m *Memory) FetchHouseholdUser(ctx context.Context, householdId int, userId int) (*HouseholdUser, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, hu := range m.householdUsers {
+		if hu.HouseholdId == householdId && hu.UserId == userId {
+			return &hu, nil
+		}
+	}
+	return nil, nil
+}
+
+func (m *Memory) InviteHouseholdMember(ctx context.Context, householdId int, userId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, hu := range m.householdUsers {
+		if hu.HouseholdId == householdId && hu.UserId == userId {
+			return ErrAlreadyMember
+		}
+	}
+	m.householdUsers = append(m.householdUsers, HouseholdUser{
+		HouseholdId: householdId,
+		UserId:      userId,
+		Role:        HouseholdMember,
+		CreatedAt:   time.Now(),
+	})
+	return nil
+}
+
+func (m *Memory) AcceptHouseholdInvite(ctx context.Context, householdId int, userId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, hu := range m.householdUsers {
+		if hu.HouseholdId == householdId && hu.UserId == userId && hu.AcceptedAt == nil {
+			now := time.Now()
+			m.householdUsers[i].AcceptedAt = &now
+			return nil
+		}
+	}
+	return ErrNoHouseholdInvite
+}
+
+func (m *Memory) DeleteHouseholdMember(ctx context.Context, householdId int, userId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.filterHouseholdUsers(func(hu HouseholdUser) bool {
+		return hu.HouseholdId != householdId || hu.UserId != userId
+	})
+	return nil
+}
+
+func (m *Memory) DeleteHousehold(ctx context.Context, householdId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.filterHouseholdUsers(func(hu HouseholdUser) bool { return hu.HouseholdId != householdId })
+	households := make([]Household, 0)
+	for _, h := range m.households {
+		if h.Id != householdId {
+			households = append(households, h)
+		}
+	}
+	m.households = households
+	return nil
+}
+
+// Callers must hold mu
+func (m *Memory) filterHouseholdUsers(keep func(hu HouseholdUser) bool) {
+	users := make([]HouseholdUser, 0)
+	for _, hu := range m.householdUsers {
+		if keep(hu) {
+			users = append(users, hu)
+		}
+	}
+	m.householdUsers = users
+}
+
+func (m *Memory) CreateWebhookEndpoint(ctx context.Context, e WebhookEndpoint) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e.Id = m.nextWebhookEndpointId
+	m.nextWebhookEndpointId++
+	m.webhookEndpoints[e.UserId] = append(m.webhookEndpoints[e.UserId], e)
+	return e.Id, nil
+}
+
+func (m *Memory) FetchWebhookEndpointsByUserId(ctx context.Context, userId int) ([]WebhookEndpoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	endpoints := make([]WebhookEndpoint, 0)
+	for _, e := range m.webhookEndpoints[userId] {
+		e.Secret = ""
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, nil
+}
+
+func (m *Memory) DeleteWebhookEndpoint(ctx context.Context, userId int, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	endpoints := m.webhookEndpoints[userId]
+	for i, e := range endpoints {
+		if e.Id != id {
+			continue
+		}
+		m.webhookEndpoints[userId] = append(endpoints[:i:i], endpoints[i+1:]...)
+		deliveries := make([]WebhookDelivery, 0, len(m.webhookDeliveries))
+		for _, d := range m.webhookDeliveries {
+			if d.EndpointId != id {
+				deliveries = append(deliveries, d)
+			}
+		}
+		m.webhookDeliveries = deliveries
+		return nil
+	}
+	return ErrNoWebhookEndpoint
+}
+
+// Returns nil if there's no endpoint with id. Callers must hold mu.
+func (m *Memory) webhookEndpoint(id int) *WebhookEndpoint {
+	for _, endpoints := range m.webhookEndpoints {
+		for i, e := range endpoints {
+			if e.Id == id {
+				return &endpoints[i]
+			}
+		}
+	}
+	return nil
+}
+
+func (m *Memory) InsertWebhookDelivery(ctx context.Context, d WebhookDelivery) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d.Id = m.nextWebhookDeliveryId
+	m.nextWebhookDeliveryId++
+	d.Status = WebhookPending
+	m.webhookDeliveries = append(m.webhookDeliveries, d)
+	return d.Id, nil
+}
+
+func (m *Memory) FetchWebhookDeliveries(ctx context.Context, userId int, endpointId int, limit int) ([]WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e := m.webhookEndpoint(endpointId)
+	if e == nil || e.UserId != userId {
+		return nil, ErrNoWebhookEndpoint
+	}
+	deliveries := make([]WebhookDelivery, 0)
+	for i := len(m.webhookDeliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		if m.webhookDeliveries[i].EndpointId == endpointId {
+			deliveries = append(deliveries, m.webhookDeliveries[i])
+		}
+	}
+	return deliveries, nil
+}
+
+func (m *Memory) FetchWebhookDelivery(ctx context.Context, userId int, id int) (*WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, d := range m.webhookDeliveries {
+		if d.Id != id {
+			continue
+		}
+		e := m.webhookEndpoint(d.EndpointId)
+		if e == nil || e.UserId != userId {
+			break
+		}
+		return &d, nil
+	}
+	return nil, ErrNoWebhookDelivery
+}
+
+func (m *Memory) FetchDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]DueWebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deliveries := make([]DueWebhookDelivery, 0)
+	for _, d := range m.webhookDeliveries {
+		if d.Status != WebhookPending || d.NextAttemptAt.After(now) {
+			continue
+		}
+		e := m.webhookEndpoint(d.EndpointId)
+		if e == nil {
+			continue
+		}
+		deliveries = append(deliveries, DueWebhookDelivery{WebhookDelivery: d, URL: e.URL, Secret: e.Secret})
+	}
+	sort.SliceStable(deliveries, func(i, j int) bool {
+		return deliveries[i].NextAttemptAt.Before(deliveries[j].NextAttemptAt)
+	})
+	if len(deliveries) > limit {
+		deliveries = deliveries[:limit]
+	}
+	return deliveries, nil
+}
+
+func (m *Memory) ClaimWebhookDelivery(ctx context.Context, id int, now time.Time, leaseUntil time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, d := range m.webhookDeliveries {
+		if d.Id == id && d.Status == WebhookPending && !d.NextAttemptAt.After(now) {
+			m.webhookDeliveries[i].NextAttemptAt = leaseUntil
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) RecordWebhookAttempt(ctx context.Context, d WebhookDelivery) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, existing := range m.webhookDeliveries {
+		if existing.Id != d.Id {
+			continue
+		}
+		existing.Status = d.Status
+		existing.Attempts = d.Attempts
+		existing.NextAttemptAt = d.NextAttemptAt
+		existing.LastAttemptAt = d.LastAttemptAt
+		existing.ResponseStatus = d.ResponseStatus
+		existing.LastError = d.LastError
+		m.webhookDeliveries[i] = existing
+	}
+	return nil
+}
+
+func (m *Memory) DeleteWebhookDeliveriesBefore(ctx context.Context, cutoff time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deliveries := make([]WebhookDelivery, 0, len(m.webhookDeliveries))
+	for _, d := range m.webhookDeliveries {
+		if !d.CreatedAt.Before(cutoff) || d.Status == WebhookPending {
+			deliveries = append(deliveries, d)
+		}
+	}
+	m.webhookDeliveries = deliveries
+	return nil
+}
+
+// Every delivery queued for endpointId, oldest first
+func (m *Memory) WebhookDeliveries(endpointId int) []WebhookDelivery {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	deliveries := make([]WebhookDelivery, 0)
+	for _, d := range m.webhookDeliveries {
+		if d.EndpointId == endpointId {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries
+}
+
+func (m *Memory) AppendStream(ctx context.Context, stream string, payload []byte) (string, error) {
+	return m.streams.append(ctx, stream, payload)
+}
+
+// Same as the package level ReadStream, for the streams appended to through m
+func (m *Memory) ReadStream(ctx context.Context, stream string, after string, limit int64) (*StreamReplay, error) {
+	id, err := ParseStreamId(after)
+	if err != nil {
+		return nil, err
+	}
+	return m.streams.read(ctx, stream, *id, limit)
+}
+
+func (m *Memory) Publish(channel string, message []byte) error {
+	return m.pubsub.publish(channel, message)
+}
+
+// Secrets are kept as is, there's nothing at rest to encrypt
+func (m *Memory) FetchUserTOTP(ctx context.Context, userId int) (*UserTOTP, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.totp[userId]
+	if !ok {
+		return nil, nil
+	}
+	return &t.UserTOTP, nil
+}
+
+func (m *Memory) HasTOTPEnabled(ctx context.Context, userId int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.totp[userId].EnabledAt != nil, nil
+}
+
+func (m *Memory) UpsertPendingTOTP(ctx context.Context, userId int, secret string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.totp[userId]
+	if ok && t.EnabledAt != nil {
+		return nil
+	}
+	t.UserTOTP = UserTOTP{UserId: userId, Secret: secret}
+	m.totp[userId] = t
+	return nil
+}
+
+func (m *Memory) EnableTOTP(ctx context.Context, userId int, step int64, recoveryCodeHashes [][]byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.totp[userId]
+	if ok && t.EnabledAt == nil {
+		now := time.Now()
+		t.EnabledAt = &now
+		t.LastUsedStep = step
+		m.totp[userId] = t
+	}
+	m.replaceRecoveryCodes(userId, recoveryCodeHashes)
+	return nil
+}
+
+func (m *Memory) UseTOTPStep(ctx context.Context, userId int, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.totp[userId]
+	if !ok || t.LastUsedStep >= step {
+		return false, nil
+	}
+	t.LastUsedStep = step
+	m.totp[userId] = t
+	return true, nil
+}
+
+func (m *Memory) StartTOTPAttempt(ctx context.Context, userId int, now time.Time, windowStart time.Time, maxAttempts int, lockUntil time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.totp[userId]
+	if !ok || (t.lockedUntil != nil && t.lockedUntil.After(now)) {
+		return false, nil
+	}
+	if t.attemptsSince == nil || t.attemptsSince.Before(windowStart) {
+		t.attempts = 1
+		t.attemptsSince = &now
+	} else {
+		t.attempts++
+	}
+	if t.attempts >= maxAttempts {
+		t.lockedUntil = &lockUntil
+	}
+	m.totp[userId] = t
+	return true, nil
+}
+
+func (m *Memory) ResetTOTPAttempts(ctx context.Context, userId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.totp[userId]
+	if !ok {
+		return nil
+	}
+	t.attempts = 0
+	t.attemptsSince = nil
+	t.lockedUntil = nil
+	m.totp[userId] = t
+	return nil
+}
+
+func (m *Memory) DeleteTOTP(ctx context.Context, userId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.totp, userId)
+	delete(m.recoveryCodes, userId)
+	return nil
+}
+
+func (m *Memory) ReplaceRecoveryCodes(ctx context.Context, userId int, codeHashes [][]byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.replaceRecoveryCodes(userId, codeHashes)
+	return nil
+}
+
+// Callers must hold mu
+func (m *Memory) replaceRecoveryCodes(userId int, codeHashes [][]byte) {
+	codes := make([]memRecoveryCode, 0, len(codeHashes))
+	for _, h := range codeHashes {
+		codes = append(codes, memRecoveryCode{hash: h})
+	}
+	m.recoveryCodes[userId] = codes
+}
+
+func (m *Memory) UseRecoveryCode(ctx context.Context, userId int, codeHash []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	codes := m.recoveryCodes[userId]
+	for i, c := range codes {
+		if !c.used && bytes.Equal(c.hash, codeHash) {
+			codes[i].used = true
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) CountUnusedRecoveryCodes(ctx context.Context, userId int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for _, c := range m.recoveryCodes[userId] {
+		if !c.used {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *Memory) CreateAPIToken(ctx context.Context, t APIToken, tokenHash []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t.Id = m.nextAPITokenId
+	m.nextAPITokenId++
+	t.LastUsedAt = nil
+	m.apiTokens = append(m.apiTokens, memAPIToken{APIToken: t, hash: tokenHash})
+	return t.Id, nil
+}
+
+func (m *Memory) FetchAPITokenByHash(ctx context.Context, tokenHash []byte) (*APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, t := range m.apiTokens {
+		if bytes.Equal(t.hash, tokenHash) {
+			return &t.APIToken, nil
+		}
+	}
+	return nil, ErrNoAPIToken
+}
+
+func (m *Memory) FetchAPITokensByUserId(ctx context.Context, userId int) ([]APIToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tokens := make([]APIToken, 0)
+	for i := len(m.apiTokens) - 1; i >= 0; i-- {
+		if m.apiTokens[i].UserId == userId {
+			tokens = append(tokens, m.apiTokens[i].APIToken)
+		}
+	}
+	return tokens, nil
+}
+
+func (m *Memory) TouchAPIToken(ctx context.Context, id int, usedAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, t := range m.apiTokens {
+		if t.Id == id && (t.LastUsedAt == nil || t.LastUsedAt.Before(usedAt.Add(-apiTokenTouchInterval))) {
+			m.apiTokens[i].LastUsedAt = &usedAt
+		}
+	}
+	return nil
+}
+
+func (m *Memory) DeleteAPIToken(ctx context.Context, userId int, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, t := range m.apiTokens {
+		if t.Id == id && t.UserId == userId {
+			m.apiTokens = append(m.apiTokens[:i:i], m.apiTokens[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNoAPIToken
+}
+
+func (m *Memory) MarkLinkHealthy(ctx context.Context, broker Broker, accountId int) error {
+	if _, err := brokerTable(broker); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memLinkKey{broker, accountId}
+	health := m.linkHealth(key)
+	now := time.Now()
+	health.Status = LinkHealthy
+	health.LastSuccessAt = &now
+	m.links[key] = health
+	return nil
+}
+
+func (m *Memory) MarkLinkBroken(ctx context.Context, broker Broker, accountId int, linkErr error) (bool, error) {
+	if _, err := brokerTable(broker); err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memLinkKey{broker, accountId}
+	health := m.linkHealth(key)
+	now := time.Now()
+	transitioned := health.Status != LinkBroken
+	health.Status = LinkBroken
+	health.LastErrorAt = &now
+	health.LastError = linkErr.Error()
+	m.links[key] = health
+	return transitioned, nil
+}
+
+// The account's link health as last marked. Accounts start out healthy, as they do in the db.
+func (m *Memory) LinkHealth(broker Broker, accountId int) LinkHealth {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.linkHealth(memLinkKey{broker, accountId})
+}
+
+// Callers must hold mu
+func (m *Memory) linkHealth(key memLinkKey) LinkHealth {
+	health, ok := m.links[key]
+	if !ok {
+		return LinkHealth{Status: LinkHealthy}
+	}
+	return health
+}
+
+// Memory keeps secrets as is, so there's never anything to re-encrypt
+func (m *Memory) RotateDataKeys(ctx context.Context, dryRun bool) ([]RotatedColumn, error) {
+	return make([]RotatedColumn, 0), nil
+}
+
+func (m *Memory) UpsertEmailCode(ctx context.Context, userId int, email string, codeHash []byte, expiresAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, e := range m.userEmails {
+		if e.UserId == userId && e.Email == email {
+			m.userEmails[i].codeHash = codeHash
+			m.userEmails[i].codeExpiresAt = expiresAt
+			return nil
+		}
+	}
+	m.userEmails = append(m.userEmails, memUserEmail{
+		UserEmail:     UserEmail{UserId: userId, Email: email, CreatedAt: time.Now()},
+		codeHash:      codeHash,
+		codeExpiresAt: expiresAt,
+	})
+	return nil
+}
+
+func (m *Memory) VerifyEmail(ctx context.Context, userId int, email string, codeHash []byte, now time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, e := range m.userEmails {
+		if e.UserId == userId && e.Email == email && e.codeHash != nil && bytes.Equal(e.codeHash, codeHash) &&
+			e.codeExpiresAt.After(now) {
+			m.userEmails[i].VerifiedAt = &now
+			m.userEmails[i].codeHash = nil
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) FetchUserEmails(ctx context.Context, userId int) ([]UserEmail, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	emails := make([]UserEmail, 0)
+	for _, e := range m.userEmails {
+		if e.UserId == userId {
+			emails = append(emails, e.UserEmail)
+		}
+	}
+	sort.SliceStable(emails, func(i, j int) bool {
+		if !emails[i].CreatedAt.Equal(emails[j].CreatedAt) {
+			return emails[i].CreatedAt.Before(emails[j].CreatedAt)
+		}
+		return emails[i].Email < emails[j].Email
+	})
+	return emails, nil
+}
+
+func (m *Memory) IsEmailVerified(ctx context.Context, userId int, email string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.userEmails {
+		if e.UserId == userId && e.Email == email {
+			return e.VerifiedAt != nil, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) DeleteUserEmail(ctx context.Context, userId int, email string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, e := range m.userEmails {
+		if e.UserId == userId && e.Email == email {
+			m.userEmails = append(m.userEmails[:i:i], m.userEmails[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNoUserEmail
+}
+
+func (m *Memory) CreateAlertRule(ctx context.Context, a AlertRule) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	a.Id = m.nextAlertRuleId
+	m.nextAlertRuleId++
+	m.alertRules = append(m.alertRules, a)
+	return a.Id, nil
+}
+
+// Rules are kept oldest first
+func (m *Memory) filterAlertRules(keep func(a AlertRule) bool) []AlertRule {
+	rules := make([]AlertRule, 0)
+	for _, a := range m.alertRules {
+		if keep(a) {
+			rules = append(rules, a)
+		}
+	}
+	return rules
+}
+
+func (m *Memory) FetchAlertRulesByUserId(ctx context.Context, userId int) ([]AlertRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	rules := m.filterAlertRules(func(a AlertRule) bool { return a.UserId == userId })
+	for i, j := 0, len(rules)-1; i < j; i, j = i+1, j-1 {
+		rules[i], rules[j] = rules[j], rules[i]
+	}
+	return rules, nil
+}
+
+func (m *Memory) FetchAlertRulesByKind(ctx context.Context, kind string) ([]AlertRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.filterAlertRules(func(a AlertRule) bool { return a.Kind == kind }), nil
+}
+
+func (m *Memory) FetchAlertRulesByPortId(ctx context.Context, portId int) ([]AlertRule, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.filterAlertRules(func(a AlertRule) bool { return a.PortId != 0 && a.PortId == portId }), nil
+}
+
+func (m *Memory) FetchAlertTickers(ctx context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	seen := make(map[string]bool)
+	tickers := make([]string, 0)
+	for _, a := range m.alertRules {
+		if a.Ticker != "" && !seen[a.Ticker] {
+			seen[a.Ticker] = true
+			tickers = append(tickers, a.Ticker)
+		}
+	}
+	return tickers, nil
+}
+
+func (m *Memory) ClaimAlertRule(ctx context.Context, a AlertRule, now time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cutoff := now.Add(-time.Duration(a.Cooldown) * time.Second)
+	for i, r := range m.alertRules {
+		if r.Id == a.Id && (r.LastTriggeredAt == nil || !r.LastTriggeredAt.After(cutoff)) {
+			m.alertRules[i].LastTriggeredAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Memory) DeleteAlertRule(ctx context.Context, userId int, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, r := range m.alertRules {
+		if r.Id == id && r.UserId == userId {
+			m.alertRules = append(m.alertRules[:i:i], m.alertRules[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNoAlertRule
+}
+
+func (m *Memory) UpsertDigestSettings(ctx context.Context, s DigestSettings) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.digestSettings[s.UserId]; ok {
+		s.CreatedAt = existing.CreatedAt
+		s.LastSentAt = existing.LastSentAt
+	} else {
+		s.LastSentAt = nil
+	}
+	m.digestSettings[s.UserId] = s
+	return nil
+}
+
+func (m *Memory) FetchDigestSettings(ctx context.Context, userId int) (*DigestSettings, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.digestSettings[userId]
+	if !ok {
+		return nil, ErrNoDigestSettings
+	}
+	return &s, nil
+}
+
+func (m *Memory) FetchAllDigestSettings(ctx context.Context) ([]DigestSettings, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	settings := make([]DigestSettings, 0, len(m.digestSettings))
+	for _, s := range m.digestSettings {
+		settings = append(settings, s)
+	}
+	sort.Slice(settings, func(i, j int) bool {
+		return settings[i].UserId < settings[j].UserId
+	})
+	return settings, nil
+}
+
+func (m *Memory) DeleteDigestSettings(ctx context.Context, userId int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.digestSettings[userId]; !ok {
+		return ErrNoDigestSettings
+	}
+	delete(m.digestSettings, userId)
+	return nil
+}
+
+func (m *Memory) ClaimDigest(ctx context.Context, userId int, slot time.Time, now time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.digestSettings[userId]
+	if !ok || (s.LastSentAt != nil && !s.LastSentAt.Before(slot)) {
+		return false, nil
+	}
+	s.LastSentAt = &now
+	m.digestSettings[userId] = s
+	return true, nil
+}
+
+func (m *Memory) ReleaseDigest(ctx context.Context, userId int, claimedAt time.Time, previous *time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.digestSettings[userId]
+	if !ok || s.LastSentAt == nil || !s.LastSentAt.Equal(claimedAt) {
+		return nil
+	}
+	s.LastSentAt = previous
+	m.digestSettings[userId] = s
+	return nil
+}
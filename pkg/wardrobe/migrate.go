@@ -0,0 +1,306 @@
+package wardrobe
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Migrations live in migrations/ as <version>_<name>.up.sql and <version>_<name>.down.sql, and are applied in
+// version order. Never edit a migration that has shipped, add a new one instead. sqlite gets its own migrations in
+// migrations/sqlite/, starting from a baseline at version 5 - every migration after that needs a sqlite version too.
+//
+//go:embed migrations/*.sql migrations/sqlite/*.sql
+var migrationFiles embed.FS
+
+func migrationsDir() string {
+	if dialect == DialectSQLite {
+		return "migrations/sqlite"
+	}
+	return "migrations"
+}
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Returns every embedded migration for the db's dialect, sorted by version
+func Migrations() ([]Migration, error) {
+	dir := migrationsDir()
+	entries, err := migrationFiles.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		name := e.Name()
+		var direction string
+		if strings.HasSuffix(name, ".up.sql") {
+			direction = "up"
+		} else if strings.HasSuffix(name, ".down.sql") {
+			direction = "down"
+		} else {
+			return nil, fmt.Errorf("invalid migration file name %s", name)
+		}
+		parts := strings.SplitN(strings.TrimSuffix(name, "."+direction+".sql"), "_", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid migration file name %s", name)
+		}
+		version, err := strconv.Atoi(parts[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid version in migration file name %s: %v", name, err)
+		}
+		sql, err := migrationFiles.ReadFile(path.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		m, found := byVersion[version]
+		if !found {
+			m = &Migration{Version: version, Name: parts[1]}
+			byVersion[version] = m
+		} else if m.Name != parts[1] {
+			return nil, fmt.Errorf("migrations %s and %s share version %d", m.Name, parts[1], version)
+		}
+		if direction == "up" {
+			m.Up = string(sql)
+		} else {
+			m.Down = string(sql)
+		}
+	}
+	migrations := make([]Migration, 0)
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s is missing its up or down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Returns the version of the latest embedded migration, aka the schema version this binary expects
+func LatestSchemaVersion() (int, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return 0, err
+	}
+	if len(migrations) == 0 {
+		return 0, nil
+	}
+	return migrations[len(migrations)-1].Version, nil
+}
+
+func createSchemaMigrationsTable(ctx context.Context) error {
+	timestampType := "TIMESTAMPTZ"
+	if dialect == DialectSQLite {
+		timestampType = "TIMESTAMP"
+	}
+	_, err := db.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			name       TEXT NOT NULL,
+			applied_at %s NOT NULL
+		)`, timestampType))
+	return err
+}
+
+// Returns the version of the latest migration applied to the db, or 0 if it's never been migrated
+func SchemaVersion(ctx context.Context) (int, error) {
+	err := createSchemaMigrationsTable(ctx)
+	if err != nil {
+		return 0, err
+	}
+	var version int
+	err = db.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	return version, nil
+}
+
+// Returns every embedded migration along with when it was applied (nil if it hasn't been)
+func FetchMigrationStatuses(ctx context.Context) ([]MigrationStatus, error) {
+	err := createSchemaMigrationsTable(ctx)
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.QueryContext(ctx, `SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		err = rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	statuses := make([]MigrationStatus, 0)
+	for _, m := range migrations {
+		status := MigrationStatus{Migration: m}
+		if appliedAt, found := applied[m.Version]; found {
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Applies every migration after the db's current version, up to and including target. Each migration runs
+// in its own transaction, so a failure leaves the db at the last migration that succeeded.
+func MigrateUp(ctx context.Context, target int) error {
+	current, err := SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.Version <= current || m.Version > target {
+			continue
+		}
+		log.Printf("Applying migration %d_%s", m.Version, m.Name)
+		err = applyMigration(ctx, m.Up, `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+			m.Version, m.Name, time.Now())
+		if err != nil {
+			return fmt.Errorf("error applying migration %d_%s: %v", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// Reverts every applied migration after target, latest first
+func MigrateDown(ctx context.Context, target int) error {
+	current, err := SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version > current || m.Version <= target {
+			continue
+		}
+		log.Printf("Reverting migration %d_%s", m.Version, m.Name)
+		err = applyMigration(ctx, m.Down, `DELETE FROM schema_migrations WHERE version=$1`, m.Version)
+		if err != nil {
+			return fmt.Errorf("error reverting migration %d_%s: %v", m.Version, m.Name, err)
+		}
+	}
+	return nil
+}
+
+// Records every migration after the db's current version, up to and including version, as applied without
+// running them. For adopting a db whose schema was set up before migrations existed, i.e. baselining production
+// at 1.
+func BaselineSchema(ctx context.Context, version int) error {
+	current, err := SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, m := range migrations {
+		found = found || m.Version == version
+	}
+	if !found {
+		return fmt.Errorf("no migration with version %d", version)
+	}
+	if version <= current {
+		return fmt.Errorf("db schema is already at version %d", current)
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if m.Version <= current || m.Version > version {
+			continue
+		}
+		log.Printf("Marking migration %d_%s applied", m.Version, m.Name)
+		_, err = tx.ExecContext(ctx, `INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, $3)`,
+			m.Version, m.Name, time.Now())
+		if err != nil {
+			_ = tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func applyMigration(ctx context.Context, migration string, record string, args ...interface{}) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, migration)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	_, err = tx.ExecContext(ctx, record, args...)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// Makes sure the db has every migration this binary expects. A db that's ahead of us is fine (i.e. mid
+// deploy, migrations must stay backwards compatible), one that's behind is not.
+func checkSchemaVersion(ctx context.Context) error {
+	latest, err := LatestSchemaVersion()
+	if err != nil {
+		return err
+	}
+	current, err := SchemaVersion(ctx)
+	if err != nil {
+		return err
+	}
+	if current == 0 {
+		return fmt.Errorf("db schema is at version 0 but this binary expects %d, run `coattails migrate` (or "+
+			"`coattails migrate baseline 1` first if its tables were created before migrations existed)", latest)
+	}
+	if current < latest {
+		return fmt.Errorf("db schema is at version %d but this binary expects %d, run `coattails migrate`", current, latest)
+	}
+	if current > latest {
+		log.Printf("Warning: db schema is at version %d, newer than the %d this binary knows about", current, latest)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+CREATE TABLE users (
+    id       SERIAL PRIMARY KEY,
+    username TEXT  NOT NULL UNIQUE,
+    password BYTEA NOT NULL
+);
+
+CREATE TABLE tda_accounts (
+    id                   SERIAL PRIMARY KEY,
+    user_id              INTEGER NOT NULL REFERENCES users (id),
+    account_num_hash     BYTEA   NOT NULL,
+    account_num_cipher   TEXT    NOT NULL,
+    refresh_token_cipher TEXT    NOT NULL
+);
+
+CREATE TABLE rh_accounts (
+    id                   SERIAL PRIMARY KEY,
+    user_id              INTEGER NOT NULL REFERENCES users (id),
+    username_hash        BYTEA   NOT NULL,
+    username_cipher      TEXT    NOT NULL,
+    password_cipher      TEXT    NOT NULL,
+    device_token_cipher  TEXT    NOT NULL,
+    refresh_token_cipher TEXT    NOT NULL
+);
+
+CREATE TABLE portfolios (
+    id             SERIAL PRIMARY KEY,
+    user_id        INTEGER NOT NULL REFERENCES users (id),
+    name           TEXT    NOT NULL,
+    type           TEXT    NOT NULL,
+    tda_account_id INTEGER REFERENCES tda_accounts (id),
+    rh_account_id  INTEGER REFERENCES rh_accounts (id)
+);
+CREATE INDEX portfolios_user_id_idx ON portfolios (user_id);
+
+CREATE TABLE stocks (
+    id                     SERIAL PRIMARY KEY,
+    ticker                 TEXT NOT NULL UNIQUE,
+    updated_collections_at TIMESTAMPTZ
+);
+
+CREATE TABLE orders (
+    uid            TEXT PRIMARY KEY,
+    port_id        INTEGER     NOT NULL REFERENCES portfolios (id) ON DELETE CASCADE,
+    stock_id       INTEGER     NOT NULL REFERENCES stocks (id),
+    quantity       NUMERIC     NOT NULL,
+    value          NUMERIC     NOT NULL,
+    is_buy         BOOLEAN     NOT NULL,
+    manually_added BOOLEAN     NOT NULL DEFAULT false,
+    date           TIMESTAMPTZ NOT NULL,
+    committed      BOOLEAN     NOT NULL DEFAULT false
+);
+CREATE INDEX orders_port_id_idx ON orders (port_id);
+
+CREATE TABLE transfers (
+    uid            TEXT PRIMARY KEY,
+    port_id        INTEGER     NOT NULL REFERENCES portfolios (id) ON DELETE CASCADE,
+    amount         NUMERIC     NOT NULL,
+    is_deposit     BOOLEAN     NOT NULL,
+    manually_added BOOLEAN     NOT NULL DEFAULT false,
+    date           TIMESTAMPTZ NOT NULL,
+    committed      BOOLEAN     NOT NULL DEFAULT false
+);
+CREATE INDEX transfers_port_id_idx ON transfers (port_id);
+
+CREATE TABLE positions (
+    port_id  INTEGER NOT NULL REFERENCES portfolios (id) ON DELETE CASCADE,
+    stock_id INTEGER NOT NULL REFERENCES stocks (id),
+    quantity NUMERIC NOT NULL,
+    value    NUMERIC NOT NULL
+);
+CREATE INDEX positions_port_id_idx ON positions (port_id);
+
+CREATE TABLE stock_quotes (
+    stock_id INTEGER NOT NULL REFERENCES stocks (id),
+    price    NUMERIC NOT NULL,
+    date     DATE    NOT NULL,
+    PRIMARY KEY (stock_id, date)
+);
+
+CREATE TABLE portfolio_values (
+    port_id             INTEGER NOT NULL REFERENCES portfolios (id) ON DELETE CASCADE,
+    cash                NUMERIC NOT NULL,
+    stock_value         NUMERIC NOT NULL,
+    daily_net_deposited NUMERIC NOT NULL,
+    normalized_cash     NUMERIC NOT NULL,
+    date                DATE    NOT NULL,
+    cum_change          NUMERIC NOT NULL,
+    daily_change        NUMERIC NOT NULL,
+    PRIMARY KEY (port_id, date)
+);
+
+CREATE TABLE daily_portfolio_values (
+    port_id INTEGER     NOT NULL REFERENCES portfolios (id) ON DELETE CASCADE,
+    date    TIMESTAMPTZ NOT NULL,
+    value   NUMERIC     NOT NULL
+);
+CREATE INDEX daily_portfolio_values_port_id_date_idx ON daily_portfolio_values (port_id, date);
+
+CREATE TABLE collections (
+    id   SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE stock_collections (
+    stock_id      INTEGER NOT NULL REFERENCES stocks (id),
+    collection_id INTEGER NOT NULL REFERENCES collections (id),
+    PRIMARY KEY (stock_id, collection_id)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE orders DROP COLUMN option_event;
+
+DROP TABLE options;
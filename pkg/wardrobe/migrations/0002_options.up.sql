@@ -0,0 +1,10 @@
+CREATE TABLE options (
+    stock_id   INTEGER NOT NULL PRIMARY KEY REFERENCES stocks (id),
+    underlying TEXT    NOT NULL,
+    strike     NUMERIC NOT NULL,
+    expiry     DATE    NOT NULL,
+    put_call   TEXT    NOT NULL,
+    multiplier NUMERIC NOT NULL DEFAULT 100
+);
+
+ALTER TABLE orders ADD COLUMN option_event TEXT;
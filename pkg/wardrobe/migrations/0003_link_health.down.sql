@@ -0,0 +1,11 @@
+ALTER TABLE rh_accounts
+    DROP COLUMN link_status,
+    DROP COLUMN last_success_at,
+    DROP COLUMN last_error_at,
+    DROP COLUMN last_error;
+
+ALTER TABLE tda_accounts
+    DROP COLUMN link_status,
+    DROP COLUMN last_success_at,
+    DROP COLUMN last_error_at,
+    DROP COLUMN last_error;
@@ -0,0 +1,11 @@
+ALTER TABLE tda_accounts
+    ADD COLUMN link_status     TEXT        NOT NULL DEFAULT 'healthy',
+    ADD COLUMN last_success_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN last_error_at   TIMESTAMPTZ,
+    ADD COLUMN last_error      TEXT;
+
+ALTER TABLE rh_accounts
+    ADD COLUMN link_status     TEXT        NOT NULL DEFAULT 'healthy',
+    ADD COLUMN last_success_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN last_error_at   TIMESTAMPTZ,
+    ADD COLUMN last_error      TEXT;
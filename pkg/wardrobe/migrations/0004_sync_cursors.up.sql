@@ -0,0 +1,7 @@
+CREATE TABLE sync_cursors (
+    broker         TEXT    NOT NULL,
+    account_id     INTEGER NOT NULL,
+    kind           TEXT    NOT NULL,
+    synced_through DATE    NOT NULL,
+    PRIMARY KEY (broker, account_id, kind)
+);
@@ -0,0 +1,8 @@
+DROP TABLE audit_log;
+
+-- Soft deleted rows would come back to life without their deleted_at, so really delete them first
+DELETE FROM transfers WHERE deleted_at IS NOT NULL;
+ALTER TABLE transfers DROP COLUMN deleted_at;
+
+DELETE FROM orders WHERE deleted_at IS NOT NULL;
+ALTER TABLE orders DROP COLUMN deleted_at;
@@ -0,0 +1,19 @@
+ALTER TABLE orders
+    ADD COLUMN deleted_at TIMESTAMPTZ;
+
+ALTER TABLE transfers
+    ADD COLUMN deleted_at TIMESTAMPTZ;
+
+CREATE TABLE audit_log (
+    id         SERIAL PRIMARY KEY,
+    user_id    INTEGER REFERENCES users (id),
+    port_id    INTEGER     NOT NULL REFERENCES portfolios (id) ON DELETE CASCADE,
+    entity     TEXT        NOT NULL,
+    entity_id  TEXT        NOT NULL,
+    action     TEXT        NOT NULL,
+    source     TEXT        NOT NULL,
+    before     JSONB,
+    after      JSONB,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX audit_log_port_id_idx ON audit_log (port_id, id);
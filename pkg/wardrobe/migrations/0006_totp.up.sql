@@ -0,0 +1,16 @@
+-- enabled_at is null until the user confirms enrollment with a code
+CREATE TABLE user_totp (
+    user_id        INTEGER     PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret_cipher  BYTEA       NOT NULL,
+    last_used_step BIGINT      NOT NULL DEFAULT 0,
+    enabled_at     TIMESTAMPTZ,
+    created_at     TIMESTAMPTZ NOT NULL
+);
+
+CREATE TABLE user_recovery_codes (
+    id        SERIAL PRIMARY KEY,
+    user_id   INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash BYTEA       NOT NULL,
+    used_at   TIMESTAMPTZ
+);
+CREATE INDEX user_recovery_codes_user_id_idx ON user_recovery_codes (user_id);
@@ -0,0 +1,13 @@
+-- Only a hash of each token is stored, the token itself is shown once when it's created. scopes is space
+-- separated.
+CREATE TABLE api_tokens (
+    id           SERIAL PRIMARY KEY,
+    user_id      INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name         TEXT        NOT NULL,
+    token_hash   BYTEA       NOT NULL UNIQUE,
+    scopes       TEXT        NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL,
+    expires_at   TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ
+);
+CREATE INDEX api_tokens_user_id_idx ON api_tokens (user_id);
@@ -0,0 +1,3 @@
+DROP TABLE household_members;
+DROP TABLE households;
+DROP TABLE portfolio_members;
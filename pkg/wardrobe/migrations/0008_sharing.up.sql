@@ -0,0 +1,28 @@
+-- Users other than the owner that a portfolio is shared with. role is viewer or editor.
+CREATE TABLE portfolio_members (
+    port_id    INTEGER     NOT NULL REFERENCES portfolios (id) ON DELETE CASCADE,
+    user_id    INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role       TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (port_id, user_id)
+);
+CREATE INDEX portfolio_members_user_id_idx ON portfolio_members (user_id);
+
+-- Every member of a household can view every other member's portfolios
+CREATE TABLE households (
+    id         SERIAL PRIMARY KEY,
+    name       TEXT        NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+
+-- role is owner or member. accepted_at is null until the invited user accepts, and pending members can't
+-- see (or be seen by) anyone.
+CREATE TABLE household_members (
+    household_id INTEGER     NOT NULL REFERENCES households (id) ON DELETE CASCADE,
+    user_id      INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    role         TEXT        NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL,
+    accepted_at  TIMESTAMPTZ,
+    PRIMARY KEY (household_id, user_id)
+);
+CREATE INDEX household_members_user_id_idx ON household_members (user_id);
@@ -0,0 +1,21 @@
+-- kind is price_change, portfolio_change or allocation. Price rules watch ticker, the others watch port_id.
+-- threshold is a percent for price_change and allocation, and dollars for portfolio_change. direction is up or
+-- down (allocation rules are always up). channels is space separated, see alerts.Channel.
+CREATE TABLE alert_rules (
+    id                SERIAL PRIMARY KEY,
+    user_id           INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    kind              TEXT        NOT NULL,
+    ticker            TEXT,
+    port_id           INTEGER     REFERENCES portfolios (id) ON DELETE CASCADE,
+    direction         TEXT        NOT NULL,
+    threshold         NUMERIC     NOT NULL,
+    channels          TEXT        NOT NULL,
+    email             TEXT        NOT NULL DEFAULT '',
+    webhook_url       TEXT        NOT NULL DEFAULT '',
+    cooldown_secs     INTEGER     NOT NULL,
+    created_at        TIMESTAMPTZ NOT NULL,
+    last_triggered_at TIMESTAMPTZ
+);
+CREATE INDEX alert_rules_user_id_idx ON alert_rules (user_id);
+CREATE INDEX alert_rules_port_id_idx ON alert_rules (port_id);
+CREATE INDEX alert_rules_ticker_idx ON alert_rules (ticker);
@@ -0,0 +1,30 @@
+-- Endpoints users want portfolio events posted to. secret_cipher is the secret deliveries are signed with,
+-- encrypted like every other secret. event_types is space separated.
+CREATE TABLE webhook_endpoints (
+    id            SERIAL PRIMARY KEY,
+    user_id       INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    url           TEXT        NOT NULL,
+    secret_cipher BYTEA       NOT NULL,
+    event_types   TEXT        NOT NULL,
+    created_at    TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX webhook_endpoints_user_id_idx ON webhook_endpoints (user_id);
+
+-- Every event sent (or still to be sent) to an endpoint. status is pending, succeeded or failed. Redeliveries are
+-- new rows with the same event_id and body.
+CREATE TABLE webhook_deliveries (
+    id              SERIAL PRIMARY KEY,
+    endpoint_id     INTEGER     NOT NULL REFERENCES webhook_endpoints (id) ON DELETE CASCADE,
+    event_id        TEXT        NOT NULL,
+    event_type      TEXT        NOT NULL,
+    body            TEXT        NOT NULL,
+    status          TEXT        NOT NULL,
+    attempts        INTEGER     NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL,
+    last_attempt_at TIMESTAMPTZ,
+    response_status INTEGER,
+    last_error      TEXT        NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX webhook_deliveries_endpoint_id_idx ON webhook_deliveries (endpoint_id);
+CREATE INDEX webhook_deliveries_pending_idx ON webhook_deliveries (next_attempt_at) WHERE status='pending';
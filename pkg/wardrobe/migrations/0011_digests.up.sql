@@ -0,0 +1,11 @@
+-- Users who've opted into emailed digests. frequency is daily or weekly. Digests go out at send_hour (EST), and
+-- weekly ones on send_weekday (0 is sunday). last_sent_at is when the last one went out.
+CREATE TABLE digest_settings (
+    user_id      INTEGER PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    email        TEXT        NOT NULL,
+    frequency    TEXT        NOT NULL,
+    send_hour    INTEGER     NOT NULL,
+    send_weekday INTEGER     NOT NULL DEFAULT 0,
+    created_at   TIMESTAMPTZ NOT NULL,
+    last_sent_at TIMESTAMPTZ
+);
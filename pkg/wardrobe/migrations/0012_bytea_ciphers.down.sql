@@ -0,0 +1,9 @@
+ALTER TABLE tda_accounts
+    ALTER COLUMN account_num_cipher TYPE TEXT USING '\x' || encode(account_num_cipher, 'hex'),
+    ALTER COLUMN refresh_token_cipher TYPE TEXT USING '\x' || encode(refresh_token_cipher, 'hex');
+
+ALTER TABLE rh_accounts
+    ALTER COLUMN username_cipher TYPE TEXT USING '\x' || encode(username_cipher, 'hex'),
+    ALTER COLUMN password_cipher TYPE TEXT USING '\x' || encode(password_cipher, 'hex'),
+    ALTER COLUMN device_token_cipher TYPE TEXT USING '\x' || encode(device_token_cipher, 'hex'),
+    ALTER COLUMN refresh_token_cipher TYPE TEXT USING '\x' || encode(refresh_token_cipher, 'hex');
@@ -0,0 +1,11 @@
+-- The baseline declared these as TEXT, which can't hold the raw ciphertexts secrets.BdcEncrypt returns. Any
+-- value that did get saved is the \x hex text of its bytes, which casting back to bytea undoes.
+ALTER TABLE tda_accounts
+    ALTER COLUMN account_num_cipher TYPE BYTEA USING account_num_cipher::bytea,
+    ALTER COLUMN refresh_token_cipher TYPE BYTEA USING refresh_token_cipher::bytea;
+
+ALTER TABLE rh_accounts
+    ALTER COLUMN username_cipher TYPE BYTEA USING username_cipher::bytea,
+    ALTER COLUMN password_cipher TYPE BYTEA USING password_cipher::bytea,
+    ALTER COLUMN device_token_cipher TYPE BYTEA USING device_token_cipher::bytea,
+    ALTER COLUMN refresh_token_cipher TYPE BYTEA USING refresh_token_cipher::bytea;
@@ -0,0 +1,6 @@
+-- Entries whose portfolio is gone can't satisfy NOT NULL, so they go
+DELETE FROM audit_log WHERE port_id IS NULL;
+ALTER TABLE audit_log
+    ALTER COLUMN port_id SET NOT NULL,
+    DROP CONSTRAINT audit_log_port_id_fkey,
+    ADD CONSTRAINT audit_log_port_id_fkey FOREIGN KEY (port_id) REFERENCES portfolios (id) ON DELETE CASCADE;
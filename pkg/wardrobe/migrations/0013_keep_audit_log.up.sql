@@ -0,0 +1,6 @@
+-- The audit log is append only, so deleting a portfolio mustn't take its history with it. Its entries are kept
+-- with a NULL port_id instead, see wardrobe.FetchAuditLog for who can still read them.
+ALTER TABLE audit_log
+    ALTER COLUMN port_id DROP NOT NULL,
+    DROP CONSTRAINT audit_log_port_id_fkey,
+    ADD CONSTRAINT audit_log_port_id_fkey FOREIGN KEY (port_id) REFERENCES portfolios (id) ON DELETE SET NULL;
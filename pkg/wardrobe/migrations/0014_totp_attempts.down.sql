@@ -0,0 +1,4 @@
+ALTER TABLE user_totp
+    DROP COLUMN attempts,
+    DROP COLUMN attempts_since,
+    DROP COLUMN locked_until;
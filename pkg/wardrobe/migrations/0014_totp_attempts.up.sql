@@ -0,0 +1,6 @@
+-- Codes entered since attempts_since, across every pending login and session, so guessing is capped per user.
+-- locked_until is set once too many are wrong.
+ALTER TABLE user_totp
+    ADD COLUMN attempts       INTEGER NOT NULL DEFAULT 0,
+    ADD COLUMN attempts_since TIMESTAMPTZ,
+    ADD COLUMN locked_until   TIMESTAMPTZ;
@@ -0,0 +1,16 @@
+DROP TABLE audit_log;
+DROP TABLE sync_cursors;
+DROP TABLE options;
+DROP TABLE stock_collections;
+DROP TABLE collections;
+DROP TABLE daily_portfolio_values;
+DROP TABLE portfolio_values;
+DROP TABLE stock_quotes;
+DROP TABLE positions;
+DROP TABLE transfers;
+DROP TABLE orders;
+DROP TABLE stocks;
+DROP TABLE portfolios;
+DROP TABLE rh_accounts;
+DROP TABLE tda_accounts;
+DROP TABLE users;
@@ -0,0 +1,154 @@
+-- sqlite support starts at schema version 5, so this is everything migrations 1 through 5 do on postgres.
+-- Decimals are TEXT so they round trip exactly, and times are TIMESTAMP (not TIMESTAMPTZ) so the driver parses
+-- them back into times.
+
+CREATE TABLE users (
+    id       INTEGER PRIMARY KEY,
+    username TEXT NOT NULL UNIQUE,
+    password BLOB NOT NULL
+);
+
+CREATE TABLE tda_accounts (
+    id                   INTEGER PRIMARY KEY,
+    user_id              INTEGER   NOT NULL REFERENCES users (id),
+    account_num_hash     BLOB      NOT NULL,
+    account_num_cipher   TEXT      NOT NULL,
+    refresh_token_cipher TEXT      NOT NULL,
+    link_status          TEXT      NOT NULL DEFAULT 'healthy',
+    last_success_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error_at        TIMESTAMP,
+    last_error           TEXT
+);
+
+CREATE TABLE rh_accounts (
+    id                   INTEGER PRIMARY KEY,
+    user_id              INTEGER   NOT NULL REFERENCES users (id),
+    username_hash        BLOB      NOT NULL,
+    username_cipher      TEXT      NOT NULL,
+    password_cipher      TEXT      NOT NULL,
+    device_token_cipher  TEXT      NOT NULL,
+    refresh_token_cipher TEXT      NOT NULL,
+    link_status          TEXT      NOT NULL DEFAULT 'healthy',
+    last_success_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_error_at        TIMESTAMP,
+    last_error           TEXT
+);
+
+CREATE TABLE portfolios (
+    id             INTEGER PRIMARY KEY,
+    user_id        INTEGER NOT NULL REFERENCES users (id),
+    name           TEXT    NOT NULL,
+    type           TEXT    NOT NULL,
+    tda_account_id INTEGER REFERENCES tda_accounts (id),
+    rh_account_id  INTEGER REFERENCES rh_accounts (id)
+);
+CREATE INDEX portfolios_user_id_idx ON portfolios (user_id);
+
+CREATE TABLE stocks (
+    id                     INTEGER PRIMARY KEY,
+    ticker                 TEXT NOT NULL UNIQUE,
+    updated_collections_at TIMESTAMP
+);
+
+CREATE TABLE orders (
+    uid            TEXT PRIMARY KEY,
+    port_id        INTEGER   NOT NULL REFERENCES portfolios (id) ON DELETE CASCADE,
+    stock_id       INTEGER   NOT NULL REFERENCES stocks (id),
+    quantity       TEXT      NOT NULL,
+    value          TEXT      NOT NULL,
+    is_buy         BOOLEAN   NOT NULL,
+    manually_added BOOLEAN   NOT NULL DEFAULT false,
+    date           TIMESTAMP NOT NULL,
+    committed      BOOLEAN   NOT NULL DEFAULT false,
+    option_event   TEXT,
+    deleted_at     TIMESTAMP
+);
+CREATE INDEX orders_port_id_idx ON orders (port_id);
+
+CREATE TABLE transfers (
+    uid            TEXT PRIMARY KEY,
+    port_id        INTEGER   NOT NULL REFERENCES portfolios (id) ON DELETE CASCADE,
+    amount         TEXT      NOT NULL,
+    is_deposit     BOOLEAN   NOT NULL,
+    manually_added BOOLEAN   NOT NULL DEFAULT false,
+    date           TIMESTAMP NOT NULL,
+    committed      BOOLEAN   NOT NULL DEFAULT false,
+    deleted_at     TIMESTAMP
+);
+CREATE INDEX transfers_port_id_idx ON transfers (port_id);
+
+CREATE TABLE positions (
+    port_id  INTEGER NOT NULL REFERENCES portfolios (id) ON DELETE CASCADE,
+    stock_id INTEGER NOT NULL REFERENCES stocks (id),
+    quantity TEXT    NOT NULL,
+    value    TEXT    NOT NULL
+);
+CREATE INDEX positions_port_id_idx ON positions (port_id);
+
+CREATE TABLE stock_quotes (
+    stock_id INTEGER NOT NULL REFERENCES stocks (id),
+    price    TEXT    NOT NULL,
+    date     DATE    NOT NULL,
+    PRIMARY KEY (stock_id, date)
+);
+
+CREATE TABLE portfolio_values (
+    port_id             INTEGER NOT NULL REFERENCES portfolios (id) ON DELETE CASCADE,
+    cash                TEXT    NOT NULL,
+    stock_value         TEXT    NOT NULL,
+    daily_net_deposited TEXT    NOT NULL,
+    normalized_cash     TEXT    NOT NULL,
+    date                DATE    NOT NULL,
+    cum_change          TEXT    NOT NULL,
+    daily_change        TEXT    NOT NULL,
+    PRIMARY KEY (port_id, date)
+);
+
+CREATE TABLE daily_portfolio_values (
+    port_id INTEGER   NOT NULL REFERENCES portfolios (id) ON DELETE CASCADE,
+    date    TIMESTAMP NOT NULL,
+    value   TEXT      NOT NULL
+);
+CREATE INDEX daily_portfolio_values_port_id_date_idx ON daily_portfolio_values (port_id, date);
+
+CREATE TABLE collections (
+    id   INTEGER PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE
+);
+
+CREATE TABLE stock_collections (
+    stock_id      INTEGER NOT NULL REFERENCES stocks (id),
+    collection_id INTEGER NOT NULL REFERENCES collections (id),
+    PRIMARY KEY (stock_id, collection_id)
+);
+
+CREATE TABLE options (
+    stock_id   INTEGER NOT NULL PRIMARY KEY REFERENCES stocks (id),
+    underlying TEXT    NOT NULL,
+    strike     TEXT    NOT NULL,
+    expiry     DATE    NOT NULL,
+    put_call   TEXT    NOT NULL,
+    multiplier TEXT    NOT NULL DEFAULT '100'
+);
+
+CREATE TABLE sync_cursors (
+    broker         TEXT    NOT NULL,
+    account_id     INTEGER NOT NULL,
+    kind           TEXT    NOT NULL,
+    synced_through DATE    NOT NULL,
+    PRIMARY KEY (broker, account_id, kind)
+);
+
+CREATE TABLE audit_log (
+    id         INTEGER PRIMARY KEY,
+    user_id    INTEGER REFERENCES users (id),
+    port_id    INTEGER   NOT NULL REFERENCES portfolios (id) ON DELETE CASCADE,
+    entity     TEXT      NOT NULL,
+    entity_id  TEXT      NOT NULL,
+    action     TEXT      NOT NULL,
+    source     TEXT      NOT NULL,
+    before     TEXT,
+    after      TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX audit_log_port_id_idx ON audit_log (port_id, id);
@@ -0,0 +1,16 @@
+-- enabled_at is null until the user confirms enrollment with a code
+CREATE TABLE user_totp (
+    user_id        INTEGER   PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    secret_cipher  BLOB      NOT NULL,
+    last_used_step INTEGER   NOT NULL DEFAULT 0,
+    enabled_at     TIMESTAMP,
+    created_at     TIMESTAMP NOT NULL
+);
+
+CREATE TABLE user_recovery_codes (
+    id        INTEGER   PRIMARY KEY,
+    user_id   INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash BLOB      NOT NULL,
+    used_at   TIMESTAMP
+);
+CREATE INDEX user_recovery_codes_user_id_idx ON user_recovery_codes (user_id);
@@ -0,0 +1,13 @@
+-- Only a hash of each token is stored, the token itself is shown once when it's created. scopes is space
+-- separated.
+CREATE TABLE api_tokens (
+    id           INTEGER   PRIMARY KEY,
+    user_id      INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name         TEXT      NOT NULL,
+    token_hash   BLOB      NOT NULL UNIQUE,
+    scopes       TEXT      NOT NULL,
+    created_at   TIMESTAMP NOT NULL,
+    expires_at   TIMESTAMP,
+    last_used_at TIMESTAMP
+);
+CREATE INDEX api_tokens_user_id_idx ON api_tokens (user_id);
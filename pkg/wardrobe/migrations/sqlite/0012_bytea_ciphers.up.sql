@@ -0,0 +1,2 @@
+-- sqlite keeps blobs as blobs whatever a column is declared as, so only postgres needs converting
+SELECT 1;
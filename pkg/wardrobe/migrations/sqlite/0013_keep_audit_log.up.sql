@@ -0,0 +1,20 @@
+-- The audit log is append only, so deleting a portfolio mustn't take its history with it. sqlite can't alter a
+-- foreign key, so the table is rebuilt with a nullable port_id.
+CREATE TABLE audit_log_new (
+    id         INTEGER PRIMARY KEY,
+    user_id    INTEGER REFERENCES users (id),
+    port_id    INTEGER   REFERENCES portfolios (id) ON DELETE SET NULL,
+    entity     TEXT      NOT NULL,
+    entity_id  TEXT      NOT NULL,
+    action     TEXT      NOT NULL,
+    source     TEXT      NOT NULL,
+    before     TEXT,
+    after      TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+INSERT INTO audit_log_new (id, user_id, port_id, entity, entity_id, action, source, before, after, created_at)
+    SELECT id, user_id, port_id, entity, entity_id, action, source, before, after, created_at
+    FROM audit_log;
+DROP TABLE audit_log;
+ALTER TABLE audit_log_new RENAME TO audit_log;
+CREATE INDEX audit_log_port_id_idx ON audit_log (port_id, id);
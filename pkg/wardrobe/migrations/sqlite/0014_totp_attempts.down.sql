@@ -0,0 +1,3 @@
+ALTER TABLE user_totp DROP COLUMN attempts;
+ALTER TABLE user_totp DROP COLUMN attempts_since;
+ALTER TABLE user_totp DROP COLUMN locked_until;
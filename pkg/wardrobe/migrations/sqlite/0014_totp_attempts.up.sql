@@ -0,0 +1,5 @@
+-- Codes entered since attempts_since, across every pending login and session, so guessing is capped per user.
+-- locked_until is set once too many are wrong.
+ALTER TABLE user_totp ADD COLUMN attempts INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE user_totp ADD COLUMN attempts_since TIMESTAMP;
+ALTER TABLE user_totp ADD COLUMN locked_until TIMESTAMP;
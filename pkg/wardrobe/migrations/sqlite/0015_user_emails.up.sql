@@ -0,0 +1,11 @@
+-- Addresses users have asked to get email at. Nothing is sent to one (besides its code) until verified_at is
+-- set, which happens once the user enters the code we sent it. code_hash is cleared once it's used.
+CREATE TABLE user_emails (
+    user_id         INTEGER   NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email           TEXT      NOT NULL,
+    code_hash       BLOB,
+    code_expires_at TIMESTAMP,
+    verified_at     TIMESTAMP,
+    created_at      TIMESTAMP NOT NULL,
+    PRIMARY KEY (user_id, email)
+);
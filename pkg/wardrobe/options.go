@@ -0,0 +1,126 @@
+package wardrobe
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+const (
+	Call = "CALL"
+	Put  = "PUT"
+)
+
+// Standard number of shares a single equity option contract controls
+var DefaultMultiplier = decimal.NewFromInt(100)
+
+type OptionEvent string
+
+const (
+	OptionOpen       OptionEvent = "open"
+	OptionClose      OptionEvent = "close"
+	OptionExpiration OptionEvent = "expiration"
+	OptionAssignment OptionEvent = "assignment"
+	OptionExercise   OptionEvent = "exercise"
+)
+
+// Returns true if the event removes contracts from the account without any premium changing hands,
+// aka the order is legitimately priced at zero
+func (e OptionEvent) ClosesAtZero() bool {
+	return e == OptionExpiration || e == OptionAssignment || e == OptionExercise
+}
+
+// Option contracts live in the stocks table like any other ticker (so orders and positions can reference
+// them), and this struct holds everything else we need to know to value them.
+type Option struct {
+	Ticker     string          `json:"ticker"`
+	Underlying string          `json:"underlying"`
+	Strike     decimal.Decimal `json:"strike"`
+	Expiry     time.Time       `json:"expiry"`
+	PutCall    string          `json:"put_call"`
+	Multiplier decimal.Decimal `json:"multiplier"`
+}
+
+// Returns the contract multiplier for ticker, or 1 if ticker isn't an option
+func Multiplier(options map[string]Option, ticker string) decimal.Decimal {
+	opt, found := options[ticker]
+	if !found || opt.Multiplier.IsZero() {
+		return decimal.NewFromInt(1)
+	}
+	return opt.Multiplier
+}
+
+func (s *SQL) UpsertOption(ctx context.Context, o Option) error {
+	err := s.UpsertStock(ctx, o.Ticker)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO options (stock_id, underlying, strike, expiry, put_call, multiplier)
+			SELECT s.id, $2, $3, $4, $5, $6
+			FROM stocks s
+			WHERE s.ticker=$1
+		ON CONFLICT (stock_id) DO UPDATE
+		SET underlying=$2, strike=$3, expiry=$4, put_call=$5, multiplier=$6`,
+		o.Ticker, o.Underlying, o.Strike, sqlDate(o.Expiry), o.PutCall, o.Multiplier)
+	return err
+}
+
+// Returns the option contract for ticker, or nil if ticker is a plain stock
+func (s *SQL) FetchOption(ctx context.Context, ticker string) (*Option, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT s.ticker, o.underlying, o.strike, o.expiry, o.put_call, o.multiplier
+		FROM options o
+		JOIN stocks s ON s.id=o.stock_id
+		WHERE s.ticker=$1`, ticker)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, nil
+	}
+	opt, err := scanOption(rows)
+	if err != nil {
+		return nil, err
+	}
+	if rows.Next() {
+		return nil, fmt.Errorf("multiple options found with ticker %s", ticker)
+	}
+	return opt, nil
+}
+
+// Fetches every option contract portId has ever traded, keyed by ticker
+func (s *SQL) FetchOptionsByPortfolioId(ctx context.Context, portId int) (map[string]Option, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT DISTINCT s.ticker, o.underlying, o.strike, o.expiry, o.put_call, o.multiplier
+		FROM options o
+		JOIN stocks s ON s.id=o.stock_id
+		JOIN orders ord ON ord.stock_id=o.stock_id
+		WHERE ord.port_id=$1 AND ord.deleted_at IS NULL`, portId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	options := make(map[string]Option)
+	for rows.Next() {
+		opt, err := scanOption(rows)
+		if err != nil {
+			return nil, err
+		}
+		options[opt.Ticker] = *opt
+	}
+	return options, nil
+}
+
+func scanOption(rows *sql.Rows) (*Option, error) {
+	var o Option
+	err := rows.Scan(&o.Ticker, &o.Underlying, &o.Strike, &o.Expiry, &o.PutCall, &o.Multiplier)
+	if err != nil {
+		return nil, err
+	}
+	return &o, nil
+}
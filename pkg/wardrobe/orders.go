@@ -1,6 +1,7 @@
 package wardrobe
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"time"
@@ -23,16 +24,16 @@ type Order struct {
 	IsBuy         bool            `json:"is_buy"`
 	ManuallyAdded bool            `json:"manually_added"`
 	Date          time.Time       `json:"date"`
+	OptionEvent   OptionEvent     `json:"option_event,omitempty"`
 }
 
-func FetchOrdersByUserId(userId int) ([]Order, error) {
-	rows, err := db.Query(`
-		SELECT o.uid, o.port_id, s.ticker, o.quantity, o.value, o.is_buy, o.manually_added, o.date
+func (s *SQL) FetchOrdersByUserId(ctx context.Context, userId int) ([]Order, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT o.uid, o.port_id, s.ticker, o.quantity, o.value, o.is_buy, o.manually_added, o.date, o.option_event
 		FROM orders o
-		JOIN portfolios p ON p.id=o.port_id
 		JOIN stocks s ON s.id=o.stock_id
-		WHERE p.user_id=$1
-		ORDER BY o.date`, userId)
+		WHERE o.port_id IN (%s) AND o.deleted_at IS NULL
+		ORDER BY o.date`, accessiblePortIdsQuery), userId)
 	if err != nil {
 		return nil, err
 	}
@@ -40,12 +41,12 @@ func FetchOrdersByUserId(userId int) ([]Order, error) {
 }
 
 // TODO refactor this with function above, sharing a ton of similar code
-func FetchOrdersByPortfolioId(portId int) ([]Order, error) {
-	rows, err := db.Query(`
-		SELECT o.uid, o.port_id, s.ticker, o.quantity, o.value, o.is_buy, o.manually_added, o.date
+func (s *SQL) FetchOrdersByPortfolioId(ctx context.Context, portId int) ([]Order, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT o.uid, o.port_id, s.ticker, o.quantity, o.value, o.is_buy, o.manually_added, o.date, o.option_event
 		FROM orders o
 		JOIN stocks s ON s.id=o.stock_id
-		WHERE o.port_id=$1
+		WHERE o.port_id=$1 AND o.deleted_at IS NULL
 		ORDER BY o.date`, portId)
 	if err != nil {
 		return nil, err
@@ -53,12 +54,12 @@ func FetchOrdersByPortfolioId(portId int) ([]Order, error) {
 	return _parseRowOrders(rows)
 }
 
-func FetchZeroPriceOrdersByPortfolioId(portId int) ([]Order, error) {
-	rows, err := db.Query(`
-		SELECT o.uid, o.port_id, s.ticker, o.quantity, o.value, o.is_buy, o.manually_added, o.date
+func (s *SQL) FetchZeroPriceOrdersByPortfolioId(ctx context.Context, portId int) ([]Order, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT o.uid, o.port_id, s.ticker, o.quantity, o.value, o.is_buy, o.manually_added, o.date, o.option_event
 		FROM orders o
 		JOIN stocks s ON s.id=o.stock_id
-		WHERE o.port_id=$1 AND o.value = 0
+		WHERE o.port_id=$1 AND CAST(o.value AS NUMERIC) = 0 AND o.deleted_at IS NULL
 		ORDER BY o.date`, portId)
 	if err != nil {
 		return nil, err
@@ -71,10 +72,14 @@ func _parseRowOrders(rows *sql.Rows) ([]Order, error) {
 	var orders []Order
 	for rows.Next() {
 		var o Order
-		err := rows.Scan(&o.Uid, &o.PortId, &o.Stock, &o.Quantity, &o.Value, &o.IsBuy, &o.ManuallyAdded, &o.Date)
+		var optionEvent sql.NullString
+		err := rows.Scan(&o.Uid, &o.PortId, &o.Stock, &o.Quantity, &o.Value, &o.IsBuy, &o.ManuallyAdded, &o.Date, &optionEvent)
 		if err != nil {
 			return nil, err
 		}
+		if optionEvent.Valid {
+			o.OptionEvent = OptionEvent(optionEvent.String)
+		}
 		orders = append(orders, o)
 	}
 	if orders == nil {
@@ -83,53 +88,147 @@ func _parseRowOrders(rows *sql.Rows) ([]Order, error) {
 	return orders, nil
 }
 
-// Inserts order if uid doesn't exist already
-func InsertIgnoreOrder(o Order) error {
-	_, err := db.Exec(`INSERT INTO stocks (ticker) VALUES ($1) ON CONFLICT (ticker) DO NOTHING`, o.Stock)
-	if err != nil {
-		return err
-	}
-	_, err = db.Exec(`
-		INSERT INTO orders (uid, port_id, stock_id, quantity, value, is_buy, manually_added, date, committed)
-			SELECT $1, $2, stocks.id, $4, $5, $6, $7, $8, false
-			FROM stocks
-			WHERE ticker=$3
-		ON CONFLICT(uid) DO NOTHING`,
-		o.Uid, o.PortId, o.Stock, o.Quantity, o.Value, o.IsBuy, o.ManuallyAdded, o.Date)
-	return err
+// Inserts order if uid doesn't exist already. Soft deleted orders count as existing, so a broker sync won't
+// bring back an order the user deleted.
+func (s *SQL) InsertIgnoreOrder(ctx context.Context, o Order) error {
+	return s.inTx(ctx, func(tx *SQL) error {
+		_, err := tx.db.ExecContext(ctx, `INSERT INTO stocks (ticker) VALUES ($1) ON CONFLICT (ticker) DO NOTHING`, o.Stock)
+		if err != nil {
+			return err
+		}
+		res, err := tx.db.ExecContext(ctx, `
+			INSERT INTO orders (uid, port_id, stock_id, quantity, value, is_buy, manually_added, date, committed, option_event)
+				SELECT $1, $2, stocks.id, $4, $5, $6, $7, $8, false, $9
+				FROM stocks
+				WHERE ticker=$3
+			ON CONFLICT(uid) DO NOTHING`,
+			o.Uid, o.PortId, o.Stock, o.Quantity, o.Value, o.IsBuy, o.ManuallyAdded, o.Date, nullOptionEvent(o.OptionEvent))
+		if err != nil {
+			return err
+		}
+		inserted, err := res.RowsAffected()
+		if err != nil || inserted == 0 {
+			return err
+		}
+		return appendAudit(ctx, tx.db, AuditOrder, o.Uid, o.PortId, AuditCreate, nil, o)
+	})
 }
 
+// Returns ErrOtherPortfolio if o.Uid is another portfolio's order, and ErrDeleted if it's been deleted.
 // WARNING: This should be called VERY carefully.
 // If an automated system calls this function and sets committed to false, we run the risk of having an infinite loop
 // where we continuously upsert and recommit, etc.
-func UpsertOrder(o Order) error {
-	err := UpsertStock(o.Stock)
+func (s *SQL) UpsertOrder(ctx context.Context, o Order) error {
+	return s.inTx(ctx, func(tx *SQL) error {
+		err := tx.UpsertStock(ctx, o.Stock)
+		if err != nil {
+			return err
+		}
+		before, deletedAt, err := tx.fetchOrderForUpdate(ctx, o.Uid)
+		if err != nil {
+			return err
+		}
+		if before != nil && before.PortId != o.PortId {
+			return ErrOtherPortfolio
+		}
+		if deletedAt != nil {
+			return ErrDeleted
+		}
+		_, err = tx.db.ExecContext(ctx, `
+			INSERT INTO orders (uid, port_id, stock_id, quantity, value, is_buy, manually_added, date, committed, option_event)
+				SELECT $1, $2, stocks.id, $4, $5, $6, $7, $8, false, $9
+				FROM stocks
+				WHERE ticker=$3
+			ON CONFLICT(uid) DO UPDATE
+			SET stock_id=excluded.stock_id,quantity=$4,value=$5,is_buy=$6,manually_added=$7,date=$8,committed=false,option_event=$9`,
+			o.Uid, o.PortId, o.Stock, o.Quantity, o.Value, o.IsBuy, o.ManuallyAdded, o.Date, nullOptionEvent(o.OptionEvent))
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return appendAudit(ctx, tx.db, AuditOrder, o.Uid, o.PortId, AuditCreate, nil, o)
+		}
+		return appendAudit(ctx, tx.db, AuditOrder, o.Uid, o.PortId, AuditUpdate, before, o)
+	})
+}
+
+func nullOptionEvent(e OptionEvent) sql.NullString {
+	return sql.NullString{String: string(e), Valid: e != ""}
+}
+
+// Locks and returns the order with uid (deleted or not), along with when it was deleted. Returns nil if there
+// is no such order.
+func (s *SQL) fetchOrderForUpdate(ctx context.Context, uid string) (*Order, *time.Time, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT o.uid, o.port_id, s.ticker, o.quantity, o.value, o.is_buy, o.manually_added, o.date, o.option_event, o.deleted_at
+		FROM orders o
+		JOIN stocks s ON s.id=o.stock_id
+		WHERE o.uid=$1
+		%s`, s.dialect.forUpdate("o")), uid)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
-	_, err = db.Exec(`
-		INSERT INTO orders (uid, port_id, stock_id, quantity, value, is_buy, manually_added, date, committed)
-			SELECT $1, $2, stocks.id, $4, $5, $6, $7, $8, false
-			FROM stocks
-			WHERE ticker=$3
-		ON CONFLICT(uid) DO UPDATE
-		SET port_id=$2,stock_id=excluded.stock_id,quantity=$4,value=$5,is_buy=$6,manually_added=$7,date=$8,committed=false`,
-		o.Uid, o.PortId, o.Stock, o.Quantity, o.Value, o.IsBuy, o.ManuallyAdded, o.Date)
-	return err
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, nil, rows.Err()
+	}
+	var o Order
+	var optionEvent sql.NullString
+	var deletedAt sql.NullTime
+	err = rows.Scan(&o.Uid, &o.PortId, &o.Stock, &o.Quantity, &o.Value, &o.IsBuy, &o.ManuallyAdded, &o.Date, &optionEvent, &deletedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+	o.OptionEvent = OptionEvent(optionEvent.String)
+	if deletedAt.Valid {
+		return &o, &deletedAt.Time, nil
+	}
+	return &o, nil, nil
 }
 
-func DeleteOrder(uid string, portId int) error {
-	_, err := db.Exec(`DELETE FROM orders WHERE uid=$1 AND port_id=$2`, uid, portId)
-	return err
+// Soft deletes the order, it can be brought back with RestoreOrder. The order is marked uncommitted so the
+// portfolio gets reloaded without it.
+func (s *SQL) DeleteOrder(ctx context.Context, uid string, portId int) error {
+	return s.inTx(ctx, func(tx *SQL) error {
+		before, deletedAt, err := tx.fetchOrderForUpdate(ctx, uid)
+		if err != nil {
+			return err
+		}
+		if before == nil || before.PortId != portId || deletedAt != nil {
+			return nil
+		}
+		_, err = tx.db.ExecContext(ctx, `UPDATE orders SET deleted_at=$3, committed=false WHERE uid=$1 AND port_id=$2`, uid, portId, time.Now())
+		if err != nil {
+			return err
+		}
+		return appendAudit(ctx, tx.db, AuditOrder, uid, portId, AuditDelete, before, nil)
+	})
+}
+
+func (s *SQL) RestoreOrder(ctx context.Context, uid string, portId int) error {
+	return s.inTx(ctx, func(tx *SQL) error {
+		o, deletedAt, err := tx.fetchOrderForUpdate(ctx, uid)
+		if err != nil {
+			return err
+		}
+		if o == nil || o.PortId != portId || deletedAt == nil {
+			return fmt.Errorf("order %s in portfolio %d: %w", uid, portId, ErrNotDeleted)
+		}
+		_, err = tx.db.ExecContext(ctx, `UPDATE orders SET deleted_at=NULL, committed=false WHERE uid=$1 AND port_id=$2`, uid, portId)
+		if err != nil {
+			return err
+		}
+		return appendAudit(ctx, tx.db, AuditOrder, uid, portId, AuditRestore, nil, o)
+	})
 }
 
-func SetOrdersCommitted(portId int) error {
-	_, err := db.Exec(`UPDATE orders SET committed=true WHERE port_id=$1`, portId)
+func (s *SQL) SetOrdersCommitted(ctx context.Context, portId int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE orders SET committed=true WHERE port_id=$1`, portId)
 	return err
 }
 
-func HasUncommittedOrders(portId int) (bool, error) {
-	rows, err := db.Query(`SELECT COUNT(*) FROM orders WHERE committed=false AND port_id=$1`, portId)
+func (s *SQL) HasUncommittedOrders(ctx context.Context, portId int) (bool, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT COUNT(*) FROM orders WHERE committed=false AND port_id=$1`, portId)
 	if err != nil {
 		return false, err
 	}
@@ -1,15 +1,15 @@
 package wardrobe
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
+	"strconv"
 	"time"
 
 	"github.com/bluedresscapital/coattails/pkg/util"
 
-	"github.com/lib/pq"
-
 	"github.com/shopspring/decimal"
 )
 
@@ -20,15 +20,27 @@ type Portfolio struct {
 	UserId      int    `json:"user_id"`
 	TDAccountId int    `json:"tda_account_id"`
 	RHAccountId int    `json:"rh_account_id"`
+	// What the user who fetched it can do with it, only set by FetchPortfoliosByUserId
+	Role PortfolioRole `json:"role,omitempty"`
 }
 
-func CreatePortfolio(userId int, name string, portType string) error {
-	_, err := db.Exec("INSERT INTO portfolios (user_id, name, type) VALUES ($1,$2,$3)", userId, name, portType)
-	return err
+func (s *SQL) CreatePortfolio(ctx context.Context, userId int, name string, portType string) error {
+	return s.inTx(ctx, func(tx *SQL) error {
+		port := Portfolio{Name: name, Type: portType, UserId: userId}
+		err := tx.db.QueryRowContext(ctx, "INSERT INTO portfolios (user_id, name, type) VALUES ($1,$2,$3) RETURNING id", userId, name, portType).Scan(&port.Id)
+		if err != nil {
+			return err
+		}
+		return auditPortfolioCreated(ctx, tx.db, port)
+	})
 }
 
-func FetchPortfolioById(id int) (*Portfolio, error) {
-	rows, err := db.Query(`
+func auditPortfolioCreated(ctx context.Context, q dbtx, port Portfolio) error {
+	return appendAudit(ctx, q, AuditPortfolio, strconv.Itoa(port.Id), port.Id, AuditCreate, nil, port)
+}
+
+func (s *SQL) FetchPortfolioById(ctx context.Context, id int) (*Portfolio, error) {
+	rows, err := s.db.QueryContext(ctx, `
 		SELECT id, name, type, user_id, tda_account_id, rh_account_id
 		FROM portfolios WHERE id=$1`, id)
 	if err != nil {
@@ -57,8 +69,8 @@ func FetchPortfolioById(id int) (*Portfolio, error) {
 	return &port, nil
 }
 
-func FetchPortfolioByTDAccountId(tdAccountId int) (*Portfolio, error) {
-	rows, err := db.Query("SELECT id, name, type, user_id FROM portfolios WHERE tda_account_id=$1", tdAccountId)
+func (s *SQL) FetchPortfolioByTDAccountId(ctx context.Context, tdAccountId int) (*Portfolio, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, type, user_id FROM portfolios WHERE tda_account_id=$1", tdAccountId)
 	if err != nil {
 		return nil, err
 	}
@@ -78,8 +90,8 @@ func FetchPortfolioByTDAccountId(tdAccountId int) (*Portfolio, error) {
 	return &port, nil
 }
 
-func FetchPortfolioByRHAccountId(rhAccountId int) (*Portfolio, error) {
-	rows, err := db.Query("SELECT id, name, type, user_id FROM portfolios WHERE rh_account_id=$1", rhAccountId)
+func (s *SQL) FetchPortfolioByRHAccountId(ctx context.Context, rhAccountId int) (*Portfolio, error) {
+	rows, err := s.db.QueryContext(ctx, "SELECT id, name, type, user_id FROM portfolios WHERE rh_account_id=$1", rhAccountId)
 	if err != nil {
 		return nil, err
 	}
@@ -99,8 +111,15 @@ func FetchPortfolioByRHAccountId(rhAccountId int) (*Portfolio, error) {
 	return &port, nil
 }
 
-func FetchPortfoliosByUserId(userId int) ([]Portfolio, error) {
-	rows, err := db.Query("SELECT id, name, type, user_id, tda_account_id, rh_account_id FROM portfolios WHERE user_id=$1", userId)
+// Every portfolio the user can see, whether they own it, it's shared with them, or it belongs to someone in
+// one of their households
+func (s *SQL) FetchPortfoliosByUserId(ctx context.Context, userId int) ([]Portfolio, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT p.id, p.name, p.type, p.user_id, p.tda_account_id, p.rh_account_id, MAX(access.rank)
+		FROM portfolios p
+		JOIN (%s) access ON access.port_id=p.id
+		GROUP BY p.id, p.name, p.type, p.user_id, p.tda_account_id, p.rh_account_id
+		ORDER BY p.id`, portfolioAccessQuery), userId)
 	if err != nil {
 		return nil, err
 	}
@@ -110,7 +129,8 @@ func FetchPortfoliosByUserId(userId int) ([]Portfolio, error) {
 		var port Portfolio
 		var tdAccountId sql.NullInt64
 		var rhAccountId sql.NullInt64
-		err = rows.Scan(&port.Id, &port.Name, &port.Type, &port.UserId, &tdAccountId, &rhAccountId)
+		var rank int
+		err = rows.Scan(&port.Id, &port.Name, &port.Type, &port.UserId, &tdAccountId, &rhAccountId, &rank)
 		if err != nil {
 			return nil, err
 		}
@@ -120,6 +140,7 @@ func FetchPortfoliosByUserId(userId int) ([]Portfolio, error) {
 		if rhAccountId.Valid {
 			port.RHAccountId = int(rhAccountId.Int64)
 		}
+		port.Role = roleFromRank(rank)
 		ports = append(ports, port)
 	}
 	if ports == nil {
@@ -139,58 +160,40 @@ type PortValue struct {
 	DailyChange       decimal.Decimal `json:"daily_change"`
 }
 
-func BulkUpsertPortfolioValuesByPortId(pvs []PortValue, portId int) error {
+// Replaces every portfolio value between the first and last pv's dates. Joins the caller's transaction if
+// there is one, otherwise runs in its own.
+func (s *SQL) BulkUpsertPortfolioValuesByPortId(ctx context.Context, pvs []PortValue, portId int) error {
 	if len(pvs) == 0 {
 		return nil
 	}
-	txn, err := db.Begin()
-	if err != nil {
-		return err
-	}
-	start := pvs[0].Date
-	end := pvs[len(pvs)-1].Date
-	_, err = txn.Exec(`DELETE FROM portfolio_values WHERE port_id =$1 AND date >= $2 AND date <= $3`, portId, start, end)
-	if err != nil {
-		txn.Rollback()
-		return err
-	}
-	stmt, _ := txn.Prepare(pq.CopyIn("portfolio_values", "port_id", "cash", "stock_value", "daily_net_deposited", "normalized_cash", "date", "cum_change", "daily_change"))
-	if err != nil {
-		return err
-	}
-	for _, pv := range pvs {
-		_, err = stmt.Exec(pv.PortId, pv.Cash, pv.StockValue, pv.DailyNetDeposited, pv.NormalizedCash, pv.Date, pv.CumChange, pv.DailyChange)
+	start := sqlDate(pvs[0].Date)
+	end := sqlDate(pvs[len(pvs)-1].Date)
+	return s.inTx(ctx, func(tx *SQL) error {
+		_, err := tx.db.ExecContext(ctx, `DELETE FROM portfolio_values WHERE port_id =$1 AND date >= $2 AND date <= $3`, portId, start, end)
 		if err != nil {
 			return err
 		}
-	}
-	_, err = stmt.Exec()
-	if err != nil {
-		return err
-	}
-	err = stmt.Close()
-	if err != nil {
-		return err
-	}
-	err = txn.Commit()
-	if err != nil {
-		return err
-	}
-	return nil
+		rows := make([][]interface{}, 0)
+		for _, pv := range pvs {
+			rows = append(rows, []interface{}{pv.PortId, pv.Cash, pv.StockValue, pv.DailyNetDeposited, pv.NormalizedCash, sqlDate(pv.Date), pv.CumChange, pv.DailyChange})
+		}
+		columns := []string{"port_id", "cash", "stock_value", "daily_net_deposited", "normalized_cash", "date", "cum_change", "daily_change"}
+		return bulkInsert(ctx, tx.db, tx.dialect, "portfolio_values", columns, rows)
+	})
 }
 
-func UpsertPortfolioValue(pv PortValue) error {
-	_, err := db.Exec(`
+func (s *SQL) UpsertPortfolioValue(ctx context.Context, pv PortValue) error {
+	_, err := s.db.ExecContext(ctx, `
 		INSERT INTO portfolio_values (port_id, cash, stock_value, daily_net_deposited, normalized_cash, date, cum_change, daily_change)
 		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
 		ON CONFLICT (port_id, date) DO UPDATE
 		SET cash=$2, stock_value=$3, daily_net_deposited=$4, normalized_cash=$5, cum_change=$7, daily_change=$8`,
-		pv.PortId, pv.Cash, pv.StockValue, pv.DailyNetDeposited, pv.NormalizedCash, pv.Date, pv.CumChange, pv.DailyChange)
+		pv.PortId, pv.Cash, pv.StockValue, pv.DailyNetDeposited, pv.NormalizedCash, sqlDate(pv.Date), pv.CumChange, pv.DailyChange)
 	return err
 }
 
-func FetchPortfolioValuesByPortId(portId int) ([]PortValue, error) {
-	rows, err := db.Query(`
+func (s *SQL) FetchPortfolioValuesByPortId(ctx context.Context, portId int) ([]PortValue, error) {
+	rows, err := s.db.QueryContext(ctx, `
 		SELECT port_id, cash, stock_value, daily_net_deposited, normalized_cash, date, cum_change, daily_change
 		FROM portfolio_values
 		WHERE port_id=$1
@@ -211,11 +214,11 @@ func FetchPortfolioValuesByPortId(portId int) ([]PortValue, error) {
 	return pvs, nil
 }
 
-func FetchPortfolioValueOnDay(portId int, date time.Time) (*PortValue, error) {
-	rows, err := db.Query(`
+func (s *SQL) FetchPortfolioValueOnDay(ctx context.Context, portId int, date time.Time) (*PortValue, error) {
+	rows, err := s.db.QueryContext(ctx, `
 		SELECT port_id, cash, stock_value, daily_net_deposited, normalized_cash, date, cum_change, daily_change
 		FROM portfolio_values
-		WHERE port_id=$1 AND date=$2`, portId, date)
+		WHERE port_id=$1 AND date=$2`, portId, sqlDate(date))
 	if err != nil {
 		return nil, err
 	}
@@ -231,8 +234,8 @@ func FetchPortfolioValueOnDay(portId int, date time.Time) (*PortValue, error) {
 	return &pv, nil
 }
 
-func FetchAllPortfolioIds() ([]int, error) {
-	rows, err := db.Query(`SELECT id FROM portfolios`)
+func (s *SQL) FetchAllPortfolioIds(ctx context.Context) ([]int, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT id FROM portfolios`)
 	if err != nil {
 		return nil, err
 	}
@@ -252,14 +255,14 @@ type DailyPortVal struct {
 	Value  decimal.Decimal `json:"value"`
 }
 
-func InsertDailyPortValue(dpv DailyPortVal) error {
-	_, err := db.Exec(`INSERT INTO daily_portfolio_values (port_id, date, value) VALUES ($1, $2, $3)`,
+func (s *SQL) InsertDailyPortValue(ctx context.Context, dpv DailyPortVal) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO daily_portfolio_values (port_id, date, value) VALUES ($1, $2, $3)`,
 		dpv.PortId, dpv.Date, dpv.Value)
 	return err
 }
 
-func FetchDailyPortValuesByPortfolioId(portId int) ([]DailyPortVal, error) {
-	rows, err := db.Query(`
+func (s *SQL) FetchDailyPortValuesByPortfolioId(ctx context.Context, portId int) ([]DailyPortVal, error) {
+	rows, err := s.db.QueryContext(ctx, `
 		SELECT port_id, date, value 
 		FROM daily_portfolio_values 
 		WHERE port_id=$1
@@ -280,9 +283,9 @@ func FetchDailyPortValuesByPortfolioId(portId int) ([]DailyPortVal, error) {
 	return ret, nil
 }
 
-func DeletePrevDailyPortValues() error {
+func (s *SQL) DeletePrevDailyPortValues(ctx context.Context) error {
 	now := util.GetTimelessDate(time.Now())
 	log.Printf("Deleting all daily port values < %s", now)
-	_, err := db.Exec(`DELETE FROM daily_portfolio_values WHERE date < $1`, now)
+	_, err := s.db.ExecContext(ctx, `DELETE FROM daily_portfolio_values WHERE date < $1`, now)
 	return err
 }
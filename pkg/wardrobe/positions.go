@@ -1,6 +1,10 @@
 package wardrobe
 
 import (
+	"context"
+	"database/sql"
+	"fmt"
+
 	"github.com/shopspring/decimal"
 )
 
@@ -9,52 +13,61 @@ type Position struct {
 	Quantity decimal.Decimal `json:"quantity"`
 	Value    decimal.Decimal `json:"value"`
 	Stock    string          `json:"stock"`
+	// Only set if the position is an option contract
+	Option *Option `json:"option,omitempty"`
 }
 
-func FetchPositions(userId int) ([]Position, error) {
-	rows, err := db.Query(`
-		SELECT p.port_id, p.quantity, p.value, s.ticker
+func (s *SQL) FetchPositions(ctx context.Context, userId int) ([]Position, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT p.port_id, p.quantity, p.value, s.ticker,
+			o.underlying, o.strike, o.expiry, o.put_call, o.multiplier
 		FROM positions p
-		JOIN portfolios port ON p.port_id=port.id
 		JOIN stocks s ON s.id=p.stock_id
-		WHERE port.user_id=$1
-	`, userId)
+		LEFT JOIN options o ON o.stock_id=p.stock_id
+		WHERE p.port_id IN (%s)
+	`, accessiblePortIdsQuery), userId)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-	var positions []Position
-	for rows.Next() {
-		var p Position
-		err = rows.Scan(&p.PortId, &p.Quantity, &p.Value, &p.Stock)
-		if err != nil {
-			return nil, err
-		}
-		positions = append(positions, p)
-	}
-	if positions == nil {
-		return make([]Position, 0), nil
-	}
-	return positions, nil
+	return _parseRowPositions(rows)
 }
 
-func FetchPortfolioPositions(portId int) ([]Position, error) {
-	rows, err := db.Query(`
-		SELECT p.port_id, p.quantity, p.value, s.ticker
+func (s *SQL) FetchPortfolioPositions(ctx context.Context, portId int) ([]Position, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT p.port_id, p.quantity, p.value, s.ticker,
+			o.underlying, o.strike, o.expiry, o.put_call, o.multiplier
 		FROM positions p
 		JOIN stocks s ON s.id=p.stock_id
+		LEFT JOIN options o ON o.stock_id=p.stock_id
 		WHERE p.port_id=$1`, portId)
 	if err != nil {
 		return nil, err
 	}
+	return _parseRowPositions(rows)
+}
+
+func _parseRowPositions(rows *sql.Rows) ([]Position, error) {
 	defer rows.Close()
 	var positions []Position
 	for rows.Next() {
 		var p Position
-		err = rows.Scan(&p.PortId, &p.Quantity, &p.Value, &p.Stock)
+		var underlying, putCall sql.NullString
+		var strike, multiplier decimal.NullDecimal
+		var expiry sql.NullTime
+		err := rows.Scan(&p.PortId, &p.Quantity, &p.Value, &p.Stock, &underlying, &strike, &expiry, &putCall, &multiplier)
 		if err != nil {
 			return nil, err
 		}
+		if underlying.Valid {
+			p.Option = &Option{
+				Ticker:     p.Stock,
+				Underlying: underlying.String,
+				Strike:     strike.Decimal,
+				Expiry:     expiry.Time,
+				PutCall:    putCall.String,
+				Multiplier: multiplier.Decimal,
+			}
+		}
 		positions = append(positions, p)
 	}
 	if positions == nil {
@@ -63,12 +76,12 @@ func FetchPortfolioPositions(portId int) ([]Position, error) {
 	return positions, nil
 }
 
-func InsertPosition(p Position) error {
-	err := UpsertStock(p.Stock)
+func (s *SQL) InsertPosition(ctx context.Context, p Position) error {
+	err := s.UpsertStock(ctx, p.Stock)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec(`
+	_, err = s.db.ExecContext(ctx, `
 		INSERT INTO positions (port_id, stock_id, quantity, value)
 			SELECT $1, s.id, $3, $4
 			FROM stocks s
@@ -77,17 +90,20 @@ func InsertPosition(p Position) error {
 	return err
 }
 
-func DeletePositions(portId int) error {
-	_, err := db.Exec(`DELETE FROM positions WHERE port_id=$1`, portId)
+func (s *SQL) DeletePositions(ctx context.Context, portId int) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM positions WHERE port_id=$1`, portId)
 	return err
 }
 
-func FetchNonZeroQuantityPositions() ([]string, error) {
-	rows, err := db.Query(`
+// Fetches tickers of every held stock. Option contracts are left out since they aren't priced like stocks,
+// positions.Reload takes care of those.
+func (s *SQL) FetchNonZeroQuantityPositions(ctx context.Context) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, `
 		SELECT s.ticker 
 		FROM positions p 
 		JOIN stocks s ON p.stock_id=s.id
-		WHERE p.quantity != 0
+		LEFT JOIN options o ON o.stock_id=p.stock_id
+		WHERE CAST(p.quantity AS NUMERIC) != 0 AND o.stock_id IS NULL
 	`)
 	if err != nil {
 		return nil, err
@@ -1,21 +1,31 @@
 package wardrobe
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/bluedresscapital/coattails/pkg/secrets"
 )
 
+var (
+	// How long a user has to enter the code robinhood sent them before they have to start linking over
+	PendingRHLinkTtl = 10 * time.Minute
+)
+
 type RHAccount struct {
-	Id         int    `json:"id"`
-	UserId     int    `json:"user_id"`
-	Username   string `json:"username"`
-	Password   string `json:"password"`
-	DeviceTok  string `json:"device_token"`
-	RefreshTok string `json:"refresh_token"`
+	Id         int        `json:"id"`
+	UserId     int        `json:"user_id"`
+	Username   string     `json:"username"`
+	Password   string     `json:"password"`
+	DeviceTok  string     `json:"device_token"`
+	RefreshTok string     `json:"refresh_token"`
+	Link       LinkHealth `json:"link"`
 }
 
-func CreateRHPortfolio(userId int, name string, username string, password string, deviceTok string, refreshTok string) error {
+func CreateRHPortfolio(ctx context.Context, userId int, name string, username string, password string, deviceTok string, refreshTok string) error {
 	usernameHash := secrets.Hash(username)
 	usernameCipher, err := secrets.BdcEncrypt(username)
 	if err != nil {
@@ -33,22 +43,30 @@ func CreateRHPortfolio(userId int, name string, username string, password string
 	if err != nil {
 		return err
 	}
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	_, err = tx.Exec(`
+	port := Portfolio{Name: name, Type: "rh", UserId: userId}
+	err = tx.QueryRowContext(ctx, `
 		INSERT INTO rh_accounts (user_id, username_hash, username_cipher, password_cipher, device_token_cipher, refresh_token_cipher)
 		VALUES ($1, $2, $3, $4, $5, $6)
-	`, userId, usernameHash[:], usernameCipher, passwordCipher, deviceTokCipher, refreshTokCipher)
+		RETURNING id
+	`, userId, usernameHash[:], usernameCipher, passwordCipher, deviceTokCipher, refreshTokCipher).Scan(&port.RHAccountId)
 	if err != nil {
 		_ = tx.Rollback()
 		return err
 	}
-	_, err = tx.Exec(`
+	err = tx.QueryRowContext(ctx, `
 		INSERT INTO portfolios (user_id, name, type, rh_account_id)
-		VALUES ($1, $2, 'rh', currval(pg_get_serial_sequence('rh_accounts', 'id')))
-		`, userId, name)
+		VALUES ($1, $2, 'rh', $3)
+		RETURNING id
+		`, userId, name, port.RHAccountId).Scan(&port.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	err = auditPortfolioCreated(ctx, tx, port)
 	if err != nil {
 		_ = tx.Rollback()
 		return err
@@ -56,17 +74,48 @@ func CreateRHPortfolio(userId int, name string, username string, password string
 	return tx.Commit()
 }
 
-func UpdateRHRefreshToken(id int, refreshTok string) error {
+// Replaces the credentials of an existing rh account, i.e. after the user re-linked it
+func UpdateRHAccount(ctx context.Context, id int, userId int, username string, password string, deviceTok string, refreshTok string) error {
+	usernameHash := secrets.Hash(username)
+	usernameCipher, err := secrets.BdcEncrypt(username)
+	if err != nil {
+		return err
+	}
+	passwordCipher, err := secrets.BdcEncrypt(password)
+	if err != nil {
+		return err
+	}
+	deviceTokCipher, err := secrets.BdcEncrypt(deviceTok)
+	if err != nil {
+		return err
+	}
 	refreshTokCipher, err := secrets.BdcEncrypt(refreshTok)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec(`UPDATE rh_accounts SET refresh_token_cipher=$1 WHERE id=$2`, refreshTokCipher, id)
+	_, err = db.ExecContext(ctx, `
+		UPDATE rh_accounts
+		SET username_hash=$1, username_cipher=$2, password_cipher=$3, device_token_cipher=$4, refresh_token_cipher=$5,
+			link_status=$8, last_success_at=$9
+		WHERE id=$6 AND user_id=$7`,
+		usernameHash[:], usernameCipher, passwordCipher, deviceTokCipher, refreshTokCipher, id, userId, LinkHealthy, time.Now())
 	return err
 }
 
-func FetchRHAccount(id int) (*RHAccount, error) {
-	rows, err := db.Query(`SELECT id, user_id, username_cipher, password_cipher, device_token_cipher, refresh_token_cipher FROM rh_accounts WHERE id=$1`, id)
+func UpdateRHRefreshToken(ctx context.Context, id int, refreshTok string) error {
+	refreshTokCipher, err := secrets.BdcEncrypt(refreshTok)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, `UPDATE rh_accounts SET refresh_token_cipher=$1 WHERE id=$2`, refreshTokCipher, id)
+	return err
+}
+
+func FetchRHAccount(ctx context.Context, id int) (*RHAccount, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, user_id, username_cipher, password_cipher, device_token_cipher, refresh_token_cipher,
+			link_status, last_success_at, last_error_at, last_error
+		FROM rh_accounts WHERE id=$1`, id)
 	if err != nil {
 		return nil, err
 	}
@@ -74,12 +123,40 @@ func FetchRHAccount(id int) (*RHAccount, error) {
 	if !rows.Next() {
 		return nil, fmt.Errorf("no rh account found with id %d", id)
 	}
+	return fetchRHAccountFromRows(rows)
+}
+
+func FetchRHAccountsByUserId(ctx context.Context, userId int) ([]RHAccount, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, user_id, username_cipher, password_cipher, device_token_cipher, refresh_token_cipher,
+			link_status, last_success_at, last_error_at, last_error
+		FROM rh_accounts WHERE user_id=$1`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	accounts := make([]RHAccount, 0)
+	for rows.Next() {
+		acc, err := fetchRHAccountFromRows(rows)
+		if err != nil {
+			return nil, err
+		}
+		accounts = append(accounts, *acc)
+	}
+	return accounts, nil
+}
+
+func fetchRHAccountFromRows(rows *sql.Rows) (*RHAccount, error) {
 	var rhAcc RHAccount
 	var usernameCipher []byte
 	var passwordCipher []byte
 	var deviceTokenCipher []byte
 	var refreshTokenCipher []byte
-	err = rows.Scan(&rhAcc.Id, &rhAcc.UserId, &usernameCipher, &passwordCipher, &deviceTokenCipher, &refreshTokenCipher)
+	var linkStatus string
+	var lastSuccessAt, lastErrorAt sql.NullTime
+	var lastError sql.NullString
+	err := rows.Scan(&rhAcc.Id, &rhAcc.UserId, &usernameCipher, &passwordCipher, &deviceTokenCipher, &refreshTokenCipher,
+		&linkStatus, &lastSuccessAt, &lastErrorAt, &lastError)
 	if err != nil {
 		return nil, err
 	}
@@ -103,18 +180,90 @@ func FetchRHAccount(id int) (*RHAccount, error) {
 	rhAcc.Password = *password
 	rhAcc.DeviceTok = *deviceTok
 	rhAcc.RefreshTok = *refreshTok
+	rhAcc.Link = newLinkHealth(Robinhood, linkStatus, lastSuccessAt, lastErrorAt, lastError)
 	return &rhAcc, nil
 }
 
-func GetStockFromInstrumentId(instrumentId string) (*string, error) {
-	res, err := cache.Get(instrumentId).Result()
+func GetStockFromInstrumentId(ctx context.Context, instrumentId string) (*string, error) {
+	res, err := kv.get(ctx, instrumentId)
 	if err != nil {
 		return nil, err
 	}
-	return &res, nil
+	stock := string(res)
+	return &stock, nil
 }
 
-func SetStockFromInstrument(instrument string, stock string) error {
-	_, err := cache.Set(instrument, stock, 0).Result()
-	return err
+func SetStockFromInstrument(ctx context.Context, instrument string, stock string) error {
+	return kv.set(ctx, instrument, []byte(stock), 0)
+}
+
+// A robinhood login waiting on the user to enter the verification code robinhood sent them
+type PendingRHLink struct {
+	UserId int `json:"user_id"`
+	// Set when re-linking an existing account, otherwise we create a new portfolio named Name
+	AccountId     int    `json:"account_id"`
+	Name          string `json:"name"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	DeviceTok     string `json:"device_token"`
+	ChallengeType string `json:"challenge_type"`
+	ChallengeId   string `json:"challenge_id"`
+}
+
+// Stores link (encrypted, since it holds the user's robinhood password) under token until the user verifies it
+func SetPendingRHLink(ctx context.Context, token string, link PendingRHLink) error {
+	b, err := json.Marshal(link)
+	if err != nil {
+		return err
+	}
+	cipher, err := secrets.BdcEncrypt(string(b))
+	if err != nil {
+		return err
+	}
+	err = kv.set(ctx, pendingRHLinkKey(token), cipher, PendingRHLinkTtl)
+	if err != nil {
+		return err
+	}
+	if link.AccountId != 0 {
+		return kv.set(ctx, pendingRHRelinkKey(link.AccountId), []byte(token), PendingRHLinkTtl)
+	}
+	return nil
+}
+
+func FetchPendingRHLink(ctx context.Context, token string) (*PendingRHLink, error) {
+	cipher, err := kv.get(ctx, pendingRHLinkKey(token))
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := secrets.BdcDecrypt(cipher)
+	if err != nil {
+		return nil, err
+	}
+	var link PendingRHLink
+	err = json.Unmarshal([]byte(*plaintext), &link)
+	if err != nil {
+		return nil, err
+	}
+	return &link, nil
+}
+
+// Returns whether we're already waiting on the user to verify a re-link of rh account accountId
+func HasPendingRHRelink(ctx context.Context, accountId int) (bool, error) {
+	return kv.exists(ctx, pendingRHRelinkKey(accountId))
+}
+
+func ClearPendingRHLink(ctx context.Context, token string, link PendingRHLink) error {
+	keys := []string{pendingRHLinkKey(token)}
+	if link.AccountId != 0 {
+		keys = append(keys, pendingRHRelinkKey(link.AccountId))
+	}
+	return kv.del(ctx, keys...)
+}
+
+func pendingRHLinkKey(token string) string {
+	return fmt.Sprintf("rh_link_%s", token)
+}
+
+func pendingRHRelinkKey(accountId int) string {
+	return fmt.Sprintf("rh_relink_%d", accountId)
 }
@@ -0,0 +1,135 @@
+package wardrobe
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	// How long a session lasts without being used
+	SessionTokenTtl = time.Duration(24*7) * time.Hour
+	// How long a session lasts no matter how often it's used
+	SessionMaxAge = time.Duration(24*30) * time.Hour
+)
+
+// Returned when a session token doesn't map to a live session
+var ErrNoSession = errors.New("no session found for token")
+
+// The session store Default uses, set by InitCache or InitLocalCache
+var sessions SessionStore
+
+// A logged in session. The token the client holds is never stored, sessions are keyed by its SessionId
+// instead, so listing them doesn't hand out anything that can be used to log in.
+type Session struct {
+	Id         string    `json:"id"`
+	UserId     int       `json:"user_id"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	IP         string    `json:"ip"`
+	UserAgent  string    `json:"user_agent"`
+	// Whether the user entered a TOTP code (or recovery code) to get this session
+	TwoFactor bool `json:"two_factor"`
+}
+
+// Returns the id of the session token maps to
+func SessionId(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// LocalSessions keeps sessions in process, for when there's no redis (i.e. running on sqlite). Sessions
+// don't survive a restart, so everyone has to log in again.
+type LocalSessions struct {
+	mu       sync.Mutex
+	sessions map[string]Session
+}
+
+var _ SessionStore = (*LocalSessions)(nil)
+
+func NewLocalSessions() *LocalSessions {
+	return &LocalSessions{sessions: make(map[string]Session)}
+}
+
+// Callers must hold mu
+func (l *LocalSessions) fetchSession(id string) (*Session, error) {
+	session, found := l.sessions[id]
+	if !found || time.Now().After(session.ExpiresAt) {
+		delete(l.sessions, id)
+		return nil, ErrNoSession
+	}
+	return &session, nil
+}
+
+func (l *LocalSessions) CreateSession(ctx context.Context, s Session) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sessions[s.Id] = s
+	return nil
+}
+
+func (l *LocalSessions) FetchSession(ctx context.Context, id string) (*Session, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.fetchSession(id)
+}
+
+func (l *LocalSessions) TouchSession(ctx context.Context, s Session) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// Never brings back a session that's been revoked in the meantime
+	if _, err := l.fetchSession(s.Id); err != nil {
+		return nil
+	}
+	l.sessions[s.Id] = s
+	return nil
+}
+
+func (l *LocalSessions) FetchSessionsByUserId(ctx context.Context, userId int) ([]Session, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ret := make([]Session, 0)
+	for id, s := range l.sessions {
+		if s.UserId != userId {
+			continue
+		}
+		if _, err := l.fetchSession(id); err != nil {
+			continue
+		}
+		ret = append(ret, s)
+	}
+	sortSessions(ret)
+	return ret, nil
+}
+
+func (l *LocalSessions) DeleteSession(ctx context.Context, userId int, id string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if s, found := l.sessions[id]; found && s.UserId == userId {
+		delete(l.sessions, id)
+	}
+	return nil
+}
+
+func (l *LocalSessions) DeleteSessionsByUserId(ctx context.Context, userId int, keepId string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for id, s := range l.sessions {
+		if s.UserId == userId && id != keepId {
+			delete(l.sessions, id)
+		}
+	}
+	return nil
+}
+
+// Most recently used first
+func sortSessions(s []Session) {
+	sort.Slice(s, func(i, j int) bool {
+		return s[i].LastSeenAt.After(s[j].LastSeenAt)
+	})
+}
@@ -0,0 +1,351 @@
+package wardrobe
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+var (
+	// Returned when sharing a portfolio with its owner, or inviting someone to a household they're already in
+	ErrAlreadyMember = errors.New("user is already a member")
+	// Returned when accepting a household invite that doesn't exist (or was already accepted)
+	ErrNoHouseholdInvite = errors.New("no pending household invite found")
+)
+
+// What a user can do with a portfolio. Each role can do everything the roles below it can.
+type PortfolioRole string
+
+const (
+	// Can't see the portfolio at all
+	RoleNone PortfolioRole = ""
+	// Can see everything in the portfolio, but not change any of it
+	RoleViewer PortfolioRole = "viewer"
+	// Can also add, change and delete orders and transfers, and reload the portfolio
+	RoleEditor PortfolioRole = "editor"
+	// Can also share the portfolio, and is the only one whose broker link it syncs with
+	RoleOwner PortfolioRole = "owner"
+)
+
+var roleRanks = map[PortfolioRole]int{RoleViewer: 1, RoleEditor: 2, RoleOwner: 3}
+
+// Whether someone with role r can do what required needs
+func (r PortfolioRole) Includes(required PortfolioRole) bool {
+	return roleRanks[r] > 0 && roleRanks[r] >= roleRanks[required]
+}
+
+// Whether role is one a portfolio can be shared with
+func IsShareableRole(role PortfolioRole) bool {
+	return role == RoleViewer || role == RoleEditor
+}
+
+func roleFromRank(rank int) PortfolioRole {
+	for role, r := range roleRanks {
+		if r == rank {
+			return role
+		}
+	}
+	return RoleNone
+}
+
+// A user (other than the owner) a portfolio is shared with
+type PortfolioMember struct {
+	PortId    int           `json:"port_id"`
+	UserId    int           `json:"user_id"`
+	Role      PortfolioRole `json:"role"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+type HouseholdRole string
+
+const (
+	// Created the household, and is the only one who can invite and remove members
+	HouseholdOwner  HouseholdRole = "owner"
+	HouseholdMember HouseholdRole = "member"
+)
+
+// A group of users who can all view each other's portfolios
+type Household struct {
+	Id        int       `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	// Pending invites included
+	Members []HouseholdUser `json:"members"`
+}
+
+type HouseholdUser struct {
+	HouseholdId int           `json:"household_id"`
+	UserId      int           `json:"user_id"`
+	Role        HouseholdRole `json:"role"`
+	CreatedAt   time.Time     `json:"created_at"`
+	// Nil until the user accepts their invite
+	AcceptedAt *time.Time `json:"accepted_at"`
+}
+
+// Every portfolio $1 can see, with how much they can do with it (as a roleRanks rank). A portfolio can show
+// up more than once (i.e. shared with someone in the owner's household), so callers take the highest rank.
+const portfolioAccessQuery = `
+	SELECT id AS port_id, 3 AS rank FROM portfolios WHERE user_id=$1
+	UNION ALL
+	SELECT port_id, CASE role WHEN 'editor' THEN 2 ELSE 1 END AS rank FROM portfolio_members WHERE user_id=$1
+	UNION ALL
+	SELECT p.id AS port_id, 1 AS rank
+	FROM portfolios p
+	JOIN household_members theirs ON theirs.user_id=p.user_id AND theirs.accepted_at IS NOT NULL
+	JOIN household_members mine ON mine.household_id=theirs.household_id AND mine.accepted_at IS NOT NULL
+	WHERE mine.user_id=$1`
+
+// The ids of every portfolio $1 can see, for filtering by
+var accessiblePortIdsQuery = fmt.Sprintf(`SELECT port_id FROM (%s) access`, portfolioAccessQuery)
+
+func (s *SQL) FetchPortfolioRole(ctx context.Context, portId int, userId int) (PortfolioRole, error) {
+	var rank sql.NullInt64
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT MAX(rank) FROM (%s) access WHERE port_id=$2`, portfolioAccessQuery), userId, portId).Scan(&rank)
+	if err != nil {
+		return RoleNone, err
+	}
+	return roleFromRank(int(rank.Int64)), nil
+}
+
+func (s *SQL) FetchPortfolioUserIds(ctx context.Context, portId int) ([]int, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT user_id FROM portfolios WHERE id=$1
+		UNION
+		SELECT user_id FROM portfolio_members WHERE port_id=$1
+		UNION
+		SELECT mine.user_id
+		FROM portfolios p
+		JOIN household_members theirs ON theirs.user_id=p.user_id AND theirs.accepted_at IS NOT NULL
+		JOIN household_members mine ON mine.household_id=theirs.household_id AND mine.accepted_at IS NOT NULL
+		WHERE p.id=$1
+		ORDER BY user_id`, portId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]int, 0)
+	for rows.Next() {
+		var id int
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+func (s *SQL) FetchPortfolioMembers(ctx context.Context, portId int) ([]PortfolioMember, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT port_id, user_id, role, created_at
+		FROM portfolio_members
+		WHERE port_id=$1
+		ORDER BY created_at`, portId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	members := make([]PortfolioMember, 0)
+	for rows.Next() {
+		var m PortfolioMember
+		err = rows.Scan(&m.PortId, &m.UserId, &m.Role, &m.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+func (s *SQL) UpsertPortfolioMember(ctx context.Context, m PortfolioMember) error {
+	return s.inTx(ctx, func(tx *SQL) error {
+		var ownerId int
+		err := tx.db.QueryRowContext(ctx, `SELECT user_id FROM portfolios WHERE id=$1`, m.PortId).Scan(&ownerId)
+		if err != nil {
+			return err
+		}
+		if ownerId == m.UserId {
+			return ErrAlreadyMember
+		}
+		before, err := tx.fetchPortfolioMemberForUpdate(ctx, m.PortId, m.UserId)
+		if err != nil {
+			return err
+		}
+		if before != nil {
+			m.CreatedAt = before.CreatedAt
+		}
+		_, err = tx.db.ExecContext(ctx, `
+			INSERT INTO portfolio_members (port_id, user_id, role, created_at)
+			VALUES ($1, $2, $3, $4)
+			ON CONFLICT (port_id, user_id) DO UPDATE
+			SET role=$3`, m.PortId, m.UserId, m.Role, m.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return appendAudit(ctx, tx.db, AuditMember, strconv.Itoa(m.UserId), m.PortId, AuditCreate, nil, m)
+		}
+		return appendAudit(ctx, tx.db, AuditMember, strconv.Itoa(m.UserId), m.PortId, AuditUpdate, before, m)
+	})
+}
+
+func (s *SQL) DeletePortfolioMember(ctx context.Context, portId int, userId int) error {
+	return s.inTx(ctx, func(tx *SQL) error {
+		before, err := tx.fetchPortfolioMemberForUpdate(ctx, portId, userId)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return nil
+		}
+		_, err = tx.db.ExecContext(ctx, `DELETE FROM portfolio_members WHERE port_id=$1 AND user_id=$2`, portId, userId)
+		if err != nil {
+			return err
+		}
+		return appendAudit(ctx, tx.db, AuditMember, strconv.Itoa(userId), portId, AuditDelete, before, nil)
+	})
+}
+
+// Returns nil if the portfolio isn't shared with the user
+func (s *SQL) fetchPortfolioMemberForUpdate(ctx context.Context, portId int, userId int) (*PortfolioMember, error) {
+	m := PortfolioMember{PortId: portId, UserId: userId}
+	err := s.db.QueryRowContext(ctx, fmt.Sprintf(`
+		SELECT role, created_at
+		FROM portfolio_members
+		WHERE port_id=$1 AND user_id=$2
+		%s`, s.dialect.forUpdate("")), portId, userId).Scan(&m.Role, &m.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// Creates a household with ownerId as its owner (and only member)
+func (s *SQL) CreateHousehold(ctx context.Context, name string, ownerId int) (int, error) {
+	var id int
+	err := s.inTx(ctx, func(tx *SQL) error {
+		now := time.Now()
+		err := tx.db.QueryRowContext(ctx, `INSERT INTO households (name, created_at) VALUES ($1, $2) RETURNING id`, name, now).Scan(&id)
+		if err != nil {
+			return err
+		}
+		_, err = tx.db.ExecContext(ctx, `
+			INSERT INTO household_members (household_id, user_id, role, created_at, accepted_at)
+			VALUES ($1, $2, $3, $4, $4)`, id, ownerId, HouseholdOwner, now)
+		return err
+	})
+	return id, err
+}
+
+func (s *SQL) FetchHouseholdsByUserId(ctx context.Context, userId int) ([]Household, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT h.id, h.name, h.created_at, m.household_id, m.user_id, m.role, m.created_at, m.accepted_at
+		FROM households h
+		JOIN household_members m ON m.household_id=h.id
+		WHERE h.id IN (SELECT household_id FROM household_members WHERE user_id=$1)
+		ORDER BY h.id, m.created_at`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	households := make([]Household, 0)
+	for rows.Next() {
+		var h Household
+		var m HouseholdUser
+		var acceptedAt sql.NullTime
+		err = rows.Scan(&h.Id, &h.Name, &h.CreatedAt, &m.HouseholdId, &m.UserId, &m.Role, &m.CreatedAt, &acceptedAt)
+		if err != nil {
+			return nil, err
+		}
+		if acceptedAt.Valid {
+			m.AcceptedAt = &acceptedAt.Time
+		}
+		if len(households) == 0 || households[len(households)-1].Id != h.Id {
+			h.Members = make([]HouseholdUser, 0)
+			households = append(households, h)
+		}
+		last := &households[len(households)-1]
+		last.Members = append(last.Members, m)
+	}
+	return households, rows.Err()
+}
+
+// Returns nil if the user isn't in (or invited to) the household
+func (s *SQL) FetchHouseholdUser(ctx context.Context, householdId int, userId int) (*HouseholdUser, error) {
+	m := HouseholdUser{HouseholdId: householdId, UserId: userId}
+	var acceptedAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, `
+		SELECT role, created_at, accepted_at
+		FROM household_members
+		WHERE household_id=$1 AND user_id=$2`, householdId, userId).Scan(&m.Role, &m.CreatedAt, &acceptedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if acceptedAt.Valid {
+		m.AcceptedAt = &acceptedAt.Time
+	}
+	return &m, nil
+}
+
+// Invites the user to the household. They can't see anyone's portfolios (nor anyone theirs) until they accept.
+func (s *SQL) InviteHouseholdMember(ctx context.Context, householdId int, userId int) error {
+	res, err := s.db.ExecContext(ctx, `
+		INSERT INTO household_members (household_id, user_id, role, created_at)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (household_id, user_id) DO NOTHING`, householdId, userId, HouseholdMember, time.Now())
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrAlreadyMember
+	}
+	return nil
+}
+
+func (s *SQL) AcceptHouseholdInvite(ctx context.Context, householdId int, userId int) error {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE household_members SET accepted_at=$3
+		WHERE household_id=$1 AND user_id=$2 AND accepted_at IS NULL`, householdId, userId, time.Now())
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoHouseholdInvite
+	}
+	return nil
+}
+
+// Removes the user from the household, or declines their invite
+func (s *SQL) DeleteHouseholdMember(ctx context.Context, householdId int, userId int) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM household_members WHERE household_id=$1 AND user_id=$2`, householdId, userId)
+	return err
+}
+
+func (s *SQL) DeleteHousehold(ctx context.Context, householdId int) error {
+	return s.inTx(ctx, func(tx *SQL) error {
+		_, err := tx.db.ExecContext(ctx, `DELETE FROM household_members WHERE household_id=$1`, householdId)
+		if err != nil {
+			return err
+		}
+		_, err = tx.db.ExecContext(ctx, `DELETE FROM households WHERE id=$1`, householdId)
+		return err
+	})
+}
@@ -0,0 +1,114 @@
+package wardrobe
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+type sharingTestStore interface {
+	PortfolioStore
+	SharingStore
+}
+
+// Alice (1) owns portfolio 1 and Bob (2) owns portfolio 2. Alice shares hers with Bob as a viewer and with Carol
+// (3) as an editor. Alice's household has Carol and Dave (4) in it, and Erin (5) invited but not accepted. Dave
+// has a second household with Frank (6). Grace (7) has nothing to do with anyone.
+func seedSharing(t *testing.T, store sharingTestStore) {
+	t.Helper()
+	ctx := context.Background()
+	must := func(err error) {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	must(store.CreatePortfolio(ctx, 1, "Alice's", "rh"))
+	must(store.CreatePortfolio(ctx, 2, "Bob's", "rh"))
+	must(store.UpsertPortfolioMember(ctx, PortfolioMember{PortId: 1, UserId: 2, Role: RoleViewer}))
+	must(store.UpsertPortfolioMember(ctx, PortfolioMember{PortId: 1, UserId: 3, Role: RoleEditor}))
+	alices, err := store.CreateHousehold(ctx, "Alice's", 1)
+	must(err)
+	for _, userId := range []int{3, 4, 5} {
+		must(store.InviteHouseholdMember(ctx, alices, userId))
+	}
+	must(store.AcceptHouseholdInvite(ctx, alices, 3))
+	must(store.AcceptHouseholdInvite(ctx, alices, 4))
+	daves, err := store.CreateHousehold(ctx, "Dave's", 4)
+	must(err)
+	must(store.InviteHouseholdMember(ctx, daves, 6))
+	must(store.AcceptHouseholdInvite(ctx, daves, 6))
+}
+
+func TestPortfolioAccess(t *testing.T) {
+	stores := []struct {
+		name string
+		new  func(t *testing.T) sharingTestStore
+	}{
+		{"sqlite", func(t *testing.T) sharingTestStore {
+			store := newTestSQLite(t)
+			for i := 1; i <= 7; i++ {
+				err := CreateUser(context.Background(), fmt.Sprintf("user%d", i), []byte("hash"))
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			return store
+		}},
+		{"memory", func(t *testing.T) sharingTestStore { return NewMemory() }},
+	}
+	roles := []struct {
+		name   string
+		portId int
+		userId int
+		want   PortfolioRole
+	}{
+		{"owner", 1, 1, RoleOwner},
+		{"viewer member", 1, 2, RoleViewer},
+		{"editor member in the owner's household", 1, 3, RoleEditor},
+		{"owner's household", 1, 4, RoleViewer},
+		{"invited to the owner's household", 1, 5, RoleNone},
+		{"household of someone in the owner's household", 1, 6, RoleNone},
+		{"stranger", 1, 7, RoleNone},
+		{"other portfolio's owner", 2, 2, RoleOwner},
+		{"member of another portfolio of the owner's", 2, 1, RoleNone},
+		{"household of a member", 2, 3, RoleNone},
+		{"no such portfolio", 3, 1, RoleNone},
+	}
+	for _, s := range stores {
+		t.Run(s.name, func(t *testing.T) {
+			ctx := context.Background()
+			store := s.new(t)
+			seedSharing(t, store)
+			for _, tt := range roles {
+				t.Run(tt.name, func(t *testing.T) {
+					role, err := store.FetchPortfolioRole(ctx, tt.portId, tt.userId)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if role != tt.want {
+						t.Errorf("expected user %d to be %q on portfolio %d, got %q", tt.userId, tt.want, tt.portId, role)
+					}
+				})
+			}
+
+			userIds, err := store.FetchPortfolioUserIds(ctx, 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := []int{1, 2, 3, 4}; !reflect.DeepEqual(userIds, want) {
+				t.Errorf("expected portfolio 1 to be seen by %v, got %v", want, userIds)
+			}
+
+			// Every portfolio a user sees comes back once, with their highest role
+			ports, err := store.FetchPortfoliosByUserId(ctx, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(ports) != 1 || ports[0].Id != 1 || ports[0].Role != RoleEditor {
+				t.Errorf("expected carol to see portfolio 1 as an editor, got %+v", ports)
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package wardrobe
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log"
+	"time"
+
+	"modernc.org/sqlite"
+)
+
+// sqlite keeps times as text, so two times only compare (and conflict) the way they do in postgres if
+// they're written with the same offset. The driver we register wraps modernc's to write every time in UTC.
+const sqliteDriverName = "coattails-sqlite"
+
+func init() {
+	sql.Register(sqliteDriverName, utcDriver{&sqlite.Driver{}})
+}
+
+// Opens (creating it if need be) the sqlite db at path, and panics if its schema is behind what this
+// binary expects
+func InitSQLite(path string) {
+	ConnectSQLite(path)
+	if err := checkSchemaVersion(context.Background()); err != nil {
+		log.Panic(err)
+	}
+}
+
+// Same as InitSQLite, but skips the schema check. Only meant for running migrations.
+func ConnectSQLite(path string) {
+	var err error
+	db, err = sql.Open(sqliteDriverName, fmt.Sprintf(
+		"file:%s?_time_format=sqlite&_pragma=foreign_keys(1)&_pragma=busy_timeout(10000)&_pragma=journal_mode(WAL)", path))
+	if err != nil {
+		log.Panic(err)
+	}
+	// sqlite only ever runs one write transaction at a time, and errors out (instead of waiting) when two
+	// transactions that both read before writing collide. Using a single connection queues them up instead.
+	db.SetMaxOpenConns(1)
+	if err = db.Ping(); err != nil {
+		log.Panic(err)
+	}
+	dialect = DialectSQLite
+}
+
+type utcDriver struct {
+	driver.Driver
+}
+
+func (d utcDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return utcConn{c.(sqliteConn)}, nil
+}
+
+// Everything modernc's sqlite connections implement
+type sqliteConn interface {
+	driver.Conn
+	driver.ConnBeginTx
+	driver.ConnPrepareContext
+	driver.ExecerContext
+	driver.QueryerContext
+	driver.Pinger
+}
+
+type sqliteStmt interface {
+	driver.Stmt
+	driver.StmtExecContext
+	driver.StmtQueryContext
+}
+
+type utcConn struct {
+	sqliteConn
+}
+
+func (c utcConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c utcConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	s, err := c.sqliteConn.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	return utcStmt{s.(sqliteStmt)}, nil
+}
+
+func (c utcConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.sqliteConn.ExecContext(ctx, query, inUTC(args))
+}
+
+func (c utcConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return c.sqliteConn.QueryContext(ctx, query, inUTC(args))
+}
+
+type utcStmt struct {
+	sqliteStmt
+}
+
+func (s utcStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (driver.Result, error) {
+	return s.sqliteStmt.ExecContext(ctx, inUTC(args))
+}
+
+func (s utcStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (driver.Rows, error) {
+	return s.sqliteStmt.QueryContext(ctx, inUTC(args))
+}
+
+func inUTC(args []driver.NamedValue) []driver.NamedValue {
+	ret := make([]driver.NamedValue, len(args))
+	for i, arg := range args {
+		if t, ok := arg.Value.(time.Time); ok {
+			arg.Value = t.UTC()
+		}
+		ret[i] = arg
+	}
+	return ret
+}
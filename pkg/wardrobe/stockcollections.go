@@ -1,12 +1,12 @@
 package wardrobe
 
 import (
+	"context"
 	"github.com/bluedresscapital/coattails/pkg/util"
-	"github.com/lib/pq"
 )
 
-func FetchStaleStockCollections() ([]string, error) {
-	rows, err := db.Query(`SELECT ticker FROM stocks WHERE updated_collections_at IS NULL`)
+func FetchStaleStockCollections(ctx context.Context) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT ticker FROM stocks WHERE updated_collections_at IS NULL`)
 	if err != nil {
 		return nil, err
 	}
@@ -20,22 +20,28 @@ func FetchStaleStockCollections() ([]string, error) {
 	return tickers, nil
 }
 
-func UpsertCollections(collections []string) (map[string]int, error) {
-	txn, err := db.Begin()
+func UpsertCollections(ctx context.Context, collections []string) (map[string]int, error) {
+	txn, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return nil, err
 	}
 	// Upsert collections, somehow get a list of their ids??
 	collectionIds := make(map[string]int)
-	stmt, _ := txn.Prepare(`
+	stmt, err := txn.PrepareContext(ctx, `
 		INSERT INTO collections (name) 
 		VALUES ($1) 
 		ON CONFLICT(name) DO UPDATE SET name=excluded.name
 		RETURNING id`)
+	if err != nil {
+		txn.Rollback()
+		return nil, err
+	}
 	for _, name := range collections {
 		var id int
-		err := stmt.QueryRow(name).Scan(&id)
+		err := stmt.QueryRowContext(ctx, name).Scan(&id)
 		if err != nil {
+			stmt.Close()
+			txn.Rollback()
 			return nil, err
 		}
 		collectionIds[name] = id
@@ -48,52 +54,39 @@ func UpsertCollections(collections []string) (map[string]int, error) {
 	return collectionIds, nil
 }
 
-func UpsertStockCollections(collectionIds []int, ticker string) error {
+func UpsertStockCollections(ctx context.Context, collectionIds []int, ticker string) error {
 	if len(collectionIds) == 0 {
 		return nil
 	}
-	id, err := FetchStockIdFromTicker(ticker)
-	if err != nil {
-		return err
-	}
-	txn, err := db.Begin()
+	id, err := FetchStockIdFromTicker(ctx, ticker)
 	if err != nil {
 		return err
 	}
-	_, err = txn.Exec(`DELETE FROM stock_collections WHERE stock_id=$1`, *id)
+	txn, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		txn.Rollback()
 		return err
 	}
-	stmt, err := txn.Prepare(pq.CopyIn("stock_collections", "stock_id", "collection_id"))
+	_, err = txn.ExecContext(ctx, `DELETE FROM stock_collections WHERE stock_id=$1`, *id)
 	if err != nil {
 		txn.Rollback()
 		return err
 	}
+	rows := make([][]interface{}, 0)
 	for _, collectionId := range collectionIds {
-		_, err = stmt.Exec(*id, collectionId)
-		if err != nil {
-			txn.Rollback()
-			return err
-		}
-	}
-	_, err = stmt.Exec()
-	if err != nil {
-		txn.Rollback()
-		return err
+		rows = append(rows, []interface{}{*id, collectionId})
 	}
-	err = stmt.Close()
+	err = bulkInsert(ctx, txn, dialect, "stock_collections", []string{"stock_id", "collection_id"}, rows)
 	if err != nil {
 		txn.Rollback()
 		return err
 	}
-	updateStmt, err := txn.Prepare("UPDATE stocks SET updated_collections_at=$1")
+	updateStmt, err := txn.PrepareContext(ctx, "UPDATE stocks SET updated_collections_at=$1")
 	if err != nil {
 		txn.Rollback()
 		return err
 	}
 	now := util.GetTimelessESTOpenNow()
-	_, err = updateStmt.Exec(now)
+	_, err = updateStmt.ExecContext(ctx, now)
 	if err != nil {
 		txn.Rollback()
 		return err
@@ -106,8 +99,8 @@ func UpsertStockCollections(collectionIds []int, ticker string) error {
 	return nil
 }
 
-func FetchCollectionsFromTicker(ticker string) ([]string, error) {
-	rows, err := db.Query(`
+func FetchCollectionsFromTicker(ctx context.Context, ticker string) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
 		SELECT c.name
 		FROM stocks s 
 		JOIN stock_collections sc ON s.id=sc.stock_id
@@ -1,11 +1,10 @@
 package wardrobe
 
 import (
+	"context"
 	"fmt"
 	"time"
 
-	"github.com/lib/pq"
-
 	"github.com/shopspring/decimal"
 )
 
@@ -15,21 +14,21 @@ type StockQuote struct {
 	Date  time.Time       `json:"date"`
 }
 
-func UpsertStockQuotePrice(ticker string, date time.Time, price decimal.Decimal) error {
-	id, err := FetchStockIdFromTicker(ticker)
+func (s *SQL) UpsertStockQuotePrice(ctx context.Context, ticker string, date time.Time, price decimal.Decimal) error {
+	id, err := s.FetchStockIdFromTicker(ctx, ticker)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec(`
+	_, err = s.db.ExecContext(ctx, `
 		INSERT INTO stock_quotes (stock_id, price, date)
 		VALUES ($1, $2, $3)
 		ON CONFLICT (stock_id, date) DO UPDATE
-		SET price=$2`, *id, price, date)
+		SET price=$2`, *id, price, sqlDate(date))
 	return err
 }
 
 //func FetchStaleStockQuotes() {
-//	rows, err := db.Query(`
+//	rows, err := db.QueryContext(ctx, `
 //		SELECT s.ticker
 //		FROM stock_quotes q
 //		JOIN stocks s ON s.id=q.stock_id
@@ -37,56 +36,35 @@ func UpsertStockQuotePrice(ticker string, date time.Time, price decimal.Decimal)
 //	`)
 //}
 
-func BatchUpsertStockQuotes(quotes []StockQuote) error {
+func (s *SQL) BatchUpsertStockQuotes(ctx context.Context, quotes []StockQuote) error {
 	if len(quotes) == 0 {
 		return nil
 	}
-	id, err := FetchStockIdFromTicker(quotes[0].Stock)
-	if err != nil {
-		return err
-	}
-	txn, err := db.Begin()
+	id, err := s.FetchStockIdFromTicker(ctx, quotes[0].Stock)
 	if err != nil {
 		return err
 	}
-	start := quotes[0].Date
-	end := quotes[len(quotes)-1].Date
-	_, err = txn.Exec(`DELETE FROM stock_quotes WHERE stock_id=$1 AND date >= $2 AND date <= $3`, *id, start, end)
-	if err != nil {
-		txn.Rollback()
-		return err
-	}
-	stmt, _ := txn.Prepare(pq.CopyIn("stock_quotes", "stock_id", "price", "date"))
-	if err != nil {
-		return err
-	}
-	for _, q := range quotes {
-		_, err = stmt.Exec(*id, q.Price, q.Date)
+	start := sqlDate(quotes[0].Date)
+	end := sqlDate(quotes[len(quotes)-1].Date)
+	return s.inTx(ctx, func(tx *SQL) error {
+		_, err := tx.db.ExecContext(ctx, `DELETE FROM stock_quotes WHERE stock_id=$1 AND date >= $2 AND date <= $3`, *id, start, end)
 		if err != nil {
 			return err
 		}
-	}
-	_, err = stmt.Exec()
-	if err != nil {
-		return err
-	}
-	err = stmt.Close()
-	if err != nil {
-		return err
-	}
-	err = txn.Commit()
-	if err != nil {
-		return err
-	}
-	return nil
+		rows := make([][]interface{}, 0)
+		for _, q := range quotes {
+			rows = append(rows, []interface{}{*id, q.Price, sqlDate(q.Date)})
+		}
+		return bulkInsert(ctx, tx.db, tx.dialect, "stock_quotes", []string{"stock_id", "price", "date"}, rows)
+	})
 }
 
-func FetchStockQuoteCount(ticker string, start time.Time, end time.Time) (*int, error) {
-	id, err := FetchStockIdFromTicker(ticker)
+func (s *SQL) FetchStockQuoteCount(ctx context.Context, ticker string, start time.Time, end time.Time) (*int, error) {
+	id, err := s.FetchStockIdFromTicker(ctx, ticker)
 	if err != nil {
 		return nil, err
 	}
-	rows, err := db.Query(`SELECT COUNT(*) FROM stock_quotes WHERE stock_id=$1 AND date >= $2 AND date <= $3`, *id, start, end)
+	rows, err := s.db.QueryContext(ctx, `SELECT COUNT(*) FROM stock_quotes WHERE stock_id=$1 AND date >= $2 AND date <= $3`, *id, sqlDate(start), sqlDate(end))
 	if err != nil {
 		return nil, err
 	}
@@ -102,13 +80,13 @@ func FetchStockQuoteCount(ticker string, start time.Time, end time.Time) (*int,
 	return &count, nil
 }
 
-func FetchStockQuotes(ticker string, start time.Time, end time.Time) ([]StockQuote, error) {
-	rows, err := db.Query(`
+func (s *SQL) FetchStockQuotes(ctx context.Context, ticker string, start time.Time, end time.Time) ([]StockQuote, error) {
+	rows, err := s.db.QueryContext(ctx, `
 		SELECT s.ticker, q.price, q.date
 		FROM stock_quotes q
 		JOIN stocks s ON s.id=q.stock_id
 		WHERE s.ticker=$1 AND q.date>=$2 AND q.date <=$3
-		ORDER BY q.date`, ticker, start, end)
+		ORDER BY q.date`, ticker, sqlDate(start), sqlDate(end))
 	if err != nil {
 		return nil, err
 	}
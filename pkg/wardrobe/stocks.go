@@ -1,18 +1,21 @@
 package wardrobe
 
-import "fmt"
+import (
+	"context"
+	"fmt"
+)
 
-func UpsertStock(ticker string) error {
-	_, err := db.Exec(`INSERT INTO stocks (ticker) VALUES ($1) ON CONFLICT (ticker) DO NOTHING`, ticker)
+func (s *SQL) UpsertStock(ctx context.Context, ticker string) error {
+	_, err := s.db.ExecContext(ctx, `INSERT INTO stocks (ticker) VALUES ($1) ON CONFLICT (ticker) DO NOTHING`, ticker)
 	return err
 }
 
-func FetchStockIdFromTicker(ticker string) (*int, error) {
-	err := UpsertStock(ticker)
+func (s *SQL) FetchStockIdFromTicker(ctx context.Context, ticker string) (*int, error) {
+	err := s.UpsertStock(ctx, ticker)
 	if err != nil {
 		return nil, err
 	}
-	rows, err := db.Query(`SELECT id from STOCKS WHERE ticker=$1`, ticker)
+	rows, err := s.db.QueryContext(ctx, `SELECT id from STOCKS WHERE ticker=$1`, ticker)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,262 @@
+package wardrobe
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+	"github.com/shopspring/decimal"
+)
+
+type OrderStore interface {
+	FetchOrdersByUserId(ctx context.Context, userId int) ([]Order, error)
+	FetchOrdersByPortfolioId(ctx context.Context, portId int) ([]Order, error)
+	FetchZeroPriceOrdersByPortfolioId(ctx context.Context, portId int) ([]Order, error)
+	InsertIgnoreOrder(ctx context.Context, o Order) error
+	UpsertOrder(ctx context.Context, o Order) error
+	DeleteOrder(ctx context.Context, uid string, portId int) error
+	RestoreOrder(ctx context.Context, uid string, portId int) error
+	SetOrdersCommitted(ctx context.Context, portId int) error
+	HasUncommittedOrders(ctx context.Context, portId int) (bool, error)
+}
+
+type TransferStore interface {
+	FetchTransfersbyUserId(ctx context.Context, userId int) ([]Transfer, error)
+	FetchTransfersByPortfolioId(ctx context.Context, portId int) ([]Transfer, error)
+	InsertIgnoreTransfer(ctx context.Context, t Transfer) error
+	UpsertTransfer(ctx context.Context, t Transfer) error
+	DeleteTransfer(ctx context.Context, uid string, portId int) error
+	RestoreTransfer(ctx context.Context, uid string, portId int) error
+	SetTransfersCommitted(ctx context.Context, portId int) error
+	HasUncommittedTransfers(ctx context.Context, portId int) (bool, error)
+}
+
+type OptionStore interface {
+	UpsertOption(ctx context.Context, o Option) error
+	FetchOption(ctx context.Context, ticker string) (*Option, error)
+	FetchOptionsByPortfolioId(ctx context.Context, portId int) (map[string]Option, error)
+}
+
+type PositionStore interface {
+	FetchPositions(ctx context.Context, userId int) ([]Position, error)
+	FetchPortfolioPositions(ctx context.Context, portId int) ([]Position, error)
+	InsertPosition(ctx context.Context, p Position) error
+	DeletePositions(ctx context.Context, portId int) error
+	FetchNonZeroQuantityPositions(ctx context.Context) ([]string, error)
+}
+
+type PortfolioStore interface {
+	CreatePortfolio(ctx context.Context, userId int, name string, portType string) error
+	FetchPortfolioById(ctx context.Context, id int) (*Portfolio, error)
+	FetchPortfolioByTDAccountId(ctx context.Context, tdAccountId int) (*Portfolio, error)
+	FetchPortfolioByRHAccountId(ctx context.Context, rhAccountId int) (*Portfolio, error)
+	FetchPortfoliosByUserId(ctx context.Context, userId int) ([]Portfolio, error)
+	FetchAllPortfolioIds(ctx context.Context) ([]int, error)
+	BulkUpsertPortfolioValuesByPortId(ctx context.Context, pvs []PortValue, portId int) error
+	UpsertPortfolioValue(ctx context.Context, pv PortValue) error
+	FetchPortfolioValuesByPortId(ctx context.Context, portId int) ([]PortValue, error)
+	FetchPortfolioValueOnDay(ctx context.Context, portId int, date time.Time) (*PortValue, error)
+	InsertDailyPortValue(ctx context.Context, dpv DailyPortVal) error
+	FetchDailyPortValuesByPortfolioId(ctx context.Context, portId int) ([]DailyPortVal, error)
+	DeletePrevDailyPortValues(ctx context.Context) error
+}
+
+// Who besides a portfolio's owner can see it. Anyone sharing a household can view each other's portfolios,
+// and portfolios can also be shared one by one as viewer or editor.
+type SharingStore interface {
+	// Returns RoleNone if the user can't see the portfolio
+	FetchPortfolioRole(ctx context.Context, portId int, userId int) (PortfolioRole, error)
+	// Everyone who can see the portfolio, its owner included
+	FetchPortfolioUserIds(ctx context.Context, portId int) ([]int, error)
+	FetchPortfolioMembers(ctx context.Context, portId int) ([]PortfolioMember, error)
+	// Shares the portfolio with the member's user, or changes their role if it already is
+	UpsertPortfolioMember(ctx context.Context, m PortfolioMember) error
+	DeletePortfolioMember(ctx context.Context, portId int, userId int) error
+	CreateHousehold(ctx context.Context, name string, ownerId int) (int, error)
+	// Households the user is in or has been invited to, with all of their members
+	FetchHouseholdsByUserId(ctx context.Context, userId int) ([]Household, error)
+	// Returns nil if the user isn't in (or invited to) the household
+	FetchHouseholdUser(ctx context.Context, householdId int, userId int) (*HouseholdUser, error)
+	InviteHouseholdMember(ctx context.Context, householdId int, userId int) error
+	AcceptHouseholdInvite(ctx context.Context, householdId int, userId int) error
+	DeleteHouseholdMember(ctx context.Context, householdId int, userId int) error
+	DeleteHousehold(ctx context.Context, householdId int) error
+}
+
+type QuoteStore interface {
+	UpsertStockQuotePrice(ctx context.Context, ticker string, date time.Time, price decimal.Decimal) error
+	BatchUpsertStockQuotes(ctx context.Context, quotes []StockQuote) error
+	FetchStockQuoteCount(ctx context.Context, ticker string, start time.Time, end time.Time) (*int, error)
+	FetchStockQuotes(ctx context.Context, ticker string, start time.Time, end time.Time) ([]StockQuote, error)
+}
+
+type SessionStore interface {
+	CreateSession(ctx context.Context, s Session) error
+	// Returns ErrNoSession if the session doesn't exist or has expired
+	FetchSession(ctx context.Context, id string) (*Session, error)
+	// Saves the session's last seen time, expiry, ip and user agent, unless it's been deleted
+	TouchSession(ctx context.Context, s Session) error
+	// Most recently used first
+	FetchSessionsByUserId(ctx context.Context, userId int) ([]Session, error)
+	DeleteSession(ctx context.Context, userId int, id string) error
+	// Deletes every one of the user's sessions but keepId's
+	DeleteSessionsByUserId(ctx context.Context, userId int, keepId string) error
+}
+
+// Users' event streams and the pub/sub channels their connections listen on, see socks.PublishToPortfolio
+type StreamStore interface {
+	// Appends payload to stream, returning its id
+	AppendStream(ctx context.Context, stream string, payload []byte) (string, error)
+	Publish(channel string, message []byte) error
+}
+
+// Users' webhook endpoints, and the deliveries queued for them, see webhooks.EmitToPortfolio and
+// webhooks.Dispatcher
+type WebhookStore interface {
+	CreateWebhookEndpoint(ctx context.Context, e WebhookEndpoint) (int, error)
+	FetchWebhookEndpointsByUserId(ctx context.Context, userId int) ([]WebhookEndpoint, error)
+	DeleteWebhookEndpoint(ctx context.Context, userId int, id int) error
+	InsertWebhookDelivery(ctx context.Context, d WebhookDelivery) (int, error)
+	FetchWebhookDeliveries(ctx context.Context, userId int, endpointId int, limit int) ([]WebhookDelivery, error)
+	FetchWebhookDelivery(ctx context.Context, userId int, id int) (*WebhookDelivery, error)
+	FetchDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]DueWebhookDelivery, error)
+	ClaimWebhookDelivery(ctx context.Context, id int, now time.Time, leaseUntil time.Time) (bool, error)
+	RecordWebhookAttempt(ctx context.Context, d WebhookDelivery) error
+	DeleteWebhookDeliveriesBefore(ctx context.Context, cutoff time.Time) error
+}
+
+// Users' TOTP enrollments, their recovery codes, and how many codes they've tried lately
+type TOTPStore interface {
+	// Returns nil if the user hasn't started enrolling
+	FetchUserTOTP(ctx context.Context, userId int) (*UserTOTP, error)
+	HasTOTPEnabled(ctx context.Context, userId int) (bool, error)
+	UpsertPendingTOTP(ctx context.Context, userId int, secret string) error
+	EnableTOTP(ctx context.Context, userId int, step int64, recoveryCodeHashes [][]byte) error
+	UseTOTPStep(ctx context.Context, userId int, step int64) (bool, error)
+	StartTOTPAttempt(ctx context.Context, userId int, now time.Time, windowStart time.Time, maxAttempts int, lockUntil time.Time) (bool, error)
+	ResetTOTPAttempts(ctx context.Context, userId int) error
+	DeleteTOTP(ctx context.Context, userId int) error
+	ReplaceRecoveryCodes(ctx context.Context, userId int, codeHashes [][]byte) error
+	UseRecoveryCode(ctx context.Context, userId int, codeHash []byte) (bool, error)
+	CountUnusedRecoveryCodes(ctx context.Context, userId int) (int, error)
+}
+
+type APITokenStore interface {
+	CreateAPIToken(ctx context.Context, t APIToken, tokenHash []byte) (int, error)
+	// Returns ErrNoAPIToken if there's no token with hash
+	FetchAPITokenByHash(ctx context.Context, tokenHash []byte) (*APIToken, error)
+	FetchAPITokensByUserId(ctx context.Context, userId int) ([]APIToken, error)
+	TouchAPIToken(ctx context.Context, id int, usedAt time.Time) error
+	DeleteAPIToken(ctx context.Context, userId int, id int) error
+}
+
+// Health of users' links to their broker accounts
+type LinkStore interface {
+	MarkLinkHealthy(ctx context.Context, broker Broker, accountId int) error
+	// Returns true if the link was healthy up until now
+	MarkLinkBroken(ctx context.Context, broker Broker, accountId int, linkErr error) (bool, error)
+}
+
+type KeyStore interface {
+	// Re-encrypts every stored ciphertext under the active data key
+	RotateDataKeys(ctx context.Context, dryRun bool) ([]RotatedColumn, error)
+}
+
+// Addresses users want email at, and whether they've verified them
+type EmailStore interface {
+	UpsertEmailCode(ctx context.Context, userId int, email string, codeHash []byte, expiresAt time.Time) error
+	VerifyEmail(ctx context.Context, userId int, email string, codeHash []byte, now time.Time) (bool, error)
+	FetchUserEmails(ctx context.Context, userId int) ([]UserEmail, error)
+	IsEmailVerified(ctx context.Context, userId int, email string) (bool, error)
+	DeleteUserEmail(ctx context.Context, userId int, email string) error
+}
+
+type AlertStore interface {
+	CreateAlertRule(ctx context.Context, a AlertRule) (int, error)
+	FetchAlertRulesByUserId(ctx context.Context, userId int) ([]AlertRule, error)
+	FetchAlertRulesByKind(ctx context.Context, kind string) ([]AlertRule, error)
+	FetchAlertRulesByPortId(ctx context.Context, portId int) ([]AlertRule, error)
+	FetchAlertTickers(ctx context.Context) ([]string, error)
+	// Returns false if the rule already triggered within its cooldown
+	ClaimAlertRule(ctx context.Context, a AlertRule, now time.Time) (bool, error)
+	DeleteAlertRule(ctx context.Context, userId int, id int) error
+}
+
+// Who's opted into digests, and when theirs last went out
+type DigestStore interface {
+	UpsertDigestSettings(ctx context.Context, s DigestSettings) error
+	// Returns ErrNoDigestSettings if the user hasn't opted in
+	FetchDigestSettings(ctx context.Context, userId int) (*DigestSettings, error)
+	FetchAllDigestSettings(ctx context.Context) ([]DigestSettings, error)
+	DeleteDigestSettings(ctx context.Context, userId int) error
+	// Returns false if the digest for slot already went out
+	ClaimDigest(ctx context.Context, userId int, slot time.Time, now time.Time) (bool, error)
+	ReleaseDigest(ctx context.Context, userId int, claimedAt time.Time, previous *time.Time) error
+}
+
+// Store is everything the business packages (orders, transfers, positions, portfolios, diapers) read and
+// write. Packages should ask for the narrowest store they need.
+type Store interface {
+	OrderStore
+	TransferStore
+	OptionStore
+	PositionStore
+	PortfolioStore
+	SharingStore
+	QuoteStore
+	SessionStore
+	AuditStore
+	StreamStore
+	WebhookStore
+	TOTPStore
+	APITokenStore
+	LinkStore
+	KeyStore
+	EmailStore
+	AlertStore
+	DigestStore
+	Transactor
+}
+
+// SQL implements every sql backed store, on either postgres or sqlite
+type SQL struct {
+	db      dbtx
+	dialect Dialect
+}
+
+func NewPostgres(db *sql.DB) *SQL {
+	return &SQL{db: db, dialect: DialectPostgres}
+}
+
+func NewSQLite(db *sql.DB) *SQL {
+	return &SQL{db: db, dialect: DialectSQLite}
+}
+
+// Redis implements every cache backed store
+type Redis struct {
+	client *redis.Client
+}
+
+func NewRedis(client *redis.Client) *Redis {
+	return &Redis{client: client}
+}
+
+// Live is the store the binaries run on. Sessions and event streams live in redis (or in process, when running
+// on sqlite), everything else lives in the db.
+type Live struct {
+	*SQL
+	SessionStore
+}
+
+var _ Store = (*Live)(nil)
+
+// Returns the store backed by the db and cache set up by InitDB (or InitSQLite) and InitCache (or
+// InitLocalCache)
+func Default() Store {
+	return &Live{SQL: defaultSQL(), SessionStore: sessions}
+}
+
+func defaultSQL() *SQL {
+	return &SQL{db: db, dialect: dialect}
+}
@@ -0,0 +1,241 @@
+package wardrobe
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+var (
+	// Most events we keep in each stream
+	EventStreamMaxLen int64 = 1000
+	// How long we keep events in a stream for
+	EventStreamRetention = 24 * time.Hour
+)
+
+var streams eventStreams
+
+// An event in a stream. Ids are <unix ms>-<seq>, like redis stream ids, and increase monotonically within a
+// stream.
+type StreamEvent struct {
+	Id      string
+	Payload []byte
+}
+
+// Events read back from a stream by ReadStream
+type StreamReplay struct {
+	Events []StreamEvent
+	// Set when events after the cursor may have been trimmed (or there were more than we were asked for), in
+	// which case Events is empty and the reader has to start over from scratch
+	Truncated bool
+}
+
+type eventStreams interface {
+	append(ctx context.Context, stream string, payload []byte) (string, error)
+	// Returns up to limit events after the id after, oldest first
+	read(ctx context.Context, stream string, after StreamId, limit int64) (*StreamReplay, error)
+}
+
+// Appends payload to stream, returning its id. Streams are trimmed to EventStreamMaxLen events, and events
+// older than EventStreamRetention.
+func AppendStream(ctx context.Context, stream string, payload []byte) (string, error) {
+	return streams.append(ctx, stream, payload)
+}
+
+func (l *Live) AppendStream(ctx context.Context, stream string, payload []byte) (string, error) {
+	return AppendStream(ctx, stream, payload)
+}
+
+// Returns up to limit events in stream after the event with id after
+func ReadStream(ctx context.Context, stream string, after string, limit int64) (*StreamReplay, error) {
+	id, err := ParseStreamId(after)
+	if err != nil {
+		return nil, err
+	}
+	return streams.read(ctx, stream, *id, limit)
+}
+
+type StreamId struct {
+	Ms  uint64
+	Seq uint64
+}
+
+func ParseStreamId(id string) (*StreamId, error) {
+	parts := strings.SplitN(id, "-", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid stream id %q", id)
+	}
+	ms, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stream id %q", id)
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stream id %q", id)
+	}
+	return &StreamId{Ms: ms, Seq: seq}, nil
+}
+
+func (s StreamId) String() string {
+	return fmt.Sprintf("%d-%d", s.Ms, s.Seq)
+}
+
+func (s StreamId) After(o StreamId) bool {
+	return s.Ms > o.Ms || (s.Ms == o.Ms && s.Seq > o.Seq)
+}
+
+// The smallest id after s
+func (s StreamId) next() StreamId {
+	return StreamId{Ms: s.Ms, Seq: s.Seq + 1}
+}
+
+// The oldest id we still keep events from
+func retentionCutoff(now time.Time) StreamId {
+	return StreamId{Ms: uint64(now.Add(-EventStreamRetention).UnixNano() / int64(time.Millisecond))}
+}
+
+type redisStreams struct {
+	client *redis.Client
+}
+
+func (r redisStreams) append(ctx context.Context, stream string, payload []byte) (string, error) {
+	pipe := r.client.WithContext(ctx).TxPipeline()
+	add := pipe.XAdd(&redis.XAddArgs{
+		Stream:       stream,
+		MaxLenApprox: EventStreamMaxLen,
+		Values:       map[string]interface{}{"payload": payload},
+	})
+	// Nothing reads a stream once it's older than the retention, so let the whole thing go once it's idle
+	pipe.Expire(stream, EventStreamRetention)
+	_, err := pipe.Exec()
+	if err != nil {
+		return "", err
+	}
+	return add.Val(), nil
+}
+
+func (r redisStreams) read(ctx context.Context, stream string, after StreamId, limit int64) (*StreamReplay, error) {
+	client := r.client.WithContext(ctx)
+	cutoff := retentionCutoff(time.Now())
+	if cutoff.After(after) {
+		return &StreamReplay{Truncated: true}, nil
+	}
+	// MAXLEN ~ only ever trims whole nodes, so once a stream is at its max length, anything older than its
+	// oldest event might have been trimmed
+	n, err := client.XLen(stream).Result()
+	if err != nil {
+		return nil, err
+	}
+	if n >= EventStreamMaxLen {
+		oldest, err := client.XRangeN(stream, "-", "+", 1).Result()
+		if err != nil {
+			return nil, err
+		}
+		if len(oldest) > 0 {
+			id, err := ParseStreamId(oldest[0].ID)
+			if err != nil {
+				return nil, err
+			}
+			if id.After(after.next()) {
+				return &StreamReplay{Truncated: true}, nil
+			}
+		}
+	}
+	msgs, err := client.XRangeN(stream, after.next().String(), "+", limit+1).Result()
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(msgs)) > limit {
+		return &StreamReplay{Truncated: true}, nil
+	}
+	replay := StreamReplay{Events: make([]StreamEvent, 0, len(msgs))}
+	for _, msg := range msgs {
+		payload, _ := msg.Values["payload"].(string)
+		replay.Events = append(replay.Events, StreamEvent{Id: msg.ID, Payload: []byte(payload)})
+	}
+	return &replay, nil
+}
+
+type localStreams struct {
+	mu      sync.Mutex
+	streams map[string]*localStream
+}
+
+type localStream struct {
+	events []localStreamEvent
+	last   StreamId
+	// The newest event we've trimmed, anything up to it is gone
+	trimmed StreamId
+}
+
+type localStreamEvent struct {
+	id      StreamId
+	payload []byte
+}
+
+func newLocalStreams() *localStreams {
+	return &localStreams{streams: make(map[string]*localStream)}
+}
+
+func (l *localStreams) append(ctx context.Context, stream string, payload []byte) (string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, ok := l.streams[stream]
+	if !ok {
+		s = &localStream{}
+		l.streams[stream] = s
+	}
+	now := time.Now()
+	id := StreamId{Ms: uint64(now.UnixNano() / int64(time.Millisecond))}
+	// Same as redis, ids never go backwards even if the clock does
+	if !id.After(s.last) {
+		id = s.last.next()
+	}
+	s.last = id
+	s.events = append(s.events, localStreamEvent{id: id, payload: payload})
+	s.trim(now)
+	return id.String(), nil
+}
+
+func (l *localStreams) read(ctx context.Context, stream string, after StreamId, limit int64) (*StreamReplay, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if retentionCutoff(time.Now()).After(after) {
+		return &StreamReplay{Truncated: true}, nil
+	}
+	replay := StreamReplay{Events: make([]StreamEvent, 0)}
+	s, ok := l.streams[stream]
+	if !ok {
+		return &replay, nil
+	}
+	s.trim(time.Now())
+	if s.trimmed.After(after) {
+		return &StreamReplay{Truncated: true}, nil
+	}
+	for _, e := range s.events {
+		if !e.id.After(after) {
+			continue
+		}
+		if int64(len(replay.Events)) == limit {
+			return &StreamReplay{Truncated: true}, nil
+		}
+		replay.Events = append(replay.Events, StreamEvent{Id: e.id.String(), Payload: e.payload})
+	}
+	return &replay, nil
+}
+
+// Drops events past the max length or retention
+func (s *localStream) trim(now time.Time) {
+	cutoff := retentionCutoff(now)
+	drop := 0
+	for drop < len(s.events) && (int64(len(s.events)-drop) > EventStreamMaxLen || cutoff.After(s.events[drop].id)) {
+		s.trimmed = s.events[drop].id
+		drop++
+	}
+	s.events = s.events[drop:]
+}
@@ -0,0 +1,45 @@
+package wardrobe
+
+import (
+	"context"
+	"database/sql"
+	"time"
+)
+
+type SyncKind string
+
+const (
+	OrderSync    SyncKind = "orders"
+	TransferSync SyncKind = "transfers"
+)
+
+// Returns the date we last successfully synced kind through for the broker account, or nil if we've never
+// synced it (aka we need to backfill its entire history)
+func FetchSyncCursor(ctx context.Context, broker Broker, accountId int, kind SyncKind) (*time.Time, error) {
+	var syncedThrough time.Time
+	err := db.QueryRowContext(ctx, `
+		SELECT synced_through FROM sync_cursors
+		WHERE broker=$1 AND account_id=$2 AND kind=$3`, broker, accountId, kind).Scan(&syncedThrough)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &syncedThrough, nil
+}
+
+func UpsertSyncCursor(ctx context.Context, broker Broker, accountId int, kind SyncKind, syncedThrough time.Time) error {
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO sync_cursors (broker, account_id, kind, synced_through)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (broker, account_id, kind) DO UPDATE
+		SET synced_through=$4`, broker, accountId, kind, sqlDate(syncedThrough))
+	return err
+}
+
+// Forgets how far we've synced the broker account, so the next sync backfills everything
+func DeleteSyncCursors(ctx context.Context, broker Broker, accountId int) error {
+	_, err := db.ExecContext(ctx, `DELETE FROM sync_cursors WHERE broker=$1 AND account_id=$2`, broker, accountId)
+	return err
+}
@@ -1,15 +1,17 @@
 package wardrobe
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/bluedresscapital/coattails/pkg/secrets"
 )
 
 // Creates a TD Portfolio - this will insert a tda_accounts object, as well as
 // insert a portfolio object
-func CreateTDPortfolio(userId int, name string, accountNum string, refreshToken string) error {
+func CreateTDPortfolio(ctx context.Context, userId int, name string, accountNum string, refreshToken string) error {
 	refreshCipher, err := secrets.BdcEncrypt(refreshToken)
 	if err != nil {
 		return err
@@ -19,22 +21,30 @@ func CreateTDPortfolio(userId int, name string, accountNum string, refreshToken
 	if err != nil {
 		return err
 	}
-	tx, err := db.Begin()
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
-	_, err = tx.Exec(`
+	port := Portfolio{Name: name, Type: "tda", UserId: userId}
+	err = tx.QueryRowContext(ctx, `
 		INSERT INTO tda_accounts (user_id, account_num_hash, account_num_cipher, refresh_token_cipher)
 		VALUES ($1, $2, $3, $4)
-		`, userId, accountNumHash[:], accountNumCipher, refreshCipher)
+		RETURNING id
+		`, userId, accountNumHash[:], accountNumCipher, refreshCipher).Scan(&port.TDAccountId)
 	if err != nil {
 		_ = tx.Rollback()
 		return err
 	}
-	_, err = tx.Exec(`
+	err = tx.QueryRowContext(ctx, `
 		INSERT INTO portfolios (user_id, name, type, tda_account_id)
-		VALUES ($1, $2, 'tda', currval(pg_get_serial_sequence('tda_accounts', 'id')))
-		`, userId, name)
+		VALUES ($1, $2, 'tda', $3)
+		RETURNING id
+		`, userId, name, port.TDAccountId).Scan(&port.Id)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	err = auditPortfolioCreated(ctx, tx, port)
 	if err != nil {
 		_ = tx.Rollback()
 		return err
@@ -42,7 +52,7 @@ func CreateTDPortfolio(userId int, name string, accountNum string, refreshToken
 	return tx.Commit()
 }
 
-func UpdateTDPortfolio(tdAccountId int, userId int, accountNum string, refreshToken string) error {
+func UpdateTDPortfolio(ctx context.Context, tdAccountId int, userId int, accountNum string, refreshToken string) error {
 	refreshCipher, err := secrets.BdcEncrypt(refreshToken)
 	if err != nil {
 		return err
@@ -52,20 +62,25 @@ func UpdateTDPortfolio(tdAccountId int, userId int, accountNum string, refreshTo
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec(`
+	// The user just re-linked the account, so the link is good as new
+	_, err = db.ExecContext(ctx, `
 	UPDATE tda_accounts 
-	SET account_num_hash=$1, account_num_cipher=$2, refresh_token_cipher=$3
-	WHERE id=$4 AND user_id=$5`, accountNumHash[:], accountNumCipher, refreshCipher, tdAccountId, userId)
-	return err
+	SET account_num_hash=$1, account_num_cipher=$2, refresh_token_cipher=$3, link_status=$6, last_success_at=$7
+	WHERE id=$4 AND user_id=$5`, accountNumHash[:], accountNumCipher, refreshCipher, tdAccountId, userId, LinkHealthy, time.Now())
+	if err != nil {
+		return err
+	}
+	// The account number may have changed, and either way we might've missed history while the link was down
+	return DeleteSyncCursors(ctx, TDA, tdAccountId)
 }
 
 // Updates tda account
-func UpdateRefreshToken(accountId int, refreshToken string) error {
+func UpdateRefreshToken(ctx context.Context, accountId int, refreshToken string) error {
 	refreshCipher, err := secrets.BdcEncrypt(refreshToken)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec(`
+	_, err = db.ExecContext(ctx, `
 		UPDATE tda_accounts
 		SET refresh_token_cipher=$1
 		WHERE id=$2`, refreshCipher, accountId)
@@ -73,14 +88,17 @@ func UpdateRefreshToken(accountId int, refreshToken string) error {
 }
 
 type TDAccount struct {
-	Id           int    `json:"id"`
-	UserId       int    `json:"user_id"`
-	AccountNum   string `json:"account_num"`
-	RefreshToken string `json:"refresh_token"`
+	Id           int        `json:"id"`
+	UserId       int        `json:"user_id"`
+	AccountNum   string     `json:"account_num"`
+	RefreshToken string     `json:"refresh_token"`
+	Link         LinkHealth `json:"link"`
 }
 
-func FetchTDAccount(id int) (*TDAccount, error) {
-	rows, err := db.Query("SELECT id, user_id, account_num_cipher, refresh_token_cipher FROM tda_accounts WHERE id=$1", id)
+func FetchTDAccount(ctx context.Context, id int) (*TDAccount, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, user_id, account_num_cipher, refresh_token_cipher, link_status, last_success_at, last_error_at, last_error
+		FROM tda_accounts WHERE id=$1`, id)
 	if err != nil {
 		return nil, err
 	}
@@ -98,8 +116,10 @@ func FetchTDAccount(id int) (*TDAccount, error) {
 	return acc, nil
 }
 
-func FetchTDAccountsByUserId(userId int) ([]TDAccount, error) {
-	rows, err := db.Query("SELECT id, user_id, account_num_cipher, refresh_token_cipher FROM tda_accounts WHERE user_id=$1", userId)
+func FetchTDAccountsByUserId(ctx context.Context, userId int) ([]TDAccount, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, user_id, account_num_cipher, refresh_token_cipher, link_status, last_success_at, last_error_at, last_error
+		FROM tda_accounts WHERE user_id=$1`, userId)
 	if err != nil {
 		return nil, err
 	}
@@ -121,10 +141,14 @@ func fetchTDAccountFromRows(rows *sql.Rows) (*TDAccount, error) {
 	var td TDAccount
 	var refreshTokenCipher []byte
 	var accountNumCipher []byte
-	err := rows.Scan(&td.Id, &td.UserId, &accountNumCipher, &refreshTokenCipher)
+	var linkStatus string
+	var lastSuccessAt, lastErrorAt sql.NullTime
+	var lastError sql.NullString
+	err := rows.Scan(&td.Id, &td.UserId, &accountNumCipher, &refreshTokenCipher, &linkStatus, &lastSuccessAt, &lastErrorAt, &lastError)
 	if err != nil {
 		return nil, err
 	}
+	td.Link = newLinkHealth(TDA, linkStatus, lastSuccessAt, lastErrorAt, lastError)
 	refreshToken, err := secrets.BdcDecrypt(refreshTokenCipher)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,218 @@
+package wardrobe
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/secrets"
+)
+
+var (
+	// How long a user has to enter their TOTP code after entering their password
+	PendingLoginTtl = 5 * time.Minute
+	// Returned when a pending login token doesn't exist or has expired
+	ErrNoPendingLogin = errors.New("no pending login found for token")
+)
+
+// A user's TOTP enrollment. EnabledAt is nil until they confirm it with a code.
+type UserTOTP struct {
+	UserId int
+	Secret string
+	// The step of the last code we accepted, we never accept it (or an earlier one) again
+	LastUsedStep int64
+	EnabledAt    *time.Time
+}
+
+// Returns the user's TOTP enrollment, or nil if they haven't started one
+func (s *SQL) FetchUserTOTP(ctx context.Context, userId int) (*UserTOTP, error) {
+	t := UserTOTP{UserId: userId}
+	var cipher []byte
+	var enabledAt sql.NullTime
+	err := s.db.QueryRowContext(ctx, `
+		SELECT secret_cipher, last_used_step, enabled_at
+		FROM user_totp
+		WHERE user_id=$1`, userId).Scan(&cipher, &t.LastUsedStep, &enabledAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	secret, err := secrets.BdcDecrypt(cipher)
+	if err != nil {
+		return nil, err
+	}
+	t.Secret = *secret
+	if enabledAt.Valid {
+		t.EnabledAt = &enabledAt.Time
+	}
+	return &t, nil
+}
+
+// Returns whether the user has confirmed TOTP enrollment
+func (s *SQL) HasTOTPEnabled(ctx context.Context, userId int) (bool, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM user_totp WHERE user_id=$1 AND enabled_at IS NOT NULL`, userId).Scan(&n)
+	return n > 0, err
+}
+
+// Starts (or restarts) enrolling the user with secret. Does nothing if they've already confirmed an enrollment.
+func (s *SQL) UpsertPendingTOTP(ctx context.Context, userId int, secret string) error {
+	cipher, err := secrets.BdcEncrypt(secret)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.ExecContext(ctx, `
+		INSERT INTO user_totp (user_id, secret_cipher, created_at)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE
+		SET secret_cipher=$2, last_used_step=0, created_at=$3
+		WHERE user_totp.enabled_at IS NULL`, userId, cipher, time.Now())
+	return err
+}
+
+// Confirms the user's pending enrollment, having accepted a code for step, and replaces their recovery codes
+func (s *SQL) EnableTOTP(ctx context.Context, userId int, step int64, recoveryCodeHashes [][]byte) error {
+	return s.inTx(ctx, func(tx *SQL) error {
+		_, err := tx.db.ExecContext(ctx, `
+			UPDATE user_totp SET enabled_at=$2, last_used_step=$3
+			WHERE user_id=$1 AND enabled_at IS NULL`, userId, time.Now(), step)
+		if err != nil {
+			return err
+		}
+		return tx.replaceRecoveryCodes(ctx, userId, recoveryCodeHashes)
+	})
+}
+
+// Records that we accepted a code for step. Returns false if we'd already accepted a code for it (or a later
+// step), i.e. the code is being replayed.
+func (s *SQL) UseTOTPStep(ctx context.Context, userId int, step int64) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE user_totp SET last_used_step=$2
+		WHERE user_id=$1 AND last_used_step < $2`, userId, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Counts an attempt at entering one of the user's codes, and locks them out until lockUntil if it's the
+// maxAttempts'th since windowStart. Returns false without counting it if they're already locked out. Attempts
+// are counted before the code is checked, so concurrent guesses can't get past maxAttempts.
+func (s *SQL) StartTOTPAttempt(ctx context.Context, userId int, now time.Time, windowStart time.Time, maxAttempts int, lockUntil time.Time) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE user_totp SET
+			attempts=CASE WHEN attempts_since IS NULL OR attempts_since < $3 THEN 1 ELSE attempts + 1 END,
+			attempts_since=CASE WHEN attempts_since IS NULL OR attempts_since < $3 THEN $2 ELSE attempts_since END,
+			locked_until=CASE
+				WHEN (CASE WHEN attempts_since IS NULL OR attempts_since < $3 THEN 1 ELSE attempts + 1 END) >= $4 THEN $5
+				ELSE locked_until
+			END
+		WHERE user_id=$1 AND (locked_until IS NULL OR locked_until <= $2)`,
+		userId, now, windowStart, maxAttempts, lockUntil)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// Forgets the user's attempts at entering codes, once they've entered a right one
+func (s *SQL) ResetTOTPAttempts(ctx context.Context, userId int) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE user_totp SET attempts=0, attempts_since=NULL, locked_until=NULL
+		WHERE user_id=$1`, userId)
+	return err
+}
+
+// Turns TOTP off for the user, along with their recovery codes
+func (s *SQL) DeleteTOTP(ctx context.Context, userId int) error {
+	return s.inTx(ctx, func(tx *SQL) error {
+		_, err := tx.db.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id=$1`, userId)
+		if err != nil {
+			return err
+		}
+		_, err = tx.db.ExecContext(ctx, `DELETE FROM user_totp WHERE user_id=$1`, userId)
+		return err
+	})
+}
+
+func (s *SQL) ReplaceRecoveryCodes(ctx context.Context, userId int, codeHashes [][]byte) error {
+	return s.inTx(ctx, func(tx *SQL) error {
+		return tx.replaceRecoveryCodes(ctx, userId, codeHashes)
+	})
+}
+
+func (s *SQL) replaceRecoveryCodes(ctx context.Context, userId int, codeHashes [][]byte) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM user_recovery_codes WHERE user_id=$1`, userId)
+	if err != nil {
+		return err
+	}
+	for _, h := range codeHashes {
+		_, err = s.db.ExecContext(ctx, `INSERT INTO user_recovery_codes (user_id, code_hash) VALUES ($1, $2)`, userId, h)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Marks the recovery code with hash used. Returns false if the user has no unused code with that hash.
+func (s *SQL) UseRecoveryCode(ctx context.Context, userId int, codeHash []byte) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE user_recovery_codes SET used_at=$3
+		WHERE user_id=$1 AND code_hash=$2 AND used_at IS NULL`, userId, codeHash, time.Now())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+func (s *SQL) CountUnusedRecoveryCodes(ctx context.Context, userId int) (int, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM user_recovery_codes WHERE user_id=$1 AND used_at IS NULL`, userId).Scan(&n)
+	return n, err
+}
+
+// A login that's passed the password check and is waiting on the user's TOTP code
+type PendingLogin struct {
+	UserId int `json:"user_id"`
+}
+
+// Stores login under token until the user enters their code (or PendingLoginTtl passes)
+func SetPendingLogin(ctx context.Context, token string, login PendingLogin) error {
+	b, err := json.Marshal(login)
+	if err != nil {
+		return err
+	}
+	return kv.set(ctx, pendingLoginKey(token), b, PendingLoginTtl)
+}
+
+func FetchPendingLogin(ctx context.Context, token string) (*PendingLogin, error) {
+	b, err := kv.get(ctx, pendingLoginKey(token))
+	if errors.Is(err, ErrCacheMiss) {
+		return nil, ErrNoPendingLogin
+	}
+	if err != nil {
+		return nil, err
+	}
+	var login PendingLogin
+	err = json.Unmarshal(b, &login)
+	if err != nil {
+		return nil, err
+	}
+	return &login, nil
+}
+
+func ClearPendingLogin(ctx context.Context, token string) error {
+	return kv.del(ctx, pendingLoginKey(token))
+}
+
+func pendingLoginKey(token string) string {
+	return "pending_login_" + SessionId(token)
+}
@@ -1,6 +1,8 @@
 package wardrobe
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
 	"time"
 
@@ -16,37 +18,91 @@ type Transfer struct {
 	Date          time.Time       `json:"date"`
 }
 
-// Inserts transfer into db, ignores if uid already exists
-func InsertIgnoreTransfer(t Transfer) error {
-	_, err := db.Exec(`
-		INSERT INTO transfers (uid, port_id, amount, is_deposit, manually_added, date, committed) 
-		VALUES ($1,$2,$3,$4,$5,$6,false)
-		ON CONFLICT (uid) DO NOTHING`,
-		t.Uid, t.PortId, t.Amount.StringFixedBank(4), t.IsDeposit, t.ManuallyAdded, t.Date)
-	return err
+// Inserts transfer into db, ignores if uid already exists (even if it was deleted)
+func (s *SQL) InsertIgnoreTransfer(ctx context.Context, t Transfer) error {
+	return s.inTx(ctx, func(tx *SQL) error {
+		res, err := tx.db.ExecContext(ctx, `
+			INSERT INTO transfers (uid, port_id, amount, is_deposit, manually_added, date, committed) 
+			VALUES ($1,$2,$3,$4,$5,$6,false)
+			ON CONFLICT (uid) DO NOTHING`,
+			t.Uid, t.PortId, t.Amount.StringFixedBank(4), t.IsDeposit, t.ManuallyAdded, t.Date)
+		if err != nil {
+			return err
+		}
+		inserted, err := res.RowsAffected()
+		if err != nil || inserted == 0 {
+			return err
+		}
+		return appendAudit(ctx, tx.db, AuditTransfer, t.Uid, t.PortId, AuditCreate, nil, t)
+	})
 }
 
-// Upserts transfer into db - function is idempotent
+// Upserts transfer into db - function is idempotent. Returns ErrOtherPortfolio if t.Uid is another portfolio's
+// transfer, and ErrDeleted if it's been deleted.
 // WARNING: This should only be called by the manual upsert transfer handler.
 // If an automated system calls this function, we will always have uncommitted orders
 // and we'll be re-running alot of reloading data
-func UpsertTransfer(t Transfer) error {
-	_, err := db.Exec(`
-		INSERT INTO transfers (uid, port_id, amount, is_deposit, manually_added, date, committed) 
-		VALUES ($1,$2,$3,$4,$5,$6, false)
-		ON CONFLICT (uid) DO UPDATE
-		SET port_id=$2,amount=$3,is_deposit=$4,manually_added=$5,date=$6,committed=false`,
-		t.Uid, t.PortId, t.Amount.StringFixedBank(4), t.IsDeposit, t.ManuallyAdded, t.Date)
-	return err
+func (s *SQL) UpsertTransfer(ctx context.Context, t Transfer) error {
+	return s.inTx(ctx, func(tx *SQL) error {
+		before, deletedAt, err := tx.fetchTransferForUpdate(ctx, t.Uid)
+		if err != nil {
+			return err
+		}
+		if before != nil && before.PortId != t.PortId {
+			return ErrOtherPortfolio
+		}
+		if deletedAt != nil {
+			return ErrDeleted
+		}
+		_, err = tx.db.ExecContext(ctx, `
+			INSERT INTO transfers (uid, port_id, amount, is_deposit, manually_added, date, committed) 
+			VALUES ($1,$2,$3,$4,$5,$6, false)
+			ON CONFLICT (uid) DO UPDATE
+			SET amount=$3,is_deposit=$4,manually_added=$5,date=$6,committed=false`,
+			t.Uid, t.PortId, t.Amount.StringFixedBank(4), t.IsDeposit, t.ManuallyAdded, t.Date)
+		if err != nil {
+			return err
+		}
+		if before == nil {
+			return appendAudit(ctx, tx.db, AuditTransfer, t.Uid, t.PortId, AuditCreate, nil, t)
+		}
+		return appendAudit(ctx, tx.db, AuditTransfer, t.Uid, t.PortId, AuditUpdate, before, t)
+	})
 }
 
-func FetchTransfersbyUserId(userId int) ([]Transfer, error) {
-	rows, err := db.Query(`
+// Locks and returns the transfer with uid (deleted or not), along with when it was deleted. Returns nil if
+// there is no such transfer.
+func (s *SQL) fetchTransferForUpdate(ctx context.Context, uid string) (*Transfer, *time.Time, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
+		SELECT uid, port_id, amount, is_deposit, manually_added, date, deleted_at
+		FROM transfers
+		WHERE uid=$1
+		%s`, s.dialect.forUpdate("")), uid)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		return nil, nil, rows.Err()
+	}
+	var t Transfer
+	var deletedAt sql.NullTime
+	err = rows.Scan(&t.Uid, &t.PortId, &t.Amount, &t.IsDeposit, &t.ManuallyAdded, &t.Date, &deletedAt)
+	if err != nil {
+		return nil, nil, err
+	}
+	if deletedAt.Valid {
+		return &t, &deletedAt.Time, nil
+	}
+	return &t, nil, nil
+}
+
+func (s *SQL) FetchTransfersbyUserId(ctx context.Context, userId int) ([]Transfer, error) {
+	rows, err := s.db.QueryContext(ctx, fmt.Sprintf(`
 		SELECT uid, port_id, amount, is_deposit, manually_added, date 
 		FROM transfers t
-		JOIN portfolios p ON t.port_id=p.id
-		WHERE p.user_id=$1
-		ORDER BY date`, userId)
+		WHERE t.port_id IN (%s) AND t.deleted_at IS NULL
+		ORDER BY date`, accessiblePortIdsQuery), userId)
 	if err != nil {
 		return nil, err
 	}
@@ -66,11 +122,11 @@ func FetchTransfersbyUserId(userId int) ([]Transfer, error) {
 	return transfers, nil
 }
 
-func FetchTransfersByPortfolioId(portId int) ([]Transfer, error) {
-	rows, err := db.Query(`
+func (s *SQL) FetchTransfersByPortfolioId(ctx context.Context, portId int) ([]Transfer, error) {
+	rows, err := s.db.QueryContext(ctx, `
 		SELECT uid, port_id, amount, is_deposit, manually_added, date 
 		FROM transfers t
-		WHERE t.port_id=$1
+		WHERE t.port_id=$1 AND t.deleted_at IS NULL
 		ORDER BY date`, portId)
 	if err != nil {
 		return nil, err
@@ -91,18 +147,49 @@ func FetchTransfersByPortfolioId(portId int) ([]Transfer, error) {
 	return transfers, nil
 }
 
-func DeleteTransfer(uid string, portId int) error {
-	_, err := db.Exec(`DELETE FROM transfers WHERE uid=$1 AND port_id=$2`, uid, portId)
-	return err
+// Soft deletes the transfer, it can be brought back with RestoreTransfer. The transfer is marked uncommitted
+// so the portfolio gets reloaded without it.
+func (s *SQL) DeleteTransfer(ctx context.Context, uid string, portId int) error {
+	return s.inTx(ctx, func(tx *SQL) error {
+		before, deletedAt, err := tx.fetchTransferForUpdate(ctx, uid)
+		if err != nil {
+			return err
+		}
+		if before == nil || before.PortId != portId || deletedAt != nil {
+			return nil
+		}
+		_, err = tx.db.ExecContext(ctx, `UPDATE transfers SET deleted_at=$3, committed=false WHERE uid=$1 AND port_id=$2`, uid, portId, time.Now())
+		if err != nil {
+			return err
+		}
+		return appendAudit(ctx, tx.db, AuditTransfer, uid, portId, AuditDelete, before, nil)
+	})
+}
+
+func (s *SQL) RestoreTransfer(ctx context.Context, uid string, portId int) error {
+	return s.inTx(ctx, func(tx *SQL) error {
+		t, deletedAt, err := tx.fetchTransferForUpdate(ctx, uid)
+		if err != nil {
+			return err
+		}
+		if t == nil || t.PortId != portId || deletedAt == nil {
+			return fmt.Errorf("transfer %s in portfolio %d: %w", uid, portId, ErrNotDeleted)
+		}
+		_, err = tx.db.ExecContext(ctx, `UPDATE transfers SET deleted_at=NULL, committed=false WHERE uid=$1 AND port_id=$2`, uid, portId)
+		if err != nil {
+			return err
+		}
+		return appendAudit(ctx, tx.db, AuditTransfer, uid, portId, AuditRestore, nil, t)
+	})
 }
 
-func SetTransfersCommitted(portId int) error {
-	_, err := db.Exec(`UPDATE transfers SET committed=true WHERE port_id=$1`, portId)
+func (s *SQL) SetTransfersCommitted(ctx context.Context, portId int) error {
+	_, err := s.db.ExecContext(ctx, `UPDATE transfers SET committed=true WHERE port_id=$1`, portId)
 	return err
 }
 
-func HasUncommittedTransfers(portId int) (bool, error) {
-	rows, err := db.Query(`SELECT COUNT(*) FROM transfers WHERE committed=false AND port_id=$1`, portId)
+func (s *SQL) HasUncommittedTransfers(ctx context.Context, portId int) (bool, error) {
+	rows, err := s.db.QueryContext(ctx, `SELECT COUNT(*) FROM transfers WHERE committed=false AND port_id=$1`, portId)
 	if err != nil {
 		return false, err
 	}
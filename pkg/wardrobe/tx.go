@@ -0,0 +1,212 @@
+package wardrobe
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/shopspring/decimal"
+)
+
+// Transactor runs a unit of work atomically. Everything fn does through tx either all happens or, if fn
+// errors (or panics), none of it does. Reads and writes that should be part of the unit of work must go
+// through tx, not the store WithTx was called on.
+type Transactor interface {
+	WithTx(ctx context.Context, fn func(tx Store) error) error
+}
+
+// The parts of *sql.DB and *sql.Tx the postgres store uses, so the same queries run in or out of a transaction
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
+// Runs fn in a transaction. If s is already in one, fn just joins it, and the outermost WithTx decides
+// whether it's committed.
+func (s *SQL) inTx(ctx context.Context, fn func(tx *SQL) error) (err error) {
+	conn, ok := s.db.(*sql.DB)
+	if !ok {
+		return fn(s)
+	}
+	txn, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			_ = txn.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			_ = txn.Rollback()
+			return
+		}
+		err = txn.Commit()
+	}()
+	return fn(&SQL{db: txn, dialect: s.dialect})
+}
+
+func (l *Live) WithTx(ctx context.Context, fn func(tx Store) error) error {
+	return l.SQL.inTx(ctx, func(tx *SQL) error {
+		return fn(&Live{SQL: tx, SessionStore: l.SessionStore})
+	})
+}
+
+// Memory has no real transactions, so WithTx snapshots everything and restores it if fn fails. Units of work
+// run one at a time, but reads outside of one can still see its writes before it finishes.
+func (m *Memory) WithTx(ctx context.Context, fn func(tx Store) error) (err error) {
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+	snapshot := m.snapshot()
+	defer func() {
+		if p := recover(); p != nil {
+			m.restore(snapshot)
+			panic(p)
+		}
+		if err != nil {
+			m.restore(snapshot)
+		}
+	}()
+	return fn(m)
+}
+
+type memSnapshot struct {
+	nextPortId      int
+	portfolios      map[int]Portfolio
+	orders          map[string]memOrder
+	transfers       map[string]memTransfer
+	options         map[string]Option
+	positions       []Position
+	portValues      map[int]map[time.Time]PortValue
+	dailyPortValues []DailyPortVal
+	quotes          map[string]map[time.Time]decimal.Decimal
+	audit           []AuditEntry
+	portMembers     []PortfolioMember
+	nextHouseholdId int
+	households      []Household
+	householdUsers  []HouseholdUser
+	// Endpoints and deliveries live in the db, so they roll back with everything else
+	nextWebhookEndpointId int
+	webhookEndpoints      map[int][]WebhookEndpoint
+	nextWebhookDeliveryId int
+	webhookDeliveries     []WebhookDelivery
+	totp                  map[int]memTOTP
+	recoveryCodes         map[int][]memRecoveryCode
+	nextAPITokenId        int
+	apiTokens             []memAPIToken
+	links                 map[memLinkKey]LinkHealth
+	userEmails            []memUserEmail
+	nextAlertRuleId       int
+	alertRules            []AlertRule
+	digestSettings        map[int]DigestSettings
+}
+
+func (m *Memory) snapshot() memSnapshot {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s := memSnapshot{
+		nextPortId:            m.nextPortId,
+		portfolios:            make(map[int]Portfolio),
+		orders:                make(map[string]memOrder),
+		transfers:             make(map[string]memTransfer),
+		options:               make(map[string]Option),
+		positions:             append([]Position(nil), m.positions...),
+		portValues:            make(map[int]map[time.Time]PortValue),
+		dailyPortValues:       append([]DailyPortVal(nil), m.dailyPortValues...),
+		quotes:                make(map[string]map[time.Time]decimal.Decimal),
+		audit:                 append([]AuditEntry(nil), m.audit...),
+		portMembers:           append([]PortfolioMember(nil), m.portMembers...),
+		nextHouseholdId:       m.nextHouseholdId,
+		households:            append([]Household(nil), m.households...),
+		householdUsers:        append([]HouseholdUser(nil), m.householdUsers...),
+		nextWebhookEndpointId: m.nextWebhookEndpointId,
+		webhookEndpoints:      make(map[int][]WebhookEndpoint),
+		nextWebhookDeliveryId: m.nextWebhookDeliveryId,
+		webhookDeliveries:     append([]WebhookDelivery(nil), m.webhookDeliveries...),
+		totp:                  make(map[int]memTOTP),
+		recoveryCodes:         make(map[int][]memRecoveryCode),
+		nextAPITokenId:        m.nextAPITokenId,
+		apiTokens:             append([]memAPIToken(nil), m.apiTokens...),
+		links:                 make(map[memLinkKey]LinkHealth),
+		userEmails:            append([]memUserEmail(nil), m.userEmails...),
+		nextAlertRuleId:       m.nextAlertRuleId,
+		alertRules:            append([]AlertRule(nil), m.alertRules...),
+		digestSettings:        make(map[int]DigestSettings),
+	}
+	for k, v := range m.portfolios {
+		s.portfolios[k] = v
+	}
+	for k, v := range m.orders {
+		s.orders[k] = v
+	}
+	for k, v := range m.transfers {
+		s.transfers[k] = v
+	}
+	for k, v := range m.options {
+		s.options[k] = v
+	}
+	for k, v := range m.portValues {
+		values := make(map[time.Time]PortValue)
+		for date, pv := range v {
+			values[date] = pv
+		}
+		s.portValues[k] = values
+	}
+	for k, v := range m.quotes {
+		prices := make(map[time.Time]decimal.Decimal)
+		for date, price := range v {
+			prices[date] = price
+		}
+		s.quotes[k] = prices
+	}
+	for k, v := range m.totp {
+		s.totp[k] = v
+	}
+	for k, v := range m.webhookEndpoints {
+		s.webhookEndpoints[k] = append([]WebhookEndpoint(nil), v...)
+	}
+	for k, v := range m.digestSettings {
+		s.digestSettings[k] = v
+	}
+	for k, v := range m.links {
+		s.links[k] = v
+	}
+	for k, v := range m.recoveryCodes {
+		s.recoveryCodes[k] = append([]memRecoveryCode(nil), v...)
+	}
+	return s
+}
+
+func (m *Memory) restore(s memSnapshot) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nextPortId = s.nextPortId
+	m.portfolios = s.portfolios
+	m.orders = s.orders
+	m.transfers = s.transfers
+	m.options = s.options
+	m.positions = s.positions
+	m.portValues = s.portValues
+	m.dailyPortValues = s.dailyPortValues
+	m.quotes = s.quotes
+	m.audit = s.audit
+	m.portMembers = s.portMembers
+	m.nextHouseholdId = s.nextHouseholdId
+	m.households = s.households
+	m.householdUsers = s.householdUsers
+	m.nextWebhookEndpointId = s.nextWebhookEndpointId
+	m.webhookEndpoints = s.webhookEndpoints
+	m.nextWebhookDeliveryId = s.nextWebhookDeliveryId
+	m.webhookDeliveries = s.webhookDeliveries
+	m.totp = s.totp
+	m.recoveryCodes = s.recoveryCodes
+	m.nextAPITokenId = s.nextAPITokenId
+	m.apiTokens = s.apiTokens
+	m.links = s.links
+	m.userEmails = s.userEmails
+	m.nextAlertRuleId = s.nextAlertRuleId
+	m.alertRules = s.alertRules
+	m.digestSettings = s.digestSettings
+}
@@ -0,0 +1,91 @@
+package wardrobe
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v7"
+)
+
+// How long a watched topic lasts without being watched again. Whatever's watching a topic has to keep
+// refreshing it well within this.
+var WatchedTopicTtl = 90 * time.Second
+
+const watchedTopicsKey = "watched_topics"
+
+var watched watchedTopics
+
+// Topics some websocket connection (on any coattails process) is subscribed to, so anything producing events
+// for them (i.e. live quotes) knows what's wanted
+type watchedTopics interface {
+	watch(ctx context.Context, topics []string, at time.Time) error
+	// Returns every topic watched since since
+	fetch(ctx context.Context, since time.Time) ([]string, error)
+}
+
+// Marks topics as watched for the next WatchedTopicTtl
+func WatchTopics(ctx context.Context, topics []string) error {
+	if len(topics) == 0 {
+		return nil
+	}
+	return watched.watch(ctx, topics, time.Now())
+}
+
+// Returns every topic that's currently watched
+func FetchWatchedTopics(ctx context.Context) ([]string, error) {
+	return watched.fetch(ctx, time.Now().Add(-WatchedTopicTtl))
+}
+
+// A sorted set of topics, scored by when they were last watched
+type redisWatchedTopics struct {
+	client *redis.Client
+}
+
+func (r redisWatchedTopics) watch(ctx context.Context, topics []string, at time.Time) error {
+	members := make([]*redis.Z, 0, len(topics))
+	for _, topic := range topics {
+		members = append(members, &redis.Z{Score: float64(at.Unix()), Member: topic})
+	}
+	return r.client.WithContext(ctx).ZAdd(watchedTopicsKey, members...).Err()
+}
+
+func (r redisWatchedTopics) fetch(ctx context.Context, since time.Time) ([]string, error) {
+	client := r.client.WithContext(ctx)
+	// Nothing else ever removes topics nobody watches anymore
+	err := client.ZRemRangeByScore(watchedTopicsKey, "-inf", "("+strconv.FormatInt(since.Unix(), 10)).Err()
+	if err != nil {
+		return nil, err
+	}
+	return client.ZRange(watchedTopicsKey, 0, -1).Result()
+}
+
+type localWatchedTopics struct {
+	mu sync.Mutex
+	// When each topic was last watched
+	topics map[string]time.Time
+}
+
+func (l *localWatchedTopics) watch(ctx context.Context, topics []string, at time.Time) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, topic := range topics {
+		l.topics[topic] = at
+	}
+	return nil
+}
+
+func (l *localWatchedTopics) fetch(ctx context.Context, since time.Time) ([]string, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	topics := make([]string, 0, len(l.topics))
+	for topic, at := range l.topics {
+		if at.Before(since) {
+			delete(l.topics, topic)
+			continue
+		}
+		topics = append(topics, topic)
+	}
+	return topics, nil
+}
@@ -0,0 +1,306 @@
+package wardrobe
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/secrets"
+)
+
+var (
+	// Returned when a webhook endpoint doesn't exist (or isn't the user's)
+	ErrNoWebhookEndpoint = errors.New("no webhook endpoint found")
+	// Returned when a webhook delivery doesn't exist (or isn't to one of the user's endpoints)
+	ErrNoWebhookDelivery = errors.New("no webhook delivery found")
+)
+
+// Statuses of webhook deliveries
+const (
+	WebhookPending   = "pending"
+	WebhookSucceeded = "succeeded"
+	// Out of retries
+	WebhookFailed = "failed"
+)
+
+type WebhookEndpoint struct {
+	Id         int       `json:"id"`
+	UserId     int       `json:"user_id"`
+	URL        string    `json:"url"`
+	EventTypes []string  `json:"event_types"`
+	CreatedAt  time.Time `json:"created_at"`
+	// What deliveries are signed with. Only read back for delivering, see FetchDueWebhookDeliveries.
+	Secret string `json:"-"`
+}
+
+type WebhookDelivery struct {
+	Id         int    `json:"id"`
+	EndpointId int    `json:"endpoint_id"`
+	EventId    string `json:"event_id"`
+	EventType  string `json:"event_type"`
+	// Exactly what's posted, and signed
+	Body          json.RawMessage `json:"body"`
+	Status        string          `json:"status"`
+	Attempts      int             `json:"attempts"`
+	NextAttemptAt time.Time       `json:"next_attempt_at"`
+	LastAttemptAt *time.Time      `json:"last_attempt_at"`
+	// Nil until the endpoint has responded at all
+	ResponseStatus *int      `json:"response_status"`
+	LastError      string    `json:"last_error,omitempty"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// A pending delivery, with where it goes and what it's signed with
+type DueWebhookDelivery struct {
+	WebhookDelivery
+	URL    string
+	Secret string
+}
+
+func (s *SQL) CreateWebhookEndpoint(ctx context.Context, e WebhookEndpoint) (int, error) {
+	cipher, err := secrets.BdcEncrypt(e.Secret)
+	if err != nil {
+		return 0, err
+	}
+	var id int
+	err = s.db.QueryRowContext(ctx, `
+		INSERT INTO webhook_endpoints (user_id, url, secret_cipher, event_types, created_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id`, e.UserId, e.URL, cipher, strings.Join(e.EventTypes, " "), e.CreatedAt).Scan(&id)
+	return id, err
+}
+
+// Oldest first, without their secrets
+func (s *SQL) FetchWebhookEndpointsByUserId(ctx context.Context, userId int) ([]WebhookEndpoint, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT id, user_id, url, event_types, created_at
+		FROM webhook_endpoints
+		WHERE user_id=$1
+		ORDER BY id`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	endpoints := make([]WebhookEndpoint, 0)
+	for rows.Next() {
+		var e WebhookEndpoint
+		var eventTypes string
+		err = rows.Scan(&e.Id, &e.UserId, &e.URL, &eventTypes, &e.CreatedAt)
+		if err != nil {
+			return nil, err
+		}
+		e.EventTypes = strings.Fields(eventTypes)
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, rows.Err()
+}
+
+// Deletes the user's endpoint, along with its delivery log. Returns ErrNoWebhookEndpoint if they don't have one
+// with id.
+func (s *SQL) DeleteWebhookEndpoint(ctx context.Context, userId int, id int) error {
+	res, err := s.db.ExecContext(ctx, `DELETE FROM webhook_endpoints WHERE id=$1 AND user_id=$2`, id, userId)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoWebhookEndpoint
+	}
+	return nil
+}
+
+// Queues d for delivery at d.NextAttemptAt
+func (s *SQL) InsertWebhookDelivery(ctx context.Context, d WebhookDelivery) (int, error) {
+	var id int
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO webhook_deliveries (endpoint_id, event_id, event_type, body, status, next_attempt_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		RETURNING id`,
+		d.EndpointId, d.EventId, d.EventType, string(d.Body), WebhookPending, d.NextAttemptAt, d.CreatedAt).Scan(&id)
+	return id, err
+}
+
+const webhookDeliveryColumns = `
+	d.id, d.endpoint_id, d.event_id, d.event_type, d.body, d.status, d.attempts, d.next_attempt_at,
+	d.last_attempt_at, d.response_status, d.last_error, d.created_at`
+
+// The user's latest deliveries to endpointId, newest first. Returns ErrNoWebhookEndpoint if the endpoint isn't
+// theirs.
+func (s *SQL) FetchWebhookDeliveries(ctx context.Context, userId int, endpointId int, limit int) ([]WebhookDelivery, error) {
+	var n int
+	err := s.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM webhook_endpoints WHERE id=$1 AND user_id=$2`, endpointId, userId).Scan(&n)
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrNoWebhookEndpoint
+	}
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries d
+		WHERE d.endpoint_id=$1
+		ORDER BY d.id DESC
+		LIMIT $2`, endpointId, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := make([]WebhookDelivery, 0)
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *d)
+	}
+	return deliveries, rows.Err()
+}
+
+// Returns ErrNoWebhookDelivery if the delivery isn't to one of the user's endpoints
+func (s *SQL) FetchWebhookDelivery(ctx context.Context, userId int, id int) (*WebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+webhookDeliveryColumns+`
+		FROM webhook_deliveries d
+		JOIN webhook_endpoints e ON e.id=d.endpoint_id
+		WHERE d.id=$1 AND e.user_id=$2`, id, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	if !rows.Next() {
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+		return nil, ErrNoWebhookDelivery
+	}
+	return scanWebhookDelivery(rows)
+}
+
+// Pending deliveries due by now, oldest first. Ones whose endpoint secret can't be decrypted are marked failed
+// rather than returned, so they don't hold up everyone else's.
+func (s *SQL) FetchDueWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]DueWebhookDelivery, error) {
+	rows, err := s.db.QueryContext(ctx, `
+		SELECT `+webhookDeliveryColumns+`, e.url, e.secret_cipher
+		FROM webhook_deliveries d
+		JOIN webhook_endpoints e ON e.id=d.endpoint_id
+		WHERE d.status=$1 AND d.next_attempt_at <= $2
+		ORDER BY d.next_attempt_at
+		LIMIT $3`, WebhookPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	deliveries := make([]DueWebhookDelivery, 0)
+	undecryptable := make([]int, 0)
+	for rows.Next() {
+		var d DueWebhookDelivery
+		var body string
+		var lastAttemptAt sql.NullTime
+		var responseStatus sql.NullInt64
+		var cipher []byte
+		err = rows.Scan(&d.Id, &d.EndpointId, &d.EventId, &d.EventType, &body, &d.Status, &d.Attempts,
+			&d.NextAttemptAt, &lastAttemptAt, &responseStatus, &d.LastError, &d.CreatedAt, &d.URL, &cipher)
+		if err != nil {
+			return nil, err
+		}
+		fillWebhookDelivery(&d.WebhookDelivery, body, lastAttemptAt, responseStatus)
+		secret, err := secrets.BdcDecrypt(cipher)
+		if err != nil {
+			log.Printf("Can't decrypt the secret of webhook endpoint %d for delivery %d: %v", d.EndpointId, d.Id, err)
+			undecryptable = append(undecryptable, d.Id)
+			continue
+		}
+		d.Secret = *secret
+		deliveries = append(deliveries, d)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, err
+	}
+	// Done reading before writing, sqlite only has the one connection
+	rows.Close()
+	for _, id := range undecryptable {
+		err = s.failWebhookDelivery(ctx, id, now, "endpoint secret can't be decrypted")
+		if err != nil {
+			return nil, err
+		}
+	}
+	return deliveries, nil
+}
+
+// Gives up on a pending delivery without attempting it, so it isn't fetched again
+func (s *SQL) failWebhookDelivery(ctx context.Context, id int, now time.Time, reason string) error {
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET status=$2, last_attempt_at=$3, last_error=$4
+		WHERE id=$1 AND status=$5`, id, WebhookFailed, now, reason, WebhookPending)
+	return err
+}
+
+// Pushes a due delivery's next attempt out to leaseUntil, so nobody else delivers it while we are. Returns
+// whether we got it.
+func (s *SQL) ClaimWebhookDelivery(ctx context.Context, id int, now time.Time, leaseUntil time.Time) (bool, error) {
+	res, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET next_attempt_at=$3
+		WHERE id=$1 AND status=$4 AND next_attempt_at <= $2`, id, now, leaseUntil, WebhookPending)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// Saves how an attempt at delivering d went. d's Status, Attempts, NextAttemptAt, LastAttemptAt,
+// ResponseStatus and LastError are saved.
+func (s *SQL) RecordWebhookAttempt(ctx context.Context, d WebhookDelivery) error {
+	var responseStatus sql.NullInt64
+	if d.ResponseStatus != nil {
+		responseStatus = sql.NullInt64{Int64: int64(*d.ResponseStatus), Valid: true}
+	}
+	_, err := s.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries
+		SET status=$2, attempts=$3, next_attempt_at=$4, last_attempt_at=$5, response_status=$6, last_error=$7
+		WHERE id=$1`,
+		d.Id, d.Status, d.Attempts, d.NextAttemptAt, nullableTime(d.LastAttemptAt), responseStatus, d.LastError)
+	return err
+}
+
+// Drops the delivery log from before cutoff, other than what's still pending
+func (s *SQL) DeleteWebhookDeliveriesBefore(ctx context.Context, cutoff time.Time) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM webhook_deliveries WHERE created_at < $1 AND status != $2`, cutoff, WebhookPending)
+	return err
+}
+
+func scanWebhookDelivery(rows *sql.Rows) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	var body string
+	var lastAttemptAt sql.NullTime
+	var responseStatus sql.NullInt64
+	err := rows.Scan(&d.Id, &d.EndpointId, &d.EventId, &d.EventType, &body, &d.Status, &d.Attempts,
+		&d.NextAttemptAt, &lastAttemptAt, &responseStatus, &d.LastError, &d.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	fillWebhookDelivery(&d, body, lastAttemptAt, responseStatus)
+	return &d, nil
+}
+
+func fillWebhookDelivery(d *WebhookDelivery, body string, lastAttemptAt sql.NullTime, responseStatus sql.NullInt64) {
+	d.Body = json.RawMessage(body)
+	if lastAttemptAt.Valid {
+		d.LastAttemptAt = &lastAttemptAt.Time
+	}
+	if responseStatus.Valid {
+		status := int(responseStatus.Int64)
+		d.ResponseStatus = &status
+	}
+}
@@ -0,0 +1,155 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/util"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+)
+
+const (
+	// How long an endpoint gets to take a delivery
+	deliveryTimeout = 10 * time.Second
+	// How long a claimed delivery is held before another dispatcher can retry it, i.e. if we died delivering it
+	claimLease = time.Minute
+	// Deliveries fetched per poll
+	batchSize = 50
+	// Attempts before a delivery is marked failed, which with the backoff below spans about 15 hours
+	maxAttempts = 12
+	minBackoff  = 30 * time.Second
+	maxBackoff  = 6 * time.Hour
+	// Most of a response we'll read to reuse its connection, past that it's just closed
+	maxDrainBytes = 64 * 1024
+	// How long the delivery log is kept, see wardrobe.DeleteWebhookDeliveriesBefore
+	LogRetention = 30 * 24 * time.Hour
+)
+
+// Delivers queued events to their endpoints, retrying failures with exponential backoff. Endpoints are only
+// ever posted to at public addresses, and redirects aren't followed.
+type Dispatcher struct {
+	store  wardrobe.WebhookStore
+	client *http.Client
+}
+
+func NewDispatcher(store wardrobe.WebhookStore) *Dispatcher {
+	return &Dispatcher{store: store, client: util.NewPublicClient(deliveryTimeout)}
+}
+
+// Delivers whatever's due every interval until ctx is done
+func (d *Dispatcher) Run(ctx context.Context, interval time.Duration) {
+	log.Printf("Delivering webhooks every %s", interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			err := d.DeliverDue(ctx)
+			if err != nil {
+				log.Printf("Error delivering webhooks: %v", err)
+			}
+		}
+	}
+}
+
+// Attempts every delivery that's due
+func (d *Dispatcher) DeliverDue(ctx context.Context) error {
+	for {
+		now := time.Now()
+		due, err := d.store.FetchDueWebhookDeliveries(ctx, now, batchSize)
+		if err != nil {
+			return err
+		}
+		for _, delivery := range due {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			// Whoever else is polling may have gotten to it first
+			claimed, err := d.store.ClaimWebhookDelivery(ctx, delivery.Id, now, now.Add(claimLease))
+			if err != nil {
+				return err
+			}
+			if !claimed {
+				continue
+			}
+			err = d.store.RecordWebhookAttempt(ctx, d.attempt(ctx, delivery))
+			if err != nil {
+				return err
+			}
+		}
+		if len(due) < batchSize {
+			return nil
+		}
+	}
+}
+
+// Posts delivery to its endpoint, returning it updated with how that went
+func (d *Dispatcher) attempt(ctx context.Context, delivery wardrobe.DueWebhookDelivery) wardrobe.WebhookDelivery {
+	result := delivery.WebhookDelivery
+	now := time.Now()
+	result.Attempts++
+	result.LastAttemptAt = &now
+	result.ResponseStatus = nil
+	status, err := d.post(ctx, delivery, now)
+	if status != 0 {
+		result.ResponseStatus = &status
+	}
+	if err == nil {
+		result.Status = wardrobe.WebhookSucceeded
+		result.LastError = ""
+		return result
+	}
+	result.LastError = err.Error()
+	if result.Attempts >= maxAttempts {
+		log.Printf("Giving up on webhook delivery %d to endpoint %d after %d attempts: %v", delivery.Id, delivery.EndpointId, result.Attempts, err)
+		result.Status = wardrobe.WebhookFailed
+		return result
+	}
+	result.NextAttemptAt = now.Add(backoff(result.Attempts))
+	return result
+}
+
+// Returns the status the endpoint responded with, or 0 if it didn't. Only the status is kept in the delivery
+// log, never the response body.
+func (d *Dispatcher) post(ctx context.Context, delivery wardrobe.DueWebhookDelivery, now time.Time) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", delivery.URL, bytes.NewReader(delivery.Body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(delivery.Id))
+	req.Header.Set(HeaderSignature, Sign(delivery.Secret, now, delivery.Body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	// Drained so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxDrainBytes))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("endpoint responded %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// How long to wait after the given number of failed attempts
+func backoff(attempts int) time.Duration {
+	wait := minBackoff
+	for i := 1; i < attempts; i++ {
+		wait *= 2
+		if wait >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return wait
+}
@@ -0,0 +1,246 @@
+package webhooks
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/bluedresscapital/coattails/pkg/portfolios"
+	"github.com/bluedresscapital/coattails/pkg/util"
+	"github.com/bluedresscapital/coattails/pkg/wardrobe"
+	"github.com/google/uuid"
+)
+
+// Event types endpoints can subscribe to
+const (
+	// Orders were imported (or added) and committed, with an OrdersImported
+	EventOrdersImported = "orders.imported"
+	// Transfers were imported (or added) and committed, with a TransfersImported
+	EventTransfersImported = "transfers.imported"
+	// A portfolio's positions were reloaded, with a PositionsReloaded
+	EventPositionsReloaded = "positions.reloaded"
+	// A portfolio's history of values was reloaded, with a PortfolioReloaded
+	EventPortfolioReloaded = "portfolio.reloaded"
+	// A portfolio's value for the day was computed, with a PortValuesComputed
+	EventPortValuesComputed = "port_values.computed"
+)
+
+var eventTypes = []string{
+	EventOrdersImported,
+	EventTransfersImported,
+	EventPositionsReloaded,
+	EventPortfolioReloaded,
+	EventPortValuesComputed,
+}
+
+// Headers every delivery is posted with
+const (
+	HeaderSignature = "X-Coattails-Signature"
+	HeaderEvent     = "X-Coattails-Event"
+	HeaderDelivery  = "X-Coattails-Delivery"
+)
+
+const (
+	// Most endpoints a user can have
+	maxEndpoints = 10
+	// Bytes of randomness in endpoint secrets
+	secretBytes = 32
+)
+
+// What's posted to endpoints. Id is the same across redeliveries, so receivers can dedupe on it.
+type Event struct {
+	Id        string      `json:"id"`
+	Type      string      `json:"type"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+type OrdersImported struct {
+	PortId int `json:"port_id"`
+}
+
+type TransfersImported struct {
+	PortId int `json:"port_id"`
+}
+
+type PositionsReloaded struct {
+	PortId    int                 `json:"port_id"`
+	Positions []wardrobe.Position `json:"positions"`
+}
+
+type PortfolioReloaded struct {
+	PortId int `json:"port_id"`
+}
+
+type PortValuesComputed struct {
+	PortId int                      `json:"port_id"`
+	Date   time.Time                `json:"date"`
+	Values portfolios.PortValueDiff `json:"values"`
+}
+
+// What queueing events for everyone who can see a portfolio reads and writes
+type Store interface {
+	wardrobe.SharingStore
+	wardrobe.WebhookStore
+}
+
+type InvalidEndpointError struct {
+	msg string
+}
+
+func (e *InvalidEndpointError) Error() string {
+	return e.msg
+}
+
+func invalid(format string, a ...interface{}) error {
+	return &InvalidEndpointError{fmt.Sprintf(format, a...)}
+}
+
+// Validates and saves an endpoint for userId subscribed to eventTypes, returning it as saved along with the
+// secret its deliveries are signed with. This is the only time the secret is handed out.
+func CreateEndpoint(ctx context.Context, store wardrobe.WebhookStore, userId int, rawURL string, types []string) (*wardrobe.WebhookEndpoint, string, error) {
+	err := util.CheckPublicURL(ctx, rawURL)
+	if err != nil {
+		return nil, "", invalid("url %v", err)
+	}
+	if len(types) == 0 {
+		return nil, "", invalid("event_types can't be empty, expected any of %v", eventTypes)
+	}
+	seen := make(map[string]bool)
+	deduped := make([]string, 0, len(types))
+	for _, t := range types {
+		if !knownEventType(t) {
+			return nil, "", invalid("unknown event type %q, expected any of %v", t, eventTypes)
+		}
+		if !seen[t] {
+			seen[t] = true
+			deduped = append(deduped, t)
+		}
+	}
+	existing, err := store.FetchWebhookEndpointsByUserId(ctx, userId)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(existing) >= maxEndpoints {
+		return nil, "", invalid("can't have more than %d webhook endpoints", maxEndpoints)
+	}
+	buf := make([]byte, secretBytes)
+	_, err = rand.Read(buf)
+	if err != nil {
+		return nil, "", err
+	}
+	secret := hex.EncodeToString(buf)
+	e := wardrobe.WebhookEndpoint{
+		UserId:     userId,
+		URL:        rawURL,
+		EventTypes: deduped,
+		CreatedAt:  time.Now(),
+		Secret:     secret,
+	}
+	e.Id, err = store.CreateWebhookEndpoint(ctx, e)
+	if err != nil {
+		return nil, "", err
+	}
+	return &e, secret, nil
+}
+
+func knownEventType(t string) bool {
+	for _, known := range eventTypes {
+		if t == known {
+			return true
+		}
+	}
+	return false
+}
+
+func subscribed(e wardrobe.WebhookEndpoint, eventType string) bool {
+	for _, t := range e.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Queues an event for every endpoint subscribed to eventType, of every user with access to portId. A
+// Dispatcher delivers it.
+func EmitToPortfolio(ctx context.Context, store Store, portId int, eventType string, data interface{}) error {
+	userIds, err := store.FetchPortfolioUserIds(ctx, portId)
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	event := Event{Id: uuid.New().String(), Type: eventType, CreatedAt: now, Data: data}
+	var body []byte
+	for _, userId := range userIds {
+		endpoints, err := store.FetchWebhookEndpointsByUserId(ctx, userId)
+		if err != nil {
+			return err
+		}
+		for _, e := range endpoints {
+			if !subscribed(e, eventType) {
+				continue
+			}
+			// Only marshalled once anybody's actually subscribed
+			if body == nil {
+				body, err = json.Marshal(event)
+				if err != nil {
+					return err
+				}
+			}
+			_, err = store.InsertWebhookDelivery(ctx, wardrobe.WebhookDelivery{
+				EndpointId:    e.Id,
+				EventId:       event.Id,
+				EventType:     eventType,
+				Body:          body,
+				NextAttemptAt: now,
+				CreatedAt:     now,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Queues the user's delivery deliveryId to be sent again, as a new delivery of the same event. Returns
+// wardrobe.ErrNoWebhookDelivery if it isn't theirs.
+func Redeliver(ctx context.Context, store wardrobe.WebhookStore, userId int, deliveryId int) (*wardrobe.WebhookDelivery, error) {
+	d, err := store.FetchWebhookDelivery(ctx, userId, deliveryId)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	redelivery := wardrobe.WebhookDelivery{
+		EndpointId:    d.EndpointId,
+		EventId:       d.EventId,
+		EventType:     d.EventType,
+		Body:          d.Body,
+		Status:        wardrobe.WebhookPending,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	}
+	redelivery.Id, err = store.InsertWebhookDelivery(ctx, redelivery)
+	if err != nil {
+		return nil, err
+	}
+	return &redelivery, nil
+}
+
+// The X-Coattails-Signature header for body sent at t: "t=<unix seconds>,v1=<hex hmac-sha256 of "<t>.<body>">".
+// Signing the timestamp lets receivers reject replays of old deliveries.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return fmt.Sprintf("t=%s,v1=%s", ts, hex.EncodeToString(mac.Sum(nil)))
+}